@@ -0,0 +1,9 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package v1alpha2
+
+// Hub marks v1alpha2 as the conversion hub, other versions are converted to
+// and from it
+func (*SopsSecret) Hub() {}
@@ -11,20 +11,240 @@ import (
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 // For upstream reference, see https://github.com/mozilla/sops/blob/master/stores/stores.go
 
+const (
+	// SuspendedCondition indicates that SopsSecret reconciliation is suspended
+	SuspendedCondition = "Suspended"
+	// TemplateErrorCondition indicates that some secret templates failed to apply
+	TemplateErrorCondition = "TemplateError"
+	// DryRunCondition indicates that SopsSecret is reconciled in dry-run mode
+	DryRunCondition = "DryRun"
+	// InsufficientKeyGroupsCondition indicates that operator credentials can
+	// not decrypt enough sops key groups to recover the data key
+	InsufficientKeyGroupsCondition = "InsufficientKeyGroups"
+	// ReadyCondition indicates that all secret templates of the observed
+	// generation are applied
+	ReadyCondition = "Ready"
+	// ProgressingCondition indicates that SopsSecret is being reconciled or
+	// failed with transient error which is retried
+	ProgressingCondition = "Progressing"
+	// StalledCondition indicates that SopsSecret failed with error which
+	// requires SopsSecret change
+	StalledCondition = "Stalled"
+	// VerifiedCondition indicates whether SopsSecret in verify-only mode
+	// passed SOPS MAC and decryption check
+	VerifiedCondition = "Verified"
+	// ConflictCondition indicates that some generated secret names are taken
+	// by secrets not owned by the SopsSecret
+	ConflictCondition = "Conflict"
+	// StaleCondition indicates that key backend is unavailable and generated
+	// secrets are kept from the last successful reconciliation
+	StaleCondition = "Stale"
+	// LimitExceededCondition indicates that SopsSecret or some generated
+	// secrets exceed operator limits and are not applied
+	LimitExceededCondition = "LimitExceeded"
+	// CertificateExpiringCondition indicates that certificates of some
+	// generated TLS secrets expire soon
+	CertificateExpiringCondition = "CertificateExpiring"
+	// CorruptedPayloadCondition indicates that encrypted values or SOPS MAC
+	// fail authentication with the data key, SopsSecret is not reconciled
+	// again until it changes
+	CorruptedPayloadCondition = "CorruptedPayload"
+	// NeedsReencryptionCondition indicates that SopsSecret is encrypted for
+	// recipients which are not in operator current recipients
+	NeedsReencryptionCondition = "NeedsReencryption"
+	// SpecErrorCondition indicates that SopsSecret failed with error which
+	// requires SopsSecret change, it is not requeued until it changes
+	SpecErrorCondition = "SpecError"
+	// CredentialErrorCondition indicates that SopsSecret can not be decrypted
+	// with operator credentials or key backends, or that impersonated service
+	// account may not write generated secrets, it is retried with backoff
+	CredentialErrorCondition = "CredentialError"
+	// APIErrorCondition indicates that reading or writing Kubernetes
+	// resources failed, it is retried quickly
+	APIErrorCondition = "APIError"
+	// BackendUnavailableCondition indicates that circuit breaker of some key
+	// backends of SopsSecret is open, so their data keys are not decrypted
+	// until a probe finds them available again
+	BackendUnavailableCondition = "BackendUnavailable"
+)
+
+// OnTemplateError defines how secret template failures are handled
+// +kubebuilder:validation:Enum=FailAll;ApplyValid
+type OnTemplateError string
+
+const (
+	// FailAllOnTemplateError stops processing of secret templates on the first failure
+	FailAllOnTemplateError OnTemplateError = "FailAll"
+	// ApplyValidOnTemplateError applies valid secret templates and reports failed ones in status
+	ApplyValidOnTemplateError OnTemplateError = "ApplyValid"
+)
+
+// DeletionPolicy defines what happens to generated secrets when SopsSecret is deleted
+// +kubebuilder:validation:Enum=Delete;Orphan;Retain
+type DeletionPolicy string
+
+const (
+	// DeleteDeletionPolicy deletes generated secrets together with SopsSecret
+	DeleteDeletionPolicy DeletionPolicy = "Delete"
+	// OrphanDeletionPolicy leaves generated secrets in place without owner reference
+	OrphanDeletionPolicy DeletionPolicy = "Orphan"
+	// RetainDeletionPolicy leaves generated secrets in place without owner
+	// reference and annotations set by the operator
+	RetainDeletionPolicy DeletionPolicy = "Retain"
+)
+
+// UpdateStrategy defines how existing generated secret is updated, it is
+// not validated by the CRD, as secret templates are usually encrypted
+type UpdateStrategy string
+
+const (
+	// PatchUpdateStrategy applies changed fields, data keys added by other
+	// actors are preserved
+	PatchUpdateStrategy UpdateStrategy = "Patch"
+	// RecreateUpdateStrategy deletes the secret and creates it again, so it
+	// holds exactly the rendered data
+	RecreateUpdateStrategy UpdateStrategy = "Recreate"
+)
+
+// MergePolicy defines what happens to data keys of generated secret which
+// are no longer rendered from its secret template
+type MergePolicy string
+
+const (
+	// ReplaceMergePolicy removes data keys no longer rendered from the secret
+	ReplaceMergePolicy MergePolicy = "Replace"
+	// MergeMergePolicy keeps data keys no longer rendered with their last
+	// values
+	MergeMergePolicy MergePolicy = "Merge"
+)
+
+// SecretTemplateFile maps file of SopsSecret to secret data key
+type SecretTemplateFile struct {
+	// Name is secret data key
+	Name string `json:"name"`
+
+	// Path is key of the file in SopsSecret spec.files
+	Path string `json:"path"`
+}
+
+// SecretTemplateValue maps value of another source to secret data key
+type SecretTemplateValue struct {
+	// Name is secret data key
+	Name string `json:"name"`
+
+	// ValueFrom is source of the value
+	ValueFrom SecretTemplateValueSource `json:"valueFrom"`
+}
+
+// SecretTemplateValueSource is source of secret data value, exactly one
+// source must be set
+type SecretTemplateValueSource struct {
+	// SopsSecretRef selects decrypted value of another SopsSecret in the same
+	// namespace
+	// +optional
+	SopsSecretRef *SopsSecretKeySelector `json:"sopsSecretRef,omitempty"`
+
+	// SealedSecret is value sealed by Bitnami sealed-secrets, which is
+	// decrypted with sealing keys of sealed-secrets controller
+	// +optional
+	SealedSecret *SealedSecretValue `json:"sealedSecret,omitempty"`
+
+	// SecretKeyRef selects value of existing Kubernetes secret in the same
+	// namespace, such as certificate issued by cert-manager
+	// +optional
+	SecretKeyRef *SecretKeySelector `json:"secretKeyRef,omitempty"`
+}
+
+// SecretKeySelector selects data key of Kubernetes secret
+type SecretKeySelector struct {
+	// Name of the secret
+	Name string `json:"name"`
+
+	// Key is data key of the secret
+	Key string `json:"key"`
+}
+
+// SealedSecretValue is ciphertext of Bitnami SealedSecret encryptedData
+type SealedSecretValue struct {
+	// EncryptedData is base64 encoded ciphertext, as in SealedSecret
+	// spec.encryptedData
+	EncryptedData string `json:"encryptedData"`
+
+	// Scope the value was sealed with. Default: strict
+	// +kubebuilder:validation:Enum=strict;namespace-wide;cluster-wide
+	// +optional
+	Scope string `json:"scope,omitempty"`
+
+	// SecretName is name of the SealedSecret the value was sealed for with
+	// strict scope. Default: name of the secret template
+	// +optional
+	SecretName string `json:"secretName,omitempty"`
+}
+
+// SecretTemplateDockerConfig holds registry credentials of image pull secret
+type SecretTemplateDockerConfig struct {
+	// Registry is server of the registry, such as https://index.docker.io/v1/
+	Registry string `json:"registry"`
+
+	// Username for the registry
+	Username string `json:"username"`
+
+	// Password for the registry
+	Password string `json:"password"`
+
+	// Email for the registry
+	// +optional
+	Email string `json:"email,omitempty"`
+}
+
+// SopsSecretKeySelector selects data key of secret templates of SopsSecret
+type SopsSecretKeySelector struct {
+	// Name of the SopsSecret
+	Name string `json:"name"`
+
+	// Key is data key of the SopsSecret secret templates
+	Key string `json:"key"`
+
+	// Template is name of secret template to select the key from, by default
+	// the first template defining the key is used
+	// +optional
+	Template string `json:"template,omitempty"`
+}
+
 // SopsSecretTemplate defines the map of secrets to create
 type SopsSecretTemplate struct {
 	// Name of the Kubernetes secret to create
 	Name string `json:"name"`
 
+	// NameTemplate is Go template of the Kubernetes secret name, which
+	// overrides name. Variables are {{ .SopsSecretName }} and {{ .Namespace }}
+	// +optional
+	NameTemplate string `json:"nameTemplate,omitempty"`
+
 	// Annotations to apply to Kubernetes secret
 	// +optional
 	Annotations map[string]string `json:"annotations,omitempty"`
 
+	// AnnotationsFromEncrypted maps annotation names to data keys of the
+	// generated secret, decrypted values of the keys are copied to the
+	// annotations for third-party controllers which read credentials from
+	// annotations. Annotations are not protected like secret data, they show
+	// in kubectl describe, audit logs and tools treating metadata as not
+	// sensitive, so acknowledgeAnnotationExposure must be set as well
+	// +optional
+	AnnotationsFromEncrypted map[string]string `json:"annotationsFromEncrypted,omitempty"`
+
+	// AcknowledgeAnnotationExposure confirms that values copied by
+	// annotationsFromEncrypted are exposed in secret metadata. Must not be
+	// encrypted
+	// +optional
+	AcknowledgeAnnotationExposure bool `json:"acknowledgeAnnotationExposure,omitempty"`
+
 	// Labels to apply to Kubernetes secret
 	// +optional
 	Labels map[string]string `json:"labels,omitempty"`
 
-	// Kubernetes secret type. Default: Opauqe. Possible values: Opauqe,
+	// Kubernetes secret type. Default: Opaque. Possible values: Opaque,
 	// kubernetes.io/service-account-token, kubernetes.io/dockercfg,
 	// kubernetes.io/dockerconfigjson, kubernetes.io/basic-auth,
 	// kubernetes.io/ssh-auth, kubernetes.io/tls, bootstrap.kubernetes.io/token
@@ -38,6 +258,90 @@ type SopsSecretTemplate struct {
 	// BinaryData is base64 data map to use in Kubernetes secret
 	// +optional
 	BinaryData map[string]string `json:"binaryData,omitempty"`
+
+	// Files maps secret data keys to files of SopsSecret spec.files
+	// +optional
+	Files []SecretTemplateFile `json:"files,omitempty"`
+
+	// Values maps secret data keys to values of other sources, such as
+	// decrypted values of other SopsSecrets
+	// +optional
+	Values []SecretTemplateValue `json:"values,omitempty"`
+
+	// DockerConfig assembles .dockerconfigjson key of image pull secret from
+	// registry credentials, secret type defaults to
+	// kubernetes.io/dockerconfigjson
+	// +optional
+	DockerConfig *SecretTemplateDockerConfig `json:"dockerConfig,omitempty"`
+
+	// HtpasswdKey is data key of kubernetes.io/basic-auth secret to emit
+	// htpasswd entry with bcrypt hashed password to, such as auth for ingress
+	// authentication
+	// +optional
+	HtpasswdKey string `json:"htpasswdKey,omitempty"`
+
+	// Immutable creates immutable Kubernetes secret named after the template
+	// with content hash suffix, new secret is created when content changes
+	// +optional
+	Immutable bool `json:"immutable,omitempty"`
+
+	// RevisionHistoryLimit is the number of previous immutable secrets to
+	// retain. Default: 1
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
+
+	// Templated enables rendering of data values as go templates, with access
+	// to decrypted values of other data keys
+	// +optional
+	Templated bool `json:"templated,omitempty"`
+
+	// Expand treats every data value as SOPS encrypted YAML document and
+	// turns each top level key of the decrypted document into separate
+	// secret data key
+	// +optional
+	Expand bool `json:"expand,omitempty"`
+
+	// Format is format of SOPS encrypted documents in data values, setting
+	// it expands documents as Expand does. Default: yaml.
+	// +kubebuilder:validation:Enum=yaml;json;dotenv;ini
+	// +optional
+	Format string `json:"format,omitempty"`
+
+	// TargetNamespaces lists additional namespaces to replicate the secret to
+	// +optional
+	TargetNamespaces []string `json:"targetNamespaces,omitempty"`
+
+	// TargetNamespaceSelector selects additional namespaces to replicate the
+	// secret to
+	// +optional
+	TargetNamespaceSelector *metav1.LabelSelector `json:"targetNamespaceSelector,omitempty"`
+
+	// TakeOwnership allows adopting already existing secret which is not
+	// owned by any controller, by default such secret is left untouched
+	// and Conflict condition is set
+	// +optional
+	TakeOwnership bool `json:"takeOwnership,omitempty"`
+
+	// Cluster is the name of operator configured remote cluster the secret is
+	// pushed to instead of the SopsSecret cluster, the secret is created in
+	// the namespace of the same name as SopsSecret namespace
+	// +optional
+	Cluster string `json:"cluster,omitempty"`
+
+	// UpdateStrategy defines how existing secret is updated. Default: Patch.
+	// Patch applies changed fields and preserves data keys added by other
+	// actors, Recreate deletes and creates the secret again whenever it
+	// differs from the rendered one, including additional data keys
+	// +optional
+	UpdateStrategy UpdateStrategy `json:"updateStrategy,omitempty"`
+
+	// MergePolicy defines what happens to data keys previously rendered from
+	// the template which are removed from it. Default: Replace. Replace
+	// removes them from the secret, Merge keeps them with their last values.
+	// Data keys added by other actors are preserved by both
+	// +optional
+	MergePolicy MergePolicy `json:"mergePolicy,omitempty"`
 }
 
 // SopsSecretSpec defines the desired state of SopsSecret
@@ -45,9 +349,97 @@ type SopsSecretSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
-	// Secrets template is a list of definitions to create Kubernetes Secrets
-	//+kubebuilder:validation:MinItems=1
-	SecretsTemplate []SopsSecretTemplate `json:"secretTemplates"`
+	// Secrets template is a list of definitions to create Kubernetes Secrets,
+	// it may be empty only when raw secrets are set
+	// +optional
+	SecretsTemplate []SopsSecretTemplate `json:"secretTemplates,omitempty"`
+
+	// RawSecrets are whole Kubernetes Secret manifests, each encrypted by SOPS
+	// as a separate YAML document, which are applied as they are
+	// +optional
+	RawSecrets []string `json:"rawSecrets,omitempty"`
+
+	// Files holds whole files, such as certificates or configuration files,
+	// referenced by secret templates files, so multi-line content does not
+	// have to be escaped in template data
+	// +optional
+	Files map[string]string `json:"files,omitempty"`
+
+	// AwsRoleARN is AWS IAM role to assume via STS to decrypt AWS KMS data key,
+	// overrides role specified in sops metadata. Must not be encrypted.
+	// +optional
+	AwsRoleARN string `json:"awsRoleARN,omitempty"`
+
+	// GcpServiceAccount is GCP service account email to impersonate to decrypt
+	// GCP KMS data key. Must not be encrypted.
+	// +optional
+	GcpServiceAccount string `json:"gcpServiceAccount,omitempty"`
+
+	// AzureIdentity is client ID of Azure user-assigned managed identity to use
+	// to decrypt Azure Key Vault data key. Must not be encrypted.
+	// +optional
+	AzureIdentity string `json:"azureIdentity,omitempty"`
+
+	// KeyProfile is the name of operator key profile, credentials of which
+	// are used to decrypt SopsSecret instead of operator credentials. Must
+	// not be encrypted.
+	// +optional
+	KeyProfile string `json:"keyProfile,omitempty"`
+
+	// VaultNamespace is Vault Enterprise namespace of transit keys, overrides
+	// operator default namespace. Must not be encrypted.
+	// +optional
+	VaultNamespace string `json:"vaultNamespace,omitempty"`
+
+	// VaultProfile is the name of operator Vault profile used to decrypt
+	// Vault transit data keys instead of operator Vault connection, it
+	// overrides sops-secrets-operator/vault-profile annotation of the
+	// namespace. Must not be encrypted.
+	// +optional
+	VaultProfile string `json:"vaultProfile,omitempty"`
+
+	// Suspend pauses reconciliation of SopsSecret, managed secrets are left as is
+	// +optional
+	Suspend bool `json:"suspend,omitempty"`
+
+	// VerifyOnly checks SOPS MAC and decryptability of SopsSecret on every
+	// reconciliation without generating any secrets, the result is recorded in
+	// Verified condition. Secrets generated before are left as is. Must not be
+	// encrypted.
+	// +optional
+	VerifyOnly bool `json:"verifyOnly,omitempty"`
+
+	// OnTemplateError defines how secret template failures are handled. Default: FailAll.
+	// FailAll stops on the first failed template, ApplyValid applies all valid
+	// templates and reports failed ones in status conditions.
+	// +optional
+	OnTemplateError OnTemplateError `json:"onTemplateError,omitempty"`
+
+	// RefreshInterval is how often successfully reconciled SopsSecret is
+	// reconciled again to repair drift of generated secrets, overrides
+	// operator --requeue-success-after flag. Must not be encrypted.
+	// +optional
+	RefreshInterval *metav1.Duration `json:"refreshInterval,omitempty"`
+
+	// DeletionPolicy defines what happens to generated secrets when SopsSecret
+	// is deleted. Default: Delete. Delete removes generated secrets, Orphan
+	// leaves them in place, Retain leaves them in place with operator
+	// annotations stripped. Must not be encrypted.
+	// +optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+
+	// Priority orders reconciliation of SopsSecrets waiting in the work
+	// queue, for example after operator restart, SopsSecrets with higher
+	// priority are reconciled first. Must not be encrypted.
+	// +optional
+	Priority int32 `json:"priority,omitempty"`
+
+	// ServiceAccountName is the name of service account in SopsSecret
+	// namespace the operator impersonates when it creates and updates
+	// generated secrets, so secrets are written with permissions of the
+	// service account instead of operator permissions. Must not be encrypted.
+	// +optional
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
 }
 
 // KmsDataItem defines AWS KMS specific encryption details
@@ -130,6 +522,34 @@ type GcpKmsDataItem struct {
 	CreationDate string `json:"created_at,omitempty"`
 }
 
+// KeyGroup defines master keys of sops key group, any of them decrypts the
+// key group share of the data key
+type KeyGroup struct {
+	// Aws KMS configuration
+	// +optional
+	AwsKms []KmsDataItem `json:"kms,omitempty"`
+
+	// PGP configuration
+	// +optional
+	Pgp []PgpDataItem `json:"pgp,omitempty"`
+
+	// Azure KMS configuration
+	// +optional
+	AzureKms []AzureKmsItem `json:"azure_kv,omitempty"`
+
+	// Hashicorp Vault KMS configurarion
+	// +optional
+	HcVault []HcVaultItem `json:"hc_vault,omitempty"`
+
+	// Gcp KMS configuration
+	// +optional
+	GcpKms []GcpKmsDataItem `json:"gcp_kms,omitempty"`
+
+	// Age configuration
+	// +optional
+	Age []AgeItem `json:"age,omitempty"`
+}
+
 // SopsMetadata defines the encryption details
 type SopsMetadata struct {
 	// Aws KMS configuration
@@ -156,6 +576,15 @@ type SopsMetadata struct {
 	// +optional
 	Age []AgeItem `json:"age,omitempty"`
 
+	// KeyGroups - sops key groups, used instead of keys above when data key
+	// is split with Shamir's secret sharing
+	// +optional
+	KeyGroups []KeyGroup `json:"key_groups,omitempty"`
+
+	// ShamirThreshold is the number of key groups required to recover the data key
+	// +optional
+	ShamirThreshold int `json:"shamir_threshold,omitempty"`
+
 	// Mac - sops setting
 	// +optional
 	Mac string `json:"mac,omitempty"`
@@ -176,6 +605,110 @@ type SopsMetadata struct {
 	// This opstion should be used with more care, as it can make resource unapplicable to the cluster.
 	// +optional
 	EncryptedRegex string `json:"encrypted_regex,omitempty"`
+
+	// Suffix of keys left unencrypted in SopsSecret resource
+	// +optional
+	UnencryptedSuffix string `json:"unencrypted_suffix,omitempty"`
+
+	// Regex of keys left unencrypted in SopsSecret resource
+	// +optional
+	UnencryptedRegex string `json:"unencrypted_regex,omitempty"`
+}
+
+// KeyGroupStatus describes whether operator credentials decrypt sops key group
+type KeyGroupStatus struct {
+	// Keys identifies master keys of the key group
+	Keys []string `json:"keys"`
+
+	// Satisfied is true when any master key of the key group is decrypted
+	Satisfied bool `json:"satisfied"`
+}
+
+// ManagedSecret describes Kubernetes secret generated from secret template
+type ManagedSecret struct {
+	// Name of the Kubernetes secret
+	Name string `json:"name"`
+
+	// Template is the name of immutable secret template the Kubernetes secret
+	// is generated from, Name is the active secret of the template
+	// +optional
+	Template string `json:"template,omitempty"`
+
+	// KeysCount is the number of data keys in the Kubernetes secret
+	KeysCount int `json:"keysCount"`
+
+	// LastSyncedHash is content hash of the Kubernetes secret written by operator
+	// +optional
+	LastSyncedHash string `json:"lastSyncedHash,omitempty"`
+
+	// LastSyncTime is the last time the Kubernetes secret was created or updated
+	// +optional
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+
+	// SSHKeyFingerprint is SHA256 fingerprint of public key of
+	// kubernetes.io/ssh-auth secret private key
+	// +optional
+	SSHKeyFingerprint string `json:"sshKeyFingerprint,omitempty"`
+}
+
+// ClusterStatus describes secrets pushed to a remote cluster
+type ClusterStatus struct {
+	// Name of the remote cluster
+	Name string `json:"name"`
+
+	// Ready is true when all secrets were pushed to the cluster
+	Ready bool `json:"ready"`
+
+	// Message describes the last push failure
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// Secrets lists names of secrets pushed to the cluster
+	// +optional
+	Secrets []string `json:"secrets,omitempty"`
+
+	// LastSyncTime is the last time a secret was created or updated in the
+	// cluster
+	// +optional
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+}
+
+// ReconcileTiming records durations of SopsSecret reconciliation phases
+type ReconcileTiming struct {
+	// Time when reconciliation finished
+	Time metav1.Time `json:"time"`
+
+	// Decryption is the duration of SopsSecret decryption including key
+	// backend calls, 0 when decrypted SopsSecret was cached
+	Decryption metav1.Duration `json:"decryption"`
+
+	// Render is the total duration of rendering secret templates
+	Render metav1.Duration `json:"render"`
+
+	// Apply is the total duration of reading and writing generated secrets
+	// with API server
+	Apply metav1.Duration `json:"apply"`
+}
+
+// SecretChange describes change of generated secret computed in dry-run mode
+type SecretChange struct {
+	// Name of the Kubernetes secret
+	Name string `json:"name"`
+
+	// Action which would be taken: Create, Update, Delete or None
+	Action string `json:"action"`
+
+	// AddedKeys lists data keys which would be added
+	// +optional
+	AddedKeys []string `json:"addedKeys,omitempty"`
+
+	// RemovedKeys lists data keys which would be removed
+	// +optional
+	RemovedKeys []string `json:"removedKeys,omitempty"`
+
+	// ChangedKeys lists data keys values of which would change
+	// +optional
+	ChangedKeys []string `json:"changedKeys,omitempty"`
 }
 
 // SopsSecretStatus defines the observed state of SopsSecret
@@ -186,6 +719,54 @@ type SopsSecretStatus struct {
 	// SopsSecret status message
 	// +optional
 	Message string `json:"message,omitempty"`
+
+	// ObservedGeneration is the last SopsSecret generation processed by operator
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// SpecHash is sha256 hash of encrypted spec and sops metadata of the last
+	// reconciled SopsSecret, it identifies encrypted content which is live
+	// without decrypting it
+	// +optional
+	SpecHash string `json:"specHash,omitempty"`
+
+	// LastForceSync is the last value of sops-secrets-operator/force-sync
+	// annotation SopsSecret was fully re-synced for
+	// +optional
+	LastForceSync string `json:"lastForceSync,omitempty"`
+
+	// Conditions represent the latest available observations of SopsSecret state
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// ManagedSecrets lists Kubernetes secrets generated from secret templates
+	// +optional
+	ManagedSecrets []ManagedSecret `json:"managedSecrets,omitempty"`
+
+	// DryRunChanges lists changes of generated secrets which would be made,
+	// set only in dry-run mode
+	// +optional
+	DryRunChanges []SecretChange `json:"dryRunChanges,omitempty"`
+
+	// KeyGroups lists sops key groups and whether operator credentials
+	// decrypt them, set when not enough key groups are decrypted
+	// +optional
+	KeyGroups []KeyGroupStatus `json:"keyGroups,omitempty"`
+
+	// Clusters lists remote clusters secrets are pushed to
+	// +optional
+	Clusters []ClusterStatus `json:"clusters,omitempty"`
+
+	// LastVerificationTime is the last time SopsSecret was verified in
+	// verify-only mode
+	// +optional
+	LastVerificationTime *metav1.Time `json:"lastVerificationTime,omitempty"`
+
+	// Timings lists durations of reconciliation phases of the last
+	// reconciliations, newest first, set only when operator runs with
+	// --enable-timing-status
+	// +optional
+	Timings []ReconcileTiming `json:"timings,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -195,6 +776,8 @@ type SopsSecretStatus struct {
 //+kubebuilder:resource:shortName=sops,scope=Namespaced
 //+kubebuilder:subresource:status
 //+kubebuilder:printcolumn:name="Status",type=string,JSONPath=`.status.message`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:storageversion
 type SopsSecret struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
@@ -5,6 +5,7 @@
 package v1alpha2
 
 import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -38,6 +39,30 @@ func (in *AzureKmsItem) DeepCopy() *AzureKmsItem {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterStatus) DeepCopyInto(out *ClusterStatus) {
+	*out = *in
+	if in.Secrets != nil {
+		in, out := &in.Secrets, &out.Secrets
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.LastSyncTime != nil {
+		in, out := &in.LastSyncTime, &out.LastSyncTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterStatus.
+func (in *ClusterStatus) DeepCopy() *ClusterStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *GcpKmsDataItem) DeepCopyInto(out *GcpKmsDataItem) {
 	*out = *in
@@ -68,6 +93,71 @@ func (in *HcVaultItem) DeepCopy() *HcVaultItem {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeyGroup) DeepCopyInto(out *KeyGroup) {
+	*out = *in
+	if in.AwsKms != nil {
+		in, out := &in.AwsKms, &out.AwsKms
+		*out = make([]KmsDataItem, len(*in))
+		copy(*out, *in)
+	}
+	if in.Pgp != nil {
+		in, out := &in.Pgp, &out.Pgp
+		*out = make([]PgpDataItem, len(*in))
+		copy(*out, *in)
+	}
+	if in.AzureKms != nil {
+		in, out := &in.AzureKms, &out.AzureKms
+		*out = make([]AzureKmsItem, len(*in))
+		copy(*out, *in)
+	}
+	if in.HcVault != nil {
+		in, out := &in.HcVault, &out.HcVault
+		*out = make([]HcVaultItem, len(*in))
+		copy(*out, *in)
+	}
+	if in.GcpKms != nil {
+		in, out := &in.GcpKms, &out.GcpKms
+		*out = make([]GcpKmsDataItem, len(*in))
+		copy(*out, *in)
+	}
+	if in.Age != nil {
+		in, out := &in.Age, &out.Age
+		*out = make([]AgeItem, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeyGroup.
+func (in *KeyGroup) DeepCopy() *KeyGroup {
+	if in == nil {
+		return nil
+	}
+	out := new(KeyGroup)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeyGroupStatus) DeepCopyInto(out *KeyGroupStatus) {
+	*out = *in
+	if in.Keys != nil {
+		in, out := &in.Keys, &out.Keys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeyGroupStatus.
+func (in *KeyGroupStatus) DeepCopy() *KeyGroupStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(KeyGroupStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *KmsDataItem) DeepCopyInto(out *KmsDataItem) {
 	*out = *in
@@ -83,6 +173,25 @@ func (in *KmsDataItem) DeepCopy() *KmsDataItem {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ManagedSecret) DeepCopyInto(out *ManagedSecret) {
+	*out = *in
+	if in.LastSyncTime != nil {
+		in, out := &in.LastSyncTime, &out.LastSyncTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ManagedSecret.
+func (in *ManagedSecret) DeepCopy() *ManagedSecret {
+	if in == nil {
+		return nil
+	}
+	out := new(ManagedSecret)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PgpDataItem) DeepCopyInto(out *PgpDataItem) {
 	*out = *in
@@ -98,6 +207,161 @@ func (in *PgpDataItem) DeepCopy() *PgpDataItem {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReconcileTiming) DeepCopyInto(out *ReconcileTiming) {
+	*out = *in
+	in.Time.DeepCopyInto(&out.Time)
+	out.Decryption = in.Decryption
+	out.Render = in.Render
+	out.Apply = in.Apply
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReconcileTiming.
+func (in *ReconcileTiming) DeepCopy() *ReconcileTiming {
+	if in == nil {
+		return nil
+	}
+	out := new(ReconcileTiming)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SealedSecretValue) DeepCopyInto(out *SealedSecretValue) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SealedSecretValue.
+func (in *SealedSecretValue) DeepCopy() *SealedSecretValue {
+	if in == nil {
+		return nil
+	}
+	out := new(SealedSecretValue)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretChange) DeepCopyInto(out *SecretChange) {
+	*out = *in
+	if in.AddedKeys != nil {
+		in, out := &in.AddedKeys, &out.AddedKeys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RemovedKeys != nil {
+		in, out := &in.RemovedKeys, &out.RemovedKeys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ChangedKeys != nil {
+		in, out := &in.ChangedKeys, &out.ChangedKeys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretChange.
+func (in *SecretChange) DeepCopy() *SecretChange {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretChange)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretKeySelector) DeepCopyInto(out *SecretKeySelector) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretKeySelector.
+func (in *SecretKeySelector) DeepCopy() *SecretKeySelector {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretKeySelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretTemplateDockerConfig) DeepCopyInto(out *SecretTemplateDockerConfig) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretTemplateDockerConfig.
+func (in *SecretTemplateDockerConfig) DeepCopy() *SecretTemplateDockerConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretTemplateDockerConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretTemplateFile) DeepCopyInto(out *SecretTemplateFile) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretTemplateFile.
+func (in *SecretTemplateFile) DeepCopy() *SecretTemplateFile {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretTemplateFile)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretTemplateValue) DeepCopyInto(out *SecretTemplateValue) {
+	*out = *in
+	in.ValueFrom.DeepCopyInto(&out.ValueFrom)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretTemplateValue.
+func (in *SecretTemplateValue) DeepCopy() *SecretTemplateValue {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretTemplateValue)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretTemplateValueSource) DeepCopyInto(out *SecretTemplateValueSource) {
+	*out = *in
+	if in.SopsSecretRef != nil {
+		in, out := &in.SopsSecretRef, &out.SopsSecretRef
+		*out = new(SopsSecretKeySelector)
+		**out = **in
+	}
+	if in.SealedSecret != nil {
+		in, out := &in.SealedSecret, &out.SealedSecret
+		*out = new(SealedSecretValue)
+		**out = **in
+	}
+	if in.SecretKeyRef != nil {
+		in, out := &in.SecretKeyRef, &out.SecretKeyRef
+		*out = new(SecretKeySelector)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretTemplateValueSource.
+func (in *SecretTemplateValueSource) DeepCopy() *SecretTemplateValueSource {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretTemplateValueSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SopsMetadata) DeepCopyInto(out *SopsMetadata) {
 	*out = *in
@@ -131,6 +395,13 @@ func (in *SopsMetadata) DeepCopyInto(out *SopsMetadata) {
 		*out = make([]AgeItem, len(*in))
 		copy(*out, *in)
 	}
+	if in.KeyGroups != nil {
+		in, out := &in.KeyGroups, &out.KeyGroups
+		*out = make([]KeyGroup, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SopsMetadata.
@@ -149,7 +420,7 @@ func (in *SopsSecret) DeepCopyInto(out *SopsSecret) {
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 	in.Sops.DeepCopyInto(&out.Sops)
 }
 
@@ -171,6 +442,21 @@ func (in *SopsSecret) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SopsSecretKeySelector) DeepCopyInto(out *SopsSecretKeySelector) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SopsSecretKeySelector.
+func (in *SopsSecretKeySelector) DeepCopy() *SopsSecretKeySelector {
+	if in == nil {
+		return nil
+	}
+	out := new(SopsSecretKeySelector)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SopsSecretList) DeepCopyInto(out *SopsSecretList) {
 	*out = *in
@@ -213,6 +499,23 @@ func (in *SopsSecretSpec) DeepCopyInto(out *SopsSecretSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.RawSecrets != nil {
+		in, out := &in.RawSecrets, &out.RawSecrets
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Files != nil {
+		in, out := &in.Files, &out.Files
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.RefreshInterval != nil {
+		in, out := &in.RefreshInterval, &out.RefreshInterval
+		*out = new(v1.Duration)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SopsSecretSpec.
@@ -228,6 +531,52 @@ func (in *SopsSecretSpec) DeepCopy() *SopsSecretSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SopsSecretStatus) DeepCopyInto(out *SopsSecretStatus) {
 	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ManagedSecrets != nil {
+		in, out := &in.ManagedSecrets, &out.ManagedSecrets
+		*out = make([]ManagedSecret, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.DryRunChanges != nil {
+		in, out := &in.DryRunChanges, &out.DryRunChanges
+		*out = make([]SecretChange, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.KeyGroups != nil {
+		in, out := &in.KeyGroups, &out.KeyGroups
+		*out = make([]KeyGroupStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.LastVerificationTime != nil {
+		in, out := &in.LastVerificationTime, &out.LastVerificationTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Timings != nil {
+		in, out := &in.Timings, &out.Timings
+		*out = make([]ReconcileTiming, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Clusters != nil {
+		in, out := &in.Clusters, &out.Clusters
+		*out = make([]ClusterStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SopsSecretStatus.
@@ -250,6 +599,13 @@ func (in *SopsSecretTemplate) DeepCopyInto(out *SopsSecretTemplate) {
 			(*out)[key] = val
 		}
 	}
+	if in.AnnotationsFromEncrypted != nil {
+		in, out := &in.AnnotationsFromEncrypted, &out.AnnotationsFromEncrypted
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 	if in.Labels != nil {
 		in, out := &in.Labels, &out.Labels
 		*out = make(map[string]string, len(*in))
@@ -271,6 +627,38 @@ func (in *SopsSecretTemplate) DeepCopyInto(out *SopsSecretTemplate) {
 			(*out)[key] = val
 		}
 	}
+	if in.Files != nil {
+		in, out := &in.Files, &out.Files
+		*out = make([]SecretTemplateFile, len(*in))
+		copy(*out, *in)
+	}
+	if in.Values != nil {
+		in, out := &in.Values, &out.Values
+		*out = make([]SecretTemplateValue, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.DockerConfig != nil {
+		in, out := &in.DockerConfig, &out.DockerConfig
+		*out = new(SecretTemplateDockerConfig)
+		**out = **in
+	}
+	if in.RevisionHistoryLimit != nil {
+		in, out := &in.RevisionHistoryLimit, &out.RevisionHistoryLimit
+		*out = new(int32)
+		**out = **in
+	}
+	if in.TargetNamespaces != nil {
+		in, out := &in.TargetNamespaces, &out.TargetNamespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.TargetNamespaceSelector != nil {
+		in, out := &in.TargetNamespaceSelector, &out.TargetNamespaceSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SopsSecretTemplate.
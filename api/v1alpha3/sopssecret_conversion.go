@@ -0,0 +1,123 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package v1alpha3
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	"github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// DataKeysAnnotation records keys of v1alpha3 secret template data, which
+// are stored in v1alpha2 binary data together with binary data keys, by
+// secret template index, so they are converted back to data
+const DataKeysAnnotation = "isindir.github.com/v1alpha3-data-keys"
+
+// ConvertTo converts v1alpha3 SopsSecret to v1alpha2 hub. Secret template
+// string data becomes v1alpha2 data, data and binary data become v1alpha2
+// binary data, values are moved as is, as they may be encrypted.
+func (src *SopsSecret) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1alpha2.SopsSecret)
+	if err := convertJSON(src, dst); err != nil {
+		return err
+	}
+	dst.APIVersion = v1alpha2.GroupVersion.String()
+
+	dataKeys := make(map[int][]string)
+	for i := range src.Spec.SecretsTemplate {
+		srcTemplate := &src.Spec.SecretsTemplate[i]
+		dstTemplate := &dst.Spec.SecretsTemplate[i]
+		dstTemplate.Data = srcTemplate.StringData
+		dstTemplate.BinaryData = nil
+		if len(srcTemplate.Data) == 0 && len(srcTemplate.BinaryData) == 0 {
+			continue
+		}
+
+		dstTemplate.BinaryData = make(map[string]string, len(srcTemplate.Data)+len(srcTemplate.BinaryData))
+		for key, value := range srcTemplate.BinaryData {
+			dstTemplate.BinaryData[key] = value
+		}
+		for key, value := range srcTemplate.Data {
+			dstTemplate.BinaryData[key] = value
+			dataKeys[i] = append(dataKeys[i], key)
+		}
+		sort.Strings(dataKeys[i])
+	}
+
+	delete(dst.Annotations, DataKeysAnnotation)
+	if len(dataKeys) > 0 {
+		annotation, err := json.Marshal(dataKeys)
+		if err != nil {
+			return err
+		}
+		if dst.Annotations == nil {
+			dst.Annotations = make(map[string]string)
+		}
+		dst.Annotations[DataKeysAnnotation] = string(annotation)
+	}
+	return nil
+}
+
+// ConvertFrom converts v1alpha2 hub SopsSecret to v1alpha3, binary data keys
+// recorded in DataKeysAnnotation become data again
+func (dst *SopsSecret) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1alpha2.SopsSecret)
+	if err := convertJSON(src, dst); err != nil {
+		return err
+	}
+	dst.APIVersion = GroupVersion.String()
+
+	var dataKeys map[int][]string
+	if annotation, ok := src.Annotations[DataKeysAnnotation]; ok {
+		if err := json.Unmarshal([]byte(annotation), &dataKeys); err != nil {
+			return fmt.Errorf("annotation %s: %w", DataKeysAnnotation, err)
+		}
+		delete(dst.Annotations, DataKeysAnnotation)
+		if len(dst.Annotations) == 0 {
+			dst.Annotations = nil
+		}
+	}
+
+	for i := range src.Spec.SecretsTemplate {
+		srcTemplate := &src.Spec.SecretsTemplate[i]
+		dstTemplate := &dst.Spec.SecretsTemplate[i]
+		dstTemplate.StringData = srcTemplate.Data
+		dstTemplate.Data = nil
+		dstTemplate.BinaryData = nil
+
+		isData := make(map[string]bool, len(dataKeys[i]))
+		for _, key := range dataKeys[i] {
+			isData[key] = true
+		}
+		for key, value := range srcTemplate.BinaryData {
+			if isData[key] {
+				if dstTemplate.Data == nil {
+					dstTemplate.Data = make(map[string]string)
+				}
+				dstTemplate.Data[key] = value
+				continue
+			}
+			if dstTemplate.BinaryData == nil {
+				dstTemplate.BinaryData = make(map[string]string)
+			}
+			dstTemplate.BinaryData[key] = value
+		}
+	}
+	return nil
+}
+
+// convertJSON copies fields which have the same name and meaning in both
+// versions, so only renamed fields need explicit conversion
+func convertJSON(src interface{}, dst interface{}) error {
+	data, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dst)
+}
@@ -0,0 +1,289 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package v1alpha3
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
+// For upstream reference, see https://github.com/mozilla/sops/blob/master/stores/stores.go
+
+// Only secret templates differ from v1alpha2, other types, conditions and
+// enum values are shared with v1alpha2
+
+// SopsSecretTemplate defines the map of secrets to create
+type SopsSecretTemplate struct {
+	// Name of the Kubernetes secret to create
+	Name string `json:"name"`
+
+	// NameTemplate is Go template of the Kubernetes secret name, which
+	// overrides name. Variables are {{ .SopsSecretName }} and {{ .Namespace }}
+	// +optional
+	NameTemplate string `json:"nameTemplate,omitempty"`
+
+	// Annotations to apply to Kubernetes secret
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// AnnotationsFromEncrypted maps annotation names to data keys of the
+	// generated secret, decrypted values of the keys are copied to the
+	// annotations for third-party controllers which read credentials from
+	// annotations. Annotations are not protected like secret data, they show
+	// in kubectl describe, audit logs and tools treating metadata as not
+	// sensitive, so acknowledgeAnnotationExposure must be set as well
+	// +optional
+	AnnotationsFromEncrypted map[string]string `json:"annotationsFromEncrypted,omitempty"`
+
+	// AcknowledgeAnnotationExposure confirms that values copied by
+	// annotationsFromEncrypted are exposed in secret metadata. Must not be
+	// encrypted
+	// +optional
+	AcknowledgeAnnotationExposure bool `json:"acknowledgeAnnotationExposure,omitempty"`
+
+	// Labels to apply to Kubernetes secret
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Kubernetes secret type. Default: Opaque. Possible values: Opaque,
+	// kubernetes.io/service-account-token, kubernetes.io/dockercfg,
+	// kubernetes.io/dockerconfigjson, kubernetes.io/basic-auth,
+	// kubernetes.io/ssh-auth, kubernetes.io/tls, bootstrap.kubernetes.io/token
+	// +optional
+	Type string `json:"type,omitempty"`
+
+	// StringData is map of plain text values to use in Kubernetes secret
+	// +optional
+	StringData map[string]string `json:"stringData,omitempty"`
+
+	// Data is map of base64 encoded values to use in Kubernetes secret, it
+	// has the same meaning as data of Kubernetes secret
+	// +optional
+	Data map[string]string `json:"data,omitempty"`
+
+	// BinaryData is map of base64 encoded values to use in Kubernetes
+	// secret, it has the same name and meaning as v1alpha2 binaryData, so
+	// encrypted binaryData is kept when SopsSecret moves to v1alpha3. Keys
+	// must not be repeated in data
+	// +optional
+	BinaryData map[string]string `json:"binaryData,omitempty"`
+
+	// Files maps secret data keys to files of SopsSecret spec.files
+	// +optional
+	Files []v1alpha2.SecretTemplateFile `json:"files,omitempty"`
+
+	// Values maps secret data keys to values of other sources, such as
+	// decrypted values of other SopsSecrets
+	// +optional
+	Values []v1alpha2.SecretTemplateValue `json:"values,omitempty"`
+
+	// DockerConfig assembles .dockerconfigjson key of image pull secret from
+	// registry credentials, secret type defaults to
+	// kubernetes.io/dockerconfigjson
+	// +optional
+	DockerConfig *v1alpha2.SecretTemplateDockerConfig `json:"dockerConfig,omitempty"`
+
+	// HtpasswdKey is data key of kubernetes.io/basic-auth secret to emit
+	// htpasswd entry with bcrypt hashed password to, such as auth for ingress
+	// authentication
+	// +optional
+	HtpasswdKey string `json:"htpasswdKey,omitempty"`
+
+	// Immutable creates immutable Kubernetes secret named after the template
+	// with content hash suffix, new secret is created when content changes
+	// +optional
+	Immutable bool `json:"immutable,omitempty"`
+
+	// RevisionHistoryLimit is the number of previous immutable secrets to
+	// retain. Default: 1
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
+
+	// Templated enables rendering of string data values as go templates, with
+	// access to decrypted values of other string data keys
+	// +optional
+	Templated bool `json:"templated,omitempty"`
+
+	// Expand treats every string data value as SOPS encrypted YAML document
+	// and turns each top level key of the decrypted document into separate
+	// secret data key
+	// +optional
+	Expand bool `json:"expand,omitempty"`
+
+	// Format is format of SOPS encrypted documents in string data values, setting
+	// it expands documents as Expand does. Default: yaml.
+	// +kubebuilder:validation:Enum=yaml;json;dotenv;ini
+	// +optional
+	Format string `json:"format,omitempty"`
+
+	// TargetNamespaces lists additional namespaces to replicate the secret to
+	// +optional
+	TargetNamespaces []string `json:"targetNamespaces,omitempty"`
+
+	// TargetNamespaceSelector selects additional namespaces to replicate the
+	// secret to
+	// +optional
+	TargetNamespaceSelector *metav1.LabelSelector `json:"targetNamespaceSelector,omitempty"`
+
+	// TakeOwnership allows adopting already existing secret which is not
+	// owned by any controller, by default such secret is left untouched
+	// and Conflict condition is set
+	// +optional
+	TakeOwnership bool `json:"takeOwnership,omitempty"`
+
+	// Cluster is the name of operator configured remote cluster the secret is
+	// pushed to instead of the SopsSecret cluster, the secret is created in
+	// the namespace of the same name as SopsSecret namespace
+	// +optional
+	Cluster string `json:"cluster,omitempty"`
+
+	// UpdateStrategy defines how existing secret is updated. Default: Patch.
+	// Patch applies changed fields and preserves data keys added by other
+	// actors, Recreate deletes and creates the secret again whenever it
+	// differs from the rendered one, including additional data keys
+	// +optional
+	UpdateStrategy v1alpha2.UpdateStrategy `json:"updateStrategy,omitempty"`
+
+	// MergePolicy defines what happens to data keys previously rendered from
+	// the template which are removed from it. Default: Replace. Replace
+	// removes them from the secret, Merge keeps them with their last values.
+	// Data keys added by other actors are preserved by both
+	// +optional
+	MergePolicy v1alpha2.MergePolicy `json:"mergePolicy,omitempty"`
+}
+
+// SopsSecretSpec defines the desired state of SopsSecret
+type SopsSecretSpec struct {
+	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
+	// Important: Run "make" to regenerate code after modifying this file
+
+	// Secrets template is a list of definitions to create Kubernetes Secrets,
+	// it may be empty only when raw secrets are set
+	// +optional
+	SecretsTemplate []SopsSecretTemplate `json:"secretTemplates,omitempty"`
+
+	// RawSecrets are whole Kubernetes Secret manifests, each encrypted by SOPS
+	// as a separate YAML document, which are applied as they are
+	// +optional
+	RawSecrets []string `json:"rawSecrets,omitempty"`
+
+	// Files holds whole files, such as certificates or configuration files,
+	// referenced by secret templates files, so multi-line content does not
+	// have to be escaped in template data
+	// +optional
+	Files map[string]string `json:"files,omitempty"`
+
+	// AwsRoleARN is AWS IAM role to assume via STS to decrypt AWS KMS data key,
+	// overrides role specified in sops metadata. Must not be encrypted.
+	// +optional
+	AwsRoleARN string `json:"awsRoleARN,omitempty"`
+
+	// GcpServiceAccount is GCP service account email to impersonate to decrypt
+	// GCP KMS data key. Must not be encrypted.
+	// +optional
+	GcpServiceAccount string `json:"gcpServiceAccount,omitempty"`
+
+	// AzureIdentity is client ID of Azure user-assigned managed identity to use
+	// to decrypt Azure Key Vault data key. Must not be encrypted.
+	// +optional
+	AzureIdentity string `json:"azureIdentity,omitempty"`
+
+	// KeyProfile is the name of operator key profile, credentials of which
+	// are used to decrypt SopsSecret instead of operator credentials. Must
+	// not be encrypted.
+	// +optional
+	KeyProfile string `json:"keyProfile,omitempty"`
+
+	// VaultNamespace is Vault Enterprise namespace of transit keys, overrides
+	// operator default namespace. Must not be encrypted.
+	// +optional
+	VaultNamespace string `json:"vaultNamespace,omitempty"`
+
+	// VaultProfile is the name of operator Vault profile used to decrypt
+	// Vault transit data keys instead of operator Vault connection, it
+	// overrides sops-secrets-operator/vault-profile annotation of the
+	// namespace. Must not be encrypted.
+	// +optional
+	VaultProfile string `json:"vaultProfile,omitempty"`
+
+	// Suspend pauses reconciliation of SopsSecret, managed secrets are left as is
+	// +optional
+	Suspend bool `json:"suspend,omitempty"`
+
+	// VerifyOnly checks SOPS MAC and decryptability of SopsSecret on every
+	// reconciliation without generating any secrets, the result is recorded in
+	// Verified condition. Secrets generated before are left as is. Must not be
+	// encrypted.
+	// +optional
+	VerifyOnly bool `json:"verifyOnly,omitempty"`
+
+	// OnTemplateError defines how secret template failures are handled. Default: FailAll.
+	// FailAll stops on the first failed template, ApplyValid applies all valid
+	// templates and reports failed ones in status conditions.
+	// +optional
+	OnTemplateError v1alpha2.OnTemplateError `json:"onTemplateError,omitempty"`
+
+	// RefreshInterval is how often successfully reconciled SopsSecret is
+	// reconciled again to repair drift of generated secrets, overrides
+	// operator --requeue-success-after flag. Must not be encrypted.
+	// +optional
+	RefreshInterval *metav1.Duration `json:"refreshInterval,omitempty"`
+
+	// DeletionPolicy defines what happens to generated secrets when SopsSecret
+	// is deleted. Default: Delete. Delete removes generated secrets, Orphan
+	// leaves them in place, Retain leaves them in place with operator
+	// annotations stripped. Must not be encrypted.
+	// +optional
+	DeletionPolicy v1alpha2.DeletionPolicy `json:"deletionPolicy,omitempty"`
+
+	// Priority orders reconciliation of SopsSecrets waiting in the work
+	// queue, for example after operator restart, SopsSecrets with higher
+	// priority are reconciled first. Must not be encrypted.
+	// +optional
+	Priority int32 `json:"priority,omitempty"`
+
+	// ServiceAccountName is the name of service account in SopsSecret
+	// namespace the operator impersonates when it creates and updates
+	// generated secrets, so secrets are written with permissions of the
+	// service account instead of operator permissions. Must not be encrypted.
+	// +optional
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// SopsSecret is the Schema for the sopssecrets API
+//+kubebuilder:resource:shortName=sops,scope=Namespaced
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Status",type=string,JSONPath=`.status.message`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+type SopsSecret struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// SopsSecret Spec definition
+	Spec SopsSecretSpec `json:"spec,omitempty"`
+	// SopsSecret Status information
+	Status v1alpha2.SopsSecretStatus `json:"status,omitempty"`
+	// SopsSecret metadata
+	Sops v1alpha2.SopsMetadata `json:"sops,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// SopsSecretList contains a list of SopsSecret
+type SopsSecretList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []SopsSecret `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&SopsSecret{}, &SopsSecretList{})
+}
@@ -0,0 +1,233 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mozilla.org/sops/v3"
+	sopsaes "go.mozilla.org/sops/v3/aes"
+	"go.mozilla.org/sops/v3/age"
+	"go.mozilla.org/sops/v3/azkv"
+	"go.mozilla.org/sops/v3/gcpkms"
+	"go.mozilla.org/sops/v3/hcvault"
+	"go.mozilla.org/sops/v3/keys"
+	"go.mozilla.org/sops/v3/keyservice"
+	"go.mozilla.org/sops/v3/kms"
+	"go.mozilla.org/sops/v3/pgp"
+	sopsyaml "go.mozilla.org/sops/v3/stores/yaml"
+	"go.mozilla.org/sops/v3/version"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/isindir/sops-secrets-operator/controllers"
+)
+
+// recipients holds comma separated master keys by sops key type, keys of
+// recipients ConfigMap have the same names
+type recipients struct {
+	Age            string
+	Pgp            string
+	Kms            string
+	GcpKms         string
+	AzureKv        string
+	HcVaultTransit string
+}
+
+func encryptCommand(args []string) error {
+	var file string
+	var verbose bool
+	var flagRecipients recipients
+	var recipientsConfigMap string
+	var encryptedSuffix string
+	flags := newFlagSet("encrypt", &file, &verbose)
+	flags.StringVar(&flagRecipients.Age, "age", "", "Comma separated age recipients.")
+	flags.StringVar(&flagRecipients.Pgp, "pgp", "", "Comma separated PGP fingerprints.")
+	flags.StringVar(&flagRecipients.Kms, "kms", "", "Comma separated AWS KMS key ARNs.")
+	flags.StringVar(&flagRecipients.GcpKms, "gcp-kms", "", "Comma separated GCP KMS key resource IDs.")
+	flags.StringVar(&flagRecipients.AzureKv, "azure-kv", "", "Comma separated Azure Key Vault key URLs.")
+	flags.StringVar(&flagRecipients.HcVaultTransit, "hc-vault-transit", "", "Comma separated Vault transit key URIs.")
+	flags.StringVar(&recipientsConfigMap, "recipients-configmap", "", "ConfigMap with cluster recipients in namespace/name format, read from the current kubeconfig context.")
+	flags.StringVar(&encryptedSuffix, "encrypted-suffix", "Templates", "Suffix of keys to encrypt.")
+	flags.Parse(args)
+	log := newLogger(verbose)
+
+	documents, err := readDocuments(file)
+	if err != nil {
+		return err
+	}
+
+	all := flagRecipients
+	if recipientsConfigMap != "" {
+		clusterRecipients, err := readRecipients(recipientsConfigMap)
+		if err != nil {
+			return err
+		}
+		all = clusterRecipients.merge(flagRecipients)
+	}
+	group, err := all.keyGroup()
+	if err != nil {
+		return err
+	}
+	if len(group) == 0 {
+		return fmt.Errorf("no recipients, use recipient flags or --recipients-configmap")
+	}
+
+	for i, document := range documents {
+		instance, err := controllers.DecodeSopsSecret(document)
+		if err != nil {
+			return fmt.Errorf("document %d: %v", i+1, err)
+		}
+		if encrypted(instance) {
+			return fmt.Errorf("document %d: manifest is already encrypted", i+1)
+		}
+
+		log.Info("Encrypting", "document", i+1, "sopssecret", instance.Name)
+		encryptedDocument, err := encryptDocument(document, group, encryptedSuffix, len(instance.Spec.Files) > 0)
+		if err != nil {
+			return fmt.Errorf("document %d: %v", i+1, err)
+		}
+		if i > 0 {
+			fmt.Println("---")
+		}
+		os.Stdout.Write(encryptedDocument)
+	}
+	return nil
+}
+
+// readRecipients reads recipients ConfigMap from the cluster
+func readRecipients(name string) (recipients, error) {
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) != 2 {
+		return recipients{}, fmt.Errorf("expected namespace/name, got %q", name)
+	}
+	c, _, err := kubeClient()
+	if err != nil {
+		return recipients{}, err
+	}
+	configMap := &corev1.ConfigMap{}
+	if err := c.Get(context.Background(), types.NamespacedName{Namespace: parts[0], Name: parts[1]}, configMap); err != nil {
+		return recipients{}, err
+	}
+	return recipients{
+		Age:            configMap.Data["age"],
+		Pgp:            configMap.Data["pgp"],
+		Kms:            configMap.Data["kms"],
+		GcpKms:         configMap.Data["gcp_kms"],
+		AzureKv:        configMap.Data["azure_kv"],
+		HcVaultTransit: configMap.Data["hc_vault_transit"],
+	}, nil
+}
+
+// merge returns recipients with additional recipients appended
+func (r recipients) merge(other recipients) recipients {
+	join := func(a, b string) string {
+		if a == "" || b == "" {
+			return a + b
+		}
+		return a + "," + b
+	}
+	return recipients{
+		Age:            join(r.Age, other.Age),
+		Pgp:            join(r.Pgp, other.Pgp),
+		Kms:            join(r.Kms, other.Kms),
+		GcpKms:         join(r.GcpKms, other.GcpKms),
+		AzureKv:        join(r.AzureKv, other.AzureKv),
+		HcVaultTransit: join(r.HcVaultTransit, other.HcVaultTransit),
+	}
+}
+
+// keyGroup returns sops master keys of recipients
+func (r recipients) keyGroup() (sops.KeyGroup, error) {
+	var group sops.KeyGroup
+	if r.Age != "" {
+		ageKeys, err := age.MasterKeysFromRecipients(r.Age)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range ageKeys {
+			group = append(group, k)
+		}
+	}
+	if r.Pgp != "" {
+		for _, k := range pgp.MasterKeysFromFingerprintString(r.Pgp) {
+			group = append(group, k)
+		}
+	}
+	if r.Kms != "" {
+		for _, k := range kms.MasterKeysFromArnString(r.Kms, nil, "") {
+			group = append(group, k)
+		}
+	}
+	if r.GcpKms != "" {
+		for _, k := range gcpkms.MasterKeysFromResourceIDString(r.GcpKms) {
+			group = append(group, k)
+		}
+	}
+	if r.AzureKv != "" {
+		azureKeys, err := azkv.MasterKeysFromURLs(r.AzureKv)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range azureKeys {
+			group = append(group, k)
+		}
+	}
+	if r.HcVaultTransit != "" {
+		vaultKeys, err := hcvault.NewMasterKeysFromURIs(r.HcVaultTransit)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range vaultKeys {
+			group = append(group, k)
+		}
+	}
+	return group, nil
+}
+
+// encryptDocument encrypts values of keys with encrypted suffix, the same
+// way as sops --encrypt --encrypted-suffix. Operator refuses plain text
+// spec.files, so with encryptFiles files are encrypted as well with
+// equivalent --encrypted-regex
+func encryptDocument(document []byte, group []keys.MasterKey, encryptedSuffix string, encryptFiles bool) ([]byte, error) {
+	store := &sopsyaml.Store{}
+	branches, err := store.LoadPlainFile(document)
+	if err != nil {
+		return nil, err
+	}
+	tree := sops.Tree{
+		Branches: branches,
+		Metadata: sops.Metadata{
+			KeyGroups:       []sops.KeyGroup{group},
+			EncryptedSuffix: encryptedSuffix,
+			Version:         version.Version,
+		},
+	}
+	if encryptFiles {
+		tree.Metadata.EncryptedSuffix = ""
+		tree.Metadata.EncryptedRegex = "(" + regexp.QuoteMeta(encryptedSuffix) + "|^files)$"
+	}
+
+	dataKey, errs := tree.GenerateDataKeyWithKeyServices([]keyservice.KeyServiceClient{keyservice.NewLocalClient()})
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("could not encrypt data key: %v", errs)
+	}
+	cipher := sopsaes.NewCipher()
+	mac, err := tree.Encrypt(dataKey, cipher)
+	if err != nil {
+		return nil, err
+	}
+	tree.Metadata.LastModified = time.Now().UTC()
+	tree.Metadata.MessageAuthenticationCode, err = cipher.Encrypt(mac, dataKey, tree.Metadata.LastModified.Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+	return store.EmitEncryptedFile(tree)
+}
@@ -0,0 +1,209 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+	"github.com/isindir/sops-secrets-operator/controllers"
+)
+
+var secretTypes = map[string]bool{
+	"":                                    true,
+	"Opaque":                              true,
+	"kubernetes.io/service-account-token": true,
+	"kubernetes.io/dockercfg":             true,
+	"kubernetes.io/dockerconfigjson":      true,
+	"kubernetes.io/basic-auth":            true,
+	"kubernetes.io/ssh-auth":              true,
+	"kubernetes.io/tls":                   true,
+	"bootstrap.kubernetes.io/token":       true,
+}
+
+func lintCommand(args []string) error {
+	var file string
+	var verbose bool
+	var serverDryRun bool
+	flags := newFlagSet("lint", &file, &verbose)
+	flags.BoolVar(&serverDryRun, "server-dry-run", false, "Also create manifests with server side dry-run in the current kubeconfig context.")
+	flags.Parse(args)
+	log := newLogger(verbose)
+
+	documents, err := readDocuments(file)
+	if err != nil {
+		return err
+	}
+
+	var c client.Client
+	var namespace string
+	if serverDryRun {
+		if c, namespace, err = kubeClient(); err != nil {
+			return err
+		}
+	}
+
+	invalid := 0
+	for i, document := range documents {
+		var problems []string
+		instance, err := controllers.DecodeSopsSecret(document)
+		if err != nil {
+			problems = append(problems, err.Error())
+		} else {
+			problems = lintSopsSecret(instance)
+			if !encrypted(instance) {
+				fmt.Printf("document %d: warning: manifest is not encrypted\n", i+1)
+			}
+		}
+		if len(problems) == 0 && serverDryRun {
+			log.Info("Creating with server side dry-run", "document", i+1)
+			if err := dryRunCreate(c, namespace, document); err != nil {
+				problems = append(problems, err.Error())
+			}
+		}
+
+		for _, problem := range problems {
+			fmt.Printf("document %d: %s\n", i+1, problem)
+		}
+		if len(problems) > 0 {
+			invalid++
+		}
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d documents are invalid", invalid, len(documents))
+	}
+	fmt.Fprintf(os.Stderr, "%d documents are valid\n", len(documents))
+	return nil
+}
+
+// lintSopsSecret checks SopsSecret against CRD validation rules and rules
+// enforced by the operator during reconciliation
+func lintSopsSecret(instance *isindirv1alpha2.SopsSecret) []string {
+	var problems []string
+	if instance.Name == "" {
+		problems = append(problems, "metadata.name is required")
+	}
+	if len(instance.Spec.SecretsTemplate) == 0 && len(instance.Spec.RawSecrets) == 0 {
+		problems = append(problems, "spec.secretTemplates or spec.rawSecrets must have at least 1 item")
+	}
+	switch instance.Spec.OnTemplateError {
+	case "", isindirv1alpha2.FailAllOnTemplateError, isindirv1alpha2.ApplyValidOnTemplateError:
+	default:
+		problems = append(problems, fmt.Sprintf("spec.onTemplateError %q is not one of FailAll, ApplyValid", instance.Spec.OnTemplateError))
+	}
+	switch instance.Spec.DeletionPolicy {
+	case "", isindirv1alpha2.DeleteDeletionPolicy, isindirv1alpha2.OrphanDeletionPolicy, isindirv1alpha2.RetainDeletionPolicy:
+	default:
+		problems = append(problems, fmt.Sprintf("spec.deletionPolicy %q is not one of Delete, Orphan, Retain", instance.Spec.DeletionPolicy))
+	}
+
+	for path, content := range instance.Spec.Files {
+		if !isEncryptedValue(content) {
+			problems = append(problems, fmt.Sprintf("spec.files[%s] is not encrypted by sops", path))
+		}
+	}
+
+	names := make(map[string]bool)
+	for i := range instance.Spec.SecretsTemplate {
+		tpl := &instance.Spec.SecretsTemplate[i]
+		field := fmt.Sprintf("spec.secretTemplates[%d]", i)
+		if tpl.Name == "" {
+			problems = append(problems, field+".name is required")
+		} else if names[tpl.Name] {
+			problems = append(problems, fmt.Sprintf("%s.name %q is duplicated", field, tpl.Name))
+		}
+		names[tpl.Name] = true
+
+		if !secretTypes[tpl.Type] && !isEncryptedValue(tpl.Type) {
+			problems = append(problems, fmt.Sprintf("%s.type %q is not supported", field, tpl.Type))
+		}
+		for key, value := range tpl.BinaryData {
+			if isEncryptedValue(value) {
+				continue
+			}
+			if _, err := base64.StdEncoding.DecodeString(value); err != nil {
+				problems = append(problems, fmt.Sprintf("%s.binaryData[%s] is not valid base64", field, key))
+			}
+		}
+		switch tpl.UpdateStrategy {
+		case "", isindirv1alpha2.PatchUpdateStrategy, isindirv1alpha2.RecreateUpdateStrategy:
+		default:
+			if !isEncryptedValue(string(tpl.UpdateStrategy)) {
+				problems = append(problems, fmt.Sprintf("%s.updateStrategy %q is not one of Patch, Recreate", field, tpl.UpdateStrategy))
+			}
+		}
+		switch tpl.MergePolicy {
+		case "", isindirv1alpha2.ReplaceMergePolicy, isindirv1alpha2.MergeMergePolicy:
+		default:
+			if !isEncryptedValue(string(tpl.MergePolicy)) {
+				problems = append(problems, fmt.Sprintf("%s.mergePolicy %q is not one of Replace, Merge", field, tpl.MergePolicy))
+			}
+		}
+		if len(tpl.AnnotationsFromEncrypted) > 0 && !tpl.AcknowledgeAnnotationExposure {
+			problems = append(problems, field+".annotationsFromEncrypted requires acknowledgeAnnotationExposure")
+		}
+		for name := range tpl.AnnotationsFromEncrypted {
+			if _, ok := tpl.Annotations[name]; ok {
+				problems = append(problems, fmt.Sprintf("%s.annotationsFromEncrypted[%s] is already defined in annotations", field, name))
+			}
+		}
+		if tpl.RevisionHistoryLimit != nil && *tpl.RevisionHistoryLimit < 0 {
+			problems = append(problems, field+".revisionHistoryLimit must be greater than or equal to 0")
+		}
+		if tpl.TargetNamespaceSelector != nil {
+			if _, err := metav1.LabelSelectorAsSelector(tpl.TargetNamespaceSelector); err != nil {
+				problems = append(problems, fmt.Sprintf("%s.targetNamespaceSelector: %v", field, err))
+			}
+		}
+		for j, file := range tpl.Files {
+			if isEncryptedValue(file.Path) {
+				continue
+			}
+			if _, ok := instance.Spec.Files[file.Path]; !ok {
+				problems = append(problems, fmt.Sprintf("%s.files[%d].path %q is not defined in spec.files", field, j, file.Path))
+			}
+		}
+	}
+	return problems
+}
+
+func isEncryptedValue(value string) bool {
+	return strings.HasPrefix(value, "ENC[")
+}
+
+// dryRunCreate creates manifest with server side dry-run, API server
+// validates it against the installed CRD
+func dryRunCreate(c client.Client, namespace string, document []byte) error {
+	obj := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal(document, &obj.Object); err != nil {
+		return err
+	}
+	if obj.GetNamespace() == "" {
+		obj.SetNamespace(namespace)
+	}
+	err := c.Create(context.Background(), obj, client.DryRunAll)
+	if !errors.IsAlreadyExists(err) {
+		return err
+	}
+
+	// validate as update of the existing SopsSecret
+	existing := &unstructured.Unstructured{}
+	existing.SetGroupVersionKind(obj.GroupVersionKind())
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(obj), existing); err != nil {
+		return err
+	}
+	obj.SetResourceVersion(existing.GetResourceVersion())
+	return c.Update(context.Background(), obj, client.DryRunAll)
+}
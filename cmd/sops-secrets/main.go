@@ -0,0 +1,131 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+// sops-secrets validates, encrypts, previews and migrates SopsSecret manifests. It is
+// also usable as kubectl plugin when installed as kubectl-sops_secret.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"go.uber.org/zap/zapcore"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+	"github.com/isindir/sops-secrets-operator/controllers"
+)
+
+const usage = `Usage: sops-secrets <command> [flags]
+
+Commands:
+  lint     validate SopsSecret manifests against the CRD schema
+  encrypt  encrypt secret templates of plaintext SopsSecret manifest
+  secrets  print Kubernetes secrets generated from SopsSecret manifest
+  migrate  rewrite old SopsSecret and exported Secret manifests to v1alpha2
+
+Run sops-secrets <command> -h for command flags.
+`
+
+type command func(args []string) error
+
+var commands = map[string]command{
+	"lint":    lintCommand,
+	"encrypt": encryptCommand,
+	"secrets": secretsCommand,
+	"migrate": migrateCommand,
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+	if err := cmd(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// newFlagSet returns flag set of the command with common flags
+func newFlagSet(name string, file *string, verbose *bool) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.StringVar(file, "f", "-", "SopsSecret manifest file, - reads standard input.")
+	flags.BoolVar(verbose, "v", false, "Log progress to standard error.")
+	return flags
+}
+
+// newLogger returns logger writing to standard error, only errors are
+// logged unless verbose
+func newLogger(verbose bool) logr.Logger {
+	if verbose {
+		return zap.New(zap.WriteTo(os.Stderr), zap.UseDevMode(true))
+	}
+	return zap.New(zap.WriteTo(os.Stderr), zap.Level(zapcore.ErrorLevel))
+}
+
+// readFile reads manifest file, - reads standard input
+func readFile(file string) ([]byte, error) {
+	if file == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(file)
+}
+
+// readDocuments reads YAML documents of manifest file
+func readDocuments(file string) ([][]byte, error) {
+	data, err := readFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return controllers.SplitDocuments(data)
+}
+
+// encrypted returns true if SopsSecret has sops metadata
+func encrypted(instance *isindirv1alpha2.SopsSecret) bool {
+	return instance.Sops.Mac != ""
+}
+
+// kubeClient returns client and default namespace of the current kubeconfig context
+func kubeClient() (client.Client, string, error) {
+	kubeconfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(),
+		&clientcmd.ConfigOverrides{},
+	)
+	namespace, _, err := kubeconfig.Namespace()
+	if err != nil {
+		return nil, "", err
+	}
+	config, err := kubeconfig.ClientConfig()
+	if err != nil {
+		return nil, "", err
+	}
+	c, err := client.New(config, client.Options{})
+	if err != nil {
+		return nil, "", err
+	}
+	return c, namespace, nil
+}
+
+// splitList splits comma separated list, empty items are skipped
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
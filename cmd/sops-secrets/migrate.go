@@ -0,0 +1,308 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"go.mozilla.org/sops/v3"
+	sopsaes "go.mozilla.org/sops/v3/aes"
+	"go.mozilla.org/sops/v3/keyservice"
+	sopsyaml "go.mozilla.org/sops/v3/stores/yaml"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+	isindirv1alpha3 "github.com/isindir/sops-secrets-operator/api/v1alpha3"
+	"github.com/isindir/sops-secrets-operator/controllers"
+)
+
+// v1alpha1GroupVersion is the first SopsSecret API, which is not served
+// anymore
+const v1alpha1GroupVersion = "isindir.github.com/v1alpha1"
+
+// specMigrations rewrite plaintext spec of SopsSecret API version to v1alpha2
+var specMigrations = map[string]func(spec map[string]interface{}) error{
+	v1alpha1GroupVersion:                  migrateV1alpha1Spec,
+	isindirv1alpha3.GroupVersion.String(): migrateV1alpha3Spec,
+}
+
+// serverAnnotations are not copied from exported secrets
+var serverAnnotations = map[string]bool{
+	corev1.LastAppliedConfigAnnotation: true,
+}
+
+func migrateCommand(args []string) error {
+	var file string
+	var verbose bool
+	var keyFiles string
+	flags := newFlagSet("migrate", &file, &verbose)
+	flags.StringVar(&keyFiles, "keys", "", "Comma separated age identity and PGP private key files used for decryption.")
+	flags.Parse(args)
+	log := newLogger(verbose)
+
+	documents, err := readDocuments(file)
+	if err != nil {
+		return err
+	}
+	keyServices, err := controllers.OfflineKeyServices(splitList(keyFiles))
+	if err != nil {
+		return err
+	}
+
+	var migrated [][]byte
+	for i, document := range documents {
+		log.Info("Migrating", "document", i+1)
+		out, err := migrateDocument(document, keyServices)
+		if err != nil {
+			return fmt.Errorf("document %d: %v", i+1, err)
+		}
+		migrated = append(migrated, out...)
+	}
+	for i, document := range migrated {
+		if i > 0 {
+			fmt.Println("---")
+		}
+		os.Stdout.Write(document)
+	}
+	return nil
+}
+
+// migrateDocument returns v1alpha2 SopsSecret manifests of SopsSecret,
+// Secret or List of them
+func migrateDocument(document []byte, keyServices []keyservice.KeyServiceClient) ([][]byte, error) {
+	typeMeta := metav1.TypeMeta{}
+	if err := yaml.Unmarshal(document, &typeMeta); err != nil {
+		return nil, err
+	}
+
+	switch typeMeta.Kind {
+	case "SopsSecret":
+		out, err := migrateSopsSecret(document, typeMeta.APIVersion, keyServices)
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{out}, nil
+	case "Secret":
+		out, err := migrateSecret(document)
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{out}, nil
+	case "List":
+		list := &metav1.List{}
+		if err := yaml.Unmarshal(document, list); err != nil {
+			return nil, err
+		}
+		var migrated [][]byte
+		for i, item := range list.Items {
+			out, err := migrateDocument(item.Raw, keyServices)
+			if err != nil {
+				return nil, fmt.Errorf("items[%d]: %v", i, err)
+			}
+			migrated = append(migrated, out...)
+		}
+		return migrated, nil
+	case "SealedSecret":
+		return nil, fmt.Errorf("SealedSecret can be decrypted only by sealed-secrets controller, migrate Secret it generates instead")
+	case "ExternalSecret":
+		return nil, fmt.Errorf("ExternalSecret holds no values, migrate Secret it generates instead")
+	}
+	return nil, fmt.Errorf("kind %q is not SopsSecret or Secret", typeMeta.Kind)
+}
+
+// migrateSopsSecret rewrites SopsSecret to v1alpha2. sops binds encrypted
+// values to their path, so encrypted SopsSecret is decrypted and encrypted
+// again with its own data key, sops metadata and master keys are preserved
+// and only the data key decryption is needed
+func migrateSopsSecret(document []byte, apiVersion string, keyServices []keyservice.KeyServiceClient) ([]byte, error) {
+	if apiVersion == isindirv1alpha2.GroupVersion.String() {
+		// already current, sops MAC is left intact
+		return document, nil
+	}
+	migrate, ok := specMigrations[apiVersion]
+	if !ok {
+		return nil, fmt.Errorf("apiVersion %q is not supported", apiVersion)
+	}
+
+	manifest := map[string]interface{}{}
+	if err := yaml.Unmarshal(document, &manifest); err != nil {
+		return nil, err
+	}
+	if _, ok := manifest["sops"]; !ok {
+		return migrateManifest(document, migrate)
+	}
+
+	store := &sopsyaml.Store{}
+	tree, err := store.LoadEncryptedFile(document)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := tree.Metadata.GetDataKeyWithKeyServices(keyServices)
+	if err != nil {
+		if userErr, ok := err.(sops.UserError); ok {
+			err = fmt.Errorf(userErr.UserError())
+		}
+		return nil, err
+	}
+	cipher := sopsaes.NewCipher()
+	if _, err := tree.Decrypt(dataKey, cipher); err != nil {
+		return nil, err
+	}
+	plaintext, err := store.EmitPlainFile(tree.Branches)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err = migrateManifest(plaintext, migrate)
+	if err != nil {
+		return nil, err
+	}
+
+	if tree.Branches, err = store.LoadPlainFile(plaintext); err != nil {
+		return nil, err
+	}
+	if apiVersion == v1alpha1GroupVersion {
+		// v1alpha1 manifests were encrypted with --encrypted-suffix _templates
+		if tree.Metadata.EncryptedSuffix == "_templates" {
+			tree.Metadata.EncryptedSuffix = "Templates"
+		}
+		tree.Metadata.EncryptedRegex = strings.ReplaceAll(tree.Metadata.EncryptedRegex, "secret_templates", "secretTemplates")
+		tree.Metadata.UnencryptedRegex = strings.ReplaceAll(tree.Metadata.UnencryptedRegex, "secret_templates", "secretTemplates")
+	}
+	if tree.Metadata.EncryptedRegex != "" || tree.Metadata.UnencryptedRegex != "" {
+		fmt.Fprintf(os.Stderr, "warning: check that encrypted or unencrypted regex of sops metadata matches migrated field names\n")
+	}
+	mac, err := tree.Encrypt(dataKey, cipher)
+	if err != nil {
+		return nil, err
+	}
+	tree.Metadata.LastModified = time.Now().UTC()
+	tree.Metadata.MessageAuthenticationCode, err = cipher.Encrypt(mac, dataKey, tree.Metadata.LastModified.Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+	return store.EmitEncryptedFile(tree)
+}
+
+// migrateManifest rewrites plaintext SopsSecret manifest to v1alpha2 and
+// checks it against v1alpha2 schema
+func migrateManifest(document []byte, migrate func(spec map[string]interface{}) error) ([]byte, error) {
+	manifest := map[string]interface{}{}
+	if err := yaml.Unmarshal(document, &manifest); err != nil {
+		return nil, err
+	}
+	spec, ok := manifest["spec"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("spec is not a map")
+	}
+	if err := migrate(spec); err != nil {
+		return nil, err
+	}
+	manifest["apiVersion"] = isindirv1alpha2.GroupVersion.String()
+
+	out, err := yaml.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := controllers.DecodeSopsSecret(out); err != nil {
+		return nil, fmt.Errorf("migrated manifest is invalid: %v", err)
+	}
+	return out, nil
+}
+
+// migrateV1alpha1Spec renames secret_templates of v1alpha1, templates have
+// the same fields
+func migrateV1alpha1Spec(spec map[string]interface{}) error {
+	templates, ok := spec["secret_templates"]
+	if !ok {
+		return fmt.Errorf("spec.secret_templates is required")
+	}
+	delete(spec, "secret_templates")
+	spec["secretTemplates"] = templates
+	return nil
+}
+
+// migrateV1alpha3Spec renames secret template fields the same way as
+// v1alpha3 conversion: stringData becomes data and data becomes binaryData
+func migrateV1alpha3Spec(spec map[string]interface{}) error {
+	templates, _ := spec["secretTemplates"].([]interface{})
+	for i, item := range templates {
+		template, ok := item.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("spec.secretTemplates[%d] is not a map", i)
+		}
+		data, hasData := template["data"]
+		stringData, hasStringData := template["stringData"]
+		delete(template, "data")
+		delete(template, "stringData")
+		if hasData {
+			template["binaryData"] = data
+		}
+		if hasStringData {
+			template["data"] = stringData
+		}
+	}
+	return nil
+}
+
+// migrateSecret returns plaintext SopsSecret generating the same secret as
+// exported Secret manifest, for example of secret generated by sealed-secrets
+// or external-secrets. Server populated metadata is dropped
+func migrateSecret(document []byte) ([]byte, error) {
+	secret := &corev1.Secret{}
+	if err := yaml.Unmarshal(document, secret); err != nil {
+		return nil, err
+	}
+	if secret.Name == "" {
+		return nil, fmt.Errorf("metadata.name is required")
+	}
+
+	template := map[string]interface{}{"name": secret.Name}
+	if secret.Type != "" && secret.Type != corev1.SecretTypeOpaque {
+		template["type"] = string(secret.Type)
+	}
+	if len(secret.Labels) > 0 {
+		template["labels"] = secret.Labels
+	}
+	annotations := map[string]string{}
+	for key, value := range secret.Annotations {
+		if !serverAnnotations[key] {
+			annotations[key] = value
+		}
+	}
+	if len(annotations) > 0 {
+		template["annotations"] = annotations
+	}
+	if len(secret.StringData) > 0 {
+		template["data"] = secret.StringData
+	}
+	binaryData := map[string]string{}
+	for key, value := range secret.Data {
+		if _, ok := secret.StringData[key]; !ok {
+			binaryData[key] = base64.StdEncoding.EncodeToString(value)
+		}
+	}
+	if len(binaryData) > 0 {
+		template["binaryData"] = binaryData
+	}
+
+	metadata := map[string]interface{}{"name": secret.Name}
+	if secret.Namespace != "" {
+		metadata["namespace"] = secret.Namespace
+	}
+	return yaml.Marshal(map[string]interface{}{
+		"apiVersion": isindirv1alpha2.GroupVersion.String(),
+		"kind":       "SopsSecret",
+		"metadata":   metadata,
+		"spec": map[string]interface{}{
+			"secretTemplates": []interface{}{template},
+		},
+	})
+}
@@ -0,0 +1,58 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/isindir/sops-secrets-operator/pkg/render"
+)
+
+func secretsCommand(args []string) error {
+	var file string
+	var verbose bool
+	var namespace string
+	var keyFiles string
+	var secretNamePrefix string
+	flags := newFlagSet("secrets", &file, &verbose)
+	flags.StringVar(&namespace, "n", "", "Namespace of generated secrets, overrides SopsSecret namespace.")
+	flags.StringVar(&secretNamePrefix, "secret-name-prefix", "", "Prefix added to generated secret names not starting with it, as --secret-name-prefix of the operator.")
+	flags.StringVar(&keyFiles, "keys", "", "Comma separated age identity and PGP private key files used for decryption.")
+	flags.Parse(args)
+	log := newLogger(verbose)
+
+	data, err := readFile(file)
+	if err != nil {
+		return err
+	}
+	renderer, err := render.New(
+		context.Background(),
+		render.Options{Namespace: namespace, SecretNamePrefix: secretNamePrefix, Log: log},
+		render.KeyFiles(splitList(keyFiles)...),
+	)
+	if err != nil {
+		return err
+	}
+	secrets, err := renderer.RenderManifests(data)
+	if err != nil {
+		return err
+	}
+
+	for i, secret := range secrets {
+		out, err := yaml.Marshal(secret)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			fmt.Println("---")
+		}
+		os.Stdout.Write(out)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"go.mozilla.org/sops/v3/keyservice"
+	"google.golang.org/grpc"
+)
+
+// ageKeyService is a sops key service client which decrypts age data keys
+// in-process with given identities, data keys these identities can not
+// decrypt are delegated
+type ageKeyService struct {
+	identities []age.Identity
+	next       keyservice.KeyServiceClient
+}
+
+func newAgeKeyService(identities []age.Identity, next keyservice.KeyServiceClient) keyservice.KeyServiceClient {
+	return &ageKeyService{
+		identities: identities,
+		next:       next,
+	}
+}
+
+// Encrypt is not used by the operator and is always delegated
+func (ks *ageKeyService) Encrypt(
+	ctx context.Context,
+	req *keyservice.EncryptRequest,
+	opts ...grpc.CallOption,
+) (*keyservice.EncryptResponse, error) {
+	return ks.next.Encrypt(ctx, req, opts...)
+}
+
+// Decrypt decrypts data key with age identities if key is an age key
+func (ks *ageKeyService) Decrypt(
+	ctx context.Context,
+	req *keyservice.DecryptRequest,
+	opts ...grpc.CallOption,
+) (*keyservice.DecryptResponse, error) {
+	if req.Key.GetAgeKey() == nil {
+		return ks.next.Decrypt(ctx, req, opts...)
+	}
+
+	reader, err := age.Decrypt(armor.NewReader(bytes.NewReader(req.Ciphertext)), ks.identities...)
+	if err != nil {
+		return ks.next.Decrypt(ctx, req, opts...)
+	}
+	plaintext, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	return &keyservice.DecryptResponse{Plaintext: plaintext}, nil
+}
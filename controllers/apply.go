@@ -0,0 +1,221 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+const (
+	// DefaultFieldManager is server-side apply field manager of generated secrets
+	DefaultFieldManager = "sops-secrets-operator"
+
+	// AppliedHashAnnotation holds canonical hash of all secret fields applied
+	// by the operator, it is used to skip applying unchanged secrets
+	AppliedHashAnnotation = "sops-secrets-operator/applied-hash"
+
+	// LastSyncAnnotation holds RFC 3339 time the operator last wrote the
+	// secret or found it up to date, it is not part of applied hash and is
+	// refreshed on its own at most once per refresh interval
+	LastSyncAnnotation = "sops-secrets-operator/last-sync"
+)
+
+// applySecretTo creates or updates secret with server-side apply with client
+// c, the writer of SopsSecret or client of the cluster secret belongs to.
+// Operator owns only fields present in secret, so keys added by other actors
+// are preserved, while keys removed from secret template are removed from the
+// secret. Conflicting fields are forcibly taken over, SopsSecret is the
+// source of truth for them. Secret is updated with the applied object
+func (r *SopsSecretReconciler) applySecretTo(ctx context.Context, c client.Client, secret *corev1.Secret, opts ...client.PatchOption) error {
+	fieldManager := r.FieldManager
+	if fieldManager == "" {
+		fieldManager = DefaultFieldManager
+	}
+
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	secret.Annotations[LastSyncAnnotation] = time.Now().UTC().Format(time.RFC3339)
+	secret.Annotations[AppliedHashAnnotation] = appliedHash(secret)
+	secret.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"}
+	secret.ResourceVersion = ""
+	secret.ManagedFields = nil
+	opts = append(opts, client.FieldOwner(fieldManager), client.ForceOwnership)
+	return c.Patch(ctx, secret, client.Apply, opts...)
+}
+
+// refreshLastSync sets last sync time of up to date secret, when it is older
+// than refresh interval of SopsSecret, so the annotation tells the secret was
+// checked recently without writing the secret on every reconciliation
+func (r *SopsSecretReconciler) refreshLastSync(
+	ctx context.Context,
+	c client.Client,
+	instance *isindirv1alpha2.SopsSecret,
+	found *corev1.Secret,
+) error {
+	interval := r.refreshInterval(instance)
+	if interval <= 0 {
+		return nil
+	}
+	if lastSync, err := time.Parse(time.RFC3339, found.Annotations[LastSyncAnnotation]); err == nil && time.Since(lastSync) < interval {
+		return nil
+	}
+
+	fieldManager := r.FieldManager
+	if fieldManager == "" {
+		fieldManager = DefaultFieldManager
+	}
+	patch := client.MergeFrom(found.DeepCopy())
+	if found.Annotations == nil {
+		found.Annotations = map[string]string{}
+	}
+	found.Annotations[LastSyncAnnotation] = time.Now().UTC().Format(time.RFC3339)
+	return c.Patch(ctx, found, patch, client.FieldOwner(fieldManager))
+}
+
+// updateSecret applies secret with client c, existing secret is deleted
+// first when it is recreated, so the secret is created again holding exactly the rendered
+// content. Protection finalizer is removed before deletion and deletion is
+// skipped when existing secret changed in the meantime. Secret held by
+// finalizers of other controllers is reported as error until it is gone, so
+// reconciliation is retried. Otherwise stale data keys are removed from
+// existing secret before apply
+func (r *SopsSecretReconciler) updateSecret(
+	ctx context.Context,
+	c client.Client,
+	found *corev1.Secret,
+	secret *corev1.Secret,
+	recreate bool,
+	staleKeys []string,
+) error {
+	if recreate {
+		if !found.DeletionTimestamp.IsZero() {
+			return fmt.Errorf("secret %s is being deleted, waiting for finalizers %v", found.Name, found.Finalizers)
+		}
+		r.logger(ctx).Info("Recreating secret", "secret", found.Name, "namespace", found.Namespace)
+		if err := r.unprotectSecret(ctx, c, found); err != nil {
+			return err
+		}
+		uid, resourceVersion := found.UID, found.ResourceVersion
+		err := c.Delete(ctx, found, client.Preconditions{UID: &uid, ResourceVersion: &resourceVersion})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		// secret deleted with finalizers of other controllers is terminating
+		// until they are removed, apply would update the terminating secret
+		if err == nil && len(found.Finalizers) > 0 {
+			return fmt.Errorf("secret %s is being deleted, waiting for finalizers %v", found.Name, found.Finalizers)
+		}
+	} else if len(staleKeys) > 0 {
+		r.logger(ctx).Info("Removing data keys no longer rendered", "secret", found.Name, "namespace", found.Namespace, "keys", staleKeys)
+		pruned := found.DeepCopy()
+		patch := client.MergeFrom(found)
+		for _, key := range staleKeys {
+			delete(pruned.Data, key)
+		}
+		if err := c.Patch(ctx, pruned, patch); err != nil {
+			return err
+		}
+	}
+	return r.applySecretTo(ctx, c, secret)
+}
+
+// appliedContentHash returns content hash of secret restricted to data keys
+// applied by the operator, so keys added by other actors are not reported
+// as drift
+func appliedContentHash(secret *corev1.Secret, applied *corev1.Secret) string {
+	restricted := &corev1.Secret{Type: secret.Type, Data: map[string][]byte{}}
+	for key := range applied.Data {
+		if value, ok := secret.Data[key]; ok {
+			restricted.Data[key] = value
+		}
+	}
+	return secretContentHash(restricted)
+}
+
+// appliedHash returns canonical sha256 hash of secret fields applied by the
+// operator: type, immutability, owner references, finalizers, labels,
+// annotations and data
+func appliedHash(secret *corev1.Secret) string {
+	hash := sha256.New()
+	write := func(values ...string) {
+		for _, value := range values {
+			hash.Write([]byte(value))
+			hash.Write([]byte{0})
+		}
+	}
+	writeMap := func(values map[string]string) {
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			if key != AppliedHashAnnotation && key != LastSyncAnnotation {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		write("map")
+		for _, key := range keys {
+			write(key, values[key])
+		}
+	}
+
+	write(string(secret.Type))
+	if secret.Immutable != nil && *secret.Immutable {
+		write("immutable")
+	}
+	for _, owner := range secret.OwnerReferences {
+		write("owner", string(owner.UID))
+	}
+	for _, finalizer := range secret.Finalizers {
+		write("finalizer", finalizer)
+	}
+	writeMap(secret.Labels)
+	writeMap(secret.Annotations)
+	write(secretContentHash(secret))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// upToDate returns true when rendered secret was already applied to found
+// secret and none of the applied fields were changed by other actors since
+func upToDate(found *corev1.Secret, rendered *corev1.Secret) bool {
+	hash, ok := found.Annotations[AppliedHashAnnotation]
+	if !ok || hash != appliedHash(rendered) || found.Type != rendered.Type {
+		return false
+	}
+	for _, finalizer := range rendered.Finalizers {
+		if !controllerutil.ContainsFinalizer(found, finalizer) {
+			return false
+		}
+	}
+	for key, value := range rendered.Labels {
+		if current, ok := found.Labels[key]; !ok || current != value {
+			return false
+		}
+	}
+	for key, value := range rendered.Annotations {
+		if current, ok := found.Annotations[key]; !ok || current != value {
+			return false
+		}
+	}
+	for key, value := range rendered.Data {
+		if current, ok := found.Data[key]; !ok || !bytes.Equal(current, value) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,133 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// AuditSource identifies SopsSecret generation a secret was generated from
+type AuditSource struct {
+	Namespace  string    `json:"namespace"`
+	Name       string    `json:"name"`
+	UID        types.UID `json:"uid"`
+	Generation int64     `json:"generation"`
+}
+
+// AuditRecord describes mutation of a generated secret, it never contains
+// secret values
+type AuditRecord struct {
+	Time time.Time `json:"time"`
+	// Actor is the field manager the operator writes secrets as
+	Actor       string             `json:"actor"`
+	Action      string             `json:"action"`
+	Secret      NotificationObject `json:"secret"`
+	Keys        []string           `json:"keys,omitempty"`
+	ContentHash string             `json:"contentHash,omitempty"`
+	SopsSecret  AuditSource        `json:"sopsSecret"`
+}
+
+// AuditLog appends JSON lines audit records of generated secret mutations to
+// standard output and optional file
+type AuditLog struct {
+	lock    sync.Mutex
+	writers []io.Writer
+	file    *os.File
+}
+
+// NewAuditLog returns audit log writing to standard output when stdout is
+// true and appending to file at path when path is not empty
+func NewAuditLog(stdout bool, path string) (*AuditLog, error) {
+	audit := &AuditLog{}
+	if stdout {
+		audit.writers = append(audit.writers, os.Stdout)
+	}
+	if path != "" {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, err
+		}
+		audit.file = file
+		audit.writers = append(audit.writers, file)
+	}
+	return audit, nil
+}
+
+// Record writes audit record to all destinations
+func (a *AuditLog) Record(record AuditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	for _, writer := range a.writers {
+		if _, err := writer.Write(line); err != nil {
+			return err
+		}
+	}
+	if a.file != nil {
+		return a.file.Sync()
+	}
+	return nil
+}
+
+// Close closes audit log file
+func (a *AuditLog) Close() error {
+	if a.file == nil {
+		return nil
+	}
+	return a.file.Close()
+}
+
+// secretChanged records mutation of generated secret in audit log and sends
+// change notification
+func (r *SopsSecretReconciler) secretChanged(ctx context.Context, instance *isindirv1alpha2.SopsSecret, action string, secret *corev1.Secret) {
+	r.audit(ctx, instance, action, secret)
+	r.notify(ctx, instance, action, secret)
+}
+
+// audit records mutation of generated secret when audit log is configured
+func (r *SopsSecretReconciler) audit(ctx context.Context, instance *isindirv1alpha2.SopsSecret, action string, secret *corev1.Secret) {
+	if r.AuditLog == nil {
+		return
+	}
+
+	actor := r.FieldManager
+	if actor == "" {
+		actor = DefaultFieldManager
+	}
+	record := AuditRecord{
+		Time:   time.Now().UTC(),
+		Actor:  actor,
+		Action: action,
+		Secret: NotificationObject{Namespace: secret.Namespace, Name: secret.Name},
+		SopsSecret: AuditSource{
+			Namespace:  instance.Namespace,
+			Name:       instance.Name,
+			UID:        instance.UID,
+			Generation: instance.Generation,
+		},
+	}
+	if len(secret.Data) > 0 {
+		record.Keys = sortedKeys(secret.Data)
+		record.ContentHash = secretContentHash(secret)
+	}
+	if err := r.AuditLog.Record(record); err != nil {
+		r.logger(ctx).Error(err, "Writing audit record failed", "secret", record.Secret, "action", action)
+	}
+}
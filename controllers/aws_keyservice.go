@@ -0,0 +1,61 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"context"
+
+	"go.mozilla.org/sops/v3/keyservice"
+	"google.golang.org/grpc"
+)
+
+// awsRoleKeyService is a sops key service client which overrides IAM role of
+// AWS KMS keys, so sops assumes this role via STS before calling KMS
+type awsRoleKeyService struct {
+	role string
+	next keyservice.KeyServiceClient
+}
+
+func newAwsRoleKeyService(role string, next keyservice.KeyServiceClient) keyservice.KeyServiceClient {
+	return &awsRoleKeyService{
+		role: role,
+		next: next,
+	}
+}
+
+// Encrypt is not used by the operator and is always delegated
+func (ks *awsRoleKeyService) Encrypt(
+	ctx context.Context,
+	req *keyservice.EncryptRequest,
+	opts ...grpc.CallOption,
+) (*keyservice.EncryptResponse, error) {
+	return ks.next.Encrypt(ctx, req, opts...)
+}
+
+// Decrypt replaces role of AWS KMS key and delegates decryption
+func (ks *awsRoleKeyService) Decrypt(
+	ctx context.Context,
+	req *keyservice.DecryptRequest,
+	opts ...grpc.CallOption,
+) (*keyservice.DecryptResponse, error) {
+	kmsKey := req.Key.GetKmsKey()
+	if kmsKey == nil {
+		return ks.next.Decrypt(ctx, req, opts...)
+	}
+
+	return ks.next.Decrypt(ctx, &keyservice.DecryptRequest{
+		Key: &keyservice.Key{
+			KeyType: &keyservice.Key_KmsKey{
+				KmsKey: &keyservice.KmsKey{
+					Arn:        kmsKey.Arn,
+					Role:       ks.role,
+					Context:    kmsKey.Context,
+					AwsProfile: kmsKey.AwsProfile,
+				},
+			},
+		},
+		Ciphertext: req.Ciphertext,
+	}, opts...)
+}
@@ -0,0 +1,144 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/2016-10-01/keyvault"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure/auth"
+	"go.mozilla.org/sops/v3/keyservice"
+	"google.golang.org/grpc"
+)
+
+// azureAuthorizerCache caches Key Vault authorizers of user-assigned managed
+// identities per Key Vault resource, authorizers refresh their tokens when
+// they expire
+type azureAuthorizerCache struct {
+	lock        sync.Mutex
+	authorizers map[string]autorest.Authorizer
+}
+
+// authorizer returns cached authorizer of the managed identity client ID for
+// Key Vault resource
+func (c *azureAuthorizerCache) authorizer(clientID string, resource string) (autorest.Authorizer, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	key := clientID + " " + resource
+	if authorizer, ok := c.authorizers[key]; ok {
+		return authorizer, nil
+	}
+
+	msiConfig := auth.NewMSIConfig()
+	msiConfig.Resource = resource
+	msiConfig.ClientID = clientID
+	authorizer, err := msiConfig.Authorizer()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create authorizer for Azure identity %s: %v", clientID, err)
+	}
+
+	if c.authorizers == nil {
+		c.authorizers = make(map[string]autorest.Authorizer)
+	}
+	c.authorizers[key] = authorizer
+	return authorizer, nil
+}
+
+// keyVaultResource returns token resource of Key Vault URL, the vault host
+// without vault name, so keys in sovereign clouds, such as
+// https://name.vault.usgovcloudapi.net, get tokens of their cloud
+func keyVaultResource(vaultURL string) (string, error) {
+	parsed, err := url.Parse(vaultURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid Azure Key Vault URL %s: %v", vaultURL, err)
+	}
+	parts := strings.SplitN(parsed.Hostname(), ".", 2)
+	if len(parts) != 2 || parts[0] == "" || !strings.Contains(parts[1], ".") {
+		return "", fmt.Errorf("invalid Azure Key Vault URL %s: expected https://<name>.<key vault domain>", vaultURL)
+	}
+	return "https://" + parts[1], nil
+}
+
+// azureIdentityKeyService is a sops key service client which decrypts Azure Key
+// Vault keys using user-assigned managed identity
+type azureIdentityKeyService struct {
+	cache    *azureAuthorizerCache
+	clientID string
+	next     keyservice.KeyServiceClient
+}
+
+func newAzureIdentityKeyService(
+	cache *azureAuthorizerCache,
+	clientID string,
+	next keyservice.KeyServiceClient,
+) keyservice.KeyServiceClient {
+	return &azureIdentityKeyService{
+		cache:    cache,
+		clientID: clientID,
+		next:     next,
+	}
+}
+
+// Encrypt is not used by the operator and is always delegated
+func (ks *azureIdentityKeyService) Encrypt(
+	ctx context.Context,
+	req *keyservice.EncryptRequest,
+	opts ...grpc.CallOption,
+) (*keyservice.EncryptResponse, error) {
+	return ks.next.Encrypt(ctx, req, opts...)
+}
+
+// Decrypt decrypts Azure Key Vault data key using managed identity
+func (ks *azureIdentityKeyService) Decrypt(
+	ctx context.Context,
+	req *keyservice.DecryptRequest,
+	opts ...grpc.CallOption,
+) (*keyservice.DecryptResponse, error) {
+	azureKey := req.Key.GetAzureKeyvaultKey()
+	if azureKey == nil {
+		return ks.next.Decrypt(ctx, req, opts...)
+	}
+
+	resource, err := keyVaultResource(azureKey.VaultUrl)
+	if err != nil {
+		return nil, err
+	}
+	authorizer, err := ks.cache.authorizer(ks.clientID, resource)
+	if err != nil {
+		return nil, err
+	}
+	client := keyvault.New()
+	client.Authorizer = authorizer
+
+	ciphertext := string(req.Ciphertext)
+	result, err := client.Decrypt(
+		ctx,
+		azureKey.VaultUrl,
+		azureKey.Name,
+		azureKey.Version,
+		keyvault.KeyOperationsParameters{
+			Algorithm: keyvault.RSAOAEP256,
+			Value:     &ciphertext,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if result.Result == nil {
+		return nil, fmt.Errorf("azure key vault returned empty result for key %s", azureKey.Name)
+	}
+	plaintext, err := base64.RawURLEncoding.DecodeString(*result.Result)
+	if err != nil {
+		return nil, err
+	}
+	return &keyservice.DecryptResponse{Plaintext: plaintext}, nil
+}
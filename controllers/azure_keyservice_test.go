@@ -0,0 +1,35 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import "testing"
+
+func TestKeyVaultResource(t *testing.T) {
+	tests := []struct {
+		vaultURL string
+		resource string
+	}{
+		{vaultURL: "https://team-a.vault.azure.net", resource: "https://vault.azure.net"},
+		{vaultURL: "https://team-a.vault.azure.net/", resource: "https://vault.azure.net"},
+		{vaultURL: "https://team-a.vault.usgovcloudapi.net", resource: "https://vault.usgovcloudapi.net"},
+		{vaultURL: "https://team-a.vault.azure.cn:443", resource: "https://vault.azure.cn"},
+		{vaultURL: "https://team-a.managedhsm.azure.net", resource: "https://managedhsm.azure.net"},
+		{vaultURL: "https://localhost"},
+		{vaultURL: "https://vault.net"},
+		{vaultURL: "://team-a.vault.azure.net"},
+	}
+	for _, tt := range tests {
+		resource, err := keyVaultResource(tt.vaultURL)
+		if tt.resource == "" {
+			if err == nil {
+				t.Errorf("%s should be rejected, got %s", tt.vaultURL, resource)
+			}
+			continue
+		}
+		if err != nil || resource != tt.resource {
+			t.Errorf("%s: expected resource %s, got %s, %v", tt.vaultURL, tt.resource, resource, err)
+		}
+	}
+}
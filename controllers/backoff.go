@@ -0,0 +1,139 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// failureClass distinguishes failures by the way they are retried: API
+// server errors usually resolve within seconds, decryption credential and key
+// backend errors need credentials or key backend to be fixed, and spec errors
+// (corrupted payload, invalid template) can not be fixed without changing
+// SopsSecret
+type failureClass string
+
+const (
+	// transientFailure is retried quickly with transient backoff
+	transientFailure failureClass = "transient"
+	// credentialFailure is retried with credential backoff
+	credentialFailure failureClass = "credential"
+	// permanentFailure is not retried, SopsSecret change triggers
+	// reconciliation
+	permanentFailure failureClass = "permanent"
+)
+
+// failureUrgency orders failure classes by how soon they are retried
+var failureUrgency = map[failureClass]int{
+	permanentFailure:  1,
+	credentialFailure: 2,
+	transientFailure:  3,
+}
+
+// soonerRetried returns the failure class of two which is retried sooner
+func soonerRetried(a failureClass, b failureClass) failureClass {
+	if failureUrgency[b] > failureUrgency[a] {
+		return b
+	}
+	return a
+}
+
+// permanentError marks errors which will not resolve without SopsSecret change
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// classifyFailure returns failure class of the error
+func classifyFailure(err error) failureClass {
+	var pe *permanentError
+	if errors.As(err, &pe) {
+		return permanentFailure
+	}
+	return transientFailure
+}
+
+// maxDuration is the longest time.Duration
+const maxDuration = time.Duration(math.MaxInt64)
+
+// BackoffPolicy defines exponential backoff of failed reconciliation requeue
+type BackoffPolicy struct {
+	// Initial is the delay after the first failure
+	Initial time.Duration
+	// Max is the maximum delay
+	Max time.Duration
+	// Multiplier is applied to the delay after each consecutive failure
+	Multiplier float64
+	// Jitter is the maximum fraction of the delay randomly added or subtracted
+	Jitter float64
+}
+
+// Delay returns requeue delay after given number of consecutive failures
+func (p BackoffPolicy) Delay(failures int) time.Duration {
+	// Max of 0 leaves the delay unlimited, it still can not exceed Duration
+	limit := float64(maxDuration)
+	if p.Max > 0 {
+		limit = float64(p.Max)
+	}
+	delay := float64(p.Initial)
+	for i := 1; i < failures && p.Multiplier > 1 && delay > 0 && delay < limit; i++ {
+		delay *= p.Multiplier
+	}
+	if delay > limit {
+		delay = limit
+	}
+	if p.Jitter > 0 {
+		delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	}
+	if delay < float64(time.Second) {
+		delay = float64(time.Second)
+	}
+	if delay >= float64(maxDuration) {
+		return maxDuration
+	}
+	return time.Duration(delay)
+}
+
+// failureTracker counts consecutive reconciliation failures of SopsSecrets per failure class
+type failureTracker struct {
+	lock     sync.Mutex
+	failures map[types.NamespacedName]map[failureClass]int
+}
+
+// inc registers failure and returns number of consecutive failures of the class
+func (t *failureTracker) inc(name types.NamespacedName, class failureClass) int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.failures == nil {
+		t.failures = make(map[types.NamespacedName]map[failureClass]int)
+	}
+	if t.failures[name] == nil {
+		t.failures[name] = make(map[failureClass]int)
+	}
+	t.failures[name][class]++
+	return t.failures[name][class]
+}
+
+// reset forgets all failures of the SopsSecret
+func (t *failureTracker) reset(name types.NamespacedName) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	delete(t.failures, name)
+}
@@ -0,0 +1,90 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffPolicyDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   BackoffPolicy
+		failures int
+		min, max time.Duration
+	}{
+		{
+			name:     "first failure",
+			policy:   BackoffPolicy{Initial: time.Second, Max: time.Minute, Multiplier: 2},
+			failures: 1,
+			min:      time.Second,
+			max:      time.Second,
+		},
+		{
+			name:     "exponential",
+			policy:   BackoffPolicy{Initial: time.Second, Max: time.Minute, Multiplier: 2},
+			failures: 4,
+			min:      8 * time.Second,
+			max:      8 * time.Second,
+		},
+		{
+			name:     "limited by max",
+			policy:   BackoffPolicy{Initial: time.Second, Max: time.Minute, Multiplier: 2},
+			failures: 10,
+			min:      time.Minute,
+			max:      time.Minute,
+		},
+		{
+			name:     "unlimited max",
+			policy:   BackoffPolicy{Initial: time.Second, Multiplier: 2},
+			failures: 4,
+			min:      8 * time.Second,
+			max:      8 * time.Second,
+		},
+		{
+			name:     "no multiplier",
+			policy:   BackoffPolicy{Initial: 5 * time.Second, Max: time.Minute},
+			failures: 4,
+			min:      5 * time.Second,
+			max:      5 * time.Second,
+		},
+		{
+			name:     "at least a second",
+			policy:   BackoffPolicy{Initial: time.Millisecond, Max: time.Minute, Multiplier: 2},
+			failures: 1,
+			min:      time.Second,
+			max:      time.Second,
+		},
+		{
+			name:     "many failures limited by max",
+			policy:   BackoffPolicy{Initial: time.Second, Max: time.Minute, Multiplier: 2},
+			failures: 1 << 30,
+			min:      time.Minute,
+			max:      time.Minute,
+		},
+		{
+			name:     "many failures unlimited max",
+			policy:   BackoffPolicy{Initial: time.Second, Multiplier: 2},
+			failures: 1 << 30,
+			min:      maxDuration,
+			max:      maxDuration,
+		},
+		{
+			name:     "many failures unlimited max with jitter",
+			policy:   BackoffPolicy{Initial: time.Second, Multiplier: 2, Jitter: 0.5},
+			failures: 1 << 30,
+			min:      maxDuration / 2,
+			max:      maxDuration,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if delay := tt.policy.Delay(tt.failures); delay < tt.min || delay > tt.max {
+				t.Errorf("expected delay between %s and %s, got %s", tt.min, tt.max, delay)
+			}
+		})
+	}
+}
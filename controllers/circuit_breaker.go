@@ -0,0 +1,346 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mozilla.org/sops/v3/keyservice"
+	"google.golang.org/grpc"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// BackendUnavailableReason is reason of BackendUnavailable condition
+const BackendUnavailableReason = "CircuitOpen"
+
+// circuitOpenMessage is the fragment of errors of data keys which are not
+// decrypted because circuit breaker of their key backend is open
+const circuitOpenMessage = "circuit breaker is open"
+
+// CircuitBreakerPolicy defines when key backends are considered unavailable
+type CircuitBreakerPolicy struct {
+	// Threshold is the number of consecutive data key decryptions failing
+	// with unreachable key backend which opens its circuit breaker, 0
+	// disables circuit breakers
+	Threshold int
+	// ProbeInterval is the delay between decryption probes of key backend
+	// with open circuit breaker
+	ProbeInterval time.Duration
+}
+
+// backendBreaker is circuit breaker of a single key backend
+type backendBreaker struct {
+	failures int
+	open     bool
+	// probe is the last failed request and key service, replayed to check
+	// key backend recovery
+	probe   *keyservice.DecryptRequest
+	service keyservice.KeyServiceClient
+	// waiting are SopsSecrets which failed while the breaker was open
+	waiting map[types.NamespacedName]bool
+}
+
+// backendBreakers are circuit breakers of key backends shared by all
+// SopsSecrets, so unavailable KMS or Vault is not called by every SopsSecret
+// on its own retry schedule, a single probe checks its recovery instead
+type backendBreakers struct {
+	policy CircuitBreakerPolicy
+	// recovered enqueues SopsSecrets waiting for recovered key backend
+	recovered func(ctx context.Context, names []types.NamespacedName)
+
+	// ctx is cancelled when manager stops, which stops probes
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	lock     sync.Mutex
+	backends map[string]*backendBreaker
+}
+
+func newBackendBreakers(
+	policy CircuitBreakerPolicy,
+	recovered func(ctx context.Context, names []types.NamespacedName),
+) *backendBreakers {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &backendBreakers{
+		policy:    policy,
+		recovered: recovered,
+		ctx:       ctx,
+		cancel:    cancel,
+		backends:  make(map[string]*backendBreaker),
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, probes are
+// started by reconciliations of any replica
+func (b *backendBreakers) NeedLeaderElection() bool {
+	return false
+}
+
+// Start implements manager.Runnable, it stops probes when manager stops
+func (b *backendBreakers) Start(ctx context.Context) error {
+	<-ctx.Done()
+	b.cancel()
+	return nil
+}
+
+// guard returns key service which short-circuits data keys of key backends
+// with open circuit breaker
+func (b *backendBreakers) guard(next keyservice.KeyServiceClient) keyservice.KeyServiceClient {
+	if b == nil || b.policy.Threshold <= 0 {
+		return next
+	}
+	return &breakerKeyService{breakers: b, next: next}
+}
+
+// allow reports whether data key of the key backend may be decrypted
+func (b *backendBreakers) allow(backend string) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	breaker, ok := b.backends[backend]
+	return !ok || !breaker.open
+}
+
+// record registers result of data key decryption and opens circuit breaker
+// of the key backend after threshold of consecutive unavailability failures
+func (b *backendBreakers) record(backend string, next keyservice.KeyServiceClient, req *keyservice.DecryptRequest, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	breaker, ok := b.backends[backend]
+	if err == nil || !isBackendUnavailable(err) {
+		if ok && !breaker.open {
+			breaker.failures = 0
+		}
+		return
+	}
+	if !ok {
+		breaker = &backendBreaker{waiting: make(map[types.NamespacedName]bool)}
+		b.backends[backend] = breaker
+	}
+	breaker.failures++
+	if breaker.open || breaker.failures < b.policy.Threshold || b.ctx.Err() != nil {
+		return
+	}
+	breaker.open = true
+	breaker.probe = req
+	breaker.service = next
+	keyBackendCircuitOpen.WithLabelValues(backend).Set(1)
+	go b.probeUntilRecovered(backend)
+}
+
+// probeUntilRecovered replays the failed request until the key backend
+// responds, then closes circuit breaker and enqueues waiting SopsSecrets.
+// Rejected data key closes circuit breaker too, as the backend is reachable.
+// Probing stops when manager stops
+func (b *backendBreakers) probeUntilRecovered(backend string) {
+	b.lock.Lock()
+	breaker := b.backends[backend]
+	req, service := breaker.probe, breaker.service
+	b.lock.Unlock()
+
+	interval := b.policy.ProbeInterval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-timer.C:
+		}
+		ctx, cancel := context.WithTimeout(b.ctx, interval)
+		_, err := service.Decrypt(ctx, req)
+		cancel()
+		if b.ctx.Err() != nil {
+			return
+		}
+		if err != nil && isBackendUnavailable(err) {
+			timer.Reset(interval)
+			continue
+		}
+
+		b.lock.Lock()
+		waiting := make([]types.NamespacedName, 0, len(breaker.waiting))
+		for name := range breaker.waiting {
+			waiting = append(waiting, name)
+		}
+		delete(b.backends, backend)
+		b.lock.Unlock()
+
+		keyBackendCircuitOpen.WithLabelValues(backend).Set(0)
+		if b.recovered != nil {
+			b.recovered(b.ctx, waiting)
+		}
+		return
+	}
+}
+
+// unavailable returns key backends of SopsSecret with open circuit breaker
+// and registers SopsSecret to be enqueued when they recover
+func (b *backendBreakers) unavailable(instance *isindirv1alpha2.SopsSecret) []string {
+	if b == nil {
+		return nil
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	name := types.NamespacedName{Namespace: instance.Namespace, Name: instance.Name}
+	var open []string
+	for _, backend := range sopsSecretBackends(instance) {
+		if breaker, ok := b.backends[backend]; ok && breaker.open {
+			breaker.waiting[name] = true
+			open = append(open, backend)
+		}
+	}
+	return open
+}
+
+// breakerKeyService is a sops key service client which fails data keys of key
+// backends with open circuit breaker without calling them
+type breakerKeyService struct {
+	breakers *backendBreakers
+	next     keyservice.KeyServiceClient
+}
+
+// Encrypt is not used by the operator and is always delegated
+func (ks *breakerKeyService) Encrypt(
+	ctx context.Context,
+	req *keyservice.EncryptRequest,
+	opts ...grpc.CallOption,
+) (*keyservice.EncryptResponse, error) {
+	return ks.next.Encrypt(ctx, req, opts...)
+}
+
+// Decrypt delegates decryption unless circuit breaker of the key backend is open
+func (ks *breakerKeyService) Decrypt(
+	ctx context.Context,
+	req *keyservice.DecryptRequest,
+	opts ...grpc.CallOption,
+) (*keyservice.DecryptResponse, error) {
+	backend := requestBackend(req.Key)
+	if backend == "" {
+		return ks.next.Decrypt(ctx, req, opts...)
+	}
+	if !ks.breakers.allow(backend) {
+		return nil, fmt.Errorf("key backend %s is unavailable, %s", backend, circuitOpenMessage)
+	}
+	resp, err := ks.next.Decrypt(ctx, req, opts...)
+	ks.breakers.record(backend, ks.next, req, err)
+	return resp, err
+}
+
+// kmsBackend returns key backend of AWS KMS key, which is the KMS region
+func kmsBackend(arn string) string {
+	region := ""
+	if parts := strings.Split(arn, ":"); len(parts) > 3 {
+		region = parts[3]
+	}
+	return kmsKeyType + ":" + region
+}
+
+// gcpBackend returns key backend of GCP KMS key, which is the key location
+func gcpBackend(resourceID string) string {
+	location := ""
+	parts := strings.Split(resourceID, "/")
+	for i := 0; i+1 < len(parts); i++ {
+		if parts[i] == "locations" {
+			location = parts[i+1]
+		}
+	}
+	return gcpKeyType + ":" + location
+}
+
+// requestBackend returns key backend of data key decryption request, empty
+// for keys decrypted in-process
+func requestBackend(key *keyservice.Key) string {
+	switch {
+	case key.GetVaultKey() != nil:
+		return vaultKeyType + ":" + key.GetVaultKey().VaultAddress
+	case key.GetKmsKey() != nil:
+		return kmsBackend(key.GetKmsKey().Arn)
+	case key.GetGcpKmsKey() != nil:
+		return gcpBackend(key.GetGcpKmsKey().ResourceId)
+	case key.GetAzureKeyvaultKey() != nil:
+		return azureKeyType + ":" + key.GetAzureKeyvaultKey().VaultUrl
+	}
+	return ""
+}
+
+// sopsSecretBackends returns key backends SopsSecret data key is encrypted
+// with, in the form returned by requestBackend
+func sopsSecretBackends(instance *isindirv1alpha2.SopsSecret) []string {
+	backends := make(map[string]bool)
+	for _, group := range sopsKeyGroups(&instance.Sops) {
+		for _, key := range group.HcVault {
+			backends[vaultKeyType+":"+key.VaultAddress] = true
+		}
+		for _, key := range group.AwsKms {
+			backends[kmsBackend(key.Arn)] = true
+		}
+		for _, key := range group.GcpKms {
+			backends[gcpBackend(key.VaultURL)] = true
+		}
+		for _, key := range group.AzureKms {
+			backends[azureKeyType+":"+key.VaultURL] = true
+		}
+	}
+	names := make([]string, 0, len(backends))
+	for backend := range backends {
+		names = append(names, backend)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// setBackendUnavailable records key backends with open circuit breaker in
+// BackendUnavailable condition, which is removed when there are none
+func setBackendUnavailable(instance *isindirv1alpha2.SopsSecret, backends []string) {
+	if len(backends) == 0 {
+		meta.RemoveStatusCondition(&instance.Status.Conditions, isindirv1alpha2.BackendUnavailableCondition)
+		return
+	}
+	meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+		Type:               isindirv1alpha2.BackendUnavailableCondition,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: instance.Generation,
+		Reason:             BackendUnavailableReason,
+		Message: fmt.Sprintf(
+			"Key backends %s are unavailable, SopsSecret is reconciled when they recover",
+			strings.Join(backends, ", "),
+		),
+	})
+}
+
+// resyncSopsSecrets enqueues SopsSecrets for reconciliation, it gives up
+// when the context is cancelled
+func (r *SopsSecretReconciler) resyncSopsSecrets(ctx context.Context, names []types.NamespacedName) {
+	if r.resync == nil {
+		return
+	}
+	for _, name := range names {
+		instance := &isindirv1alpha2.SopsSecret{}
+		if err := r.Get(ctx, name, instance); err != nil {
+			continue
+		}
+		select {
+		case r.resync <- event.GenericEvent{Object: instance}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
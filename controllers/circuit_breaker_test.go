@@ -0,0 +1,133 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mozilla.org/sops/v3/keyservice"
+	"google.golang.org/grpc"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// failingKeyService fails all decryptions with its error and counts calls
+type failingKeyService struct {
+	err   error
+	calls int
+}
+
+func (ks *failingKeyService) Encrypt(context.Context, *keyservice.EncryptRequest, ...grpc.CallOption) (*keyservice.EncryptResponse, error) {
+	return nil, ks.err
+}
+
+func (ks *failingKeyService) Decrypt(context.Context, *keyservice.DecryptRequest, ...grpc.CallOption) (*keyservice.DecryptResponse, error) {
+	ks.calls++
+	return nil, ks.err
+}
+
+func vaultDecryptRequest(address string) *keyservice.DecryptRequest {
+	return &keyservice.DecryptRequest{
+		Key: &keyservice.Key{KeyType: &keyservice.Key_VaultKey{VaultKey: &keyservice.VaultKey{VaultAddress: address}}},
+	}
+}
+
+func TestBreakerOpensAfterThreshold(t *testing.T) {
+	breakers := newBackendBreakers(CircuitBreakerPolicy{Threshold: 2, ProbeInterval: time.Hour}, nil)
+	next := &failingKeyService{err: fmt.Errorf("dial tcp: connection refused")}
+	svc := breakers.guard(next)
+
+	for i := 0; i < 3; i++ {
+		if _, err := svc.Decrypt(context.Background(), vaultDecryptRequest("https://vault:8200")); err == nil {
+			t.Fatalf("decryption must fail")
+		}
+	}
+	if next.calls != 2 {
+		t.Errorf("expected backend to be called until threshold, got %d calls", next.calls)
+	}
+
+	instance := &isindirv1alpha2.SopsSecret{}
+	instance.Sops.HcVault = []isindirv1alpha2.HcVaultItem{{VaultAddress: "https://vault:8200"}}
+	if open := breakers.unavailable(instance); !reflect.DeepEqual(open, []string{"vault:https://vault:8200"}) {
+		t.Errorf("unexpected unavailable backends %v", open)
+	}
+}
+
+func TestBreakerIgnoresRejectedKeys(t *testing.T) {
+	breakers := newBackendBreakers(CircuitBreakerPolicy{Threshold: 1, ProbeInterval: time.Hour}, nil)
+	next := &failingKeyService{err: fmt.Errorf("Code: 403. permission denied")}
+	svc := breakers.guard(next)
+
+	for i := 0; i < 2; i++ {
+		svc.Decrypt(context.Background(), vaultDecryptRequest("https://vault:8200"))
+	}
+	if next.calls != 2 {
+		t.Errorf("rejected data key must not open circuit breaker, got %d calls", next.calls)
+	}
+}
+
+func TestBackendOfRequestMatchesMetadata(t *testing.T) {
+	instance := &isindirv1alpha2.SopsSecret{}
+	instance.Sops.AwsKms = []isindirv1alpha2.KmsDataItem{{Arn: "arn:aws:kms:eu-west-1:123456789012:key/id"}}
+	instance.Sops.GcpKms = []isindirv1alpha2.GcpKmsDataItem{{VaultURL: "projects/p/locations/global/keyRings/r/cryptoKeys/k"}}
+	instance.Sops.AzureKms = []isindirv1alpha2.AzureKmsItem{{VaultURL: "https://kv.vault.azure.net"}}
+
+	requests := []*keyservice.Key{
+		{KeyType: &keyservice.Key_KmsKey{KmsKey: &keyservice.KmsKey{Arn: "arn:aws:kms:eu-west-1:123456789012:key/id"}}},
+		{KeyType: &keyservice.Key_GcpKmsKey{GcpKmsKey: &keyservice.GcpKmsKey{ResourceId: "projects/p/locations/global/keyRings/r/cryptoKeys/k"}}},
+		{KeyType: &keyservice.Key_AzureKeyvaultKey{AzureKeyvaultKey: &keyservice.AzureKeyVaultKey{VaultUrl: "https://kv.vault.azure.net"}}},
+	}
+	var backends []string
+	for _, key := range requests {
+		backends = append(backends, requestBackend(key))
+	}
+	expected := []string{"aws_kms:eu-west-1", "azure_kv:https://kv.vault.azure.net", "gcp_kms:global"}
+	if got := sopsSecretBackends(instance); !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected SopsSecret backends %v", got)
+	}
+	for _, backend := range backends {
+		found := false
+		for _, e := range expected {
+			found = found || e == backend
+		}
+		if !found {
+			t.Errorf("backend %s of request does not match metadata", backend)
+		}
+	}
+}
+
+func TestBreakerGuardsKeyProfileServices(t *testing.T) {
+	breakers := newBackendBreakers(CircuitBreakerPolicy{Threshold: 1, ProbeInterval: time.Hour}, nil)
+	defer breakers.cancel()
+	r := &SopsSecretReconciler{
+		KeyProfiles: map[string]*KeyProfile{
+			"team-a": {Namespaces: []string{"team-a"}, AwsRoleARN: "arn:aws:iam::123456789012:role/team-a"},
+		},
+		breakers: breakers,
+	}
+	// KMS region is unreachable for the operator credentials
+	req := &keyservice.DecryptRequest{
+		Key: &keyservice.Key{KeyType: &keyservice.Key_KmsKey{KmsKey: &keyservice.KmsKey{Arn: "arn:aws:kms:eu-west-1:123456789012:key/id"}}},
+	}
+	breakers.guard(&failingKeyService{err: fmt.Errorf("dial tcp: connection refused")}).Decrypt(context.Background(), req)
+
+	instance := &isindirv1alpha2.SopsSecret{}
+	instance.Namespace = "team-a"
+	instance.Spec.KeyProfile = "team-a"
+	services := r.keyServices(context.Background(), instance)
+	if len(services) != 1 {
+		t.Fatalf("expected profile key service only, got %d services", len(services))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := services[0].Decrypt(ctx, req); err == nil || !strings.Contains(err.Error(), circuitOpenMessage) {
+		t.Errorf("profile key service must not call key backend with open circuit breaker, got %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// SopsSecretCRDName is the name of SopsSecret CustomResourceDefinition
+const SopsSecretCRDName = "sopssecrets.isindir.github.com"
+
+// ConversionWebhookConfig locates SopsSecret conversion webhook served by the
+// operator
+type ConversionWebhookConfig struct {
+	// Service is namespace and name of webhook Service, which forwards port
+	// 443 to the webhook server
+	Service types.NamespacedName
+	// URL of the webhook is used instead of Service when set, for webhook
+	// servers running outside of the cluster
+	URL string
+	// CABundle is PEM bundle of CAs of webhook serving certificate
+	CABundle []byte
+}
+
+//+kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,verbs=get;patch
+
+// ConfigureConversionWebhook sets conversion of SopsSecret CRD to the webhook,
+// so API server converts v1alpha3 SopsSecrets to stored v1alpha2 with the
+// operator instead of storing them under v1alpha2 schema. It is used where
+// the CRD can not be templated, such as CRDs installed by helm charts
+func ConfigureConversionWebhook(ctx context.Context, c client.Client, config ConversionWebhookConfig) error {
+	if len(config.CABundle) == 0 {
+		return fmt.Errorf("conversion webhook CA bundle is empty")
+	}
+	// merge patch keeps fields not set, so the other location is removed
+	clientConfig := map[string]interface{}{"caBundle": config.CABundle}
+	if config.URL != "" {
+		clientConfig["url"] = config.URL
+		clientConfig["service"] = nil
+	} else {
+		if config.Service.Namespace == "" || config.Service.Name == "" {
+			return fmt.Errorf("conversion webhook requires service namespace and name or URL")
+		}
+		clientConfig["service"] = map[string]interface{}{
+			"namespace": config.Service.Namespace,
+			"name":      config.Service.Name,
+			"path":      "/convert",
+		}
+		clientConfig["url"] = nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"conversion": map[string]interface{}{
+				"strategy": "Webhook",
+				"webhook": map[string]interface{}{
+					"clientConfig":             clientConfig,
+					"conversionReviewVersions": []string{"v1"},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	crd := &unstructured.Unstructured{}
+	crd.SetAPIVersion("apiextensions.k8s.io/v1")
+	crd.SetKind("CustomResourceDefinition")
+	crd.SetName(SopsSecretCRDName)
+	return c.Patch(ctx, crd, client.RawPatch(types.MergePatchType, patch))
+}
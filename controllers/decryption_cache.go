@@ -0,0 +1,70 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// decryptionCacheEntry is decrypted SopsSecret together with generation and
+// sops MAC of the encrypted SopsSecret it was decrypted from
+type decryptionCacheEntry struct {
+	generation int64
+	mac        string
+	expires    time.Time
+	instance   *isindirv1alpha2.SopsSecret
+}
+
+// decryptionCache is LRU cache of decrypted SopsSecrets keyed by UID, entries
+// are valid only for the same generation and sops MAC, so spec change
+// invalidates cached payload
+type decryptionCache struct {
+	ttl   time.Duration
+	cache *lru.Cache
+}
+
+func newDecryptionCache(size int, ttl time.Duration) (*decryptionCache, error) {
+	cache, err := lru.New(size)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptionCache{ttl: ttl, cache: cache}, nil
+}
+
+// get returns copy of cached decrypted SopsSecret or nil
+func (c *decryptionCache) get(instanceEncrypted *isindirv1alpha2.SopsSecret) *isindirv1alpha2.SopsSecret {
+	if c == nil {
+		return nil
+	}
+	value, ok := c.cache.Get(instanceEncrypted.UID)
+	if !ok {
+		return nil
+	}
+	entry := value.(*decryptionCacheEntry)
+	if entry.generation != instanceEncrypted.Generation ||
+		entry.mac != instanceEncrypted.Sops.Mac ||
+		(c.ttl > 0 && time.Now().After(entry.expires)) {
+		c.cache.Remove(instanceEncrypted.UID)
+		return nil
+	}
+	return entry.instance.DeepCopy()
+}
+
+// add stores copy of decrypted SopsSecret
+func (c *decryptionCache) add(instanceEncrypted *isindirv1alpha2.SopsSecret, instance *isindirv1alpha2.SopsSecret) {
+	if c == nil {
+		return
+	}
+	c.cache.Add(instanceEncrypted.UID, &decryptionCacheEntry{
+		generation: instanceEncrypted.Generation,
+		mac:        instanceEncrypted.Sops.Mac,
+		expires:    time.Now().Add(c.ttl),
+		instance:   instance.DeepCopy(),
+	})
+}
@@ -0,0 +1,143 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/go-logr/logr"
+	sopsaes "go.mozilla.org/sops/v3/aes"
+	"go.mozilla.org/sops/v3/keyservice"
+	sopsjson "go.mozilla.org/sops/v3/stores/json"
+	"google.golang.org/grpc"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// Decryptor decrypts SopsSecrets and SOPS encrypted documents embedded in
+// their secret templates
+type Decryptor interface {
+	// Decrypt returns decrypted copy of encrypted SopsSecret
+	Decrypt(instanceEncrypted *isindirv1alpha2.SopsSecret, log logr.Logger) (*isindirv1alpha2.SopsSecret, error)
+	// DecryptDocument returns cleartext of SOPS encrypted document in json,
+	// yaml, dotenv, ini or binary format
+	DecryptDocument(document []byte, format string) ([]byte, error)
+}
+
+// sopsDecryptor decrypts SOPS documents in-process, data keys are decrypted
+// by sops key services, which may be local or remote
+type sopsDecryptor struct {
+	keyServices []keyservice.KeyServiceClient
+}
+
+// NewSopsDecryptor returns decryptor which decrypts data keys with given
+// sops key services, tried in order
+func NewSopsDecryptor(keyServices ...keyservice.KeyServiceClient) Decryptor {
+	return &sopsDecryptor{keyServices: keyServices}
+}
+
+func (d *sopsDecryptor) Decrypt(instanceEncrypted *isindirv1alpha2.SopsSecret, log logr.Logger) (*isindirv1alpha2.SopsSecret, error) {
+	return decryptSopsSecretInstance(instanceEncrypted, d.keyServices, log)
+}
+
+func (d *sopsDecryptor) DecryptDocument(document []byte, format string) ([]byte, error) {
+	return customDecryptData(document, format, d.keyServices)
+}
+
+// verifyMac checks that SOPS MAC of encrypted SopsSecret is authenticated by
+// its data key and last modification time. Computed MAC can not be compared
+// with it, as fields Kubernetes adds to stored objects are covered by MAC,
+// tampered values are detected by AES-GCM authentication on decryption
+func (d *sopsDecryptor) verifyMac(instanceEncrypted *isindirv1alpha2.SopsSecret) error {
+	layouts, err := encryptedLayouts(instanceEncrypted)
+	if err != nil {
+		return err
+	}
+	tree, err := (&sopsjson.Store{}).LoadEncryptedFile(layouts[0])
+	if err != nil {
+		return &permanentError{err}
+	}
+	key, err := tree.Metadata.GetDataKeyWithKeyServices(d.keyServices)
+	if err != nil {
+		return err
+	}
+	_, err = sopsaes.NewCipher().Decrypt(
+		tree.Metadata.MessageAuthenticationCode,
+		key,
+		tree.Metadata.LastModified.Format(time.RFC3339),
+	)
+	if err != nil {
+		return &permanentError{&corruptedPayloadError{fmt.Errorf("sops MAC is not authentic: %v", err)}}
+	}
+	return nil
+}
+
+// corruptedPayloadError is returned when encrypted values or SOPS MAC fail
+// authentication with successfully decrypted data key, so the content can
+// never be decrypted
+type corruptedPayloadError struct {
+	err error
+}
+
+func (e *corruptedPayloadError) Error() string {
+	return e.err.Error()
+}
+
+func (e *corruptedPayloadError) Unwrap() error {
+	return e.err
+}
+
+// isCorruptedPayload reports whether the error is caused by corrupted payload
+func isCorruptedPayload(err error) bool {
+	var corruptedErr *corruptedPayloadError
+	return errors.As(err, &corruptedErr)
+}
+
+// DialKeyService connects to external sops keyservice, address is
+// tcp://host:port or unix:///path/to/socket as accepted by sops --keyservice
+func DialKeyService(ctx context.Context, address string) (keyservice.KeyServiceClient, error) {
+	parsed, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid keyservice address %s: %v", address, err)
+	}
+
+	target := parsed.Host
+	switch parsed.Scheme {
+	case "tcp":
+	case "unix":
+		target = parsed.Path
+	default:
+		return nil, fmt.Errorf("invalid keyservice address %s: scheme must be tcp or unix", address)
+	}
+
+	conn, err := grpc.DialContext(
+		ctx,
+		target,
+		// keyservice is expected to run in the same pod or to be reachable
+		// over a trusted network, as sops keyservice serves plain gRPC
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, parsed.Scheme, addr)
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to keyservice %s: %v", address, err)
+	}
+	return keyservice.NewKeyServiceClient(conn), nil
+}
+
+// decryptor returns decryptor of SopsSecret, which uses operator decryptor
+// when configured, or sops with key services of the SopsSecret
+func (r *SopsSecretReconciler) decryptor(ctx context.Context, instance *isindirv1alpha2.SopsSecret) Decryptor {
+	if r.Decryptor != nil {
+		return r.Decryptor
+	}
+	return NewSopsDecryptor(r.keyServices(ctx, instance)...)
+}
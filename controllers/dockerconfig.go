@@ -0,0 +1,52 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// dockerConfigEntry is registry entry of .dockerconfigjson
+type dockerConfigEntry struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Email    string `json:"email,omitempty"`
+	Auth     string `json:"auth"`
+}
+
+// dockerConfigJSON returns .dockerconfigjson content with credentials of a
+// single registry
+func dockerConfigJSON(config *isindirv1alpha2.SecretTemplateDockerConfig) ([]byte, error) {
+	if config.Registry == "" || config.Username == "" || config.Password == "" {
+		return nil, fmt.Errorf("dockerConfig: registry, username and password must be specified")
+	}
+	return json.Marshal(struct {
+		Auths map[string]dockerConfigEntry `json:"auths"`
+	}{
+		Auths: map[string]dockerConfigEntry{
+			config.Registry: {
+				Username: config.Username,
+				Password: config.Password,
+				Email:    config.Email,
+				Auth:     base64.StdEncoding.EncodeToString([]byte(config.Username + ":" + config.Password)),
+			},
+		},
+	})
+}
+
+// dockerConfigSecretType returns type of secret with assembled docker config,
+// which must be kubernetes.io/dockerconfigjson
+func dockerConfigSecretType(secretTpl *isindirv1alpha2.SopsSecretTemplate) (corev1.SecretType, error) {
+	if secretTpl.Type != "" && getSecretType(secretTpl.Type) != corev1.SecretTypeDockerConfigJson {
+		return "", fmt.Errorf("dockerConfig: secret type must be %s, not %s", corev1.SecretTypeDockerConfigJson, secretTpl.Type)
+	}
+	return corev1.SecretTypeDockerConfigJson, nil
+}
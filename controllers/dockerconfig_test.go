@@ -0,0 +1,99 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+func TestDockerConfigJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		config isindirv1alpha2.SecretTemplateDockerConfig
+		want   string
+		err    string
+	}{
+		{
+			name: "escaped password",
+			config: isindirv1alpha2.SecretTemplateDockerConfig{
+				Registry: "registry.example.com",
+				Username: "robot",
+				Password: `p@ss"word\`,
+			},
+			want: `{"auths":{"registry.example.com":{"username":"robot","password":"p@ss\"word\\","auth":"cm9ib3Q6cEBzcyJ3b3JkXA=="}}}`,
+		},
+		{
+			name: "email",
+			config: isindirv1alpha2.SecretTemplateDockerConfig{
+				Registry: "https://index.docker.io/v1/",
+				Username: "robot",
+				Password: "secret",
+				Email:    "robot@example.com",
+			},
+			want: `{"auths":{"https://index.docker.io/v1/":{"username":"robot","password":"secret","email":"robot@example.com","auth":"cm9ib3Q6c2VjcmV0"}}}`,
+		},
+		{
+			name: "missing password",
+			config: isindirv1alpha2.SecretTemplateDockerConfig{
+				Registry: "registry.example.com",
+				Username: "robot",
+			},
+			err: "dockerConfig: registry, username and password must be specified",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := dockerConfigJSON(&tt.config)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewSecretDockerConfig(t *testing.T) {
+	instance := &isindirv1alpha2.SopsSecret{}
+	instance.Name = "registry"
+	instance.Namespace = "default"
+	secretTpl := &isindirv1alpha2.SopsSecretTemplate{
+		Name: "pull-secret",
+		DockerConfig: &isindirv1alpha2.SecretTemplateDockerConfig{
+			Registry: "registry.example.com",
+			Username: "robot",
+			Password: "secret",
+		},
+	}
+
+	secret, err := newSecretForCR(instance, secretTpl, nil, logr.Discard())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Type != corev1.SecretTypeDockerConfigJson {
+		t.Errorf("secret type = %s, want %s", secret.Type, corev1.SecretTypeDockerConfigJson)
+	}
+	want := `{"auths":{"registry.example.com":{"username":"robot","password":"secret","auth":"cm9ib3Q6c2VjcmV0"}}}`
+	if got := string(secret.Data[corev1.DockerConfigJsonKey]); got != want {
+		t.Errorf("%s = %s, want %s", corev1.DockerConfigJsonKey, got, want)
+	}
+
+	secretTpl.Type = "Opaque"
+	if _, err := newSecretForCR(instance, secretTpl, nil, logr.Discard()); err == nil {
+		t.Error("dockerConfig with Opaque type should be rejected")
+	}
+}
@@ -0,0 +1,167 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// DryRunAnnotation set to "true" on SopsSecret makes operator compute changes
+// of generated secrets without writing them
+const DryRunAnnotation = "sops-secrets-operator/dry-run"
+
+// dryRun reports whether SopsSecret is reconciled in dry-run mode
+func dryRun(instance *isindirv1alpha2.SopsSecret) bool {
+	return instance.Annotations[DryRunAnnotation] == "true"
+}
+
+// dryRunChanges renders secret templates and compares them with existing
+// secrets, secret values are never included in the result
+func (r *SopsSecretReconciler) dryRunChanges(
+	ctx context.Context,
+	instance *isindirv1alpha2.SopsSecret,
+	decryptor Decryptor,
+) ([]isindirv1alpha2.SecretChange, error) {
+	declaredSecrets := make(map[string]bool)
+	var changes []isindirv1alpha2.SecretChange
+	writer, err := r.secretWriter(instance)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range instance.Spec.SecretsTemplate {
+		secretTemplate := &instance.Spec.SecretsTemplate[i]
+
+		// secrets pushed to remote clusters are compared with the remote ones
+		c := writer
+		if secretTemplate.Cluster != "" {
+			if err := r.checkRemotePush(secretTemplate.Cluster, instance.Namespace); err != nil {
+				return nil, err
+			}
+			remote, err := r.remoteClient(ctx, secretTemplate.Cluster)
+			if err != nil {
+				return nil, err
+			}
+			c = remote
+		} else {
+			declaredSecrets[secretTemplate.Name] = true
+		}
+
+		newSecret, err := r.newSecret(ctx, instance, secretTemplate, decryptor)
+		if err != nil {
+			return nil, &permanentError{err}
+		}
+
+		foundSecret := &corev1.Secret{}
+		err = c.Get(ctx, types.NamespacedName{Namespace: newSecret.Namespace, Name: newSecret.Name}, foundSecret)
+		if errors.IsNotFound(err) {
+			changes = append(changes, isindirv1alpha2.SecretChange{
+				Name:      newSecret.Name,
+				Action:    "Create",
+				AddedKeys: sortedKeys(newSecret.Data),
+			})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		preserveHtpasswd(secretTemplate, newSecret, foundSecret)
+		var staleKeys []string
+		if metav1.IsControlledBy(foundSecret, instance) && !secretTemplate.Immutable {
+			staleKeys = mergeKeys(secretTemplate, newSecret, foundSecret)
+		}
+		setManagedKeys(newSecret)
+
+		// server-side dry run shows the result of apply, including keys
+		// added to the secret by other actors
+		applied := newSecret.DeepCopy()
+		if err := r.applySecretTo(ctx, c, applied, client.DryRunAll); err != nil {
+			return nil, err
+		}
+		for _, key := range staleKeys {
+			delete(applied.Data, key)
+		}
+		// sync time is set by every apply, it is not a change
+		if lastSync, ok := foundSecret.Annotations[LastSyncAnnotation]; ok {
+			applied.Annotations[LastSyncAnnotation] = lastSync
+		} else {
+			delete(applied.Annotations, LastSyncAnnotation)
+		}
+		change := secretDataChange(newSecret.Name, foundSecret.Data, applied.Data)
+		if change.Action == "None" &&
+			(foundSecret.Type != applied.Type ||
+				!apiequality.Semantic.DeepEqual(foundSecret.Labels, applied.Labels) ||
+				!apiequality.Semantic.DeepEqual(foundSecret.Annotations, applied.Annotations)) {
+			change.Action = "Update"
+		}
+		changes = append(changes, change)
+	}
+
+	ownedSecrets := &corev1.SecretList{}
+	if err := r.listOwnedSecrets(ctx, instance, ownedSecrets); err != nil {
+		return nil, err
+	}
+	immutable := immutableTemplates(instance)
+	for i := range ownedSecrets.Items {
+		secret := &ownedSecrets.Items[i]
+		if declaredSecrets[secret.Name] ||
+			immutable[secret.Annotations[TemplateAnnotation]] ||
+			!metav1.IsControlledBy(secret, instance) {
+			continue
+		}
+		changes = append(changes, isindirv1alpha2.SecretChange{
+			Name:        secret.Name,
+			Action:      "Delete",
+			RemovedKeys: sortedKeys(secret.Data),
+		})
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Name < changes[j].Name
+	})
+	return changes, nil
+}
+
+// secretDataChange compares data of existing and rendered secret
+func secretDataChange(name string, found map[string][]byte, rendered map[string][]byte) isindirv1alpha2.SecretChange {
+	change := isindirv1alpha2.SecretChange{Name: name, Action: "None"}
+	for _, key := range sortedKeys(rendered) {
+		value, ok := found[key]
+		if !ok {
+			change.AddedKeys = append(change.AddedKeys, key)
+		} else if !bytes.Equal(value, rendered[key]) {
+			change.ChangedKeys = append(change.ChangedKeys, key)
+		}
+	}
+	for _, key := range sortedKeys(found) {
+		if _, ok := rendered[key]; !ok {
+			change.RemovedKeys = append(change.RemovedKeys, key)
+		}
+	}
+	if len(change.AddedKeys)+len(change.ChangedKeys)+len(change.RemovedKeys) > 0 {
+		change.Action = "Update"
+	}
+	return change
+}
+
+func sortedKeys(data map[string][]byte) []string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,34 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"fmt"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// setEncryptedAnnotations copies decrypted values of secret data keys to
+// annotations listed in annotationsFromEncrypted. Annotations are readable
+// as plain metadata, so copying requires explicit acknowledgment
+func setEncryptedAnnotations(secretTpl *isindirv1alpha2.SopsSecretTemplate, data map[string][]byte, annotations map[string]string) error {
+	if len(secretTpl.AnnotationsFromEncrypted) == 0 {
+		return nil
+	}
+	if !secretTpl.AcknowledgeAnnotationExposure {
+		return fmt.Errorf("annotationsFromEncrypted: acknowledgeAnnotationExposure must be set to expose decrypted values in annotations")
+	}
+	for name, key := range secretTpl.AnnotationsFromEncrypted {
+		if _, ok := annotations[name]; ok {
+			return fmt.Errorf("annotationsFromEncrypted[%v]: annotation is already defined in annotations", name)
+		}
+		value, ok := data[key]
+		if !ok {
+			return fmt.Errorf("annotationsFromEncrypted[%v]: key %v is not defined in data", name, key)
+		}
+		annotations[name] = string(value)
+	}
+	return nil
+}
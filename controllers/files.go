@@ -0,0 +1,31 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// checkFilesEncrypted rejects SopsSecret with spec.files values which are not
+// encrypted by sops. Files are not covered by --encrypted-suffix='Templates',
+// so such SopsSecrets would keep secret files in plain text in the cluster
+// and in git
+func checkFilesEncrypted(instance *isindirv1alpha2.SopsSecret) error {
+	var plain []string
+	for path, content := range instance.Spec.Files {
+		if !strings.HasPrefix(content, "ENC[") {
+			plain = append(plain, path)
+		}
+	}
+	if len(plain) == 0 {
+		return nil
+	}
+	sort.Strings(plain)
+	return &permanentError{fmt.Errorf("spec.files %s are not encrypted by sops, encrypt files with --encrypted-regex", strings.Join(plain, ", "))}
+}
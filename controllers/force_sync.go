@@ -0,0 +1,22 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// ForceSyncAnnotation requests full re-sync of SopsSecret each time its value,
+// usually a timestamp, changes
+const ForceSyncAnnotation = "sops-secrets-operator/force-sync"
+
+// forceSyncRequested reports whether force-sync annotation of SopsSecret has
+// value which was not synced yet. While it is requested, decryption cache and
+// corrupted payload check are bypassed and generated secrets are applied even
+// when they are up to date
+func forceSyncRequested(instance *isindirv1alpha2.SopsSecret) bool {
+	value := instance.Annotations[ForceSyncAnnotation]
+	return value != "" && value != instance.Status.LastForceSync
+}
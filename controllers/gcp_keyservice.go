@@ -0,0 +1,146 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.mozilla.org/sops/v3/keyservice"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/cloudkms/v1"
+	"google.golang.org/api/iamcredentials/v1"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+)
+
+const gcpCloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// gcpTokenCache caches token sources of impersonated GCP service accounts, tokens
+// are refreshed by the token source when they expire
+type gcpTokenCache struct {
+	lock    sync.Mutex
+	sources map[string]oauth2.TokenSource
+}
+
+// tokenSource returns cached token source for the service account
+func (c *gcpTokenCache) tokenSource(serviceAccount string) (oauth2.TokenSource, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if ts, ok := c.sources[serviceAccount]; ok {
+		return ts, nil
+	}
+
+	// operator own credentials are used to impersonate service account
+	iamService, err := iamcredentials.NewService(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	if c.sources == nil {
+		c.sources = make(map[string]oauth2.TokenSource)
+	}
+	ts := oauth2.ReuseTokenSource(nil, &impersonatedTokenSource{
+		service:        iamService,
+		serviceAccount: serviceAccount,
+	})
+	c.sources[serviceAccount] = ts
+	return ts, nil
+}
+
+// impersonatedTokenSource generates access tokens of a service account using IAM credentials API
+type impersonatedTokenSource struct {
+	service        *iamcredentials.Service
+	serviceAccount string
+}
+
+// Token generates new access token of the impersonated service account
+func (ts *impersonatedTokenSource) Token() (*oauth2.Token, error) {
+	resp, err := ts.service.Projects.ServiceAccounts.GenerateAccessToken(
+		fmt.Sprintf("projects/-/serviceAccounts/%s", ts.serviceAccount),
+		&iamcredentials.GenerateAccessTokenRequest{
+			Scope: []string{gcpCloudPlatformScope},
+		},
+	).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to impersonate GCP service account %s: %v", ts.serviceAccount, err)
+	}
+
+	expiry, err := time.Parse(time.RFC3339, resp.ExpireTime)
+	if err != nil {
+		return nil, err
+	}
+	return &oauth2.Token{
+		AccessToken: resp.AccessToken,
+		Expiry:      expiry,
+	}, nil
+}
+
+// gcpImpersonationKeyService is a sops key service client which decrypts GCP
+// KMS keys as impersonated service account
+type gcpImpersonationKeyService struct {
+	cache          *gcpTokenCache
+	serviceAccount string
+	next           keyservice.KeyServiceClient
+}
+
+func newGcpImpersonationKeyService(
+	cache *gcpTokenCache,
+	serviceAccount string,
+	next keyservice.KeyServiceClient,
+) keyservice.KeyServiceClient {
+	return &gcpImpersonationKeyService{
+		cache:          cache,
+		serviceAccount: serviceAccount,
+		next:           next,
+	}
+}
+
+// Encrypt is not used by the operator and is always delegated
+func (ks *gcpImpersonationKeyService) Encrypt(
+	ctx context.Context,
+	req *keyservice.EncryptRequest,
+	opts ...grpc.CallOption,
+) (*keyservice.EncryptResponse, error) {
+	return ks.next.Encrypt(ctx, req, opts...)
+}
+
+// Decrypt decrypts GCP KMS data key using impersonated service account credentials
+func (ks *gcpImpersonationKeyService) Decrypt(
+	ctx context.Context,
+	req *keyservice.DecryptRequest,
+	opts ...grpc.CallOption,
+) (*keyservice.DecryptResponse, error) {
+	gcpKey := req.Key.GetGcpKmsKey()
+	if gcpKey == nil {
+		return ks.next.Decrypt(ctx, req, opts...)
+	}
+
+	ts, err := ks.cache.tokenSource(ks.serviceAccount)
+	if err != nil {
+		return nil, err
+	}
+	kmsService, err := cloudkms.NewService(ctx, option.WithTokenSource(ts))
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := kmsService.Projects.Locations.KeyRings.CryptoKeys.Decrypt(
+		gcpKey.ResourceId,
+		&cloudkms.DecryptRequest{Ciphertext: string(req.Ciphertext)},
+	).Do()
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := base64.StdEncoding.DecodeString(resp.Plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return &keyservice.DecryptResponse{Plaintext: plaintext}, nil
+}
@@ -0,0 +1,87 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// ReconciledReason is the reason of conditions of successfully reconciled SopsSecret
+const ReconciledReason = "Reconciled"
+
+// setHealth records reconciliation outcome of the current generation in
+// observedGeneration and Ready, Progressing and Stalled conditions, following
+// kstatus conventions understood by Argo CD and Flux. Empty failure class
+// means success, transient and credential failures are progressing as they
+// are retried, permanent failures are stalled until SopsSecret changes.
+func setHealth(instance *isindirv1alpha2.SopsSecret, class failureClass, reason string, message string) {
+	status := &instance.Status
+	status.ObservedGeneration = instance.Generation
+
+	ready := metav1.ConditionFalse
+	progressing := metav1.ConditionFalse
+	switch class {
+	case "":
+		ready = metav1.ConditionTrue
+	case transientFailure, credentialFailure:
+		progressing = metav1.ConditionTrue
+	}
+	setFailureCondition(instance, class, reason, message)
+
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               isindirv1alpha2.ReadyCondition,
+		Status:             ready,
+		ObservedGeneration: instance.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               isindirv1alpha2.ProgressingCondition,
+		Status:             progressing,
+		ObservedGeneration: instance.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+	if class == permanentFailure {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               isindirv1alpha2.StalledCondition,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: instance.Generation,
+			Reason:             reason,
+			Message:            message,
+		})
+	} else {
+		meta.RemoveStatusCondition(&status.Conditions, isindirv1alpha2.StalledCondition)
+	}
+}
+
+// failureConditions are conditions of failure classes, so the way failure is
+// retried can be told from SopsSecret status
+var failureConditions = map[failureClass]string{
+	permanentFailure:  isindirv1alpha2.SpecErrorCondition,
+	credentialFailure: isindirv1alpha2.CredentialErrorCondition,
+	transientFailure:  isindirv1alpha2.APIErrorCondition,
+}
+
+// setFailureCondition sets condition of the failure class and removes
+// conditions of other classes, empty failure class removes all of them
+func setFailureCondition(instance *isindirv1alpha2.SopsSecret, class failureClass, reason string, message string) {
+	for failure, conditionType := range failureConditions {
+		if failure != class {
+			meta.RemoveStatusCondition(&instance.Status.Conditions, conditionType)
+			continue
+		}
+		meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+			Type:               conditionType,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: instance.Generation,
+			Reason:             reason,
+			Message:            message,
+		})
+	}
+}
@@ -0,0 +1,62 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+	corev1 "k8s.io/api/core/v1"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// htpasswdEntry returns htpasswd line with bcrypt hashed password of
+// kubernetes.io/basic-auth secret data
+func htpasswdEntry(secretTpl *isindirv1alpha2.SopsSecretTemplate, data map[string][]byte) ([]byte, error) {
+	if getSecretType(secretTpl.Type) != corev1.SecretTypeBasicAuth {
+		return nil, fmt.Errorf("htpasswdKey: secret type must be %s", corev1.SecretTypeBasicAuth)
+	}
+	if secretTpl.Immutable {
+		return nil, fmt.Errorf("htpasswdKey: immutable secret can not have salted password hash")
+	}
+	if _, ok := data[secretTpl.HtpasswdKey]; ok {
+		return nil, fmt.Errorf("htpasswdKey: key %v is already defined in data", secretTpl.HtpasswdKey)
+	}
+	username, password := data[corev1.BasicAuthUsernameKey], data[corev1.BasicAuthPasswordKey]
+	if len(username) == 0 || len(password) == 0 {
+		return nil, fmt.Errorf("htpasswdKey: %s and %s keys must be specified", corev1.BasicAuthUsernameKey, corev1.BasicAuthPasswordKey)
+	}
+	if bytes.ContainsAny(username, ":\n") {
+		return nil, fmt.Errorf("htpasswdKey: %s must not contain colon or newline", corev1.BasicAuthUsernameKey)
+	}
+	hash, err := bcrypt.GenerateFromPassword(password, bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("htpasswdKey: %v", err)
+	}
+	return []byte(fmt.Sprintf("%s:%s\n", username, hash)), nil
+}
+
+// preserveHtpasswd keeps htpasswd entry of found secret when it still matches
+// rendered username and password. Salt of bcrypt hash is random, so rendered
+// entry differs on every render and would rewrite the secret otherwise
+func preserveHtpasswd(secretTpl *isindirv1alpha2.SopsSecretTemplate, rendered *corev1.Secret, found *corev1.Secret) {
+	if secretTpl.HtpasswdKey == "" {
+		return
+	}
+	current, ok := found.Data[secretTpl.HtpasswdKey]
+	if !ok {
+		return
+	}
+	entry := bytes.SplitN(bytes.TrimSpace(current), []byte(":"), 2)
+	if len(entry) != 2 ||
+		!bytes.Equal(entry[0], rendered.Data[corev1.BasicAuthUsernameKey]) ||
+		bcrypt.CompareHashAndPassword(entry[1], rendered.Data[corev1.BasicAuthPasswordKey]) != nil {
+		return
+	}
+	rendered.Data[secretTpl.HtpasswdKey] = current
+	setContentHash(rendered, secretContentHash(rendered))
+}
@@ -0,0 +1,69 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"golang.org/x/crypto/bcrypt"
+	corev1 "k8s.io/api/core/v1"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+func TestPreserveHtpasswd(t *testing.T) {
+	instance := &isindirv1alpha2.SopsSecret{}
+	instance.Name = "ingress-auth"
+	instance.Namespace = "default"
+	render := func(username, password string) (*isindirv1alpha2.SopsSecretTemplate, *corev1.Secret) {
+		secretTpl := &isindirv1alpha2.SopsSecretTemplate{
+			Name:        "ingress-auth",
+			Type:        "kubernetes.io/basic-auth",
+			HtpasswdKey: "auth",
+			Data:        map[string]string{"username": username, "password": password},
+		}
+		secret, err := newSecretForCR(instance, secretTpl, nil, logr.Discard())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return secretTpl, secret
+	}
+	_, found := render("admin", "secret")
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		preserve bool
+	}{
+		{name: "unchanged password", username: "admin", password: "secret", preserve: true},
+		{name: "changed password", username: "admin", password: "changed"},
+		{name: "changed username", username: "operator", password: "secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secretTpl, rendered := render(tt.username, tt.password)
+			preserveHtpasswd(secretTpl, rendered, found)
+
+			entry := rendered.Data["auth"]
+			preserved := bytes.Equal(entry, found.Data["auth"])
+			if preserved != tt.preserve {
+				t.Fatalf("htpasswd entry preserved = %t, want %t", preserved, tt.preserve)
+			}
+			if tt.preserve && rendered.Annotations[ContentHashAnnotation] != found.Annotations[ContentHashAnnotation] {
+				t.Error("content hash of preserved entry should match found secret")
+			}
+			parts := bytes.SplitN(bytes.TrimSpace(entry), []byte(":"), 2)
+			if len(parts) != 2 || string(parts[0]) != tt.username {
+				t.Fatalf("htpasswd entry %q is not for user %s", entry, tt.username)
+			}
+			if err := bcrypt.CompareHashAndPassword(parts[1], []byte(tt.password)); err != nil {
+				t.Errorf("htpasswd entry does not match password: %v", err)
+			}
+		})
+	}
+}
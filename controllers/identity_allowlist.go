@@ -0,0 +1,90 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// IdentityAllowlist limits cloud identities SopsSecrets may select with
+// spec.awsRoleARN, spec.gcpServiceAccount and spec.azureIdentity, so
+// SopsSecret authors can not make operator act as any identity operator
+// credentials may assume. Each entry allows identities matching its pattern
+// in SopsSecrets of its namespace only, so teams can not select identities of
+// other teams. Empty list rejects every override
+type IdentityAllowlist struct {
+	AwsRoles           []IdentityPattern
+	GcpServiceAccounts []IdentityPattern
+	AzureIdentities    []IdentityPattern
+}
+
+// IdentityPattern allows identities matching path.Match Pattern, "*" allows
+// any identity, in SopsSecrets of Namespace, "*" allows all namespaces
+type IdentityPattern struct {
+	Namespace string
+	Pattern   string
+}
+
+// ParseIdentityPatterns parses comma separated namespace=pattern entries of
+// identity allowlist flags
+func ParseIdentityPatterns(value string) ([]IdentityPattern, error) {
+	var patterns []IdentityPattern
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("invalid namespace=pattern entry %q", item)
+		}
+		pattern := IdentityPattern{Namespace: strings.TrimSpace(parts[0]), Pattern: strings.TrimSpace(parts[1])}
+		if _, err := path.Match(pattern.Pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", pattern.Pattern, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// namespaceIdentityAllowed reports whether identity matches one of patterns
+// allowed in the namespace
+func namespaceIdentityAllowed(patterns []IdentityPattern, namespace string, identity string) bool {
+	for _, pattern := range patterns {
+		if pattern.Namespace != "*" && pattern.Namespace != namespace {
+			continue
+		}
+		if pattern.Pattern == "*" {
+			return true
+		}
+		if matched, err := path.Match(pattern.Pattern, identity); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIdentityOverrides rejects SopsSecret selecting identities which are
+// not allowed, identities of key profiles are configured by operator
+// administrators and are not checked
+func (r *SopsSecretReconciler) checkIdentityOverrides(instance *isindirv1alpha2.SopsSecret) error {
+	spec := &instance.Spec
+	if spec.KeyProfile != "" {
+		return nil
+	}
+	if spec.AwsRoleARN != "" && !namespaceIdentityAllowed(r.IdentityAllowlist.AwsRoles, instance.Namespace, spec.AwsRoleARN) {
+		return &permanentError{fmt.Errorf("AWS role %s is not allowed in namespace %s by --allowed-aws-roles", spec.AwsRoleARN, instance.Namespace)}
+	}
+	if spec.GcpServiceAccount != "" && !namespaceIdentityAllowed(r.IdentityAllowlist.GcpServiceAccounts, instance.Namespace, spec.GcpServiceAccount) {
+		return &permanentError{fmt.Errorf("GCP service account %s is not allowed in namespace %s by --allowed-gcp-service-accounts", spec.GcpServiceAccount, instance.Namespace)}
+	}
+	if spec.AzureIdentity != "" && !namespaceIdentityAllowed(r.IdentityAllowlist.AzureIdentities, instance.Namespace, spec.AzureIdentity) {
+		return &permanentError{fmt.Errorf("Azure identity %s is not allowed in namespace %s by --allowed-azure-identities", spec.AzureIdentity, instance.Namespace)}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+func TestParseIdentityPatterns(t *testing.T) {
+	patterns, err := ParseIdentityPatterns(" team-a=arn:aws:iam::1:role/team-a-*, *=arn:aws:iam::1:role/shared ,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []IdentityPattern{
+		{Namespace: "team-a", Pattern: "arn:aws:iam::1:role/team-a-*"},
+		{Namespace: "*", Pattern: "arn:aws:iam::1:role/shared"},
+	}
+	if !reflect.DeepEqual(patterns, expected) {
+		t.Errorf("unexpected patterns %+v", patterns)
+	}
+
+	for _, value := range []string{"arn:aws:iam::1:role/team-a", "=arn:aws:iam::1:role/team-a", "team-a=", "team-a=[", "*"} {
+		if _, err := ParseIdentityPatterns(value); err == nil {
+			t.Errorf("%q should be rejected", value)
+		}
+	}
+}
+
+func TestCheckIdentityOverridesAwsRole(t *testing.T) {
+	r := &SopsSecretReconciler{
+		IdentityAllowlist: IdentityAllowlist{
+			AwsRoles: []IdentityPattern{
+				{Namespace: "team-a", Pattern: "arn:aws:iam::1:role/team-a-*"},
+				{Namespace: "*", Pattern: "arn:aws:iam::1:role/shared"},
+			},
+		},
+	}
+	tests := []struct {
+		namespace string
+		role      string
+		allowed   bool
+	}{
+		{namespace: "team-a", role: "arn:aws:iam::1:role/team-a-sops", allowed: true},
+		{namespace: "team-b", role: "arn:aws:iam::1:role/team-a-sops", allowed: false},
+		{namespace: "team-b", role: "arn:aws:iam::1:role/shared", allowed: true},
+		{namespace: "team-a", role: "arn:aws:iam::1:role/admin", allowed: false},
+	}
+	for _, tt := range tests {
+		instance := &isindirv1alpha2.SopsSecret{ObjectMeta: metav1.ObjectMeta{Namespace: tt.namespace}}
+		instance.Spec.AwsRoleARN = tt.role
+		err := r.checkIdentityOverrides(instance)
+		if tt.allowed && err != nil {
+			t.Errorf("%s in %s should be allowed: %v", tt.role, tt.namespace, err)
+		}
+		if !tt.allowed && classifyFailure(err) != permanentFailure {
+			t.Errorf("%s in %s should be rejected permanently, got %v", tt.role, tt.namespace, err)
+		}
+	}
+}
+
+func TestCheckIdentityOverridesGcpServiceAccount(t *testing.T) {
+	r := &SopsSecretReconciler{
+		IdentityAllowlist: IdentityAllowlist{
+			GcpServiceAccounts: []IdentityPattern{
+				{Namespace: "team-a", Pattern: "team-a-*@project.iam.gserviceaccount.com"},
+			},
+		},
+	}
+	instance := &isindirv1alpha2.SopsSecret{ObjectMeta: metav1.ObjectMeta{Namespace: "team-a"}}
+	instance.Spec.GcpServiceAccount = "team-a-sops@project.iam.gserviceaccount.com"
+	if err := r.checkIdentityOverrides(instance); err != nil {
+		t.Errorf("service account should be allowed in team-a: %v", err)
+	}
+	instance.Namespace = "team-b"
+	if err := r.checkIdentityOverrides(instance); classifyFailure(err) != permanentFailure {
+		t.Errorf("service account of team-a should be rejected in team-b, got %v", err)
+	}
+}
+
+func TestCheckIdentityOverridesAzureIdentity(t *testing.T) {
+	r := &SopsSecretReconciler{
+		IdentityAllowlist: IdentityAllowlist{
+			AzureIdentities: []IdentityPattern{
+				{Namespace: "team-a", Pattern: "00000000-0000-0000-0000-000000000000"},
+			},
+		},
+	}
+	instance := &isindirv1alpha2.SopsSecret{ObjectMeta: metav1.ObjectMeta{Namespace: "team-a"}}
+	instance.Spec.AzureIdentity = "00000000-0000-0000-0000-000000000000"
+	if err := r.checkIdentityOverrides(instance); err != nil {
+		t.Errorf("identity should be allowed in team-a: %v", err)
+	}
+	instance.Namespace = "team-b"
+	if err := r.checkIdentityOverrides(instance); classifyFailure(err) != permanentFailure {
+		t.Errorf("identity of team-a should be rejected in team-b, got %v", err)
+	}
+}
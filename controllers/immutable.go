@@ -0,0 +1,110 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"context"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+const (
+	// TemplateAnnotation holds name of the secret template immutable secret is
+	// generated from
+	TemplateAnnotation = "sops-secrets-operator/template"
+
+	// defaultRevisionHistoryLimit is the number of previous immutable secrets
+	// retained when secret template does not set revision history limit
+	defaultRevisionHistoryLimit = 1
+)
+
+// immutableSecretName returns name of immutable secret with content hash suffix
+func immutableSecretName(templateName string, contentHash string) string {
+	return templateName + "-" + contentHash[:10]
+}
+
+// secretTemplateName returns name of the secret template secret is generated from
+func secretTemplateName(secret *corev1.Secret) string {
+	if name, ok := secret.Annotations[TemplateAnnotation]; ok {
+		return name
+	}
+	return secret.Name
+}
+
+// immutableTemplates returns names of immutable secret templates of SopsSecret
+func immutableTemplates(instance *isindirv1alpha2.SopsSecret) map[string]bool {
+	templates := make(map[string]bool)
+	for i := range instance.Spec.SecretsTemplate {
+		if instance.Spec.SecretsTemplate[i].Immutable {
+			templates[instance.Spec.SecretsTemplate[i].Name] = true
+		}
+	}
+	return templates
+}
+
+// pruneSecretGenerations deletes previous immutable secrets of the secret
+// template beyond its revision history limit, the newest ones are retained
+func (r *SopsSecretReconciler) pruneSecretGenerations(
+	ctx context.Context,
+	instance *isindirv1alpha2.SopsSecret,
+	secretTemplate *isindirv1alpha2.SopsSecretTemplate,
+	activeSecret string,
+) error {
+	log := r.logger(ctx)
+	ownedSecrets := &corev1.SecretList{}
+	if err := r.listOwnedSecrets(ctx, instance, ownedSecrets); err != nil {
+		return err
+	}
+
+	var previous []*corev1.Secret
+	for i := range ownedSecrets.Items {
+		secret := &ownedSecrets.Items[i]
+		if secret.Name == activeSecret ||
+			secret.Annotations[TemplateAnnotation] != secretTemplate.Name ||
+			!metav1.IsControlledBy(secret, instance) {
+			continue
+		}
+		previous = append(previous, secret)
+	}
+
+	limit := defaultRevisionHistoryLimit
+	if secretTemplate.RevisionHistoryLimit != nil {
+		limit = int(*secretTemplate.RevisionHistoryLimit)
+	}
+	if len(previous) <= limit {
+		return nil
+	}
+	writer, err := r.secretWriter(instance)
+	if err != nil {
+		return err
+	}
+	sort.Slice(previous, func(i, j int) bool {
+		if !previous[i].CreationTimestamp.Equal(&previous[j].CreationTimestamp) {
+			return previous[j].CreationTimestamp.Before(&previous[i].CreationTimestamp)
+		}
+		return previous[i].Name < previous[j].Name
+	})
+
+	for _, secret := range previous[limit:] {
+		log.Info(
+			"Deleting previous immutable Secret",
+			"secret",
+			secret.Name,
+			"namespace",
+			secret.Namespace,
+		)
+		if err := writer.Delete(ctx, secret); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		r.Recorder.Eventf(instance, corev1.EventTypeNormal, "SecretDeleted", "Previous immutable secret %s deleted", secret.Name)
+		r.secretChanged(ctx, instance, SecretDeletedAction, secret)
+	}
+	return nil
+}
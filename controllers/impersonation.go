@@ -0,0 +1,64 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"fmt"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// serviceAccountUserPrefix prefixes user names of service accounts
+const serviceAccountUserPrefix = "system:serviceaccount:"
+
+// impersonatedClients caches clients impersonating service accounts by
+// service account user name
+type impersonatedClients struct {
+	lock    sync.Mutex
+	clients map[string]client.Client
+}
+
+// secretWriter returns client generated secrets of SopsSecret are written
+// with, it impersonates spec.serviceAccountName when set and is the operator
+// client otherwise. Every write of generated and replicated secrets goes
+// through it, generated secrets are still read with the operator client and
+// cache
+func (r *SopsSecretReconciler) secretWriter(instance *isindirv1alpha2.SopsSecret) (client.Client, error) {
+	name := instance.Spec.ServiceAccountName
+	if name == "" {
+		return r.Client, nil
+	}
+	if r.RestConfig == nil {
+		return nil, &permanentError{fmt.Errorf("serviceAccountName %s: impersonation is not enabled in the operator", name)}
+	}
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+		return nil, &permanentError{fmt.Errorf("serviceAccountName %s is invalid: %v", name, errs)}
+	}
+	// API server adds service account groups to impersonated service
+	// account, so RBAC bound to groups applies as well
+	userName := serviceAccountUserPrefix + instance.Namespace + ":" + name
+
+	r.impersonated.lock.Lock()
+	defer r.impersonated.lock.Unlock()
+	if c, ok := r.impersonated.clients[userName]; ok {
+		return c, nil
+	}
+	config := rest.CopyConfig(r.RestConfig)
+	config.Impersonate = rest.ImpersonationConfig{UserName: userName}
+	c, err := client.New(config, client.Options{Scheme: r.Scheme, Mapper: r.RESTMapper()})
+	if err != nil {
+		return nil, fmt.Errorf("creating client impersonating %s: %v", userName, err)
+	}
+	if r.impersonated.clients == nil {
+		r.impersonated.clients = make(map[string]client.Client)
+	}
+	r.impersonated.clients[userName] = c
+	return c, nil
+}
@@ -0,0 +1,73 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// TestSecretDeletionUsesSecretWriter checks secrets of SopsSecret with
+// serviceAccountName are never deleted or released with operator client
+func TestSecretDeletionUsesSecretWriter(t *testing.T) {
+	controller := true
+	instance := &isindirv1alpha2.SopsSecret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "app", UID: "sopssecret-uid"},
+	}
+	instance.Spec.ServiceAccountName = "deployer"
+	instance.Spec.DeletionPolicy = isindirv1alpha2.OrphanDeletionPolicy
+	owned := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "team-a",
+		Name:      "stale",
+		OwnerReferences: []metav1.OwnerReference{{
+			APIVersion: isindirv1alpha2.GroupVersion.String(),
+			Kind:       "SopsSecret",
+			Name:       instance.Name,
+			UID:        instance.UID,
+			Controller: &controller,
+		}},
+	}}
+	replica := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+		Namespace: "team-b",
+		Name:      "app",
+		Labels:    map[string]string{ReplicaOfUIDLabel: string(instance.UID)},
+	}}
+
+	tests := map[string]func(r *SopsSecretReconciler) error{
+		"prune orphaned": func(r *SopsSecretReconciler) error {
+			return r.pruneOrphanedSecrets(context.Background(), instance, map[string]bool{})
+		},
+		"prune replicas": func(r *SopsSecretReconciler) error {
+			return r.pruneReplicas(context.Background(), instance, nil)
+		},
+		"release": func(r *SopsSecretReconciler) error {
+			return r.releaseSecrets(context.Background(), instance)
+		},
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			// impersonation is not enabled, RestConfig is not set
+			r := newFakeReconciler(owned.DeepCopy(), replica.DeepCopy())
+			if err := run(r); classifyFailure(err) != permanentFailure {
+				t.Fatalf("expected impersonation error, got %v", err)
+			}
+			for _, secret := range []*corev1.Secret{owned, replica} {
+				found := &corev1.Secret{}
+				if err := r.Get(context.Background(), types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name}, found); err != nil {
+					t.Fatalf("secret %s/%s was deleted with operator client: %v", secret.Namespace, secret.Name, err)
+				}
+				if len(found.OwnerReferences) != len(secret.OwnerReferences) || len(found.Labels) != len(secret.Labels) {
+					t.Errorf("secret %s/%s was released with operator client", secret.Namespace, secret.Name)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// initialSync tracks the first pass over all SopsSecrets after operator
+// start. SopsSecrets existing when informer cache synced are pending until
+// they are reconciled once, whatever the outcome
+type initialSync struct {
+	reconciler *SopsSecretReconciler
+	cache      cache.Cache
+	timeout    time.Duration
+	started    time.Time
+
+	lock       sync.Mutex
+	listed     bool
+	done       bool
+	pending    map[types.NamespacedName]bool
+	reconciled map[types.NamespacedName]bool
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, standby
+// replicas never reconcile and become ready after the timeout
+func (s *initialSync) NeedLeaderElection() bool {
+	return false
+}
+
+// Start implements manager.Runnable, it lists SopsSecrets once informer
+// cache is synced
+func (s *initialSync) Start(ctx context.Context) error {
+	if !s.cache.WaitForCacheSync(ctx) {
+		return nil
+	}
+	sopsSecrets := &isindirv1alpha2.SopsSecretList{}
+	if err := s.reconciler.List(ctx, sopsSecrets); err != nil {
+		return fmt.Errorf("listing SopsSecrets for initial sync: %v", err)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i := range sopsSecrets.Items {
+		sopsSecret := &sopsSecrets.Items[i]
+		name := types.NamespacedName{Namespace: sopsSecret.Namespace, Name: sopsSecret.Name}
+		if s.reconciler.watched(sopsSecret) && !s.reconciled[name] {
+			s.pending[name] = true
+		}
+	}
+	s.listed = true
+	s.reconciled = nil
+	return nil
+}
+
+// reconcileDone records SopsSecret reconciliation
+func (s *initialSync) reconcileDone(name types.NamespacedName) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.done {
+		return
+	}
+	if s.listed {
+		delete(s.pending, name)
+	} else {
+		s.reconciled[name] = true
+	}
+}
+
+func (s *initialSync) check(_ *http.Request) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.done {
+		return nil
+	}
+	if (s.listed && len(s.pending) == 0) || time.Since(s.started) >= s.timeout {
+		// once ready, operator stays ready
+		s.done = true
+		s.pending = nil
+		return nil
+	}
+	if !s.listed {
+		return fmt.Errorf("informer cache is not synced yet")
+	}
+	return fmt.Errorf("%d SopsSecrets are not reconciled since start", len(s.pending))
+}
+
+// InitialSyncChecker returns readiness check failing until all SopsSecrets
+// existing on start are reconciled or initial sync timeout elapses, check
+// always passes when initial sync timeout is not set
+func (r *SopsSecretReconciler) InitialSyncChecker() healthz.Checker {
+	return func(req *http.Request) error {
+		if r.initialSync == nil {
+			return nil
+		}
+		return r.initialSync.check(req)
+	}
+}
@@ -0,0 +1,122 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mozilla.org/sops/v3"
+	"go.mozilla.org/sops/v3/keys"
+	"go.mozilla.org/sops/v3/keyservice"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// keyGroupsError is returned when operator credentials decrypt fewer sops
+// key groups than required to recover the data key
+type keyGroupsError struct {
+	required int
+	groups   []isindirv1alpha2.KeyGroupStatus
+}
+
+func (e *keyGroupsError) Error() string {
+	return fmt.Sprintf(
+		"%d of %d key groups required to decrypt data key, %d decrypted with available credentials",
+		e.required,
+		len(e.groups),
+		e.satisfied(),
+	)
+}
+
+// asKeyGroupsError returns keyGroupsError wrapped in the error or nil
+func asKeyGroupsError(err error) *keyGroupsError {
+	var groupsErr *keyGroupsError
+	if errors.As(err, &groupsErr) {
+		return groupsErr
+	}
+	return nil
+}
+
+func (e *keyGroupsError) satisfied() int {
+	satisfied := 0
+	for _, group := range e.groups {
+		if group.Satisfied {
+			satisfied++
+		}
+	}
+	return satisfied
+}
+
+// checkKeyGroups decrypts master keys of every key group one by one after
+// data key decryption failed and returns keyGroupsError if not enough key
+// groups are decrypted, nil otherwise. Documents without multiple key groups
+// are not checked, their failure is a plain decryption error.
+func checkKeyGroups(metadata sops.Metadata, keyServices []keyservice.KeyServiceClient) error {
+	if len(metadata.KeyGroups) < 2 {
+		return nil
+	}
+	required := metadata.ShamirThreshold
+	if required <= 0 || required > len(metadata.KeyGroups) {
+		required = len(metadata.KeyGroups)
+	}
+
+	err := &keyGroupsError{required: required}
+	for _, group := range metadata.KeyGroups {
+		status := isindirv1alpha2.KeyGroupStatus{Keys: []string{}}
+		for _, key := range group {
+			status.Keys = append(status.Keys, key.ToString())
+			if !status.Satisfied && decryptsMasterKey(key, keyServices) {
+				status.Satisfied = true
+			}
+		}
+		err.groups = append(err.groups, status)
+	}
+	if err.satisfied() >= required {
+		return nil
+	}
+	return err
+}
+
+func decryptsMasterKey(key keys.MasterKey, keyServices []keyservice.KeyServiceClient) bool {
+	svcKey := keyservice.KeyFromMasterKey(key)
+	for _, ks := range keyServices {
+		_, err := ks.Decrypt(context.Background(), &keyservice.DecryptRequest{
+			Key:        &svcKey,
+			Ciphertext: key.EncryptedDataKey(),
+		})
+		if err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// sopsKeyGroups returns key groups of SopsSecret, keys outside of key groups
+// form a single key group
+func sopsKeyGroups(metadata *isindirv1alpha2.SopsMetadata) []isindirv1alpha2.KeyGroup {
+	if len(metadata.KeyGroups) > 0 {
+		return metadata.KeyGroups
+	}
+	return []isindirv1alpha2.KeyGroup{{
+		AwsKms:   metadata.AwsKms,
+		Pgp:      metadata.Pgp,
+		AzureKms: metadata.AzureKms,
+		HcVault:  metadata.HcVault,
+		GcpKms:   metadata.GcpKms,
+		Age:      metadata.Age,
+	}}
+}
+
+// usesPgp returns true if any key group of SopsSecret has PGP keys
+func usesPgp(instance *isindirv1alpha2.SopsSecret) bool {
+	for _, group := range sopsKeyGroups(&instance.Sops) {
+		if len(group.Pgp) > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,304 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+
+	"filippo.io/age"
+	"go.mozilla.org/sops/v3/keyservice"
+	"golang.org/x/crypto/openpgp"
+	"google.golang.org/grpc"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// KeyProfiles is the operator key profiles configuration file
+type KeyProfiles struct {
+	Profiles map[string]*KeyProfile `json:"profiles"`
+}
+
+// KeyProfile is a set of credentials used to decrypt SopsSecrets which
+// select it with spec.keyProfile. Data keys of types the profile has no
+// credentials for are not decrypted, operator credentials are never used
+type KeyProfile struct {
+	// Namespaces lists namespaces SopsSecrets of which may use the profile,
+	// "*" allows all namespaces
+	Namespaces []string `json:"namespaces"`
+	// AgeKeysSecret is secret with age identities
+	AgeKeysSecret *KeyProfileSecret `json:"ageKeysSecret,omitempty"`
+	// GpgKeysSecret is secret with PGP private keys, protected keys are
+	// unlocked with passphrase key of the secret
+	GpgKeysSecret *KeyProfileSecret `json:"gpgKeysSecret,omitempty"`
+	// Vault is Vault authentication used to decrypt Vault transit data keys
+	Vault *KeyProfileVault `json:"vault,omitempty"`
+	// AwsRoleARN is AWS IAM role assumed to decrypt AWS KMS data keys
+	AwsRoleARN string `json:"awsRoleARN,omitempty"`
+	// GcpServiceAccount is GCP service account impersonated to decrypt GCP
+	// KMS data keys
+	GcpServiceAccount string `json:"gcpServiceAccount,omitempty"`
+	// AzureIdentity is client ID of Azure managed identity used to decrypt
+	// Azure Key Vault data keys
+	AzureIdentity string `json:"azureIdentity,omitempty"`
+
+	// VaultAuth is authenticator of the profile Vault configuration
+	VaultAuth *VaultAuth `json:"-"`
+}
+
+// KeyProfileSecret references secret holding key material of a profile
+type KeyProfileSecret struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// KeyProfileVault configures Vault login of a profile
+type KeyProfileVault struct {
+	// Server is Vault API URL
+	Server string `json:"server"`
+	// Namespace is Vault Enterprise namespace
+	Namespace string `json:"namespace,omitempty"`
+	// Method is Vault authentication method, kubernetes by default
+	Method string `json:"method,omitempty"`
+	// Path is login path under auth/, for example kubernetes/login
+	Path          string `json:"path"`
+	Role          string `json:"role,omitempty"`
+	TokenPath     string `json:"tokenPath,omitempty"`
+	TokenAudience string `json:"tokenAudience,omitempty"`
+	Username      string `json:"username,omitempty"`
+	PasswordPath  string `json:"passwordPath,omitempty"`
+}
+
+// LoadKeyProfiles reads key profiles configuration file and creates Vault
+// authenticators of profiles, which have to be started by the caller
+func LoadKeyProfiles(path string) (map[string]*KeyProfile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &KeyProfiles{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := setupKeyProfiles(config.Profiles); err != nil {
+		return nil, err
+	}
+	return config.Profiles, nil
+}
+
+// setupKeyProfiles validates key profiles and creates Vault authenticators
+// of profiles, which have to be started by the caller
+func setupKeyProfiles(profiles map[string]*KeyProfile) error {
+	for name, profile := range profiles {
+		if profile == nil || len(profile.Namespaces) == 0 {
+			return fmt.Errorf("key profile %s: namespaces must not be empty", name)
+		}
+		for _, secret := range []*KeyProfileSecret{profile.AgeKeysSecret, profile.GpgKeysSecret} {
+			if secret != nil && (secret.Namespace == "" || secret.Name == "") {
+				return fmt.Errorf("key profile %s: secret namespace and name must not be empty", name)
+			}
+		}
+		if profile.Vault == nil {
+			continue
+		}
+
+		method := profile.Vault.Method
+		if method == "" {
+			method = "kubernetes"
+		}
+		login, err := NewVaultLoginMethod(method, VaultLoginConfig{
+			Path:          profile.Vault.Path,
+			Role:          profile.Vault.Role,
+			TokenPath:     profile.Vault.TokenPath,
+			TokenAudience: profile.Vault.TokenAudience,
+			Username:      profile.Vault.Username,
+			PasswordPath:  profile.Vault.PasswordPath,
+		})
+		if err != nil {
+			return fmt.Errorf("key profile %s: %v", name, err)
+		}
+		profile.VaultAuth, err = CreateVaultAuth(profile.Vault.Server, profile.Vault.Namespace, login)
+		if err != nil {
+			return fmt.Errorf("key profile %s: %v", name, err)
+		}
+		// profile tokens are kept in memory, sinks expose the token of the
+		// operator authenticator only
+	}
+	return nil
+}
+
+// allowed reports whether SopsSecrets in the namespace may use the profile
+func (p *KeyProfile) allowed(namespace string) bool {
+	for _, allowed := range p.Namespaces {
+		if allowed == "*" || allowed == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// keyProfile returns key profile selected by SopsSecret, nil is returned
+// when SopsSecret uses operator credentials
+func (r *SopsSecretReconciler) keyProfile(instance *isindirv1alpha2.SopsSecret) (*KeyProfile, error) {
+	name := instance.Spec.KeyProfile
+	if name == "" {
+		return nil, nil
+	}
+	profile, ok := r.keyProfiles()[name]
+	if !ok {
+		return nil, &permanentError{fmt.Errorf("key profile %s does not exist", name)}
+	}
+	if !profile.allowed(instance.Namespace) {
+		return nil, &permanentError{fmt.Errorf("key profile %s may not be used in namespace %s", name, instance.Namespace)}
+	}
+	return profile, nil
+}
+
+// Data key types of sops key service requests
+const (
+	ageKeyType   = "age"
+	pgpKeyType   = "pgp"
+	vaultKeyType = "vault"
+	kmsKeyType   = "aws_kms"
+	gcpKeyType   = "gcp_kms"
+	azureKeyType = "azure_kv"
+)
+
+// keyProfileKeyService is a sops key service client which decrypts data keys
+// only with key services of the profile credentials, data keys of other types
+// are rejected
+type keyProfileKeyService struct {
+	profile  string
+	vault    *VaultAuth
+	services map[string]keyservice.KeyServiceClient
+}
+
+// Encrypt is not used by the operator
+func (ks *keyProfileKeyService) Encrypt(
+	ctx context.Context,
+	req *keyservice.EncryptRequest,
+	opts ...grpc.CallOption,
+) (*keyservice.EncryptResponse, error) {
+	return nil, fmt.Errorf("key profile %s does not encrypt data keys", ks.profile)
+}
+
+// Decrypt decrypts data key with profile key service of its type
+func (ks *keyProfileKeyService) Decrypt(
+	ctx context.Context,
+	req *keyservice.DecryptRequest,
+	opts ...grpc.CallOption,
+) (*keyservice.DecryptResponse, error) {
+	var keyType string
+	switch {
+	case req.Key.GetAgeKey() != nil:
+		keyType = ageKeyType
+	case req.Key.GetPgpKey() != nil:
+		keyType = pgpKeyType
+	case req.Key.GetVaultKey() != nil:
+		keyType = vaultKeyType
+		// Vault key service falls back to operator token file without token
+		if ks.vault != nil && ks.vault.Token() == "" {
+			return nil, fmt.Errorf("key profile %s has no Vault token yet", ks.profile)
+		}
+	case req.Key.GetKmsKey() != nil:
+		keyType = kmsKeyType
+	case req.Key.GetGcpKmsKey() != nil:
+		keyType = gcpKeyType
+	case req.Key.GetAzureKeyvaultKey() != nil:
+		keyType = azureKeyType
+	}
+
+	svc, ok := ks.services[keyType]
+	if !ok {
+		return nil, fmt.Errorf("key profile %s has no credentials for %s data keys", ks.profile, keyType)
+	}
+	return svc.Decrypt(ctx, req, opts...)
+}
+
+// keyProfileKeyServices returns key service which decrypts data keys with
+// profile credentials only
+func (r *SopsSecretReconciler) keyProfileKeyServices(ctx context.Context, name string, profile *KeyProfile) []keyservice.KeyServiceClient {
+	ks := &keyProfileKeyService{profile: name, services: map[string]keyservice.KeyServiceClient{}}
+	if profile == nil {
+		// missing or disallowed profile decrypts nothing
+		return []keyservice.KeyServiceClient{ks}
+	}
+
+	// age and PGP data keys which profile keys can not decrypt must not fall
+	// back to operator keys
+	denied := &keyProfileKeyService{profile: name}
+	local := keyservice.NewLocalClient()
+	if profile.AgeKeysSecret != nil {
+		if identities := r.keyProfileAgeIdentities(ctx, profile.AgeKeysSecret); len(identities) > 0 {
+			ks.services[ageKeyType] = newAgeKeyService(identities, denied)
+		}
+	}
+	if profile.GpgKeysSecret != nil {
+		if keys := r.keyProfilePgpKeys(ctx, profile.GpgKeysSecret); len(keys) > 0 {
+			ks.services[pgpKeyType] = newPgpKeyService(keys, denied)
+		}
+	}
+	if profile.VaultAuth != nil {
+		ks.vault = profile.VaultAuth
+		ks.services[vaultKeyType] = newVaultKeyService(profile.VaultAuth, "")
+	}
+	if profile.AwsRoleARN != "" {
+		ks.services[kmsKeyType] = newAwsRoleKeyService(profile.AwsRoleARN, local)
+	}
+	if profile.GcpServiceAccount != "" {
+		ks.services[gcpKeyType] = newGcpImpersonationKeyService(&r.gcpTokens, profile.GcpServiceAccount, local)
+	}
+	if profile.AzureIdentity != "" {
+		ks.services[azureKeyType] = newAzureIdentityKeyService(&r.azureAuthorizers, profile.AzureIdentity, local)
+	}
+	return []keyservice.KeyServiceClient{r.breakers.guard(ks)}
+}
+
+// keyProfileAgeIdentities returns age identities from all values of the
+// profile secret
+func (r *SopsSecretReconciler) keyProfileAgeIdentities(ctx context.Context, ref *KeyProfileSecret) []age.Identity {
+	secret := r.keyProfileSecret(ctx, ref)
+	if secret == nil {
+		return nil
+	}
+	var identities []age.Identity
+	for _, key := range sortedKeys(secret.Data) {
+		keyIdentities, err := age.ParseIdentities(bytes.NewReader(secret.Data[key]))
+		if err != nil {
+			r.logger(ctx).Info("Parsing key profile age identities error", "secret", ref, "key", key, "error", err)
+			continue
+		}
+		identities = append(identities, keyIdentities...)
+	}
+	return identities
+}
+
+// keyProfilePgpKeys returns PGP private keys of the profile secret
+func (r *SopsSecretReconciler) keyProfilePgpKeys(ctx context.Context, ref *KeyProfileSecret) openpgp.EntityList {
+	secret := r.keyProfileSecret(ctx, ref)
+	if secret == nil {
+		return nil
+	}
+	keys, err := r.pgpKeyring.keys(secret)
+	if err != nil {
+		r.logger(ctx).Info("Parsing key profile PGP keys error", "secret", ref, "error", err)
+		return nil
+	}
+	return keys
+}
+
+func (r *SopsSecretReconciler) keyProfileSecret(ctx context.Context, ref *KeyProfileSecret) *corev1.Secret {
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, secret); err != nil {
+		r.logger(ctx).Info("Reading key profile secret error", "secret", ref, "error", err)
+		return nil
+	}
+	return secret
+}
@@ -0,0 +1,98 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"errors"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// SecretLimits guard etcd against SopsSecrets generating oversized secrets,
+// limits which are not positive are not enforced
+type SecretLimits struct {
+	// MaxSecretSize is the maximum size of decrypted keys and values of a
+	// generated secret in bytes
+	MaxSecretSize int
+	// MaxSecretKeys is the maximum number of data keys of a generated secret
+	MaxSecretKeys int
+	// MaxSecrets is the maximum number of secret templates of a SopsSecret
+	MaxSecrets int
+}
+
+// limitError is returned when SopsSecret or generated secret exceeds limits
+type limitError struct {
+	message string
+}
+
+func (e *limitError) Error() string {
+	return e.message
+}
+
+// asLimitError returns limitError wrapped in the error or nil
+func asLimitError(err error) *limitError {
+	var limitErr *limitError
+	if errors.As(err, &limitErr) {
+		return limitErr
+	}
+	return nil
+}
+
+// checkSopsSecret returns permanent error when SopsSecret declares more
+// secret templates than allowed
+func (l SecretLimits) checkSopsSecret(instance *isindirv1alpha2.SopsSecret) error {
+	if l.MaxSecrets > 0 && len(instance.Spec.SecretsTemplate) > l.MaxSecrets {
+		return &permanentError{&limitError{fmt.Sprintf(
+			"%d secret templates exceed limit of %d secrets per SopsSecret",
+			len(instance.Spec.SecretsTemplate),
+			l.MaxSecrets,
+		)}}
+	}
+	return nil
+}
+
+// checkSecret returns permanent error when rendered secret has more keys or
+// larger payload than allowed
+func (l SecretLimits) checkSecret(secret *corev1.Secret) error {
+	if l.MaxSecretKeys > 0 && len(secret.Data) > l.MaxSecretKeys {
+		return &permanentError{&limitError{fmt.Sprintf(
+			"secret %s has %d keys, limit is %d",
+			secret.Name,
+			len(secret.Data),
+			l.MaxSecretKeys,
+		)}}
+	}
+	if l.MaxSecretSize > 0 {
+		size := 0
+		for key, value := range secret.Data {
+			size += len(key) + len(value)
+		}
+		if size > l.MaxSecretSize {
+			return &permanentError{&limitError{fmt.Sprintf(
+				"secret %s payload has %d bytes, limit is %d",
+				secret.Name,
+				size,
+				l.MaxSecretSize,
+			)}}
+		}
+	}
+	return nil
+}
+
+// setLimitExceeded records limit violation in LimitExceeded condition
+func setLimitExceeded(instance *isindirv1alpha2.SopsSecret, err *limitError) {
+	meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+		Type:               isindirv1alpha2.LimitExceededCondition,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: instance.Generation,
+		Reason:             "LimitExceeded",
+		Message:            err.Error(),
+	})
+}
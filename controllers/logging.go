@@ -0,0 +1,30 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	utiluuid "k8s.io/apimachinery/pkg/util/uuid"
+)
+
+// correlationIDKey is log key of ID shared by all log lines of one
+// reconciliation or Vault login session
+const correlationIDKey = "correlationID"
+
+// newCorrelationID returns random correlation ID
+func newCorrelationID() string {
+	return string(utiluuid.NewUUID())
+}
+
+// logger returns logger of the reconciliation in ctx, it carries SopsSecret
+// name and correlation ID
+func (r *SopsSecretReconciler) logger(ctx context.Context) logr.Logger {
+	if log := logr.FromContext(ctx); log != nil {
+		return log
+	}
+	return r.Log
+}
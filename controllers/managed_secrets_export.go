@@ -0,0 +1,126 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// managedByLabel marks managed secrets ConfigMaps published by the operator
+const managedByLabel = "app.kubernetes.io/managed-by"
+
+// managedSecretsExport tracks content of managed secrets ConfigMaps published
+// by the operator, so unchanged ConfigMaps are not written again
+type managedSecretsExport struct {
+	lock      sync.Mutex
+	published map[string]string
+}
+
+// publishManagedSecrets writes ConfigMap ManagedSecretsConfigMap to the
+// namespace, its data maps names of secrets generated by SopsSecrets of the
+// namespace to names of the SopsSecrets, so admission policies (OPA
+// Gatekeeper, Kyverno) can forbid manual edits of managed secrets and
+// auditors can enumerate coverage. ConfigMap is deleted when namespace has no
+// managed secrets. It is built from status of SopsSecrets, status of the
+// SopsSecret just reconciled is taken from current, which may be nil when it
+// was deleted. Failures are only logged, next reconciliation publishes the
+// ConfigMap again
+func (r *SopsSecretReconciler) publishManagedSecrets(ctx context.Context, name types.NamespacedName, current *isindirv1alpha2.SopsSecret) {
+	if r.ManagedSecretsConfigMap == "" {
+		return
+	}
+	log := r.logger(ctx)
+
+	r.managedSecretsExport.lock.Lock()
+	defer r.managedSecretsExport.lock.Unlock()
+
+	list := &isindirv1alpha2.SopsSecretList{}
+	if err := r.List(ctx, list, client.InNamespace(name.Namespace)); err != nil {
+		log.Info("Listing SopsSecrets for managed secrets ConfigMap error", "error", err)
+		return
+	}
+	data := map[string]string{}
+	for i := range list.Items {
+		item := &list.Items[i]
+		if item.Name == name.Name {
+			continue
+		}
+		addManagedSecrets(data, item)
+	}
+	if current != nil {
+		addManagedSecrets(data, current)
+	}
+
+	hash := managedSecretsHash(data)
+	if r.managedSecretsExport.published[name.Namespace] == hash {
+		return
+	}
+
+	configMap := &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      r.ManagedSecretsConfigMap,
+			Namespace: name.Namespace,
+			Labels:    map[string]string{managedByLabel: "sops-secrets-operator"},
+		},
+		Data: data,
+	}
+	var err error
+	if len(data) == 0 {
+		err = client.IgnoreNotFound(r.Delete(ctx, configMap))
+	} else {
+		fieldManager := r.FieldManager
+		if fieldManager == "" {
+			fieldManager = DefaultFieldManager
+		}
+		err = r.Patch(ctx, configMap, client.Apply, client.FieldOwner(fieldManager), client.ForceOwnership)
+	}
+	if err != nil {
+		log.Info("Publishing managed secrets ConfigMap error", "configMap", r.ManagedSecretsConfigMap, "error", err)
+		delete(r.managedSecretsExport.published, name.Namespace)
+		return
+	}
+	if r.managedSecretsExport.published == nil {
+		r.managedSecretsExport.published = make(map[string]string)
+	}
+	r.managedSecretsExport.published[name.Namespace] = hash
+}
+
+// addManagedSecrets adds secrets recorded in SopsSecret status to managed
+// secrets ConfigMap data
+func addManagedSecrets(data map[string]string, instance *isindirv1alpha2.SopsSecret) {
+	for _, managed := range instance.Status.ManagedSecrets {
+		data[managed.Name] = instance.Name
+	}
+}
+
+// managedSecretsHash returns canonical hash of managed secrets ConfigMap data
+func managedSecretsHash(data map[string]string) string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	hash := sha256.New()
+	for _, key := range keys {
+		hash.Write([]byte(key))
+		hash.Write([]byte{0})
+		hash.Write([]byte(data[key]))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
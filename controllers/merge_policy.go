@@ -0,0 +1,115 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+const (
+	// ManagedKeysAnnotation lists data keys rendered from secret template, so
+	// keys removed from the template are told apart from keys added to the
+	// secret by other actors
+	ManagedKeysAnnotation = "sops-secrets-operator/managed-keys"
+
+	// legacyFieldManager is field manager of secrets written by operator
+	// versions before server-side apply
+	legacyFieldManager = "manager"
+)
+
+// mergeKeys applies merge policy of secret template to rendered secret and
+// returns data keys to remove from found secret. Previously rendered keys
+// are read from managed keys annotation of found secret, or from data keys
+// owned by legacy field manager when it has none. Merge policy keeps such
+// keys in rendered secret with their found values. Server-side apply removes
+// only keys no other field manager shares, so Replace policy removes them
+// explicitly
+func mergeKeys(secretTpl *isindirv1alpha2.SopsSecretTemplate, rendered *corev1.Secret, found *corev1.Secret) []string {
+	previous, ok := managedKeys(found)
+	if !ok {
+		previous = legacyManagedKeys(found)
+	}
+
+	var stale []string
+	kept := false
+	for _, key := range previous {
+		if _, ok := rendered.Data[key]; ok {
+			continue
+		}
+		value, ok := found.Data[key]
+		if !ok {
+			continue
+		}
+		if secretTpl.MergePolicy == isindirv1alpha2.MergeMergePolicy {
+			if rendered.Data == nil {
+				rendered.Data = make(map[string][]byte)
+			}
+			rendered.Data[key] = value
+			kept = true
+			continue
+		}
+		stale = append(stale, key)
+	}
+	if kept {
+		setContentHash(rendered, secretContentHash(rendered))
+	}
+	sort.Strings(stale)
+	return stale
+}
+
+// setManagedKeys records data keys of rendered secret in its managed keys
+// annotation
+func setManagedKeys(secret *corev1.Secret) {
+	keys := make([]string, 0, len(secret.Data))
+	for key := range secret.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if secret.Annotations == nil {
+		secret.Annotations = make(map[string]string)
+	}
+	secret.Annotations[ManagedKeysAnnotation] = strings.Join(keys, ",")
+}
+
+// managedKeys returns data keys recorded in managed keys annotation of
+// secret, it reports false when secret has no annotation
+func managedKeys(secret *corev1.Secret) ([]string, bool) {
+	value, ok := secret.Annotations[ManagedKeysAnnotation]
+	if !ok {
+		return nil, false
+	}
+	if value == "" {
+		return nil, true
+	}
+	return strings.Split(value, ","), true
+}
+
+// legacyManagedKeys returns data keys of secret owned by legacy field
+// manager
+func legacyManagedKeys(secret *corev1.Secret) []string {
+	var keys []string
+	for _, entry := range secret.ManagedFields {
+		if entry.Manager != legacyFieldManager || entry.FieldsV1 == nil {
+			continue
+		}
+		fields := map[string]interface{}{}
+		if err := json.Unmarshal(entry.FieldsV1.Raw, &fields); err != nil {
+			continue
+		}
+		data, _ := fields["f:data"].(map[string]interface{})
+		for field := range data {
+			if strings.HasPrefix(field, "f:") {
+				keys = append(keys, strings.TrimPrefix(field, "f:"))
+			}
+		}
+	}
+	return keys
+}
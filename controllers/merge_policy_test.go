@@ -0,0 +1,152 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// renderedSecret returns rendered secret with data and content hash
+func renderedSecret(data map[string]string) *corev1.Secret {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}},
+		Data:       map[string][]byte{},
+	}
+	for key, value := range data {
+		secret.Data[key] = []byte(value)
+	}
+	secret.Annotations[ContentHashAnnotation] = secretContentHash(secret)
+	return secret
+}
+
+// foundSecret returns existing secret generated with managed keys
+func foundSecret(data map[string]string, managed ...string) *corev1.Secret {
+	secret := renderedSecret(data)
+	previous := renderedSecret(nil)
+	for _, key := range managed {
+		previous.Data[key] = nil
+	}
+	setManagedKeys(previous)
+	secret.Annotations[ManagedKeysAnnotation] = previous.Annotations[ManagedKeysAnnotation]
+	return secret
+}
+
+func TestMergeKeysReplaceRemovesKeysNoLongerRendered(t *testing.T) {
+	tpl := &isindirv1alpha2.SopsSecretTemplate{}
+	rendered := renderedSecret(map[string]string{"username": "admin"})
+	found := foundSecret(map[string]string{"username": "admin", "password": "secret"}, "username", "password")
+
+	stale := mergeKeys(tpl, rendered, found)
+	if !reflect.DeepEqual(stale, []string{"password"}) {
+		t.Errorf("expected password to be removed, got %v", stale)
+	}
+	if _, ok := rendered.Data["password"]; ok {
+		t.Errorf("removed key must not be rendered")
+	}
+}
+
+func TestMergeKeysReplaceIsDefault(t *testing.T) {
+	for _, policy := range []isindirv1alpha2.MergePolicy{"", isindirv1alpha2.ReplaceMergePolicy} {
+		tpl := &isindirv1alpha2.SopsSecretTemplate{MergePolicy: policy}
+		rendered := renderedSecret(nil)
+		found := foundSecret(map[string]string{"a": "1", "b": "2"}, "a", "b")
+
+		stale := mergeKeys(tpl, rendered, found)
+		if !reflect.DeepEqual(stale, []string{"a", "b"}) {
+			t.Errorf("policy %q: expected all keys to be removed, got %v", policy, stale)
+		}
+	}
+}
+
+func TestMergeKeysPreservesKeysOfOtherActors(t *testing.T) {
+	tpl := &isindirv1alpha2.SopsSecretTemplate{}
+	rendered := renderedSecret(map[string]string{"username": "admin"})
+	found := foundSecret(map[string]string{"username": "admin", "injected": "sidecar"}, "username")
+
+	if stale := mergeKeys(tpl, rendered, found); len(stale) != 0 {
+		t.Errorf("keys added by other actors must be preserved, got %v", stale)
+	}
+	if _, ok := rendered.Data["injected"]; ok {
+		t.Errorf("keys added by other actors must not be rendered")
+	}
+}
+
+func TestMergeKeysSkipsKeysAlreadyRemoved(t *testing.T) {
+	tpl := &isindirv1alpha2.SopsSecretTemplate{}
+	rendered := renderedSecret(map[string]string{"username": "admin"})
+	found := foundSecret(map[string]string{"username": "admin"}, "username", "password")
+
+	if stale := mergeKeys(tpl, rendered, found); len(stale) != 0 {
+		t.Errorf("keys missing in the secret must not be removed, got %v", stale)
+	}
+}
+
+func TestMergeKeysMergeKeepsKeysNoLongerRendered(t *testing.T) {
+	tpl := &isindirv1alpha2.SopsSecretTemplate{MergePolicy: isindirv1alpha2.MergeMergePolicy}
+	rendered := renderedSecret(map[string]string{"username": "admin"})
+	found := foundSecret(map[string]string{"username": "root", "password": "secret"}, "username", "password")
+
+	if stale := mergeKeys(tpl, rendered, found); len(stale) != 0 {
+		t.Errorf("merge policy must not remove keys, got %v", stale)
+	}
+	if string(rendered.Data["password"]) != "secret" {
+		t.Errorf("expected password to be kept with its last value, got %q", rendered.Data["password"])
+	}
+	if string(rendered.Data["username"]) != "admin" {
+		t.Errorf("rendered key must take precedence, got %q", rendered.Data["username"])
+	}
+	if rendered.Annotations[ContentHashAnnotation] != secretContentHash(rendered) {
+		t.Errorf("content hash must cover kept keys")
+	}
+
+	setManagedKeys(rendered)
+	if rendered.Annotations[ManagedKeysAnnotation] != "password,username" {
+		t.Errorf("kept keys must stay managed, got %q", rendered.Annotations[ManagedKeysAnnotation])
+	}
+}
+
+func TestMergeKeysLegacySecret(t *testing.T) {
+	tpl := &isindirv1alpha2.SopsSecretTemplate{}
+	rendered := renderedSecret(map[string]string{"username": "admin"})
+	found := renderedSecret(map[string]string{"username": "admin", "password": "secret", "injected": "sidecar"})
+	found.ManagedFields = []metav1.ManagedFieldsEntry{
+		{
+			Manager:   legacyFieldManager,
+			Operation: metav1.ManagedFieldsOperationUpdate,
+			FieldsV1:  &metav1.FieldsV1{Raw: []byte(`{"f:data":{".":{},"f:password":{},"f:username":{}}}`)},
+		},
+		{
+			Manager:   "kubectl-edit",
+			Operation: metav1.ManagedFieldsOperationUpdate,
+			FieldsV1:  &metav1.FieldsV1{Raw: []byte(`{"f:data":{"f:injected":{}}}`)},
+		},
+	}
+
+	stale := mergeKeys(tpl, rendered, found)
+	if !reflect.DeepEqual(stale, []string{"password"}) {
+		t.Errorf("expected keys of legacy field manager to be removed, got %v", stale)
+	}
+}
+
+func TestSetManagedKeys(t *testing.T) {
+	secret := renderedSecret(map[string]string{"b": "2", "a": "1"})
+	setManagedKeys(secret)
+	keys, ok := managedKeys(secret)
+	if !ok || !reflect.DeepEqual(keys, []string{"a", "b"}) {
+		t.Errorf("unexpected managed keys %v", keys)
+	}
+
+	empty := renderedSecret(nil)
+	setManagedKeys(empty)
+	if keys, ok := managedKeys(empty); !ok || len(keys) != 0 {
+		t.Errorf("secret without keys must record empty managed keys, got %v %v", keys, ok)
+	}
+}
@@ -0,0 +1,193 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	// vaultLoginAttempts counts Vault login attempts by result, which is
+	// success or failure reason
+	vaultLoginAttempts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "sops_secrets_operator_vault_login_attempts_total",
+			Help: "Number of Vault login attempts by result.",
+		},
+		[]string{"result"},
+	)
+
+	// vaultLoginFailures is the number of consecutive failed Vault logins
+	vaultLoginFailures = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "sops_secrets_operator_vault_login_consecutive_failures",
+			Help: "Number of consecutive failed Vault login attempts.",
+		},
+	)
+
+	// vaultTokenExpiry holds expiry time.Time of the last obtained or
+	// renewed Vault token, zero time when the token does not expire
+	vaultTokenExpiry atomic.Value
+
+	// vaultTokenTTL is the remaining TTL of Vault token, +Inf for token
+	// which does not expire and 0 before login
+	vaultTokenTTL = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "sops_secrets_operator_vault_token_ttl_seconds",
+			Help: "Remaining TTL of Vault token in seconds.",
+		},
+		func() float64 {
+			expiry, ok := vaultTokenExpiry.Load().(time.Time)
+			switch {
+			case !ok:
+				return 0
+			case expiry.IsZero():
+				return math.Inf(1)
+			}
+			return math.Max(time.Until(expiry).Seconds(), 0)
+		},
+	)
+
+	// vaultTokenRenewals counts Vault token renewals
+	vaultTokenRenewals = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "sops_secrets_operator_vault_token_renewals_total",
+			Help: "Number of Vault token renewals.",
+		},
+	)
+
+	// vaultTokenLastRenewal is the time of the last Vault token renewal
+	vaultTokenLastRenewal = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "sops_secrets_operator_vault_token_last_renewal_timestamp_seconds",
+			Help: "Unix time of the last Vault token renewal.",
+		},
+	)
+
+	// lastSyncTimestamp is the time of the last successful sync of SopsSecret
+	lastSyncTimestamp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "sops_secrets_operator_last_sync_timestamp_seconds",
+			Help: "Unix time of the last successful sync of SopsSecret.",
+		},
+		[]string{"namespace", "name"},
+	)
+
+	// propagationLatency is the time from SopsSecret generation change until
+	// its secrets are synced
+	propagationLatency = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "sops_secrets_operator_propagation_latency_seconds",
+			Help:    "Time from SopsSecret generation change until its secrets are synced.",
+			Buckets: []float64{1, 5, 10, 30, 60, 120, 300, 600, 1800, 3600},
+		},
+	)
+
+	// lastPropagationLatency is propagation latency of the last synced
+	// generation of SopsSecret
+	lastPropagationLatency = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "sops_secrets_operator_last_propagation_latency_seconds",
+			Help: "Time from SopsSecret generation change until its secrets were synced, for the last synced generation.",
+		},
+		[]string{"namespace", "name"},
+	)
+
+	// reconcileRetries counts reconciliations requeued after failure by
+	// namespace of SopsSecret
+	reconcileRetries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "sops_secrets_operator_reconcile_retries_total",
+			Help: "Number of SopsSecret reconciliations requeued after failure by namespace.",
+		},
+		[]string{"namespace"},
+	)
+
+	// notificationsTotal counts secret change notifications by result, which
+	// is sent, failed or dropped
+	notificationsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "sops_secrets_operator_notifications_total",
+			Help: "Number of secret change notifications by delivery result.",
+		},
+		[]string{"result"},
+	)
+
+	// verificationFailed is 1 for SopsSecrets in verify-only mode which
+	// failed the last verification and 0 for verified ones
+	verificationFailed = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "sops_secrets_operator_verification_failed",
+			Help: "Whether the last verification of verify-only SopsSecret failed.",
+		},
+		[]string{"namespace", "name"},
+	)
+
+	// certificateExpiring is 1 for SopsSecrets generating TLS secrets with
+	// certificates expiring within warning period and 0 for other ones
+	certificateExpiring = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "sops_secrets_operator_certificate_expiring",
+			Help: "Whether SopsSecret generates TLS secrets with certificates expiring within warning period.",
+		},
+		[]string{"namespace", "name"},
+	)
+
+	// needsReencryption is the number of recipients SopsSecret is encrypted
+	// for which are not current, 0 when it is encrypted for current ones only
+	needsReencryption = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "sops_secrets_operator_stale_recipients",
+			Help: "Number of recipients SopsSecret is encrypted for which are not current.",
+		},
+		[]string{"namespace", "name"},
+	)
+	// keyBackendCircuitOpen is 1 for key backends with open circuit breaker
+	// and 0 for recovered ones
+	keyBackendCircuitOpen = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "sops_secrets_operator_key_backend_circuit_open",
+			Help: "Whether circuit breaker of key backend is open.",
+		},
+		[]string{"backend"},
+	)
+
+	// stuckSopsSecrets is the number of SopsSecrets per namespace which are
+	// not ready for longer than stuck threshold, as of the last scan
+	stuckSopsSecrets = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "sops_secret_stuck_total",
+			Help: "Number of SopsSecrets not ready for longer than stuck threshold.",
+		},
+		[]string{"namespace"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		buildInfo,
+		vaultLoginAttempts,
+		vaultLoginFailures,
+		vaultTokenTTL,
+		vaultTokenRenewals,
+		vaultTokenLastRenewal,
+		lastSyncTimestamp,
+		propagationLatency,
+		lastPropagationLatency,
+		reconcileRetries,
+		queuedRequests,
+		notificationsTotal,
+		verificationFailed,
+		certificateExpiring,
+		needsReencryption,
+		keyBackendCircuitOpen,
+		stuckSopsSecrets,
+	)
+}
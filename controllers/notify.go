@@ -0,0 +1,217 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+const (
+	// NotificationsAnnotation set to "true" on a namespace enables
+	// notifications about secrets generated by SopsSecrets in the namespace
+	NotificationsAnnotation = "sops-secrets-operator/notifications"
+
+	// SignatureHeader holds hex encoded HMAC-SHA256 of notification body
+	SignatureHeader = "X-Sops-Secrets-Operator-Signature"
+
+	notificationQueueSize = 1000
+)
+
+// Secret change notification actions
+const (
+	SecretCreatedAction = "created"
+	SecretUpdatedAction = "updated"
+	SecretDeletedAction = "deleted"
+)
+
+// NotificationObject identifies object in a notification
+type NotificationObject struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// Notification describes change of a generated secret, it never contains
+// secret values
+type Notification struct {
+	Action     string             `json:"action"`
+	SopsSecret NotificationObject `json:"sopsSecret"`
+	Secret     NotificationObject `json:"secret"`
+	Keys       []string           `json:"keys,omitempty"`
+	Time       time.Time          `json:"time"`
+}
+
+// NotificationWebhook is a notification receiver
+type NotificationWebhook struct {
+	URL string
+	// Slack formats notification as Slack incoming webhook message
+	Slack bool
+}
+
+// Notifier delivers notifications to webhooks in background, failed
+// deliveries are retried with backoff
+type Notifier struct {
+	Webhooks []NotificationWebhook
+	// SigningKey signs notification bodies sent to generic webhooks
+	SigningKey []byte
+	// Retries is the number of retries of failed delivery
+	Retries int
+	Backoff BackoffPolicy
+	Client  *http.Client
+	Log     logr.Logger
+
+	once  sync.Once
+	queue chan Notification
+}
+
+func (n *Notifier) init() {
+	n.once.Do(func() {
+		n.queue = make(chan Notification, notificationQueueSize)
+		if n.Client == nil {
+			n.Client = &http.Client{Timeout: 10 * time.Second}
+		}
+	})
+}
+
+// Notify queues notification for delivery, notification is dropped when the
+// queue is full, so reconciliation is never blocked by slow receivers
+func (n *Notifier) Notify(notification Notification) {
+	n.init()
+	select {
+	case n.queue <- notification:
+	default:
+		notificationsTotal.WithLabelValues("dropped").Inc()
+		n.Log.Info("Notification queue is full, dropping notification", "secret", notification.Secret)
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable
+func (n *Notifier) NeedLeaderElection() bool {
+	return false
+}
+
+// Start implements manager.Runnable, it delivers queued notifications until
+// context is cancelled
+func (n *Notifier) Start(ctx context.Context) error {
+	n.init()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case notification := <-n.queue:
+			for _, webhook := range n.Webhooks {
+				n.deliver(ctx, webhook, notification)
+			}
+		}
+	}
+}
+
+// deliver sends notification to webhook, retrying failures
+func (n *Notifier) deliver(ctx context.Context, webhook NotificationWebhook, notification Notification) {
+	for attempt := 1; ; attempt++ {
+		retry, err := n.send(ctx, webhook, notification)
+		if err == nil {
+			notificationsTotal.WithLabelValues("sent").Inc()
+			return
+		}
+		if !retry || attempt > n.Retries {
+			notificationsTotal.WithLabelValues("failed").Inc()
+			n.Log.Info("Notification delivery failed", "secret", notification.Secret, "attempts", attempt, "error", err.Error())
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(n.Backoff.Delay(attempt)):
+		}
+	}
+}
+
+// send posts notification to webhook, returns whether failure may be retried
+func (n *Notifier) send(ctx context.Context, webhook NotificationWebhook, notification Notification) (bool, error) {
+	var payload interface{} = notification
+	if webhook.Slack {
+		payload = map[string]string{
+			"text": fmt.Sprintf(
+				"Secret `%s/%s` %s by SopsSecret `%s/%s`",
+				notification.Secret.Namespace,
+				notification.Secret.Name,
+				notification.Action,
+				notification.SopsSecret.Namespace,
+				notification.SopsSecret.Name,
+			),
+		}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return false, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(n.SigningKey) > 0 && !webhook.Slack {
+		mac := hmac.New(sha256.New, n.SigningKey)
+		mac.Write(body)
+		req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		// client errors other than rate limiting will not resolve by retrying
+		retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return false, nil
+}
+
+// notify queues notification about generated secret change when
+// notifications are configured and enabled in SopsSecret namespace
+func (r *SopsSecretReconciler) notify(ctx context.Context, instance *isindirv1alpha2.SopsSecret, action string, secret *corev1.Secret) {
+	if r.Notifier == nil {
+		return
+	}
+
+	namespace := &corev1.Namespace{}
+	if err := r.Get(ctx, types.NamespacedName{Name: instance.Namespace}, namespace); err != nil {
+		r.logger(ctx).Info("Reading namespace notifications annotation error", "error", err)
+		return
+	}
+	if namespace.Annotations[NotificationsAnnotation] != "true" {
+		return
+	}
+
+	notification := Notification{
+		Action:     action,
+		SopsSecret: NotificationObject{Namespace: instance.Namespace, Name: instance.Name},
+		Secret:     NotificationObject{Namespace: secret.Namespace, Name: secret.Name},
+		Time:       time.Now().UTC(),
+	}
+	if action != SecretDeletedAction {
+		notification.Keys = sortedKeys(secret.Data)
+	}
+	r.Notifier.Notify(notification)
+}
@@ -0,0 +1,288 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/yaml"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// OperatorConfigKey is key of SopsOperatorConfig ConfigMap holding the
+// configuration
+const OperatorConfigKey = "config.yaml"
+
+// ResyncAnnotation of SopsOperatorConfig ConfigMap requests reconciliation
+// of all SopsSecrets whenever its value changes, for example after key
+// rotation or Vault policy change
+const ResyncAnnotation = "sops-secrets-operator/resync"
+
+// OperatorConfig is operator configuration of SopsOperatorConfig ConfigMap,
+// which is applied without restart. Settings which are set override
+// settings of command line flags, removed settings revert to them
+type OperatorConfig struct {
+	// KeyProfiles replaces key profiles of --key-profiles-file
+	KeyProfiles map[string]*KeyProfile `json:"keyProfiles,omitempty"`
+	// ManagedSecretLabels replaces --managed-secret-labels
+	ManagedSecretLabels map[string]string `json:"managedSecretLabels,omitempty"`
+	// ManagedSecretAnnotations replaces --managed-secret-annotations
+	ManagedSecretAnnotations map[string]string `json:"managedSecretAnnotations,omitempty"`
+	// Limits replaces all --max-secret* limits
+	Limits *OperatorConfigLimits `json:"limits,omitempty"`
+	// CurrentRecipients replaces --current-recipients
+	CurrentRecipients []string `json:"currentRecipients,omitempty"`
+}
+
+// OperatorConfigLimits are secret limits of SopsOperatorConfig, limits
+// which are not positive are not enforced
+type OperatorConfigLimits struct {
+	MaxSecretSize           int `json:"maxSecretSize,omitempty"`
+	MaxSecretKeys           int `json:"maxSecretKeys,omitempty"`
+	MaxSecretsPerSopsSecret int `json:"maxSecretsPerSopsSecret,omitempty"`
+}
+
+// ParseOperatorConfig parses and validates SopsOperatorConfig, Vault
+// authenticators of key profiles are created, but not started
+func ParseOperatorConfig(data []byte) (*OperatorConfig, error) {
+	config := &OperatorConfig{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, err
+	}
+	if err := setupKeyProfiles(config.KeyProfiles); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// OperatorConfigReconciler watches SopsOperatorConfig ConfigMap and applies
+// it to SopsSecret reconciler, SopsSecrets are reconciled again with the
+// new configuration
+type OperatorConfigReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Recorder record.EventRecorder
+	// ConfigMap is namespace and name of SopsOperatorConfig ConfigMap
+	ConfigMap types.NamespacedName
+	// SopsSecrets is reconciler the configuration is applied to
+	SopsSecrets *SopsSecretReconciler
+	// VaultLoginBackoff is login backoff of key profile Vault authenticators
+	VaultLoginBackoff BackoffPolicy
+
+	lock sync.Mutex
+	// applied is hash of applied configuration
+	applied string
+	// stopVault stops Vault authenticators of applied configuration
+	stopVault context.CancelFunc
+	// resync is last seen value of ResyncAnnotation
+	resync string
+}
+
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+
+// Reconcile applies SopsOperatorConfig, invalid configuration is rejected and
+// the previous one is kept
+func (r *OperatorConfigReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("configmap", req.NamespacedName, correlationIDKey, newCorrelationID())
+
+	configMap := &corev1.ConfigMap{}
+	if err := r.Get(ctx, req.NamespacedName, configMap); err != nil {
+		if !errors.IsNotFound(err) {
+			return reconcile.Result{}, err
+		}
+		if r.apply("", nil) {
+			log.Info("SopsOperatorConfig not found, using command line configuration")
+			r.SopsSecrets.resyncAll(ctx)
+		}
+		return reconcile.Result{}, nil
+	}
+
+	data := configMap.Data[OperatorConfigKey]
+	hash := sha256.Sum256([]byte(data))
+	r.lock.Lock()
+	applied := r.applied == hex.EncodeToString(hash[:])
+	resync := r.resync != configMap.Annotations[ResyncAnnotation]
+	r.resync = configMap.Annotations[ResyncAnnotation]
+	r.lock.Unlock()
+	if applied {
+		if resync {
+			log.Info("Resync of all SopsSecrets requested")
+			r.Recorder.Event(configMap, corev1.EventTypeNormal, "ResyncRequested", "Reconciling all SopsSecrets")
+			r.SopsSecrets.resyncAll(ctx)
+		}
+		return reconcile.Result{}, nil
+	}
+
+	config, err := ParseOperatorConfig([]byte(data))
+	if err != nil {
+		log.Error(err, "invalid SopsOperatorConfig, keeping previous configuration")
+		r.Recorder.Eventf(configMap, corev1.EventTypeWarning, "InvalidConfig", "SopsOperatorConfig is rejected: %v", err)
+		if resync {
+			r.SopsSecrets.resyncAll(ctx)
+		}
+		return reconcile.Result{}, nil
+	}
+	if r.apply(hex.EncodeToString(hash[:]), config) {
+		log.Info("SopsOperatorConfig applied")
+		r.Recorder.Event(configMap, corev1.EventTypeNormal, "ConfigApplied", "SopsOperatorConfig applied")
+		r.SopsSecrets.resyncAll(ctx)
+	}
+	return reconcile.Result{}, nil
+}
+
+// apply applies configuration of given hash unless it is already applied,
+// nil configuration reverts to command line configuration. It reports
+// whether the configuration was applied
+func (r *OperatorConfigReconciler) apply(hash string, config *OperatorConfig) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if hash == r.applied {
+		return false
+	}
+
+	// authenticators of the new configuration log in while previous ones
+	// keep their tokens until they are replaced
+	var stopVault context.CancelFunc
+	if config != nil {
+		var vaultCtx context.Context
+		vaultCtx, stopVault = context.WithCancel(context.Background())
+		for name, profile := range config.KeyProfiles {
+			if profile.VaultAuth != nil {
+				profile.VaultAuth.LoginBackoff.Initial = r.VaultLoginBackoff.Initial
+				profile.VaultAuth.LoginBackoff.Max = r.VaultLoginBackoff.Max
+				r.Log.Info("starting vault authenticator", "keyProfile", name)
+				go profile.VaultAuth.StartAutoRenew(vaultCtx)
+			}
+		}
+	}
+	r.SopsSecrets.setOperatorConfig(config)
+	if r.stopVault != nil {
+		r.stopVault()
+	}
+	r.stopVault = stopVault
+	r.applied = hash
+	return true
+}
+
+// Start stops Vault authenticators of applied configuration when manager
+// stops
+func (r *OperatorConfigReconciler) Start(ctx context.Context) error {
+	<-ctx.Done()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.stopVault != nil {
+		r.stopVault()
+	}
+	return nil
+}
+
+// SetupWithManager sets up the controller with the Manager
+func (r *OperatorConfigReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.Add(r); err != nil {
+		return err
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("operatorconfig").
+		For(&corev1.ConfigMap{}, builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			return obj.GetNamespace() == r.ConfigMap.Namespace && obj.GetName() == r.ConfigMap.Name
+		}))).
+		Complete(r)
+}
+
+// setOperatorConfig applies SopsOperatorConfig, nil reverts to command line
+// configuration
+func (r *SopsSecretReconciler) setOperatorConfig(config *OperatorConfig) {
+	r.configLock.Lock()
+	defer r.configLock.Unlock()
+	r.config = config
+}
+
+// operatorConfig returns applied SopsOperatorConfig, nil when there is none
+func (r *SopsSecretReconciler) operatorConfig() *OperatorConfig {
+	r.configLock.RLock()
+	defer r.configLock.RUnlock()
+	return r.config
+}
+
+// keyProfiles returns key profiles of SopsOperatorConfig or command line
+func (r *SopsSecretReconciler) keyProfiles() map[string]*KeyProfile {
+	if config := r.operatorConfig(); config != nil && config.KeyProfiles != nil {
+		return config.KeyProfiles
+	}
+	return r.KeyProfiles
+}
+
+// limits returns secret limits of SopsOperatorConfig or command line
+func (r *SopsSecretReconciler) limits() SecretLimits {
+	if config := r.operatorConfig(); config != nil && config.Limits != nil {
+		return SecretLimits{
+			MaxSecretSize: config.Limits.MaxSecretSize,
+			MaxSecretKeys: config.Limits.MaxSecretKeys,
+			MaxSecrets:    config.Limits.MaxSecretsPerSopsSecret,
+		}
+	}
+	return r.Limits
+}
+
+// currentRecipients returns current recipients of SopsOperatorConfig or
+// command line
+func (r *SopsSecretReconciler) currentRecipients() []string {
+	if config := r.operatorConfig(); config != nil && config.CurrentRecipients != nil {
+		return config.CurrentRecipients
+	}
+	return r.CurrentRecipients
+}
+
+// managedSecretMetadata returns default labels and annotations of generated
+// secrets of SopsOperatorConfig or command line
+func (r *SopsSecretReconciler) managedSecretMetadata() (map[string]string, map[string]string) {
+	labels, annotations := r.ManagedSecretLabels, r.ManagedSecretAnnotations
+	if config := r.operatorConfig(); config != nil {
+		if config.ManagedSecretLabels != nil {
+			labels = config.ManagedSecretLabels
+		}
+		if config.ManagedSecretAnnotations != nil {
+			annotations = config.ManagedSecretAnnotations
+		}
+	}
+	return labels, annotations
+}
+
+// resyncAll enqueues all watched SopsSecrets for reconciliation
+func (r *SopsSecretReconciler) resyncAll(ctx context.Context) {
+	if r.resync == nil {
+		return
+	}
+	sopsSecrets := &isindirv1alpha2.SopsSecretList{}
+	if err := r.List(ctx, sopsSecrets); err != nil {
+		r.Log.Info("Listing SopsSecrets error", "error", err)
+		return
+	}
+	for i := range sopsSecrets.Items {
+		if !r.watched(&sopsSecrets.Items[i]) {
+			continue
+		}
+		select {
+		case r.resync <- event.GenericEvent{Object: &sopsSecrets.Items[i]}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,238 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+
+	"go.mozilla.org/sops/v3/keyservice"
+	"google.golang.org/grpc"
+)
+
+// pgpPassphraseKey is the key of PGP keys secret holding passphrase of
+// protected private keys, all other keys hold armored or binary private keys
+const pgpPassphraseKey = "passphrase"
+
+// pgpKeyService is a sops key service client which decrypts PGP data keys
+// in-process with private keys loaded from secrets, data keys these keys can
+// not decrypt are delegated to the operator GnuPG keyring
+type pgpKeyService struct {
+	keys openpgp.EntityList
+	next keyservice.KeyServiceClient
+}
+
+func newPgpKeyService(keys openpgp.EntityList, next keyservice.KeyServiceClient) keyservice.KeyServiceClient {
+	return &pgpKeyService{
+		keys: keys,
+		next: next,
+	}
+}
+
+// Encrypt is not used by the operator and is always delegated
+func (ks *pgpKeyService) Encrypt(
+	ctx context.Context,
+	req *keyservice.EncryptRequest,
+	opts ...grpc.CallOption,
+) (*keyservice.EncryptResponse, error) {
+	return ks.next.Encrypt(ctx, req, opts...)
+}
+
+// Decrypt decrypts data key with private keys from secrets if key is a PGP key
+func (ks *pgpKeyService) Decrypt(
+	ctx context.Context,
+	req *keyservice.DecryptRequest,
+	opts ...grpc.CallOption,
+) (*keyservice.DecryptResponse, error) {
+	if req.Key.GetPgpKey() == nil {
+		return ks.next.Decrypt(ctx, req, opts...)
+	}
+
+	block, err := armor.Decode(bytes.NewReader(req.Ciphertext))
+	if err != nil {
+		return ks.next.Decrypt(ctx, req, opts...)
+	}
+	message, err := openpgp.ReadMessage(block.Body, ks.keys, nil, nil)
+	if err != nil {
+		return ks.next.Decrypt(ctx, req, opts...)
+	}
+	plaintext, err := ioutil.ReadAll(message.UnverifiedBody)
+	if err != nil {
+		return nil, err
+	}
+	return &keyservice.DecryptResponse{Plaintext: plaintext}, nil
+}
+
+// pgpKeyring caches PGP keys parsed from secrets, keys are parsed again when
+// secret resource version changes
+type pgpKeyring struct {
+	lock    sync.Mutex
+	entries map[types.NamespacedName]pgpKeyringEntry
+}
+
+type pgpKeyringEntry struct {
+	resourceVersion string
+	keys            openpgp.EntityList
+}
+
+// keys returns PGP private keys of the secret
+func (k *pgpKeyring) keys(secret *corev1.Secret) (openpgp.EntityList, error) {
+	name := types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name}
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if entry, ok := k.entries[name]; ok && entry.resourceVersion == secret.ResourceVersion {
+		return entry.keys, nil
+	}
+
+	keys, err := parsePgpKeys(secret)
+	if err != nil {
+		return nil, err
+	}
+	if k.entries == nil {
+		k.entries = make(map[types.NamespacedName]pgpKeyringEntry)
+	}
+	k.entries[name] = pgpKeyringEntry{resourceVersion: secret.ResourceVersion, keys: keys}
+	return keys, nil
+}
+
+// parsePgpKeys reads armored or binary PGP private keys from secret data,
+// protected keys are decrypted with passphrase from the secret
+func parsePgpKeys(secret *corev1.Secret) (openpgp.EntityList, error) {
+	passphrase := secret.Data[pgpPassphraseKey]
+
+	var keys openpgp.EntityList
+	for _, key := range sortedKeys(secret.Data) {
+		if key == pgpPassphraseKey {
+			continue
+		}
+
+		entities, err := parsePgpKeyring(secret.Data[key], passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("secret %s/%s key %s: %v", secret.Namespace, secret.Name, key, err)
+		}
+		keys = append(keys, entities...)
+	}
+	return keys, nil
+}
+
+// parsePgpKeyring reads armored or binary PGP keyring, protected private
+// keys are decrypted with passphrase
+func parsePgpKeyring(value []byte, passphrase []byte) (openpgp.EntityList, error) {
+	var entities openpgp.EntityList
+	var err error
+	if bytes.HasPrefix(bytes.TrimSpace(value), []byte("-----BEGIN")) {
+		entities, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(value))
+	} else {
+		entities, err = openpgp.ReadKeyRing(bytes.NewReader(value))
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, entity := range entities {
+		if err := decryptPgpPrivateKey(entity.PrivateKey, passphrase); err != nil {
+			return nil, err
+		}
+		for _, subkey := range entity.Subkeys {
+			if err := decryptPgpPrivateKey(subkey.PrivateKey, passphrase); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return entities, nil
+}
+
+func decryptPgpPrivateKey(privateKey *packet.PrivateKey, passphrase []byte) error {
+	if privateKey == nil || !privateKey.Encrypted {
+		return nil
+	}
+	if len(passphrase) == 0 {
+		return fmt.Errorf("private key %X is protected and secret has no %s", privateKey.Fingerprint, pgpPassphraseKey)
+	}
+	return privateKey.Decrypt(passphrase)
+}
+
+// pgpKeys returns PGP keys from keys secret of the namespace followed by keys
+// from operator keys secret and keys held on PKCS#11 token, secrets which can
+// not be read are skipped
+func (r *SopsSecretReconciler) pgpKeys(ctx context.Context, namespace string) openpgp.EntityList {
+	log := r.logger(ctx)
+
+	var names []types.NamespacedName
+	if r.NamespaceGpgKeysSecret != "" {
+		names = append(names, types.NamespacedName{Namespace: namespace, Name: r.NamespaceGpgKeysSecret})
+	}
+	if r.GpgKeysSecret.Name != "" {
+		names = append(names, r.GpgKeysSecret)
+	}
+
+	var keys openpgp.EntityList
+	for _, name := range names {
+		secret := &corev1.Secret{}
+		if err := r.Get(ctx, name, secret); err != nil {
+			// namespace keys secret is optional
+			if !errors.IsNotFound(err) || name == r.GpgKeysSecret {
+				log.Info("Reading PGP keys secret error", "secret", name, "error", err)
+			}
+			continue
+		}
+		secretKeys, err := r.pgpKeyring.keys(secret)
+		if err != nil {
+			log.Info("Parsing PGP keys secret error", "secret", name, "error", err)
+			continue
+		}
+		keys = append(keys, secretKeys...)
+	}
+	return append(keys, r.pkcs11Keys(ctx)...)
+}
+
+// pgpKeysSopsSecrets maps PGP keys secret to SopsSecrets encrypted with PGP
+// keys which may use it, so they are decrypted again with new keys
+func (r *SopsSecretReconciler) pgpKeysSopsSecrets(obj client.Object) []reconcile.Request {
+	var opts []client.ListOption
+	switch {
+	case r.GpgKeysSecret.Name != "" &&
+		obj.GetNamespace() == r.GpgKeysSecret.Namespace &&
+		obj.GetName() == r.GpgKeysSecret.Name:
+	case r.Pkcs11 != nil &&
+		obj.GetNamespace() == r.Pkcs11.Secret.Namespace &&
+		obj.GetName() == r.Pkcs11.Secret.Name:
+	case r.NamespaceGpgKeysSecret != "" && obj.GetName() == r.NamespaceGpgKeysSecret:
+		opts = append(opts, client.InNamespace(obj.GetNamespace()))
+	default:
+		return nil
+	}
+
+	sopsSecrets := &isindirv1alpha2.SopsSecretList{}
+	if err := r.List(context.Background(), sopsSecrets, opts...); err != nil {
+		r.Log.Info("Listing SopsSecrets error", "secret", obj.GetName(), "error", err)
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for i := range sopsSecrets.Items {
+		sopsSecret := &sopsSecrets.Items[i]
+		if usesPgp(sopsSecret) {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: sopsSecret.Namespace, Name: sopsSecret.Name},
+			})
+		}
+	}
+	return requests
+}
@@ -0,0 +1,168 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"fmt"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// pkcs11PinKey is the key of PKCS#11 secret holding user PIN of the token,
+// all other keys hold armored or binary PGP public keys of private keys
+// stored on the token
+const pkcs11PinKey = "pin"
+
+// Pkcs11Config configures decryption of PGP data keys with private keys held
+// on a PKCS#11 token, private keys never leave the token
+type Pkcs11Config struct {
+	// Library is path of PKCS#11 module of the token
+	Library string
+	// Slot is ID of the token slot
+	Slot uint
+	// Secret holds token PIN and PGP public keys of token private keys
+	Secret types.NamespacedName
+	// Sessions is the number of token sessions used concurrently
+	Sessions int
+}
+
+// pkcs11Token is a logged in PKCS#11 token with a pool of sessions
+type pkcs11Token interface {
+	// decrypter returns RSA private key on the token matching public key,
+	// nil is returned when the token has no such key
+	decrypter(publicKey *rsa.PublicKey) (crypto.Decrypter, error)
+	io.Closer
+}
+
+// pkcs11Keyring keeps token opened with PIN from PKCS#11 secret, token is
+// opened again when secret resource version changes
+type pkcs11Keyring struct {
+	lock            sync.Mutex
+	resourceVersion string
+	token           pkcs11Token
+	entities        openpgp.EntityList
+}
+
+// keys returns PGP keys of the secret backed by token private keys
+func (k *pkcs11Keyring) keys(config *Pkcs11Config, secret *corev1.Secret) (openpgp.EntityList, error) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if k.token != nil && k.resourceVersion == secret.ResourceVersion {
+		return k.entities, nil
+	}
+
+	pin, ok := secret.Data[pkcs11PinKey]
+	if !ok {
+		return nil, fmt.Errorf("secret %s/%s has no %s", secret.Namespace, secret.Name, pkcs11PinKey)
+	}
+	var publicKeys openpgp.EntityList
+	for _, key := range sortedKeys(secret.Data) {
+		if key == pkcs11PinKey {
+			continue
+		}
+		entities, err := parsePgpKeyring(secret.Data[key], nil)
+		if err != nil {
+			return nil, fmt.Errorf("secret %s/%s key %s: %v", secret.Namespace, secret.Name, key, err)
+		}
+		publicKeys = append(publicKeys, entities...)
+	}
+
+	sessions := config.Sessions
+	if sessions < 1 {
+		sessions = 1
+	}
+	token, err := openPkcs11Token(config.Library, config.Slot, string(pin), sessions)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := pkcs11Entities(token, publicKeys)
+	if err != nil {
+		token.Close()
+		return nil, err
+	}
+
+	if k.token != nil {
+		// sessions of the previous token may still be decrypting, Close
+		// waits for them to be returned to the pool
+		go k.token.Close()
+	}
+	k.resourceVersion = secret.ResourceVersion
+	k.token = token
+	k.entities = keys
+	return keys, nil
+}
+
+// pkcs11Entities returns public keys with private key of every encryption
+// capable RSA key or subkey found on the token, keys not found on the token
+// are left out
+func pkcs11Entities(token pkcs11Token, publicKeys openpgp.EntityList) (openpgp.EntityList, error) {
+	privateKey := func(publicKey *packet.PublicKey) (*packet.PrivateKey, error) {
+		rsaKey, ok := publicKey.PublicKey.(*rsa.PublicKey)
+		if !ok || !publicKey.PubKeyAlgo.CanEncrypt() {
+			return nil, nil
+		}
+		decrypter, err := token.decrypter(rsaKey)
+		if err != nil || decrypter == nil {
+			return nil, err
+		}
+		return &packet.PrivateKey{PublicKey: *publicKey, PrivateKey: decrypter}, nil
+	}
+
+	var entities openpgp.EntityList
+	for _, entity := range publicKeys {
+		found := false
+		primary, err := privateKey(entity.PrimaryKey)
+		if err != nil {
+			return nil, fmt.Errorf("PGP key %X: %v", entity.PrimaryKey.Fingerprint, err)
+		}
+		if primary != nil {
+			entity.PrivateKey = primary
+			found = true
+		}
+		for i := range entity.Subkeys {
+			subkey, err := privateKey(entity.Subkeys[i].PublicKey)
+			if err != nil {
+				return nil, fmt.Errorf("PGP key %X: %v", entity.Subkeys[i].PublicKey.Fingerprint, err)
+			}
+			if subkey != nil {
+				entity.Subkeys[i].PrivateKey = subkey
+				found = true
+			}
+		}
+		if found {
+			entities = append(entities, entity)
+		}
+	}
+	return entities, nil
+}
+
+// pkcs11Keys returns PGP keys backed by PKCS#11 token, nil is returned when
+// PKCS#11 is not configured or token can not be used
+func (r *SopsSecretReconciler) pkcs11Keys(ctx context.Context) openpgp.EntityList {
+	if r.Pkcs11 == nil {
+		return nil
+	}
+	log := r.logger(ctx)
+
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, r.Pkcs11.Secret, secret); err != nil {
+		log.Info("Reading PKCS#11 secret error", "secret", r.Pkcs11.Secret, "error", err)
+		return nil
+	}
+	keys, err := r.pkcs11Keyring.keys(r.Pkcs11, secret)
+	if err != nil {
+		log.Info("Opening PKCS#11 token error", "library", r.Pkcs11.Library, "slot", r.Pkcs11.Slot, "error", err)
+		return nil
+	}
+	return keys
+}
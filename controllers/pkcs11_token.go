@@ -0,0 +1,124 @@
+//go:build pkcs11
+// +build pkcs11
+
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"fmt"
+	"io"
+
+	"github.com/miekg/pkcs11"
+)
+
+// hsmToken is a PKCS#11 token, sessions are pooled so concurrent
+// reconciliations do not open sessions of their own
+type hsmToken struct {
+	ctx      *pkcs11.Ctx
+	slot     uint
+	size     int
+	sessions chan pkcs11.SessionHandle
+}
+
+// openPkcs11Token loads PKCS#11 module, opens sessions to token in slot and
+// logs in with PIN
+func openPkcs11Token(library string, slot uint, pin string, sessions int) (pkcs11Token, error) {
+	ctx := pkcs11.New(library)
+	if ctx == nil {
+		return nil, fmt.Errorf("failed to load PKCS#11 library %s", library)
+	}
+	if err := ctx.Initialize(); err != nil {
+		ctx.Destroy()
+		return nil, fmt.Errorf("failed to initialize PKCS#11 library %s: %v", library, err)
+	}
+
+	token := &hsmToken{
+		ctx:      ctx,
+		slot:     slot,
+		sessions: make(chan pkcs11.SessionHandle, sessions),
+	}
+	for i := 0; i < sessions; i++ {
+		session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
+		if err != nil {
+			token.Close()
+			return nil, fmt.Errorf("failed to open PKCS#11 session to slot %d: %v", slot, err)
+		}
+		token.sessions <- session
+		token.size++
+
+		// login state is shared by all sessions of the token
+		if i == 0 {
+			if err := ctx.Login(session, pkcs11.CKU_USER, pin); err != nil && err != pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN) {
+				token.Close()
+				return nil, fmt.Errorf("failed to log in to PKCS#11 slot %d: %v", slot, err)
+			}
+		}
+	}
+	return token, nil
+}
+
+func (t *hsmToken) decrypter(publicKey *rsa.PublicKey) (crypto.Decrypter, error) {
+	session := <-t.sessions
+	defer func() { t.sessions <- session }()
+
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA),
+		pkcs11.NewAttribute(pkcs11.CKA_MODULUS, publicKey.N.Bytes()),
+	}
+	if err := t.ctx.FindObjectsInit(session, template); err != nil {
+		return nil, err
+	}
+	objects, _, err := t.ctx.FindObjects(session, 1)
+	if finalErr := t.ctx.FindObjectsFinal(session); err == nil {
+		err = finalErr
+	}
+	if err != nil || len(objects) == 0 {
+		return nil, err
+	}
+	return &hsmKey{token: t, handle: objects[0], publicKey: publicKey}, nil
+}
+
+// Close waits for all sessions to be returned to the pool, logs out and
+// unloads PKCS#11 module
+func (t *hsmToken) Close() error {
+	for i := 0; i < t.size; i++ {
+		session := <-t.sessions
+		if i == 0 {
+			t.ctx.Logout(session)
+		}
+	}
+	err := t.ctx.CloseAllSessions(t.slot)
+	t.ctx.Finalize()
+	t.ctx.Destroy()
+	return err
+}
+
+// hsmKey is RSA private key on PKCS#11 token
+type hsmKey struct {
+	token     *hsmToken
+	handle    pkcs11.ObjectHandle
+	publicKey *rsa.PublicKey
+}
+
+func (k *hsmKey) Public() crypto.PublicKey {
+	return k.publicKey
+}
+
+// Decrypt decrypts PKCS#1 v1.5 padded ciphertext on the token, as used by
+// PGP for RSA encrypted session keys
+func (k *hsmKey) Decrypt(_ io.Reader, ciphertext []byte, _ crypto.DecrypterOpts) ([]byte, error) {
+	session := <-k.token.sessions
+	defer func() { k.token.sessions <- session }()
+
+	mechanism := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil)}
+	if err := k.token.ctx.DecryptInit(session, mechanism, k.handle); err != nil {
+		return nil, err
+	}
+	return k.token.ctx.Decrypt(session, ciphertext)
+}
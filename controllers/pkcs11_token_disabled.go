@@ -0,0 +1,16 @@
+//go:build !pkcs11
+// +build !pkcs11
+
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import "fmt"
+
+// openPkcs11Token fails, PKCS#11 support requires cgo and is built only with
+// pkcs11 build tag
+func openPkcs11Token(library string, slot uint, pin string, sessions int) (pkcs11Token, error) {
+	return nil, fmt.Errorf("operator is built without PKCS#11 support, build it with -tags pkcs11")
+}
@@ -0,0 +1,168 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// priorityPollInterval is how often full work queue is checked for room
+const priorityPollInterval = 10 * time.Millisecond
+
+// priorityQueue admits SopsSecret events to controller work queue in order of
+// spec.priority. Work queue of controller-runtime is FIFO and can not be
+// replaced, so events wait in priority heap and are moved to the work queue
+// only while it holds fewer requests than there are workers, which keeps a
+// burst of events, such as initial listing after restart, ordered by priority
+type priorityQueue struct {
+	lock   sync.Mutex
+	cond   *sync.Cond
+	items  priorityItems
+	queued map[types.NamespacedName]*priorityItem
+	seq    uint64
+	limit  int
+	start  sync.Once
+}
+
+var _ handler.EventHandler = &priorityQueue{}
+
+func newPriorityQueue(workers int) *priorityQueue {
+	if workers < 1 {
+		workers = 1
+	}
+	pq := &priorityQueue{
+		queued: make(map[types.NamespacedName]*priorityItem),
+		limit:  workers,
+	}
+	pq.cond = sync.NewCond(&pq.lock)
+	return pq
+}
+
+// Create admits request of created SopsSecret
+func (pq *priorityQueue) Create(evt event.CreateEvent, q workqueue.RateLimitingInterface) {
+	pq.push(evt.Object, q)
+}
+
+// Update admits request of updated SopsSecret
+func (pq *priorityQueue) Update(evt event.UpdateEvent, q workqueue.RateLimitingInterface) {
+	pq.push(evt.ObjectNew, q)
+}
+
+// Delete admits request of deleted SopsSecret
+func (pq *priorityQueue) Delete(evt event.DeleteEvent, q workqueue.RateLimitingInterface) {
+	pq.push(evt.Object, q)
+}
+
+// Generic admits request of SopsSecret
+func (pq *priorityQueue) Generic(evt event.GenericEvent, q workqueue.RateLimitingInterface) {
+	pq.push(evt.Object, q)
+}
+
+// push adds request of SopsSecret to priority heap, request already waiting
+// keeps its position unless priority is raised
+func (pq *priorityQueue) push(obj client.Object, q workqueue.RateLimitingInterface) {
+	if obj == nil {
+		return
+	}
+	pq.start.Do(func() {
+		go pq.run(q)
+	})
+
+	var priority int32
+	if sopsSecret, ok := obj.(*isindirv1alpha2.SopsSecret); ok {
+		priority = sopsSecret.Spec.Priority
+	}
+	name := types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}
+
+	pq.lock.Lock()
+	defer pq.lock.Unlock()
+	if item, ok := pq.queued[name]; ok {
+		if priority > item.priority {
+			item.priority = priority
+			heap.Fix(&pq.items, item.index)
+		}
+		return
+	}
+	queuedRequests.add(name, time.Now())
+	pq.seq++
+	item := &priorityItem{name: name, priority: priority, seq: pq.seq}
+	heap.Push(&pq.items, item)
+	pq.queued[name] = item
+	pq.cond.Signal()
+}
+
+// run moves requests with highest priority to work queue while it has room,
+// until work queue shuts down
+func (pq *priorityQueue) run(q workqueue.RateLimitingInterface) {
+	for !q.ShuttingDown() {
+		pq.lock.Lock()
+		for pq.items.Len() == 0 {
+			pq.cond.Wait()
+		}
+		pq.lock.Unlock()
+
+		// requests pushed while waiting for room are ordered before the pop
+		for q.Len() >= pq.limit && !q.ShuttingDown() {
+			time.Sleep(priorityPollInterval)
+		}
+
+		pq.lock.Lock()
+		item := heap.Pop(&pq.items).(*priorityItem)
+		delete(pq.queued, item.name)
+		pq.lock.Unlock()
+		q.Add(reconcile.Request{NamespacedName: item.name})
+	}
+}
+
+type priorityItem struct {
+	name     types.NamespacedName
+	priority int32
+	// seq keeps FIFO order of requests with equal priority
+	seq   uint64
+	index int
+}
+
+// priorityItems implements heap.Interface, highest priority first
+type priorityItems []*priorityItem
+
+func (p priorityItems) Len() int { return len(p) }
+
+func (p priorityItems) Less(i, j int) bool {
+	if p[i].priority != p[j].priority {
+		return p[i].priority > p[j].priority
+	}
+	return p[i].seq < p[j].seq
+}
+
+func (p priorityItems) Swap(i, j int) {
+	p[i], p[j] = p[j], p[i]
+	p[i].index = i
+	p[j].index = j
+}
+
+func (p *priorityItems) Push(x interface{}) {
+	item := x.(*priorityItem)
+	item.index = len(*p)
+	*p = append(*p, item)
+}
+
+func (p *priorityItems) Pop() interface{} {
+	old := *p
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*p = old[:len(old)-1]
+	return item
+}
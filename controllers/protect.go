@@ -0,0 +1,68 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+const (
+	// ProtectAnnotation set to "true" on SopsSecret blocks its deletion and
+	// deletion of its generated secrets until the annotation is removed
+	ProtectAnnotation = "sops-secrets-operator/protect"
+
+	// ProtectionFinalizer is added to protected SopsSecret and its generated
+	// secrets
+	ProtectionFinalizer = "isindir.github.com/protection-finalizer"
+)
+
+// protected reports whether SopsSecret has protect annotation
+func protected(instance *isindirv1alpha2.SopsSecret) bool {
+	return instance.Annotations[ProtectAnnotation] == "true"
+}
+
+// unprotectSecrets removes protection finalizer from secrets controlled by
+// SopsSecret, so they can be deleted
+func (r *SopsSecretReconciler) unprotectSecrets(ctx context.Context, instance *isindirv1alpha2.SopsSecret) error {
+	ownedSecrets := &corev1.SecretList{}
+	if err := r.listOwnedSecrets(ctx, instance, ownedSecrets); err != nil {
+		return err
+	}
+	writer, err := r.secretWriter(instance)
+	if err != nil {
+		return err
+	}
+	for i := range ownedSecrets.Items {
+		secret := &ownedSecrets.Items[i]
+		if !metav1.IsControlledBy(secret, instance) {
+			continue
+		}
+		if err := r.unprotectSecret(ctx, writer, secret); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unprotectSecret removes protection finalizer from secret with client c
+func (r *SopsSecretReconciler) unprotectSecret(ctx context.Context, c client.Client, secret *corev1.Secret) error {
+	if !controllerutil.ContainsFinalizer(secret, ProtectionFinalizer) {
+		return nil
+	}
+	patch := client.MergeFrom(secret.DeepCopy())
+	controllerutil.RemoveFinalizer(secret, ProtectionFinalizer)
+	if err := c.Patch(ctx, secret, patch); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var (
+	queueDepthDesc = prometheus.NewDesc(
+		"sops_secrets_operator_queue_depth",
+		"Number of SopsSecrets waiting for reconciliation by namespace.",
+		[]string{"namespace"},
+		nil,
+	)
+	queueOldestDesc = prometheus.NewDesc(
+		"sops_secrets_operator_queue_oldest_pending_seconds",
+		"Time the longest waiting SopsSecret of namespace waits for reconciliation.",
+		[]string{"namespace"},
+		nil,
+	)
+)
+
+// pendingRequests tracks since when SopsSecrets wait for reconciliation,
+// work queue of controller-runtime reports its depth per controller only.
+// Requests requeued after a delay are pending once the delay passes
+type pendingRequests struct {
+	lock  sync.Mutex
+	since map[types.NamespacedName]time.Time
+}
+
+var _ prometheus.Collector = &pendingRequests{}
+
+// queuedRequests are requests of SopsSecret controller
+var queuedRequests = &pendingRequests{}
+
+// add records request pending from the time, request already pending keeps
+// the earlier time
+func (p *pendingRequests) add(name types.NamespacedName, at time.Time) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.since == nil {
+		p.since = make(map[types.NamespacedName]time.Time)
+	}
+	if since, ok := p.since[name]; ok && !since.After(at) {
+		return
+	}
+	p.since[name] = at
+}
+
+// done forgets request once its reconciliation starts
+func (p *pendingRequests) done(name types.NamespacedName) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	delete(p.since, name)
+}
+
+// Describe implements prometheus.Collector
+func (p *pendingRequests) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueDepthDesc
+	ch <- queueOldestDesc
+}
+
+// Collect implements prometheus.Collector, namespaces without pending
+// requests are not reported
+func (p *pendingRequests) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	depth := map[string]int{}
+	oldest := map[string]time.Time{}
+
+	p.lock.Lock()
+	for name, since := range p.since {
+		if since.After(now) {
+			continue
+		}
+		depth[name.Namespace]++
+		if first, ok := oldest[name.Namespace]; !ok || since.Before(first) {
+			oldest[name.Namespace] = since
+		}
+	}
+	p.lock.Unlock()
+
+	for namespace, count := range depth {
+		ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(count), namespace)
+		ch <- prometheus.MustNewConstMetric(queueOldestDesc, prometheus.GaugeValue, now.Sub(oldest[namespace]).Seconds(), namespace)
+	}
+}
+
+// trackPending wraps event handler, so requests it adds to work queue are
+// recorded as pending
+func trackPending(h handler.EventHandler) handler.EventHandler {
+	return &pendingHandler{handler: h}
+}
+
+type pendingHandler struct {
+	handler handler.EventHandler
+}
+
+func (h *pendingHandler) Create(evt event.CreateEvent, q workqueue.RateLimitingInterface) {
+	h.handler.Create(evt, &pendingQueue{q})
+}
+
+func (h *pendingHandler) Update(evt event.UpdateEvent, q workqueue.RateLimitingInterface) {
+	h.handler.Update(evt, &pendingQueue{q})
+}
+
+func (h *pendingHandler) Delete(evt event.DeleteEvent, q workqueue.RateLimitingInterface) {
+	h.handler.Delete(evt, &pendingQueue{q})
+}
+
+func (h *pendingHandler) Generic(evt event.GenericEvent, q workqueue.RateLimitingInterface) {
+	h.handler.Generic(evt, &pendingQueue{q})
+}
+
+// pendingQueue records requests added to work queue in queuedRequests
+type pendingQueue struct {
+	workqueue.RateLimitingInterface
+}
+
+func (q *pendingQueue) Add(item interface{}) {
+	if req, ok := item.(reconcile.Request); ok {
+		queuedRequests.add(req.NamespacedName, time.Now())
+	}
+	q.RateLimitingInterface.Add(item)
+}
+
+func (q *pendingQueue) AddAfter(item interface{}, duration time.Duration) {
+	if req, ok := item.(reconcile.Request); ok {
+		queuedRequests.add(req.NamespacedName, time.Now().Add(duration))
+	}
+	q.RateLimitingInterface.AddAfter(item, duration)
+}
+
+func (q *pendingQueue) AddRateLimited(item interface{}) {
+	if req, ok := item.(reconcile.Request); ok {
+		queuedRequests.add(req.NamespacedName, time.Now())
+	}
+	q.RateLimitingInterface.AddRateLimited(item)
+}
@@ -0,0 +1,60 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// namespaceRateLimiter is a token bucket rate limiter of reconcile requests
+// keyed by namespace, so SopsSecrets of a single namespace can not starve
+// reconciliation of other namespaces
+type namespaceRateLimiter struct {
+	lock     sync.Mutex
+	limit    rate.Limit
+	burst    int
+	limiters map[string]*rate.Limiter
+}
+
+var _ workqueue.RateLimiter = &namespaceRateLimiter{}
+
+func newNamespaceRateLimiter(qps float64, burst int) *namespaceRateLimiter {
+	return &namespaceRateLimiter{
+		limit:    rate.Limit(qps),
+		burst:    burst,
+		limiters: make(map[string]*rate.Limiter),
+	}
+}
+
+// When returns delay before the item can be processed
+func (l *namespaceRateLimiter) When(item interface{}) time.Duration {
+	namespace := ""
+	if req, ok := item.(reconcile.Request); ok {
+		namespace = req.Namespace
+	}
+
+	l.lock.Lock()
+	limiter, ok := l.limiters[namespace]
+	if !ok {
+		limiter = rate.NewLimiter(l.limit, l.burst)
+		l.limiters[namespace] = limiter
+	}
+	l.lock.Unlock()
+
+	return limiter.Reserve().Delay()
+}
+
+// Forget is a no-op, token buckets are not tracking individual items
+func (l *namespaceRateLimiter) Forget(item interface{}) {}
+
+// NumRequeues is always 0, token buckets are not tracking individual items
+func (l *namespaceRateLimiter) NumRequeues(item interface{}) int {
+	return 0
+}
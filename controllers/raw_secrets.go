@@ -0,0 +1,88 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// ExpandRawSecrets decrypts raw secrets of decrypted SopsSecret and appends
+// them to its secret templates, so they are generated as any other secret.
+// Raw secrets are cleared afterwards, expanding the same instance again is a
+// no-op
+func ExpandRawSecrets(instance *isindirv1alpha2.SopsSecret, decryptor Decryptor) error {
+	for i, document := range instance.Spec.RawSecrets {
+		secretTpl, err := rawSecretTemplate(instance, document, decryptor)
+		if err != nil {
+			return fmt.Errorf("rawSecrets[%d]: %w", i, err)
+		}
+		instance.Spec.SecretsTemplate = append(instance.Spec.SecretsTemplate, *secretTpl)
+	}
+	instance.Spec.RawSecrets = nil
+
+	if len(instance.Spec.SecretsTemplate) == 0 {
+		return &permanentError{fmt.Errorf("at least one secret template or raw secret is required")}
+	}
+	return nil
+}
+
+// rawSecretTemplate returns secret template equivalent to SOPS encrypted
+// Secret manifest
+func rawSecretTemplate(
+	instance *isindirv1alpha2.SopsSecret,
+	document string,
+	decryptor Decryptor,
+) (*isindirv1alpha2.SopsSecretTemplate, error) {
+	cleartext, err := decryptor.DecryptDocument([]byte(document), "yaml")
+	if err != nil {
+		return nil, err
+	}
+
+	secret := &corev1.Secret{}
+	if err := yaml.UnmarshalStrict(cleartext, secret); err != nil {
+		return nil, &permanentError{fmt.Errorf("document is not a Secret manifest: %v", err)}
+	}
+	if secret.Kind != "Secret" || (secret.APIVersion != "v1" && secret.APIVersion != "") {
+		return nil, &permanentError{fmt.Errorf("document is %s %s, not v1 Secret", secret.APIVersion, secret.Kind)}
+	}
+	if secret.Name == "" {
+		return nil, &permanentError{fmt.Errorf("metadata.name is required")}
+	}
+	if secret.Namespace != "" && secret.Namespace != instance.Namespace {
+		return nil, &permanentError{fmt.Errorf("secret %s is in namespace %s, not in SopsSecret namespace %s", secret.Name, secret.Namespace, instance.Namespace)}
+	}
+	if secret.Immutable != nil && *secret.Immutable {
+		// generated secrets are updated in place, immutable secrets are
+		// generated by secret templates with immutable field
+		return nil, &permanentError{fmt.Errorf("secret %s: immutable raw secrets are not supported", secret.Name)}
+	}
+
+	secretTpl := &isindirv1alpha2.SopsSecretTemplate{
+		Name:        secret.Name,
+		Type:        string(secret.Type),
+		Labels:      secret.Labels,
+		Annotations: secret.Annotations,
+	}
+	if len(secret.StringData) > 0 {
+		secretTpl.Data = secret.StringData
+	}
+	for key, value := range secret.Data {
+		if _, ok := secret.StringData[key]; ok {
+			// stringData overrides data the same way API server merges them
+			continue
+		}
+		if secretTpl.BinaryData == nil {
+			secretTpl.BinaryData = make(map[string]string, len(secret.Data))
+		}
+		secretTpl.BinaryData[key] = base64.StdEncoding.EncodeToString(value)
+	}
+	return secretTpl, nil
+}
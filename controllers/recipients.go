@@ -0,0 +1,96 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// sopsRecipients returns recipients of all key groups of SopsSecret in the
+// form sops prints them: AWS KMS key ARN, PGP fingerprint, Azure Key Vault
+// key URL, Vault transit key URL, GCP KMS resource ID and age recipient
+func sopsRecipients(metadata *isindirv1alpha2.SopsMetadata) []string {
+	var recipients []string
+	for _, group := range sopsKeyGroups(metadata) {
+		for _, key := range group.AwsKms {
+			recipients = append(recipients, key.Arn)
+		}
+		for _, key := range group.Pgp {
+			recipients = append(recipients, key.FingerPrint)
+		}
+		for _, key := range group.AzureKms {
+			recipients = append(recipients, fmt.Sprintf("%s/keys/%s/%s", key.VaultURL, key.KeyName, key.Version))
+		}
+		for _, key := range group.HcVault {
+			recipients = append(recipients, fmt.Sprintf("%s/v1/%s/keys/%s", key.VaultAddress, key.EnginePath, key.KeyName))
+		}
+		for _, key := range group.GcpKms {
+			recipients = append(recipients, key.VaultURL)
+		}
+		for _, key := range group.Age {
+			recipients = append(recipients, key.Recipient)
+		}
+	}
+	return recipients
+}
+
+// staleRecipients returns sorted recipients of SopsSecret which are not
+// current, PGP fingerprints are compared case insensitively
+func staleRecipients(metadata *isindirv1alpha2.SopsMetadata, current []string) []string {
+	currentSet := make(map[string]bool)
+	for _, recipient := range current {
+		currentSet[strings.ToUpper(recipient)] = true
+	}
+	seen := make(map[string]bool)
+	var stale []string
+	for _, recipient := range sopsRecipients(metadata) {
+		if currentSet[strings.ToUpper(recipient)] || seen[recipient] {
+			continue
+		}
+		seen[recipient] = true
+		stale = append(stale, recipient)
+	}
+	sort.Strings(stale)
+	return stale
+}
+
+// checkRecipients records recipients of SopsSecret which are not current
+// in NeedsReencryption condition, nothing is checked unless current
+// recipients are configured
+func (r *SopsSecretReconciler) checkRecipients(instance *isindirv1alpha2.SopsSecret) {
+	current := r.currentRecipients()
+	if len(current) == 0 {
+		meta.RemoveStatusCondition(&instance.Status.Conditions, isindirv1alpha2.NeedsReencryptionCondition)
+		needsReencryption.DeleteLabelValues(instance.Namespace, instance.Name)
+		return
+	}
+
+	stale := staleRecipients(&instance.Sops, current)
+	needsReencryption.WithLabelValues(instance.Namespace, instance.Name).Set(float64(len(stale)))
+	if len(stale) == 0 {
+		meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+			Type:               isindirv1alpha2.NeedsReencryptionCondition,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: instance.Generation,
+			Reason:             "RecipientsCurrent",
+			Message:            "SopsSecret is encrypted for current recipients only",
+		})
+		return
+	}
+	meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+		Type:               isindirv1alpha2.NeedsReencryptionCondition,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: instance.Generation,
+		Reason:             "StaleRecipients",
+		Message:            "SopsSecret is encrypted for recipients which are not current: " + strings.Join(stale, ", "),
+	})
+}
@@ -0,0 +1,150 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"go.mozilla.org/sops/v3"
+	"go.mozilla.org/sops/v3/age"
+	"go.mozilla.org/sops/v3/azkv"
+	"go.mozilla.org/sops/v3/gcpkms"
+	"go.mozilla.org/sops/v3/hcvault"
+	"go.mozilla.org/sops/v3/kms"
+	"go.mozilla.org/sops/v3/pgp"
+	sopsjson "go.mozilla.org/sops/v3/stores/json"
+	corev1 "k8s.io/api/core/v1"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// ReencryptAnnotation set to "true" allows operator to re-encrypt data key of
+// SopsSecret for current recipients, when re-encryption is enabled
+const ReencryptAnnotation = "sops-secrets-operator/reencrypt"
+
+// recipientKeyGroup returns sops master keys of recipients in the form
+// reported by sopsRecipients
+func recipientKeyGroup(recipients []string) (sops.KeyGroup, error) {
+	var group sops.KeyGroup
+	for _, recipient := range recipients {
+		switch {
+		case strings.HasPrefix(recipient, "age1"):
+			ageKeys, err := age.MasterKeysFromRecipients(recipient)
+			if err != nil {
+				return nil, err
+			}
+			for _, k := range ageKeys {
+				group = append(group, k)
+			}
+		case strings.HasPrefix(recipient, "arn:"):
+			for _, k := range kms.MasterKeysFromArnString(recipient, nil, "") {
+				group = append(group, k)
+			}
+		case strings.HasPrefix(recipient, "projects/"):
+			for _, k := range gcpkms.MasterKeysFromResourceIDString(recipient) {
+				group = append(group, k)
+			}
+		case strings.Contains(recipient, "/v1/") && strings.Contains(recipient, "/keys/"):
+			vaultKeys, err := hcvault.NewMasterKeysFromURIs(recipient)
+			if err != nil {
+				return nil, err
+			}
+			for _, k := range vaultKeys {
+				group = append(group, k)
+			}
+		case strings.Contains(recipient, "/keys/"):
+			azureKeys, err := azkv.MasterKeysFromURLs(recipient)
+			if err != nil {
+				return nil, err
+			}
+			for _, k := range azureKeys {
+				group = append(group, k)
+			}
+		default:
+			for _, k := range pgp.MasterKeysFromFingerprintString(recipient) {
+				group = append(group, k)
+			}
+		}
+	}
+	return group, nil
+}
+
+// updateKeys returns sops metadata of SopsSecret with its data key encrypted
+// for recipients in a single key group, like sops updatekeys. Values and MAC
+// stay encrypted with the same data key, so they are not changed
+func (d *sopsDecryptor) updateKeys(instanceEncrypted *isindirv1alpha2.SopsSecret, recipients []string) (*isindirv1alpha2.SopsMetadata, error) {
+	group, err := recipientKeyGroup(recipients)
+	if err != nil {
+		return nil, &permanentError{fmt.Errorf("invalid current recipients: %v", err)}
+	}
+	layouts, err := encryptedLayouts(instanceEncrypted)
+	if err != nil {
+		return nil, err
+	}
+	store := &sopsjson.Store{}
+	tree, err := store.LoadEncryptedFile(layouts[0])
+	if err != nil {
+		return nil, &permanentError{err}
+	}
+	dataKey, err := tree.Metadata.GetDataKeyWithKeyServices(d.keyServices)
+	if err != nil {
+		return nil, err
+	}
+
+	tree.Metadata.KeyGroups = []sops.KeyGroup{group}
+	tree.Metadata.ShamirThreshold = 0
+	if errs := tree.Metadata.UpdateMasterKeysWithKeyServices(dataKey, d.keyServices); len(errs) > 0 {
+		return nil, fmt.Errorf("could not encrypt data key for current recipients: %v", errs)
+	}
+	document, err := store.EmitEncryptedFile(tree)
+	if err != nil {
+		return nil, err
+	}
+	updated := &isindirv1alpha2.SopsSecret{}
+	if err := json.Unmarshal(document, updated); err != nil {
+		return nil, err
+	}
+	return &updated.Sops, nil
+}
+
+// reencrypt re-encrypts data key of SopsSecret for current recipients and
+// updates SopsSecret, when re-encryption is enabled, SopsSecret opts in with
+// ReencryptAnnotation and NeedsReencryption condition reports stale
+// recipients. It reports whether SopsSecret was updated, failures are
+// reported in events and reconciliation continues with the current keys
+func (r *SopsSecretReconciler) reencrypt(ctx context.Context, instanceEncrypted *isindirv1alpha2.SopsSecret, decryptor Decryptor) bool {
+	if !r.EnableReencryption || instanceEncrypted.Annotations[ReencryptAnnotation] != "true" {
+		return false
+	}
+	recipients := r.currentRecipients()
+	if len(recipients) == 0 || len(staleRecipients(&instanceEncrypted.Sops, recipients)) == 0 {
+		return false
+	}
+	updater, ok := decryptor.(interface {
+		updateKeys(*isindirv1alpha2.SopsSecret, []string) (*isindirv1alpha2.SopsMetadata, error)
+	})
+	if !ok {
+		return false
+	}
+
+	log := r.logger(ctx)
+	metadata, err := updater.updateKeys(instanceEncrypted, recipients)
+	if err == nil {
+		updated := instanceEncrypted.DeepCopy()
+		updated.Sops = *metadata
+		err = r.Update(ctx, updated)
+	}
+	if err != nil {
+		log.Info("Re-encryption error", "error", err)
+		r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeWarning, "ReencryptionFailed", "Failed to re-encrypt for current recipients: %v", err)
+		return false
+	}
+	log.Info("Re-encrypted for current recipients")
+	r.Recorder.Event(instanceEncrypted, corev1.EventTypeNormal, "Reencrypted", "Data key is re-encrypted for current recipients")
+	return true
+}
@@ -0,0 +1,206 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// sopsSecretDependencies tracks SopsSecrets referenced by sopsSecretRef
+// values of other SopsSecrets. References are encrypted, so they are known
+// only after decryption and are recorded by reconciliation of the
+// referencing SopsSecret
+type sopsSecretDependencies struct {
+	lock sync.Mutex
+	// dependents maps referenced SopsSecret to SopsSecrets referencing it
+	dependents map[types.NamespacedName]map[types.NamespacedName]bool
+	// references maps SopsSecret to SopsSecrets it references
+	references map[types.NamespacedName][]types.NamespacedName
+}
+
+func newSopsSecretDependencies() *sopsSecretDependencies {
+	return &sopsSecretDependencies{
+		dependents: make(map[types.NamespacedName]map[types.NamespacedName]bool),
+		references: make(map[types.NamespacedName][]types.NamespacedName),
+	}
+}
+
+// set replaces SopsSecrets referenced by the SopsSecret
+func (d *sopsSecretDependencies) set(name types.NamespacedName, references []types.NamespacedName) {
+	if d == nil {
+		return
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for _, reference := range d.references[name] {
+		delete(d.dependents[reference], name)
+		if len(d.dependents[reference]) == 0 {
+			delete(d.dependents, reference)
+		}
+	}
+	delete(d.references, name)
+	if len(references) == 0 {
+		return
+	}
+	d.references[name] = references
+	for _, reference := range references {
+		if d.dependents[reference] == nil {
+			d.dependents[reference] = make(map[types.NamespacedName]bool)
+		}
+		d.dependents[reference][name] = true
+	}
+}
+
+// dependentsOf returns SopsSecrets referencing the SopsSecret
+func (d *sopsSecretDependencies) dependentsOf(name types.NamespacedName) []types.NamespacedName {
+	if d == nil {
+		return nil
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	var dependents []types.NamespacedName
+	for dependent := range d.dependents[name] {
+		dependents = append(dependents, dependent)
+	}
+	return dependents
+}
+
+// referencingSopsSecrets maps SopsSecret to SopsSecrets referencing it, so
+// their secrets are rendered again with its new values
+func (r *SopsSecretReconciler) referencingSopsSecrets(obj client.Object) []reconcile.Request {
+	var requests []reconcile.Request
+	for _, dependent := range r.dependencies.dependentsOf(client.ObjectKeyFromObject(obj)) {
+		requests = append(requests, reconcile.Request{NamespacedName: dependent})
+	}
+	return requests
+}
+
+// resolveSopsSecretRefs sets values of secret templates of decrypted
+// SopsSecret referencing decrypted values of other SopsSecrets in the same
+// namespace, resolved values are treated as data values of the template.
+// Referenced SopsSecret is decrypted with its own keys and values come from
+// its own secret templates, its references are not followed
+func (r *SopsSecretReconciler) resolveSopsSecretRefs(
+	ctx context.Context,
+	instance *isindirv1alpha2.SopsSecret,
+) error {
+	name := types.NamespacedName{Namespace: instance.Namespace, Name: instance.Name}
+	seen := make(map[types.NamespacedName]bool)
+	var references []types.NamespacedName
+	for i := range instance.Spec.SecretsTemplate {
+		for _, value := range instance.Spec.SecretsTemplate[i].Values {
+			ref := value.ValueFrom.SopsSecretRef
+			if ref == nil {
+				continue
+			}
+			reference := types.NamespacedName{Namespace: instance.Namespace, Name: ref.Name}
+			if !seen[reference] {
+				seen[reference] = true
+				references = append(references, reference)
+			}
+		}
+	}
+	// dependencies are recorded before resolution, so SopsSecret failing on
+	// missing reference is reconciled again when the reference is created
+	r.dependencies.set(name, references)
+	if len(references) == 0 {
+		return nil
+	}
+
+	log := r.logger(ctx)
+	decrypted := make(map[types.NamespacedName]*isindirv1alpha2.SopsSecret)
+	for i := range instance.Spec.SecretsTemplate {
+		secretTpl := &instance.Spec.SecretsTemplate[i]
+		for _, value := range secretTpl.Values {
+			ref := value.ValueFrom.SopsSecretRef
+			if ref == nil {
+				if value.ValueFrom.SealedSecret != nil || value.ValueFrom.SecretKeyRef != nil {
+					continue
+				}
+				return &permanentError{fmt.Errorf("%s: values[%v]: valueFrom has no source", secretTpl.Name, value.Name)}
+			}
+			if secretTpl.Expand || secretTpl.Format != "" {
+				return &permanentError{fmt.Errorf("%s: values can not be used with expand or format", secretTpl.Name)}
+			}
+			if _, ok := secretTpl.Data[value.Name]; ok {
+				return &permanentError{fmt.Errorf("%s: values[%v]: key is already defined in data", secretTpl.Name, value.Name)}
+			}
+
+			reference := types.NamespacedName{Namespace: instance.Namespace, Name: ref.Name}
+			if reference == name {
+				return &permanentError{fmt.Errorf("%s: values[%v]: SopsSecret references itself", secretTpl.Name, value.Name)}
+			}
+			referenced, ok := decrypted[reference]
+			if !ok {
+				referencedEncrypted := &isindirv1alpha2.SopsSecret{}
+				if err := r.Get(ctx, reference, referencedEncrypted); err != nil {
+					if errors.IsNotFound(err) {
+						// creation of referenced SopsSecret triggers reconciliation
+						return &permanentError{fmt.Errorf("%s: values[%v]: SopsSecret %s not found", secretTpl.Name, value.Name, ref.Name)}
+					}
+					return err
+				}
+				referenced = r.decryptions.get(referencedEncrypted)
+				if referenced == nil {
+					var err error
+					log.V(1).Info("Decrypting referenced SopsSecret", "reference", ref.Name)
+					referenced, err = r.decryptor(ctx, referencedEncrypted).Decrypt(referencedEncrypted, log)
+					if err != nil {
+						return fmt.Errorf("%s: values[%v]: SopsSecret %s: %w", secretTpl.Name, value.Name, ref.Name, err)
+					}
+					r.decryptions.add(referencedEncrypted, referenced)
+				}
+				decrypted[reference] = referenced
+			}
+
+			resolved, err := referencedValue(referenced, ref)
+			if err != nil {
+				return &permanentError{fmt.Errorf("%s: values[%v]: %v", secretTpl.Name, value.Name, err)}
+			}
+			if secretTpl.Data == nil {
+				secretTpl.Data = make(map[string]string)
+			}
+			secretTpl.Data[value.Name] = resolved
+		}
+	}
+	return nil
+}
+
+// referencedValue returns value of data key selected from secret templates of
+// decrypted SopsSecret
+func referencedValue(referenced *isindirv1alpha2.SopsSecret, ref *isindirv1alpha2.SopsSecretKeySelector) (string, error) {
+	for i := range referenced.Spec.SecretsTemplate {
+		secretTpl := &referenced.Spec.SecretsTemplate[i]
+		if ref.Template != "" && secretTpl.Name != ref.Template {
+			continue
+		}
+		if value, ok := secretTpl.Data[ref.Key]; ok {
+			return value, nil
+		}
+		if value, ok := secretTpl.BinaryData[ref.Key]; ok {
+			decoded, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return "", fmt.Errorf("SopsSecret %s: binaryData[%v] is not a valid base64 string", ref.Name, ref.Key)
+			}
+			return string(decoded), nil
+		}
+	}
+	if ref.Template != "" {
+		return "", fmt.Errorf("SopsSecret %s: key %v is not defined in template %s", ref.Name, ref.Key, ref.Template)
+	}
+	return "", fmt.Errorf("SopsSecret %s: key %v is not defined", ref.Name, ref.Key)
+}
@@ -0,0 +1,297 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// remoteKubeconfigKey is the key of remote cluster secret holding kubeconfig
+const remoteKubeconfigKey = "kubeconfig"
+
+// remoteClients caches clients of remote clusters, client is created again
+// when kubeconfig secret resource version changes
+type remoteClients struct {
+	lock    sync.Mutex
+	entries map[string]remoteClientEntry
+}
+
+type remoteClientEntry struct {
+	resourceVersion string
+	client          client.Client
+}
+
+// remoteClient returns client of remote cluster configured by kubeconfig
+// secret of the operator
+func (r *SopsSecretReconciler) remoteClient(ctx context.Context, cluster string) (client.Client, error) {
+	name, ok := r.RemoteClusters[cluster]
+	if !ok {
+		return nil, &permanentError{fmt.Errorf("cluster %s is not configured", cluster)}
+	}
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, name, secret); err != nil {
+		return nil, fmt.Errorf("reading kubeconfig secret of cluster %s: %v", cluster, err)
+	}
+
+	r.remoteClients.lock.Lock()
+	defer r.remoteClients.lock.Unlock()
+	if entry, ok := r.remoteClients.entries[cluster]; ok && entry.resourceVersion == secret.ResourceVersion {
+		return entry.client, nil
+	}
+
+	config, err := clientcmd.RESTConfigFromKubeConfig(secret.Data[remoteKubeconfigKey])
+	if err != nil {
+		return nil, fmt.Errorf("invalid kubeconfig of cluster %s in secret %s: %v", cluster, name, err)
+	}
+	remote, err := client.New(config, client.Options{Scheme: r.Scheme})
+	if err != nil {
+		return nil, fmt.Errorf("creating client of cluster %s: %v", cluster, err)
+	}
+	if r.remoteClients.entries == nil {
+		r.remoteClients.entries = make(map[string]remoteClientEntry)
+	}
+	r.remoteClients.entries[cluster] = remoteClientEntry{resourceVersion: secret.ResourceVersion, client: remote}
+	return remote, nil
+}
+
+// remotePushAllowed reports whether SopsSecrets in the namespace may push
+// secrets to remote cluster
+func (r *SopsSecretReconciler) remotePushAllowed(cluster string, namespace string) bool {
+	for _, allowed := range r.RemoteSourceNamespaces[cluster] {
+		if allowed == "*" || allowed == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRemotePush returns permanent error when SopsSecrets in the namespace
+// may not push secrets to remote cluster
+func (r *SopsSecretReconciler) checkRemotePush(cluster string, namespace string) error {
+	if r.remotePushAllowed(cluster, namespace) {
+		return nil
+	}
+	return &permanentError{fmt.Errorf("pushing secrets from namespace %s to cluster %s is not allowed", namespace, cluster)}
+}
+
+// hasRemoteTemplates reports whether any secret template of SopsSecret is
+// pushed to remote cluster or secrets pushed before are still recorded
+func hasRemoteTemplates(instance *isindirv1alpha2.SopsSecret) bool {
+	if len(instance.Status.Clusters) > 0 {
+		return true
+	}
+	for i := range instance.Spec.SecretsTemplate {
+		if instance.Spec.SecretsTemplate[i].Cluster != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// pushSecret creates or refreshes the secret defined by secret template in
+// remote cluster, secret is labeled as replica of SopsSecret, as owner
+// references can not point to other clusters. On failure it returns status
+// message, failure class and error
+func (r *SopsSecretReconciler) pushSecret(
+	ctx context.Context,
+	instanceEncrypted *isindirv1alpha2.SopsSecret,
+	instance *isindirv1alpha2.SopsSecret,
+	secretTemplate *isindirv1alpha2.SopsSecretTemplate,
+	decryptor Decryptor,
+	declaredRemote map[string]map[string]bool,
+) (string, failureClass, error) {
+	log := r.logger(ctx)
+	cluster := secretTemplate.Cluster
+	status := clusterStatus(&instanceEncrypted.Status, cluster)
+	status.Secrets = append(status.Secrets, secretTemplate.Name)
+	if declaredRemote[cluster] == nil {
+		declaredRemote[cluster] = make(map[string]bool)
+	}
+	declaredRemote[cluster][secretTemplate.Name] = true
+
+	fail := func(message string, err error) (string, failureClass, error) {
+		status.Ready = false
+		status.Message = fmt.Sprintf("%s: %v", secretTemplate.Name, err)
+		log.Info(
+			message,
+			"cluster",
+			cluster,
+			"error",
+			err,
+		)
+		return message, classifyFailure(err), err
+	}
+
+	if replicated(secretTemplate) || secretTemplate.Immutable {
+		return fail("Remote secret template error", &permanentError{fmt.Errorf("secret template pushed to cluster %s can not be replicated or immutable", cluster)})
+	}
+	if err := r.checkRemotePush(cluster, instance.Namespace); err != nil {
+		r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeWarning, "RemotePushDenied", "Secret %s can not be pushed: %v", secretTemplate.Name, err)
+		return fail("Remote secret push denied", err)
+	}
+	remote, err := r.remoteClient(ctx, cluster)
+	if err != nil {
+		return fail("Remote cluster error", err)
+	}
+
+	secret, err := r.newSecret(ctx, instance, secretTemplate, decryptor)
+	if err != nil {
+		r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeWarning, "TemplateRenderError", "Failed to render secret template %s: %v", secretTemplate.Name, err)
+		return fail("New child secret creation error", &permanentError{err})
+	}
+	if secret.Labels == nil {
+		secret.Labels = map[string]string{}
+	}
+	secret.Labels[ReplicaOfUIDLabel] = string(instance.UID)
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	secret.Annotations[ReplicaOfAnnotation] = instance.Namespace + "/" + instance.Name
+
+	found := &corev1.Secret{}
+	err = remote.Get(ctx, types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name}, found)
+	if err != nil && !errors.IsNotFound(err) {
+		return fail("Remote secret read error", err)
+	}
+	exists := err == nil
+	if exists {
+		preserveHtpasswd(secretTemplate, secret, found)
+	}
+	if exists && found.Labels[ReplicaOfUIDLabel] != string(instance.UID) {
+		r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeWarning, "SecretConflict", "Secret %s exists in cluster %s and is not pushed from this SopsSecret", found.Name, cluster)
+		return fail("Remote secret conflict error", fmt.Errorf("secret %s already exists in cluster %s and is not pushed from this sopssecret", found.Name, cluster))
+	}
+	if exists && !forceSyncRequested(instanceEncrypted) && upToDate(found, secret) {
+		return "", "", nil
+	}
+
+	if err := r.applySecretTo(ctx, remote, secret); err != nil {
+		r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeWarning, "SecretApplyFailed", "Failed to apply secret %s to cluster %s: %v", secret.Name, cluster, err)
+		return fail("Remote secret apply error", err)
+	}
+	now := metav1.Now()
+	status.LastSyncTime = &now
+	if !exists {
+		r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeNormal, "SecretPushed", "Secret %s pushed to cluster %s", secret.Name, cluster)
+		r.secretChanged(ctx, instance, SecretCreatedAction, secret)
+	} else if secret.ResourceVersion != found.ResourceVersion {
+		r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeNormal, "SecretUpdated", "Secret %s updated in cluster %s", secret.Name, cluster)
+		r.secretChanged(ctx, instance, SecretUpdatedAction, secret)
+	}
+	return "", "", nil
+}
+
+// clusterStatus returns status of remote cluster, status is added when
+// missing
+func clusterStatus(status *isindirv1alpha2.SopsSecretStatus, cluster string) *isindirv1alpha2.ClusterStatus {
+	for i := range status.Clusters {
+		if status.Clusters[i].Name == cluster {
+			return &status.Clusters[i]
+		}
+	}
+	status.Clusters = append(status.Clusters, isindirv1alpha2.ClusterStatus{Name: cluster, Ready: true})
+	sort.Slice(status.Clusters, func(i, j int) bool {
+		return status.Clusters[i].Name < status.Clusters[j].Name
+	})
+	return clusterStatus(status, cluster)
+}
+
+// resetClusterStatus prepares status of remote clusters for reconciliation,
+// secrets are recorded again by pushSecret and last sync time is kept
+func resetClusterStatus(status *isindirv1alpha2.SopsSecretStatus) []string {
+	var clusters []string
+	for i := range status.Clusters {
+		clusters = append(clusters, status.Clusters[i].Name)
+		status.Clusters[i].Ready = true
+		status.Clusters[i].Message = ""
+		status.Clusters[i].Secrets = nil
+	}
+	return clusters
+}
+
+// pruneRemoteSecrets deletes secrets pushed to remote clusters which are no
+// longer declared, nil declaredRemote deletes all pushed secrets. Clusters
+// the namespace may not push to are skipped, secrets pushed before are left
+// to the cluster administrator. Clusters without declared secrets are
+// removed from status
+func (r *SopsSecretReconciler) pruneRemoteSecrets(
+	ctx context.Context,
+	instanceEncrypted *isindirv1alpha2.SopsSecret,
+	clusters []string,
+	declaredRemote map[string]map[string]bool,
+) error {
+	log := r.logger(ctx)
+	for cluster := range declaredRemote {
+		clusters = append(clusters, cluster)
+	}
+	sort.Strings(clusters)
+
+	for i, cluster := range clusters {
+		if i > 0 && clusters[i-1] == cluster {
+			continue
+		}
+		if !r.remotePushAllowed(cluster, instanceEncrypted.Namespace) {
+			continue
+		}
+		remote, err := r.remoteClient(ctx, cluster)
+		if err != nil {
+			if classifyFailure(err) == permanentFailure {
+				// cluster was removed from operator configuration
+				continue
+			}
+			return err
+		}
+
+		pushed := &corev1.SecretList{}
+		if err := remote.List(
+			ctx,
+			pushed,
+			client.InNamespace(instanceEncrypted.Namespace),
+			client.MatchingLabels{ReplicaOfUIDLabel: string(instanceEncrypted.UID)},
+		); err != nil {
+			return fmt.Errorf("listing secrets of cluster %s: %v", cluster, err)
+		}
+		for j := range pushed.Items {
+			secret := &pushed.Items[j]
+			if declaredRemote[cluster][secret.Name] {
+				continue
+			}
+
+			log.Info(
+				"Deleting pushed Secret",
+				"secret",
+				secret.Name,
+				"cluster",
+				cluster,
+			)
+			if err := remote.Delete(ctx, secret); err != nil && !errors.IsNotFound(err) {
+				return fmt.Errorf("deleting secret %s of cluster %s: %v", secret.Name, cluster, err)
+			}
+			r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeNormal, "SecretDeleted", "Secret %s deleted from cluster %s", secret.Name, cluster)
+			r.secretChanged(ctx, instanceEncrypted, SecretDeletedAction, secret)
+		}
+	}
+
+	statuses := instanceEncrypted.Status.Clusters[:0]
+	for _, status := range instanceEncrypted.Status.Clusters {
+		if len(declaredRemote[status.Name]) > 0 {
+			statuses = append(statuses, status)
+		}
+	}
+	instanceEncrypted.Status.Clusters = statuses
+	return nil
+}
@@ -0,0 +1,109 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// newRemoteReconciler returns reconciler with eu-west remote cluster, its
+// kubeconfig secret does not exist, so reaching the cluster fails
+func newRemoteReconciler(sourceNamespaces ...string) *SopsSecretReconciler {
+	r := newFakeReconciler()
+	r.RemoteClusters = map[string]types.NamespacedName{
+		"eu-west": {Namespace: "sops-system", Name: "eu-west-kubeconfig"},
+	}
+	r.RemoteSourceNamespaces = map[string][]string{"eu-west": sourceNamespaces}
+	return r
+}
+
+func TestRemotePushAllowed(t *testing.T) {
+	tests := []struct {
+		name             string
+		sourceNamespaces []string
+		cluster          string
+		allowed          bool
+	}{
+		{name: "listed namespace", sourceNamespaces: []string{"billing", "payments"}, cluster: "eu-west", allowed: true},
+		{name: "all namespaces", sourceNamespaces: []string{"*"}, cluster: "eu-west", allowed: true},
+		{name: "other namespace", sourceNamespaces: []string{"billing"}, cluster: "eu-west"},
+		{name: "no namespaces", cluster: "eu-west"},
+		{name: "other cluster", sourceNamespaces: []string{"*"}, cluster: "us-east"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRemoteReconciler(tt.sourceNamespaces...)
+			if allowed := r.remotePushAllowed(tt.cluster, "payments"); allowed != tt.allowed {
+				t.Errorf("remotePushAllowed = %t, want %t", allowed, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestPushSecretDenied(t *testing.T) {
+	r := newRemoteReconciler("billing")
+	instance := &isindirv1alpha2.SopsSecret{}
+	instance.Name = "payments-db"
+	instance.Namespace = "payments"
+	instance.Spec.SecretsTemplate = []isindirv1alpha2.SopsSecretTemplate{
+		{Name: "payments-db", Cluster: "eu-west", Data: map[string]string{"password": "secret"}},
+	}
+
+	declaredRemote := map[string]map[string]bool{}
+	_, class, err := r.pushSecret(context.Background(), instance, instance, &instance.Spec.SecretsTemplate[0], nil, declaredRemote)
+	if err == nil || err.Error() != "pushing secrets from namespace payments to cluster eu-west is not allowed" {
+		t.Fatalf("expected push denied error, got %v", err)
+	}
+	if class != permanentFailure {
+		t.Errorf("denied push should be permanent failure, got %v", class)
+	}
+	status := clusterStatus(&instance.Status, "eu-west")
+	if status.Ready || !strings.Contains(status.Message, "is not allowed") {
+		t.Errorf("cluster status should report denied push, got %+v", status)
+	}
+}
+
+func TestPruneRemoteSecretsSourceNamespaces(t *testing.T) {
+	tests := []struct {
+		name             string
+		sourceNamespaces []string
+		err              string
+	}{
+		// cluster is reached to list pushed secrets
+		{name: "allowed namespace", sourceNamespaces: []string{"payments"}, err: "reading kubeconfig secret of cluster eu-west"},
+		// cluster is skipped, the namespace may not write to it
+		{name: "denied namespace", sourceNamespaces: []string{"billing"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRemoteReconciler(tt.sourceNamespaces...)
+			instance := &isindirv1alpha2.SopsSecret{}
+			instance.Name = "payments-db"
+			instance.Namespace = "payments"
+			instance.UID = "payments-uid"
+			instance.Status.Clusters = []isindirv1alpha2.ClusterStatus{{Name: "eu-west", Ready: true}}
+
+			err := r.pruneRemoteSecrets(context.Background(), instance, resetClusterStatus(&instance.Status), nil)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(instance.Status.Clusters) != 0 {
+				t.Errorf("cluster without declared secrets should be removed from status, got %v", instance.Status.Clusters)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"filippo.io/age"
+	"github.com/go-logr/logr"
+	"go.mozilla.org/sops/v3/keyservice"
+	"golang.org/x/crypto/openpgp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+	isindirv1alpha3 "github.com/isindir/sops-secrets-operator/api/v1alpha3"
+)
+
+// SplitDocuments splits multi-document YAML, empty documents are skipped
+func SplitDocuments(data []byte) ([][]byte, error) {
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(data)))
+	var documents [][]byte
+	for {
+		document, err := reader.Read()
+		if err == io.EOF {
+			return documents, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(document)) > 0 {
+			documents = append(documents, document)
+		}
+	}
+}
+
+// DecodeSopsSecret decodes SopsSecret manifest of any served version into
+// v1alpha2, fields unknown to the CRD are rejected as API server would prune
+// them and break sops MAC verification
+func DecodeSopsSecret(document []byte) (*isindirv1alpha2.SopsSecret, error) {
+	typeMeta := metav1.TypeMeta{}
+	if err := yaml.Unmarshal(document, &typeMeta); err != nil {
+		return nil, err
+	}
+	if typeMeta.Kind != "SopsSecret" {
+		return nil, fmt.Errorf("kind %q is not SopsSecret", typeMeta.Kind)
+	}
+
+	instance := &isindirv1alpha2.SopsSecret{}
+	switch typeMeta.APIVersion {
+	case isindirv1alpha2.GroupVersion.String():
+		if err := yaml.UnmarshalStrict(document, instance); err != nil {
+			return nil, err
+		}
+	case isindirv1alpha3.GroupVersion.String():
+		instanceV1alpha3 := &isindirv1alpha3.SopsSecret{}
+		if err := yaml.UnmarshalStrict(document, instanceV1alpha3); err != nil {
+			return nil, err
+		}
+		if err := instanceV1alpha3.ConvertTo(instance); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("apiVersion %q is not supported", typeMeta.APIVersion)
+	}
+	return instance, nil
+}
+
+// OfflineKeyServices returns sops key services decrypting data keys with age
+// identities and PGP private keys from given files, other keys are decrypted
+// with local credentials as sops does
+func OfflineKeyServices(keyFiles []string) ([]keyservice.KeyServiceClient, error) {
+	var identities []age.Identity
+	var pgpKeys openpgp.EntityList
+	for _, file := range keyFiles {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Contains(data, []byte("AGE-SECRET-KEY-")) {
+			fileIdentities, err := age.ParseIdentities(bytes.NewReader(data))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", file, err)
+			}
+			identities = append(identities, fileIdentities...)
+			continue
+		}
+		fileKeys, err := parsePgpKeyring(data, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		pgpKeys = append(pgpKeys, fileKeys...)
+	}
+
+	var svc keyservice.KeyServiceClient = keyservice.NewLocalClient()
+	if len(pgpKeys) > 0 {
+		svc = newPgpKeyService(pgpKeys, svc)
+	}
+	if len(identities) > 0 {
+		svc = newAgeKeyService(identities, svc)
+	}
+	return []keyservice.KeyServiceClient{svc}, nil
+}
+
+// DecryptSopsSecret decrypts SopsSecret outside of the reconciler, for
+// command line tools
+func DecryptSopsSecret(
+	instanceEncrypted *isindirv1alpha2.SopsSecret,
+	decryptor Decryptor,
+	log logr.Logger,
+) (*isindirv1alpha2.SopsSecret, error) {
+	return decryptor.Decrypt(instanceEncrypted, log)
+}
+
+// RenderSecrets returns Kubernetes secrets generated from secret templates of
+// decrypted SopsSecret, the same way reconciler generates them. Secret names
+// are used as they are, callers resolve them with ResolveSecretNames after
+// ExpandRawSecrets. Secrets are not replicated and have no owner reference.
+func RenderSecrets(
+	instance *isindirv1alpha2.SopsSecret,
+	decryptor Decryptor,
+	log logr.Logger,
+) ([]*corev1.Secret, error) {
+	if err := ExpandRawSecrets(instance, decryptor); err != nil {
+		return nil, err
+	}
+	secrets := make([]*corev1.Secret, 0, len(instance.Spec.SecretsTemplate))
+	for i := range instance.Spec.SecretsTemplate {
+		secret, err := newSecretForCR(instance, &instance.Spec.SecretsTemplate[i], decryptor, log)
+		if err != nil {
+			return nil, err
+		}
+		secret.TypeMeta.APIVersion = "v1"
+		secret.TypeMeta.Kind = "Secret"
+		secrets = append(secrets, secret)
+	}
+	return secrets, nil
+}
@@ -0,0 +1,262 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+const (
+	// ReplicaOfUIDLabel holds UID of SopsSecret replicated secret is generated
+	// from, owner references can not point to other namespaces
+	ReplicaOfUIDLabel = "sops-secrets-operator/replica-of-uid"
+
+	// ReplicaOfAnnotation holds namespace/name of SopsSecret replicated secret
+	// is generated from
+	ReplicaOfAnnotation = "sops-secrets-operator/replica-of"
+)
+
+// replicated reports whether secret template is replicated to other namespaces
+func replicated(secretTemplate *isindirv1alpha2.SopsSecretTemplate) bool {
+	return len(secretTemplate.TargetNamespaces) > 0 || secretTemplate.TargetNamespaceSelector != nil
+}
+
+// hasReplicatedTemplates reports whether any secret template of SopsSecret is
+// replicated to other namespaces
+func hasReplicatedTemplates(instance *isindirv1alpha2.SopsSecret) bool {
+	for i := range instance.Spec.SecretsTemplate {
+		if replicated(&instance.Spec.SecretsTemplate[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// replicationAllowed reports whether SopsSecrets in the namespace may
+// replicate secrets to other namespaces
+func (r *SopsSecretReconciler) replicationAllowed(namespace string) bool {
+	for _, allowed := range r.ReplicationSourceNamespaces {
+		if allowed == "*" || allowed == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// targetNamespaces returns sorted namespaces secret template is replicated
+// to, SopsSecret namespace is excluded
+func (r *SopsSecretReconciler) targetNamespaces(
+	ctx context.Context,
+	instance *isindirv1alpha2.SopsSecret,
+	secretTemplate *isindirv1alpha2.SopsSecretTemplate,
+) ([]string, error) {
+	targets := make(map[string]bool)
+	for _, namespace := range secretTemplate.TargetNamespaces {
+		targets[namespace] = true
+	}
+	if secretTemplate.TargetNamespaceSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(secretTemplate.TargetNamespaceSelector)
+		if err != nil {
+			return nil, &permanentError{err}
+		}
+		namespaces := &corev1.NamespaceList{}
+		if err := r.List(ctx, namespaces, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+			return nil, err
+		}
+		for _, namespace := range namespaces.Items {
+			if namespace.DeletionTimestamp.IsZero() {
+				targets[namespace.Name] = true
+			}
+		}
+	}
+	delete(targets, instance.Namespace)
+
+	result := make([]string, 0, len(targets))
+	for namespace := range targets {
+		result = append(result, namespace)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// replicateSecret creates or refreshes copies of the secret defined by secret
+// template in target namespaces, replicas are recorded in declaredReplicas
+// as namespace/name, on failure it returns status message, failure class and error
+func (r *SopsSecretReconciler) replicateSecret(
+	ctx context.Context,
+	instanceEncrypted *isindirv1alpha2.SopsSecret,
+	instance *isindirv1alpha2.SopsSecret,
+	secretTemplate *isindirv1alpha2.SopsSecretTemplate,
+	decryptor Decryptor,
+	declaredReplicas map[string]bool,
+) (string, failureClass, error) {
+	log := r.logger(ctx)
+	sopsSecretName := types.NamespacedName{Namespace: instance.Namespace, Name: instance.Name}
+
+	if !r.replicationAllowed(instance.Namespace) {
+		err := &permanentError{fmt.Errorf("replication from namespace %s is not allowed", instance.Namespace)}
+		r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeWarning, "ReplicationDenied", "Secret %s can not be replicated: %v", secretTemplate.Name, err)
+		return "Secret replication denied", permanentFailure, err
+	}
+
+	namespaces, err := r.targetNamespaces(ctx, instance, secretTemplate)
+	if err != nil {
+		log.Info(
+			"Resolving target namespaces error",
+			"error",
+			err,
+		)
+		return "Resolving target namespaces error", classifyFailure(err), err
+	}
+
+	secret, err := r.newSecret(ctx, instance, secretTemplate, decryptor)
+	if err != nil {
+		return "New child secret creation error", permanentFailure, err
+	}
+	// replicas are written as spec.serviceAccountName, which needs access to
+	// secrets of target namespaces
+	writer, err := r.secretWriter(instance)
+	if err != nil {
+		r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeWarning, "InvalidServiceAccount", "Failed to impersonate service account: %v", err)
+		return "Service account impersonation error", classifyFailure(err), err
+	}
+	secret.Labels[ReplicaOfUIDLabel] = string(instance.UID)
+	secret.Annotations[ReplicaOfAnnotation] = sopsSecretName.String()
+
+	for _, namespace := range namespaces {
+		replica := secret.DeepCopy()
+		replica.Namespace = namespace
+		declaredReplicas[namespace+"/"+replica.Name] = true
+
+		found := &corev1.Secret{}
+		err := r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: replica.Name}, found)
+		if err != nil && !errors.IsNotFound(err) {
+			return "Unknown Error", transientFailure, err
+		}
+		exists := err == nil
+		if exists {
+			preserveHtpasswd(secretTemplate, replica, found)
+		}
+
+		if exists && found.Labels[ReplicaOfUIDLabel] != string(instance.UID) {
+			r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeWarning, "SecretConflict", "Secret %s/%s exists and is not replicated from this SopsSecret", namespace, found.Name)
+			return "Replicated secret conflict error", transientFailure, fmt.Errorf("secret %s/%s already exists and is not a replica of this sopssecret", namespace, found.Name)
+		}
+
+		if exists && !forceSyncRequested(instanceEncrypted) && upToDate(found, replica) {
+			continue
+		}
+		if err := r.applySecretTo(ctx, writer, replica); err != nil {
+			r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeWarning, "SecretApplyFailed", "Failed to apply secret %s/%s: %v", namespace, replica.Name, err)
+			if errors.IsForbidden(err) && instance.Spec.ServiceAccountName != "" {
+				// permissions of impersonated service account must be granted
+				return "Replicated secret apply error", credentialFailure, err
+			}
+			return "Replicated secret apply error", transientFailure, err
+		}
+		switch {
+		case !exists:
+			r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeNormal, "SecretReplicated", "Secret %s replicated to namespace %s", replica.Name, namespace)
+			r.secretChanged(ctx, instance, SecretCreatedAction, replica)
+		case replica.ResourceVersion != found.ResourceVersion:
+			r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeNormal, "SecretUpdated", "Secret %s/%s updated", namespace, replica.Name)
+			r.secretChanged(ctx, instance, SecretUpdatedAction, replica)
+		}
+	}
+	return "", "", nil
+}
+
+// replicas returns secrets replicated from SopsSecret to other namespaces
+func (r *SopsSecretReconciler) replicas(ctx context.Context, instance *isindirv1alpha2.SopsSecret) ([]corev1.Secret, error) {
+	replicas := &corev1.SecretList{}
+	if err := r.List(ctx, replicas, client.MatchingLabels{ReplicaOfUIDLabel: string(instance.UID)}); err != nil {
+		return nil, err
+	}
+	return replicas.Items, nil
+}
+
+// pruneReplicas deletes replicated secrets which are no longer declared, nil
+// declaredReplicas deletes all replicas
+func (r *SopsSecretReconciler) pruneReplicas(
+	ctx context.Context,
+	instance *isindirv1alpha2.SopsSecret,
+	declaredReplicas map[string]bool,
+) error {
+	log := r.logger(ctx)
+	replicas, err := r.replicas(ctx, instance)
+	if err != nil {
+		return err
+	}
+	writer, err := r.secretWriter(instance)
+	if err != nil {
+		return err
+	}
+
+	for i := range replicas {
+		replica := &replicas[i]
+		if declaredReplicas[replica.Namespace+"/"+replica.Name] {
+			continue
+		}
+
+		log.Info(
+			"Deleting replicated Secret",
+			"secret",
+			replica.Name,
+			"namespace",
+			replica.Namespace,
+		)
+		if err := writer.Delete(ctx, replica); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		r.Recorder.Eventf(instance, corev1.EventTypeNormal, "SecretDeleted", "Replicated secret %s/%s deleted", replica.Namespace, replica.Name)
+		r.secretChanged(ctx, instance, SecretDeletedAction, replica)
+	}
+	return nil
+}
+
+// replicaSopsSecret maps replicated secret to SopsSecret it is generated from
+func replicaSopsSecret(obj client.Object) []reconcile.Request {
+	parts := strings.SplitN(obj.GetAnnotations()[ReplicaOfAnnotation], "/", 2)
+	if len(parts) != 2 || obj.GetLabels()[ReplicaOfUIDLabel] == "" {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: parts[0], Name: parts[1]}}}
+}
+
+// namespaceSelectingSopsSecrets maps namespace to SopsSecrets replicating
+// secrets by namespace selector, so namespaces can be added and removed
+func (r *SopsSecretReconciler) namespaceSelectingSopsSecrets(obj client.Object) []reconcile.Request {
+	sopsSecrets := &isindirv1alpha2.SopsSecretList{}
+	if err := r.List(context.Background(), sopsSecrets); err != nil {
+		r.Log.Info("Listing SopsSecrets error", "namespace", obj.GetName(), "error", err)
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for i := range sopsSecrets.Items {
+		sopsSecret := &sopsSecrets.Items[i]
+		for j := range sopsSecret.Spec.SecretsTemplate {
+			if sopsSecret.Spec.SecretsTemplate[j].TargetNamespaceSelector != nil {
+				requests = append(requests, reconcile.Request{
+					NamespacedName: types.NamespacedName{Namespace: sopsSecret.Namespace, Name: sopsSecret.Name},
+				})
+				break
+			}
+		}
+	}
+	return requests
+}
@@ -0,0 +1,170 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// replicationNamespaces are namespaces of replication tests, source holds
+// the SopsSecret and matches the selector of its secret template
+func replicationNamespaces() []client.Object {
+	namespace := func(name, env string) client.Object {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"env": env}}}
+	}
+	return []client.Object{
+		namespace("source", "prod"),
+		namespace("team-a", "prod"),
+		namespace("team-b", "prod"),
+		namespace("team-c", "dev"),
+	}
+}
+
+// replicatedSopsSecret returns SopsSecret in source namespace replicating
+// secret template
+func replicatedSopsSecret(secretTemplate isindirv1alpha2.SopsSecretTemplate) *isindirv1alpha2.SopsSecret {
+	instance := &isindirv1alpha2.SopsSecret{}
+	instance.Name = "shared"
+	instance.Namespace = "source"
+	instance.UID = "source-uid"
+	instance.Spec.SecretsTemplate = []isindirv1alpha2.SopsSecretTemplate{secretTemplate}
+	return instance
+}
+
+func TestTargetNamespaces(t *testing.T) {
+	tests := []struct {
+		name     string
+		template isindirv1alpha2.SopsSecretTemplate
+		want     []string
+	}{
+		{
+			name: "namespace selector",
+			template: isindirv1alpha2.SopsSecretTemplate{
+				TargetNamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}},
+			},
+			want: []string{"team-a", "team-b"},
+		},
+		{
+			name: "namespace list and selector",
+			template: isindirv1alpha2.SopsSecretTemplate{
+				TargetNamespaces:        []string{"team-c"},
+				TargetNamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}},
+			},
+			want: []string{"team-a", "team-b", "team-c"},
+		},
+		{
+			name: "own namespace listed",
+			template: isindirv1alpha2.SopsSecretTemplate{
+				TargetNamespaces: []string{"source", "team-a"},
+			},
+			want: []string{"team-a"},
+		},
+		{
+			name: "selector matching no namespace",
+			template: isindirv1alpha2.SopsSecretTemplate{
+				TargetNamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "staging"}},
+			},
+			want: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newFakeReconciler(replicationNamespaces()...)
+			instance := replicatedSopsSecret(tt.template)
+
+			got, err := r.targetNamespaces(context.Background(), instance, &instance.Spec.SecretsTemplate[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("target namespaces = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplicateSecretConflict(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels map[string]string
+	}{
+		{name: "secret not managed by operator"},
+		{name: "replica of other SopsSecret", labels: map[string]string{ReplicaOfUIDLabel: "other-uid"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			existing := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "shared", Namespace: "team-a", Labels: tt.labels},
+				Data:       map[string][]byte{"password": []byte("unrelated")},
+			}
+			r := newFakeReconciler(append(replicationNamespaces(), existing)...)
+			r.ReplicationSourceNamespaces = []string{"*"}
+			instance := replicatedSopsSecret(isindirv1alpha2.SopsSecretTemplate{
+				Name:             "shared",
+				TargetNamespaces: []string{"team-a"},
+				Data:             map[string]string{"password": "secret"},
+			})
+
+			_, class, err := r.replicateSecret(context.Background(), instance, instance, &instance.Spec.SecretsTemplate[0], nil, map[string]bool{})
+			if err == nil || !strings.Contains(err.Error(), "is not a replica of this sopssecret") {
+				t.Fatalf("expected conflict error, got %v", err)
+			}
+			if class != transientFailure {
+				t.Errorf("conflict should be transient failure, got %v", class)
+			}
+			found := &corev1.Secret{}
+			if err := r.Get(context.Background(), types.NamespacedName{Namespace: "team-a", Name: "shared"}, found); err != nil {
+				t.Fatal(err)
+			}
+			if string(found.Data["password"]) != "unrelated" {
+				t.Error("conflicting secret should not be overwritten")
+			}
+		})
+	}
+}
+
+func TestReplicateSecretDenied(t *testing.T) {
+	tests := []struct {
+		name             string
+		sourceNamespaces []string
+	}{
+		{name: "replication disabled"},
+		{name: "other source namespace", sourceNamespaces: []string{"platform"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newFakeReconciler(replicationNamespaces()...)
+			r.ReplicationSourceNamespaces = tt.sourceNamespaces
+			instance := replicatedSopsSecret(isindirv1alpha2.SopsSecretTemplate{
+				Name:             "shared",
+				TargetNamespaces: []string{"team-a"},
+				Data:             map[string]string{"password": "secret"},
+			})
+
+			_, class, err := r.replicateSecret(context.Background(), instance, instance, &instance.Spec.SecretsTemplate[0], nil, map[string]bool{})
+			if err == nil || err.Error() != "replication from namespace source is not allowed" {
+				t.Fatalf("expected replication denied error, got %v", err)
+			}
+			if class != permanentFailure {
+				t.Errorf("denied replication should be permanent failure, got %v", class)
+			}
+			err = r.Get(context.Background(), types.NamespacedName{Namespace: "team-a", Name: "shared"}, &corev1.Secret{})
+			if !errors.IsNotFound(err) {
+				t.Errorf("secret should not be replicated, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+const (
+	// RestartTargetsAnnotation lists comma separated workloads in SopsSecret
+	// namespace to restart when generated secrets change, for example
+	// deployment/api,statefulset/db
+	RestartTargetsAnnotation = "sops-secrets-operator/restart-targets"
+
+	// SecretsChecksumAnnotation is set on pod template of restart targets, its
+	// change triggers rolling restart
+	SecretsChecksumAnnotation = "sops-secrets-operator/secrets-checksum"
+)
+
+// managedSecretsChecksum returns checksum of content hashes of managed secrets
+func managedSecretsChecksum(status *isindirv1alpha2.SopsSecretStatus) string {
+	hash := sha256.New()
+	for _, managed := range status.ManagedSecrets {
+		fmt.Fprintf(hash, "%s=%s\n", managed.Name, managed.LastSyncedHash)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// restartTargets sets checksum of generated secrets on pod templates of
+// workloads listed in restart targets annotation, workloads are restarted only
+// when the checksum changes
+func (r *SopsSecretReconciler) restartTargets(ctx context.Context, instance *isindirv1alpha2.SopsSecret) error {
+	log := r.logger(ctx)
+	targets := instance.Annotations[RestartTargetsAnnotation]
+	if targets == "" {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]string{
+						SecretsChecksumAnnotation: managedSecretsChecksum(&instance.Status),
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, target := range strings.Split(targets, ",") {
+		target = strings.TrimSpace(target)
+		if target == "" {
+			continue
+		}
+
+		parts := strings.SplitN(target, "/", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return &permanentError{fmt.Errorf("invalid restart target %q, expected kind/name", target)}
+		}
+		var workload client.Object
+		switch strings.ToLower(parts[0]) {
+		case "deployment", "deployments":
+			workload = &appsv1.Deployment{}
+		case "statefulset", "statefulsets":
+			workload = &appsv1.StatefulSet{}
+		default:
+			return &permanentError{fmt.Errorf("invalid restart target %q, kind must be deployment or statefulset", target)}
+		}
+		workload.SetNamespace(instance.Namespace)
+		workload.SetName(parts[1])
+
+		if err := r.Patch(ctx, workload, client.RawPatch(types.MergePatchType, patch)); err != nil {
+			r.Recorder.Eventf(instance, corev1.EventTypeWarning, "RestartFailed", "Failed to restart %s: %v", target, err)
+			return err
+		}
+		log.V(1).Info("Restart target checksum set", "target", target, "namespace", instance.Namespace)
+	}
+	return nil
+}
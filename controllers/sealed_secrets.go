@@ -0,0 +1,179 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// sealingKeyLabel marks secrets holding sealing keys of sealed-secrets
+// controller
+const sealingKeyLabel = "sealedsecrets.bitnami.com/sealed-secrets-key"
+
+// resolveSealedSecretValues decrypts sealedSecret values of secret templates
+// with sealing keys of sealed-secrets controller, so SopsSecrets can reuse
+// values sealed for SealedSecrets
+func (r *SopsSecretReconciler) resolveSealedSecretValues(
+	ctx context.Context,
+	instance *isindirv1alpha2.SopsSecret,
+) error {
+	var keys []*rsa.PrivateKey
+	for i := range instance.Spec.SecretsTemplate {
+		secretTpl := &instance.Spec.SecretsTemplate[i]
+		for _, value := range secretTpl.Values {
+			sealed := value.ValueFrom.SealedSecret
+			if sealed == nil {
+				continue
+			}
+			if r.SealedSecretsKeyNamespace == "" {
+				return &permanentError{fmt.Errorf("%s: values[%v]: sealed secret values are not enabled in the operator", secretTpl.Name, value.Name)}
+			}
+			if secretTpl.Expand || secretTpl.Format != "" {
+				return &permanentError{fmt.Errorf("%s: values can not be used with expand or format", secretTpl.Name)}
+			}
+			if _, ok := secretTpl.Data[value.Name]; ok {
+				return &permanentError{fmt.Errorf("%s: values[%v]: key is already defined in data", secretTpl.Name, value.Name)}
+			}
+
+			if keys == nil {
+				var err error
+				if keys, err = r.sealingKeys(ctx); err != nil {
+					return err
+				}
+			}
+			secretName := sealed.SecretName
+			if secretName == "" {
+				secretName = secretTpl.Name
+			}
+			plaintext, err := unsealValue(keys, sealed, instance.Namespace, secretName)
+			if err != nil {
+				return &permanentError{fmt.Errorf("%s: values[%v]: %v", secretTpl.Name, value.Name, err)}
+			}
+			if secretTpl.Data == nil {
+				secretTpl.Data = make(map[string]string)
+			}
+			secretTpl.Data[value.Name] = string(plaintext)
+		}
+	}
+	return nil
+}
+
+// sealingKeys returns RSA private keys of sealed-secrets controller, secrets
+// which can not be parsed are skipped
+func (r *SopsSecretReconciler) sealingKeys(ctx context.Context) ([]*rsa.PrivateKey, error) {
+	log := r.logger(ctx)
+
+	secrets := &corev1.SecretList{}
+	if err := r.List(ctx, secrets, client.InNamespace(r.SealedSecretsKeyNamespace), client.HasLabels{sealingKeyLabel}); err != nil {
+		return nil, err
+	}
+	var keys []*rsa.PrivateKey
+	for i := range secrets.Items {
+		secret := &secrets.Items[i]
+		key, err := parseSealingKey(secret.Data[corev1.TLSPrivateKeyKey])
+		if err != nil {
+			log.Info("Parsing sealing key secret error", "secret", secret.Name, "error", err)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no sealing keys found in namespace %s", r.SealedSecretsKeyNamespace)
+	}
+	return keys, nil
+}
+
+// parseSealingKey parses PEM encoded RSA private key of sealing key secret
+func parseSealingKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not PEM encoded", corev1.TLSPrivateKeyKey)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not RSA private key", corev1.TLSPrivateKeyKey)
+	}
+	return rsaKey, nil
+}
+
+// unsealValue decrypts sealed value the same way as sealed-secrets
+// controller. Ciphertext is RSA-OAEP encrypted AES-GCM session key prefixed
+// by its length, followed by value encrypted with the session key. RSA-OAEP
+// label binds the value to the scope it was sealed for
+func unsealValue(keys []*rsa.PrivateKey, sealed *isindirv1alpha2.SealedSecretValue, namespace string, name string) ([]byte, error) {
+	ciphertext, err := base64.StdEncoding.DecodeString(sealed.EncryptedData)
+	if err != nil {
+		return nil, fmt.Errorf("encryptedData is not a valid base64 string")
+	}
+
+	var label []byte
+	switch sealed.Scope {
+	case "", "strict":
+		label = []byte(namespace + "/" + name)
+	case "namespace-wide":
+		label = []byte(namespace)
+	case "cluster-wide":
+	default:
+		return nil, fmt.Errorf("scope %q is not one of strict, namespace-wide, cluster-wide", sealed.Scope)
+	}
+
+	if len(ciphertext) < 2 {
+		return nil, fmt.Errorf("encryptedData is too short")
+	}
+	sessionKeyLen := int(binary.BigEndian.Uint16(ciphertext))
+	if len(ciphertext) < sessionKeyLen+2 {
+		return nil, fmt.Errorf("encryptedData is too short")
+	}
+	encryptedSessionKey := ciphertext[2 : sessionKeyLen+2]
+	encryptedValue := ciphertext[sessionKeyLen+2:]
+
+	for _, key := range keys {
+		sessionKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, key, encryptedSessionKey, label)
+		if err != nil {
+			continue
+		}
+		block, err := aes.NewCipher(sessionKey)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		// session key is used only once, so sealed-secrets uses zero nonce
+		return aead.Open(nil, make([]byte, aead.NonceSize()), encryptedValue, nil)
+	}
+	return nil, fmt.Errorf("no sealing key decrypts the value sealed for %s scope", scopeName(sealed.Scope))
+}
+
+// scopeName returns sealed-secrets scope name, strict by default
+func scopeName(scope string) string {
+	if scope == "" {
+		return "strict"
+	}
+	return scope
+}
@@ -0,0 +1,58 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// secretListPageSize is the number of secrets read from API server at once
+// when Secret cache is disabled
+const secretListPageSize = 500
+
+// listOwnedSecrets lists secrets controlled by SopsSecret. With Secret cache
+// disabled the owner field index does not exist, so secrets of SopsSecret
+// namespace are read from API server in pages and only controlled ones are
+// kept
+func (r *SopsSecretReconciler) listOwnedSecrets(ctx context.Context, instance *isindirv1alpha2.SopsSecret, ownedSecrets *corev1.SecretList) error {
+	if !r.DisableSecretCache {
+		return r.List(
+			ctx,
+			ownedSecrets,
+			client.InNamespace(instance.Namespace),
+			client.MatchingFields{secretOwnerKey: instance.Name},
+		)
+	}
+
+	ownedSecrets.Items = nil
+	continueToken := ""
+	for {
+		page := &corev1.SecretList{}
+		if err := r.List(
+			ctx,
+			page,
+			client.InNamespace(instance.Namespace),
+			client.Limit(secretListPageSize),
+			client.Continue(continueToken),
+		); err != nil {
+			return err
+		}
+		for i := range page.Items {
+			if metav1.IsControlledBy(&page.Items[i], instance) {
+				ownedSecrets.Items = append(ownedSecrets.Items, page.Items[i])
+			}
+		}
+		if page.Continue == "" {
+			return nil
+		}
+		continueToken = page.Continue
+	}
+}
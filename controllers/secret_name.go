@@ -0,0 +1,63 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// ResolveSecretNames sets names of secret templates of decrypted SopsSecret
+// to names rendered from their nameTemplate, and prefixes names which do not
+// start with prefix. Prefix is a template with the same variables as
+// nameTemplate. Names must be resolved exactly once, after raw secrets are
+// expanded, as nameTemplate is rendered again and replaces prefixed names.
+// Templates resolved to the same name in the same cluster are rejected, they
+// would overwrite each other's secret
+func ResolveSecretNames(instance *isindirv1alpha2.SopsSecret, prefix string) error {
+	ctx := &templateContext{
+		SopsSecretName: instance.Name,
+		Namespace:      instance.Namespace,
+	}
+	if prefix != "" {
+		var err error
+		if prefix, err = renderTemplate("secret name prefix", prefix, ctx); err != nil {
+			return &permanentError{err}
+		}
+	}
+
+	// templates by cluster and resolved name
+	resolved := make(map[string]string)
+	for i := range instance.Spec.SecretsTemplate {
+		secretTpl := &instance.Spec.SecretsTemplate[i]
+		name := secretTpl.Name
+		if secretTpl.NameTemplate != "" {
+			rendered, err := renderTemplate(secretTpl.Name+" nameTemplate", secretTpl.NameTemplate, ctx)
+			if err != nil {
+				return &permanentError{err}
+			}
+			name = strings.TrimSpace(rendered)
+		}
+		if !strings.HasPrefix(name, prefix) {
+			name = prefix + name
+		}
+		if other, ok := resolved[secretTpl.Cluster+"/"+name]; ok {
+			return &permanentError{fmt.Errorf("%s: secret name %q is also used by secret template %s", secretTpl.Name, name, other)}
+		}
+		resolved[secretTpl.Cluster+"/"+name] = secretTpl.Name
+		if name == secretTpl.Name {
+			continue
+		}
+		if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+			return &permanentError{fmt.Errorf("%s: secret name %q is invalid: %s", secretTpl.Name, name, strings.Join(errs, ", "))}
+		}
+		secretTpl.Name = name
+	}
+	return nil
+}
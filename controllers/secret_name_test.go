@@ -0,0 +1,124 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+func TestResolveSecretNames(t *testing.T) {
+	tests := []struct {
+		name      string
+		prefix    string
+		templates []isindirv1alpha2.SopsSecretTemplate
+		want      []string
+		err       string
+	}{
+		{
+			name: "rendered names",
+			templates: []isindirv1alpha2.SopsSecretTemplate{
+				{Name: "database", NameTemplate: "{{ .SopsSecretName }}-database"},
+				{Name: "cache"},
+			},
+			want: []string{"app-database", "cache"},
+		},
+		{
+			name:   "prefix",
+			prefix: "{{ .Namespace }}-",
+			templates: []isindirv1alpha2.SopsSecretTemplate{
+				{Name: "database"},
+				{Name: "team-a-cache"},
+			},
+			want: []string{"team-a-database", "team-a-cache"},
+		},
+		{
+			name: "same name in other cluster",
+			templates: []isindirv1alpha2.SopsSecretTemplate{
+				{Name: "database"},
+				{Name: "remote-database", NameTemplate: "database", Cluster: "eu-west"},
+			},
+			want: []string{"database", "database"},
+		},
+		{
+			name: "rendered name collides with template name",
+			templates: []isindirv1alpha2.SopsSecretTemplate{
+				{Name: "app-database"},
+				{Name: "database", NameTemplate: "{{ .SopsSecretName }}-database"},
+			},
+			err: `database: secret name "app-database" is also used by secret template app-database`,
+		},
+		{
+			name: "rendered names collide",
+			templates: []isindirv1alpha2.SopsSecretTemplate{
+				{Name: "primary", NameTemplate: "{{ .SopsSecretName }}"},
+				{Name: "secondary", NameTemplate: "{{ .SopsSecretName }}"},
+			},
+			err: `secondary: secret name "app" is also used by secret template primary`,
+		},
+		{
+			name:   "prefixed names collide",
+			prefix: "sops-",
+			templates: []isindirv1alpha2.SopsSecretTemplate{
+				{Name: "database"},
+				{Name: "sops-database"},
+			},
+			err: `sops-database: secret name "sops-database" is also used by secret template database`,
+		},
+		{
+			name: "rendered name is not DNS name",
+			templates: []isindirv1alpha2.SopsSecretTemplate{
+				{Name: "database", NameTemplate: "{{ .SopsSecretName }}_Database"},
+			},
+			err: `database: secret name "app_Database" is invalid`,
+		},
+		{
+			name: "rendered name is too long",
+			templates: []isindirv1alpha2.SopsSecretTemplate{
+				{Name: "database", NameTemplate: strings.Repeat("a", 254)},
+			},
+			err: "database: secret name",
+		},
+		{
+			name: "invalid template",
+			templates: []isindirv1alpha2.SopsSecretTemplate{
+				{Name: "database", NameTemplate: "{{ .SopsSecretName"},
+			},
+			err: "database nameTemplate parse error",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := &isindirv1alpha2.SopsSecret{}
+			instance.Name = "app"
+			instance.Namespace = "team-a"
+			instance.Spec.SecretsTemplate = tt.templates
+
+			err := ResolveSecretNames(instance, tt.prefix)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				if class := classifyFailure(err); class != permanentFailure {
+					t.Errorf("secret name error should be permanent failure, got %v", class)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var names []string
+			for _, secretTpl := range instance.Spec.SecretsTemplate {
+				names = append(names, secretTpl.Name)
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("secret names = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// secretReferencingSopsSecrets maps secret to SopsSecrets with secretKeyRef
+// values referencing it, so their secrets are rendered again with its new
+// values
+func (r *SopsSecretReconciler) secretReferencingSopsSecrets(obj client.Object) []reconcile.Request {
+	var requests []reconcile.Request
+	for _, dependent := range r.secretDependencies.dependentsOf(client.ObjectKeyFromObject(obj)) {
+		requests = append(requests, reconcile.Request{NamespacedName: dependent})
+	}
+	return requests
+}
+
+// resolveSecretKeyRefs sets values of secret templates of decrypted
+// SopsSecret copied from existing Kubernetes secrets in the same namespace,
+// resolved values are treated as data values of the template. Secrets are
+// read with impersonated service account of SopsSecret when it is set
+func (r *SopsSecretReconciler) resolveSecretKeyRefs(
+	ctx context.Context,
+	instance *isindirv1alpha2.SopsSecret,
+) error {
+	name := types.NamespacedName{Namespace: instance.Namespace, Name: instance.Name}
+	seen := make(map[types.NamespacedName]bool)
+	var references []types.NamespacedName
+	for i := range instance.Spec.SecretsTemplate {
+		for _, value := range instance.Spec.SecretsTemplate[i].Values {
+			ref := value.ValueFrom.SecretKeyRef
+			if ref == nil {
+				continue
+			}
+			reference := types.NamespacedName{Namespace: instance.Namespace, Name: ref.Name}
+			if !seen[reference] {
+				seen[reference] = true
+				references = append(references, reference)
+			}
+		}
+	}
+	// dependencies are recorded before resolution, so SopsSecret failing on
+	// missing secret is reconciled again when the secret is created
+	r.secretDependencies.set(name, references)
+	if len(references) == 0 {
+		return nil
+	}
+
+	reader, err := r.secretWriter(instance)
+	if err != nil {
+		return err
+	}
+	secrets := make(map[types.NamespacedName]*corev1.Secret)
+	for i := range instance.Spec.SecretsTemplate {
+		secretTpl := &instance.Spec.SecretsTemplate[i]
+		for _, value := range secretTpl.Values {
+			ref := value.ValueFrom.SecretKeyRef
+			if ref == nil {
+				continue
+			}
+			if secretTpl.Expand || secretTpl.Format != "" {
+				return &permanentError{fmt.Errorf("%s: values can not be used with expand or format", secretTpl.Name)}
+			}
+			if _, ok := secretTpl.Data[value.Name]; ok {
+				return &permanentError{fmt.Errorf("%s: values[%v]: key is already defined in data", secretTpl.Name, value.Name)}
+			}
+
+			reference := types.NamespacedName{Namespace: instance.Namespace, Name: ref.Name}
+			secret, ok := secrets[reference]
+			if !ok {
+				secret = &corev1.Secret{}
+				if err := reader.Get(ctx, reference, secret); err != nil {
+					if errors.IsNotFound(err) {
+						return r.missingSecretRef(fmt.Errorf("%s: values[%v]: secret %s not found", secretTpl.Name, value.Name, ref.Name))
+					}
+					return fmt.Errorf("%s: values[%v]: secret %s: %w", secretTpl.Name, value.Name, ref.Name, err)
+				}
+				secrets[reference] = secret
+			}
+
+			resolved, ok := secret.Data[ref.Key]
+			if !ok {
+				return r.missingSecretRef(fmt.Errorf("%s: values[%v]: key %v is not defined in secret %s", secretTpl.Name, value.Name, ref.Key, ref.Name))
+			}
+			if secretTpl.Data == nil {
+				secretTpl.Data = make(map[string]string)
+			}
+			secretTpl.Data[value.Name] = string(resolved)
+		}
+	}
+	return nil
+}
+
+// missingSecretRef returns error of missing referenced secret or key, which
+// is permanent when secrets are watched, as the secret change triggers
+// reconciliation, otherwise it is retried
+func (r *SopsSecretReconciler) missingSecretRef(err error) error {
+	if r.DisableSecretCache {
+		return err
+	}
+	return &permanentError{err}
+}
@@ -6,41 +6,249 @@ package controllers
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 	corev1 "k8s.io/api/core/v1"
-	apiequality "k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"sigs.k8s.io/yaml"
 
 	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+	isindirv1alpha3 "github.com/isindir/sops-secrets-operator/api/v1alpha3"
 
 	"go.mozilla.org/sops/v3"
 	sopsaes "go.mozilla.org/sops/v3/aes"
+	"go.mozilla.org/sops/v3/keyservice"
 	sopslogging "go.mozilla.org/sops/v3/logging"
 	sopsdotenv "go.mozilla.org/sops/v3/stores/dotenv"
+	sopsini "go.mozilla.org/sops/v3/stores/ini"
 	sopsjson "go.mozilla.org/sops/v3/stores/json"
 	sopsyaml "go.mozilla.org/sops/v3/stores/yaml"
 )
 
+const (
+	// ContentHashAnnotation holds the hash of the data the operator wrote to a
+	// managed secret, it is used to detect manual changes (drift)
+	ContentHashAnnotation = "sops-secrets-operator/content-hash"
+
+	// ShortContentHashAnnotation holds the first characters of content hash,
+	// enough to compare the secret with lastSyncedHash of SopsSecret status
+	ShortContentHashAnnotation = "sops-secrets-operator/short-content-hash"
+
+	// SourceGenerationAnnotation holds generation of SopsSecret the secret
+	// was generated from
+	SourceGenerationAnnotation = "sops-secrets-operator/source-generation"
+
+	// SpecHashAnnotation holds hash of encrypted spec and sops metadata of
+	// SopsSecret the secret was generated from, see status.specHash
+	SpecHashAnnotation = "sops-secrets-operator/spec-hash"
+
+	// SecretsFinalizer releases generated secrets on SopsSecret deletion
+	// according to its deletion policy
+	SecretsFinalizer = "isindir.github.com/secrets-finalizer"
+
+	// operatorAnnotationPrefix is the prefix of annotations set by the operator
+	operatorAnnotationPrefix = "sops-secrets-operator/"
+
+	// secretOwnerKey is the field index of secrets by their controlling SopsSecret name
+	secretOwnerKey = ".metadata.controller"
+)
+
 // SopsSecretReconciler reconciles a SopsSecret object
 type SopsSecretReconciler struct {
 	client.Client
-	Log          logr.Logger
-	Scheme       *runtime.Scheme
-	RequeueAfter int64
+	Log       logr.Logger
+	Scheme    *runtime.Scheme
+	Recorder  record.EventRecorder
+	VaultAuth *VaultAuth
+	// AzureIdentity is default client ID of Azure managed identity used to decrypt Key Vault keys
+	AzureIdentity string
+	// IdentityAllowlist limits identities SopsSecrets may select
+	IdentityAllowlist IdentityAllowlist
+	// TransientBackoff is requeue policy for API server and other failures
+	// which usually resolve within seconds
+	TransientBackoff BackoffPolicy
+	// CredentialBackoff is requeue policy for decryption failures caused by
+	// credentials or key backends, failures which require SopsSecret change
+	// are not requeued
+	CredentialBackoff BackoffPolicy
+	// RequeueSuccessAfter is how often successfully reconciled SopsSecrets
+	// are reconciled again, periodic reconciliation is disabled when not
+	// positive. SopsSecret spec.refreshInterval takes precedence
+	RequeueSuccessAfter time.Duration
+	// MaxConcurrentReconciles is the maximum number of SopsSecrets reconciled concurrently
+	MaxConcurrentReconciles int
+	// CacheSyncTimeout is how long controller waits for informer caches to
+	// sync on start, controller-runtime default is used when not positive
+	CacheSyncTimeout time.Duration
+	// InitialSyncTimeout enables readiness gate of InitialSyncChecker, which
+	// passes after all SopsSecrets existing on start are reconciled or the
+	// timeout elapses
+	InitialSyncTimeout time.Duration
+	// NamespaceRateLimit is the maximum rate of reconciliations per namespace
+	// per second, rate limiting is disabled when not positive
+	NamespaceRateLimit float64
+	// NamespaceRateBurst is the maximum burst of reconciliations per namespace
+	NamespaceRateBurst int
+	// WatchLabelSelector restricts reconciliation to SopsSecrets it matches,
+	// so several operator instances can share a cluster, nil matches all
+	WatchLabelSelector labels.Selector
+	// ShardTotal is the number of operator replicas SopsSecrets are
+	// distributed to by hash of namespace and name, sharding is disabled
+	// when not greater than 1
+	ShardTotal int
+	// ShardIndex is the shard of SopsSecrets reconciled by this replica,
+	// from 0 to ShardTotal-1
+	ShardIndex int
+	// Limits restrict number and size of generated secrets
+	Limits SecretLimits
+	// CertificateExpiryWarning is how long before expiry certificates of
+	// generated TLS secrets are reported, reporting is disabled when not
+	// positive
+	CertificateExpiryWarning time.Duration
+	// CurrentRecipients are recipients SopsSecrets should be encrypted for,
+	// SopsSecrets encrypted for other ones need re-encryption. Nothing is
+	// reported when empty
+	CurrentRecipients []string
+	// SecretNamePrefix is prefix of generated secret names, which is added
+	// to names not starting with it. It is a template with the same variables
+	// as secret template nameTemplate
+	SecretNamePrefix string
+	// EnableReencryption allows re-encryption of data keys of SopsSecrets
+	// opted in with ReencryptAnnotation for CurrentRecipients
+	EnableReencryption bool
+	// DecryptionCacheSize is the maximum number of decrypted SopsSecrets kept
+	// in memory, caching is disabled when not positive
+	DecryptionCacheSize int
+	// DecryptionCacheTTL is the maximum age of cached decrypted SopsSecret
+	DecryptionCacheTTL time.Duration
+	// ReplicationSourceNamespaces lists namespaces SopsSecrets of which may
+	// replicate secrets to other namespaces, "*" allows all namespaces
+	ReplicationSourceNamespaces []string
+	// ManagedSecretLabels are added to every generated secret, labels of
+	// secret template take precedence
+	ManagedSecretLabels map[string]string
+	// ManagedSecretAnnotations are added to every generated secret,
+	// annotations of secret template take precedence
+	ManagedSecretAnnotations map[string]string
+	// GpgKeysSecret is secret with PGP private keys used to decrypt all
+	// SopsSecrets, in addition to the operator GnuPG keyring
+	GpgKeysSecret types.NamespacedName
+	// NamespaceGpgKeysSecret is name of optional secret with PGP private keys
+	// used to decrypt SopsSecrets in the same namespace
+	NamespaceGpgKeysSecret string
+	// RemoteClusters maps names of remote clusters secret templates may push
+	// secrets to to secrets holding their kubeconfig
+	RemoteClusters map[string]types.NamespacedName
+	// RemoteSourceNamespaces lists per remote cluster namespaces
+	// SopsSecrets of which may push secrets to it, "*" allows all namespaces
+	RemoteSourceNamespaces map[string][]string
+	// KeyProfiles are credential sets SopsSecrets select with spec.keyProfile
+	KeyProfiles map[string]*KeyProfile
+	// VaultProfiles are Vault connections SopsSecrets select with
+	// spec.vaultProfile or namespace annotation
+	VaultProfiles map[string]*VaultProfile
+	// Pkcs11 configures decryption with PGP private keys held on PKCS#11
+	// token, nil disables it
+	Pkcs11 *Pkcs11Config
+	// SealedSecretsKeyNamespace is namespace of sealed-secrets controller
+	// sealing key secrets used to decrypt sealedSecret values, such values
+	// are rejected when empty
+	SealedSecretsKeyNamespace string
+	// FieldManager is server-side apply field manager of generated secrets,
+	// DefaultFieldManager is used when empty
+	FieldManager string
+	// AuditLog records mutations of generated secrets, nil disables audit
+	AuditLog *AuditLog
+	// Notifier sends notifications about generated secret changes, nil
+	// disables notifications
+	Notifier *Notifier
+	// Decryptor, when set, decrypts all SopsSecrets instead of sops with
+	// operator key services
+	Decryptor Decryptor
+	// RemoteKeyServices are external sops key services used to decrypt data
+	// keys after the local key service
+	RemoteKeyServices []keyservice.KeyServiceClient
+	// DisableLocalKeyService decrypts data keys with remote key services
+	// only, so key material is never accessed by the operator process
+	DisableLocalKeyService bool
+	// DisableSecretCache must be set when manager client does not cache
+	// secrets, secrets are then not watched and are read from API server
+	DisableSecretCache bool
+	// ManagedSecretsConfigMap is the name of ConfigMap published to every
+	// namespace with SopsSecrets, listing secrets generated in the namespace,
+	// publishing is disabled when empty
+	ManagedSecretsConfigMap string
+	// RestConfig is API server configuration of the operator, clients
+	// impersonating spec.serviceAccountName are derived from it. SopsSecrets
+	// with service account are rejected when nil
+	RestConfig *rest.Config
+	// CircuitBreaker defines when key backends are considered unavailable,
+	// their data keys are then not decrypted until a probe finds them
+	// available again
+	CircuitBreaker CircuitBreakerPolicy
+	// EnableTimingStatus records durations of decryption, rendering and
+	// applying of the last reconciliations in SopsSecret status
+	EnableTimingStatus bool
+	// StuckThreshold is the time SopsSecret may be not ready before it is
+	// reported stuck by Warning event and metric, 0 disables stuck detection
+	StuckThreshold time.Duration
+	// StuckScanInterval is the interval of stuck SopsSecrets scans and
+	// their repeated events
+	StuckScanInterval time.Duration
+
+	gcpTokens        gcpTokenCache
+	azureAuthorizers azureAuthorizerCache
+	failures         failureTracker
+	syncs            syncTracker
+	decryptions      *decryptionCache
+	pgpKeyring       pgpKeyring
+	pkcs11Keyring    pkcs11Keyring
+	remoteClients    remoteClients
+	impersonated     impersonatedClients
+	// managedSecretsExport tracks published managed secrets ConfigMaps
+	managedSecretsExport managedSecretsExport
+	initialSync          *initialSync
+	dependencies         *sopsSecretDependencies
+	// secretDependencies tracks secrets referenced by secretKeyRef values
+	secretDependencies *sopsSecretDependencies
+	breakers           *backendBreakers
+	// resync receives SopsSecrets to reconcile regardless of changes
+	resync chan event.GenericEvent
+
+	configLock sync.RWMutex
+	config     *OperatorConfig
 }
 
 //+kubebuilder:rbac:groups=isindir.github.com,resources=sopssecrets,verbs=get;list;watch;create;update;patch;delete
@@ -48,186 +256,839 @@ type SopsSecretReconciler struct {
 //+kubebuilder:rbac:groups=isindir.github.com,resources=sopssecrets/finalizers,verbs=update
 //+kubebuilder:rbac:groups="",resources=secrets,verbs="*"
 //+kubebuilder:rbac:groups="",resources=secrets/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+//+kubebuilder:rbac:groups=apps,resources=deployments;statefulsets,verbs=patch
+//+kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=impersonate
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=create;patch;delete
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.8.3/pkg/reconcile
 func (r *SopsSecretReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	_ = r.Log.WithValues("sopssecret", req.NamespacedName)
+	log := r.Log.WithValues("sopssecret", req.NamespacedName, correlationIDKey, newCorrelationID())
+	ctx = logr.NewContext(ctx, log)
+	queuedRequests.done(req.NamespacedName)
 
-	r.Log.Info("Reconciling", "sopssecret", req.NamespacedName)
+	log.Info("Reconciling")
+	defer r.initialSync.reconcileDone(req.NamespacedName)
+
+	ctx, span := startSpan(
+		ctx,
+		"Reconcile",
+		attribute.String("sopssecret.namespace", req.Namespace),
+		attribute.String("sopssecret.name", req.Name),
+	)
+	defer span.End()
+	timing := &reconcileTiming{}
+	ctx = withReconcileTiming(ctx, timing)
 
 	instanceEncrypted := &isindirv1alpha2.SopsSecret{}
-	err := r.Get(context.TODO(), req.NamespacedName, instanceEncrypted)
+	_, fetchSpan := startSpan(ctx, "Fetch")
+	err := r.Get(ctx, req.NamespacedName, instanceEncrypted)
+	endSpan(fetchSpan, client.IgnoreNotFound(err))
 	if err != nil {
 		if errors.IsNotFound(err) {
 			// Request object not found, could have been deleted after reconcile request.
 			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
 			// Return and don't requeue
-			r.Log.Info(
+			log.Info(
 				"Request object not found, could have been deleted after reconcile request",
-				"sopssecret",
-				req.NamespacedName,
 			)
+			r.failures.reset(req.NamespacedName)
+			r.dependencies.set(req.NamespacedName, nil)
+			r.secretDependencies.set(req.NamespacedName, nil)
+			verificationFailed.DeleteLabelValues(req.Namespace, req.Name)
+			certificateExpiring.DeleteLabelValues(req.Namespace, req.Name)
+			needsReencryption.DeleteLabelValues(req.Namespace, req.Name)
+			r.syncs.forget(req.NamespacedName)
+			r.publishManagedSecrets(ctx, req.NamespacedName, nil)
 			return reconcile.Result{}, nil
 		}
 		// Error reading the object - requeue the request.
-		r.Log.Info(
+		log.Info(
 			"Error reading the object - requeue the request",
-			"sopssecret",
-			req.NamespacedName,
 		)
 		return reconcile.Result{}, err
 	}
 
-	instance, err := decryptSopsSecretInstance(instanceEncrypted, r.Log)
+	if !r.watched(instanceEncrypted) {
+		// SopsSecret belongs to another operator instance or shard, even if
+		// it was managed by this one before
+		log.Info(
+			"SopsSecret does not match watch label selector or shard, skipping",
+		)
+		r.failures.reset(req.NamespacedName)
+		return reconcile.Result{}, nil
+	}
+	r.syncs.start(req.NamespacedName, instanceEncrypted)
+	// status of the SopsSecret is final once reconciliation returns
+	defer r.publishManagedSecrets(ctx, req.NamespacedName, instanceEncrypted)
+
+	if !instanceEncrypted.DeletionTimestamp.IsZero() {
+		return r.finalizeSopsSecret(ctx, instanceEncrypted)
+	}
+	if err := r.ensureFinalizer(ctx, instanceEncrypted); err != nil {
+		log.Info(
+			"Updating SopsSecret finalizer error",
+			"error",
+			err,
+		)
+		return reconcile.Result{}, err
+	}
+
+	if instanceEncrypted.Spec.Suspend {
+		instanceEncrypted.Status.Message = "Reconciliation suspended"
+		meta.SetStatusCondition(&instanceEncrypted.Status.Conditions, metav1.Condition{
+			Type:               isindirv1alpha2.SuspendedCondition,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: instanceEncrypted.Generation,
+			Reason:             "Suspended",
+			Message:            "Reconciliation is suspended by spec.suspend",
+		})
+		instanceEncrypted.Status.ObservedGeneration = instanceEncrypted.Generation
+		meta.SetStatusCondition(&instanceEncrypted.Status.Conditions, metav1.Condition{
+			Type:               isindirv1alpha2.ProgressingCondition,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: instanceEncrypted.Generation,
+			Reason:             "Suspended",
+			Message:            "Reconciliation is suspended by spec.suspend",
+		})
+		r.Status().Update(context.Background(), instanceEncrypted)
+
+		log.Info(
+			"Reconciliation is suspended",
+		)
+		r.failures.reset(req.NamespacedName)
+		return reconcile.Result{}, nil
+	}
+	meta.RemoveStatusCondition(&instanceEncrypted.Status.Conditions, isindirv1alpha2.SuspendedCondition)
+
+	if _, err := r.keyProfile(instanceEncrypted); err != nil {
+		instanceEncrypted.Status.Message = "Invalid key profile"
+		setHealth(instanceEncrypted, classifyFailure(err), "InvalidKeyProfile", err.Error())
+		r.Status().Update(context.Background(), instanceEncrypted)
+		r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeWarning, "InvalidKeyProfile", "Failed to decrypt: %v", err)
+		return r.requeueAfterFailure(ctx, req.NamespacedName, classifyFailure(err)), nil
+	}
+	if _, err := r.vaultProfile(ctx, instanceEncrypted); err != nil {
+		instanceEncrypted.Status.Message = "Invalid Vault profile"
+		setHealth(instanceEncrypted, classifyFailure(err), "InvalidVaultProfile", err.Error())
+		r.Status().Update(context.Background(), instanceEncrypted)
+		r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeWarning, "InvalidVaultProfile", "Failed to decrypt: %v", err)
+		return r.requeueAfterFailure(ctx, req.NamespacedName, classifyFailure(err)), nil
+	}
+	if err := r.checkIdentityOverrides(instanceEncrypted); err != nil {
+		instanceEncrypted.Status.Message = "Identity not allowed"
+		setHealth(instanceEncrypted, permanentFailure, "IdentityNotAllowed", err.Error())
+		r.Status().Update(context.Background(), instanceEncrypted)
+		r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeWarning, "IdentityNotAllowed", "Failed to decrypt: %v", err)
+		return r.requeueAfterFailure(ctx, req.NamespacedName, permanentFailure), nil
+	}
+	if err := checkFilesEncrypted(instanceEncrypted); err != nil {
+		instanceEncrypted.Status.Message = "Unencrypted files"
+		setHealth(instanceEncrypted, permanentFailure, "UnencryptedFiles", err.Error())
+		r.Status().Update(context.Background(), instanceEncrypted)
+		r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeWarning, "UnencryptedFiles", "Refused to apply: %v", err)
+		return r.requeueAfterFailure(ctx, req.NamespacedName, permanentFailure), nil
+	}
+
+	r.checkRecipients(instanceEncrypted)
+	decryptor := r.decryptor(ctx, instanceEncrypted)
+	if r.reencrypt(ctx, instanceEncrypted, decryptor) {
+		// update of re-encrypted SopsSecret triggers reconciliation
+		return reconcile.Result{}, nil
+	}
+	if instanceEncrypted.Spec.VerifyOnly {
+		return r.reconcileVerifyOnly(ctx, instanceEncrypted, decryptor)
+	}
+	instanceEncrypted.Status.LastVerificationTime = nil
+	meta.RemoveStatusCondition(&instanceEncrypted.Status.Conditions, isindirv1alpha2.VerifiedCondition)
+
+	forceSync := forceSyncRequested(instanceEncrypted)
+	if forceSync {
+		log.Info("Force sync requested", "value", instanceEncrypted.Annotations[ForceSyncAnnotation])
+	}
+
+	if corrupted := meta.FindStatusCondition(instanceEncrypted.Status.Conditions, isindirv1alpha2.CorruptedPayloadCondition); !forceSync && corrupted != nil &&
+		corrupted.Status == metav1.ConditionTrue &&
+		corrupted.ObservedGeneration == instanceEncrypted.Generation {
+		// decrypting the same content again only burns KMS and Vault calls
+		log.Info("Payload of this generation is corrupted, waiting for SopsSecret change")
+		return reconcile.Result{}, nil
+	}
+
+	var instance *isindirv1alpha2.SopsSecret
+	if !forceSync {
+		instance = r.decryptions.get(instanceEncrypted)
+	}
+	if instance == nil {
+		_, decryptSpan := startSpan(ctx, "Decrypt", attribute.Array("sops.key_backends", keyBackends(instanceEncrypted)))
+		decryptStart := time.Now()
+		instance, err = decryptor.Decrypt(instanceEncrypted, log)
+		timing.observe(decryptionPhase, decryptStart)
+		endSpan(decryptSpan, err)
+	} else {
+		log.V(1).Info("Using cached decrypted SopsSecret")
+	}
 	if err != nil {
 		//instance.Status.SecretsTotal = len(instance.Spec.SecretsTemplate)
 		instanceEncrypted.Status.Message = "Decryption error"
 
+		groupsErr := asKeyGroupsError(err)
+		if groupsErr != nil {
+			instanceEncrypted.Status.Message = "Insufficient key groups"
+			instanceEncrypted.Status.KeyGroups = groupsErr.groups
+			meta.SetStatusCondition(&instanceEncrypted.Status.Conditions, metav1.Condition{
+				Type:    isindirv1alpha2.InsufficientKeyGroupsCondition,
+				Status:  metav1.ConditionTrue,
+				Reason:  isindirv1alpha2.InsufficientKeyGroupsCondition,
+				Message: groupsErr.Error(),
+			})
+		}
+
+		reason, class := classifyDecryptionFailure(err)
+		if groupsErr != nil {
+			reason = isindirv1alpha2.InsufficientKeyGroupsCondition
+		}
+		if reason == CorruptedPayloadReason {
+			meta.SetStatusCondition(&instanceEncrypted.Status.Conditions, metav1.Condition{
+				Type:               isindirv1alpha2.CorruptedPayloadCondition,
+				Status:             metav1.ConditionTrue,
+				ObservedGeneration: instanceEncrypted.Generation,
+				Reason:             CorruptedPayloadReason,
+				Message:            err.Error(),
+			})
+		}
+		setBackendUnavailable(instanceEncrypted, r.breakers.unavailable(instanceEncrypted))
+		if reason == KeyBackendUnavailableReason && setStale(instanceEncrypted, err.Error()) {
+			// last generated secrets are still valid, only their refresh fails
+			instanceEncrypted.Status.Message = "Key backend unavailable, secrets are stale"
+			setFailureCondition(instanceEncrypted, class, reason, err.Error())
+		} else {
+			meta.RemoveStatusCondition(&instanceEncrypted.Status.Conditions, isindirv1alpha2.StaleCondition)
+			setHealth(instanceEncrypted, class, reason, err.Error())
+		}
+
 		// will not process instance error as we are already in error mode here
+		r.recordTiming(ctx, instanceEncrypted)
+		r.Status().Update(context.Background(), instanceEncrypted)
+		r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeWarning, reason, "Failed to decrypt: %v", err)
+
+		if reason == CorruptedPayloadReason {
+			// SopsSecret update triggers reconciliation
+			return reconcile.Result{}, nil
+		}
+		// Failed to decrypt, re-schedule reconciliation with backoff
+		return r.requeueAfterFailure(ctx, req.NamespacedName, class), nil
+	}
+	r.decryptions.add(instanceEncrypted, instance)
+	meta.RemoveStatusCondition(&instanceEncrypted.Status.Conditions, isindirv1alpha2.StaleCondition)
+	meta.RemoveStatusCondition(&instanceEncrypted.Status.Conditions, isindirv1alpha2.BackendUnavailableCondition)
+	meta.RemoveStatusCondition(&instanceEncrypted.Status.Conditions, isindirv1alpha2.CorruptedPayloadCondition)
+	// secrets rendered from decrypted copy record the encrypted source
+	instance.Status.SpecHash = specHash(instanceEncrypted)
+	instanceEncrypted.Status.KeyGroups = nil
+	meta.RemoveStatusCondition(&instanceEncrypted.Status.Conditions, isindirv1alpha2.InsufficientKeyGroupsCondition)
+
+	if err := ExpandRawSecrets(instance, decryptor); err != nil {
+		class := classifyFailure(err)
+		instanceEncrypted.Status.Message = "Raw secret error"
+		setHealth(instanceEncrypted, class, "InvalidRawSecret", err.Error())
+		r.Status().Update(context.Background(), instanceEncrypted)
+		r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeWarning, "InvalidRawSecret", "Failed to decrypt raw secret: %v", err)
+		return r.requeueAfterFailure(ctx, req.NamespacedName, class), nil
+	}
+
+	if err := r.resolveSopsSecretRefs(ctx, instance); err != nil {
+		class := classifyFailure(err)
+		instanceEncrypted.Status.Message = "SopsSecret reference error"
+		setHealth(instanceEncrypted, class, "ReferenceFailed", err.Error())
+		r.Status().Update(context.Background(), instanceEncrypted)
+		r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeWarning, "ReferenceFailed", "Failed to resolve SopsSecret reference: %v", err)
+
+		log.Info(
+			"SopsSecret reference error",
+			"error",
+			err,
+		)
+		return r.requeueAfterFailure(ctx, req.NamespacedName, class), nil
+	}
+
+	if err := r.resolveSecretKeyRefs(ctx, instance); err != nil {
+		class := classifyFailure(err)
+		instanceEncrypted.Status.Message = "Secret reference error"
+		setHealth(instanceEncrypted, class, "SecretReferenceFailed", err.Error())
+		r.Status().Update(context.Background(), instanceEncrypted)
+		r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeWarning, "SecretReferenceFailed", "Failed to resolve secret reference: %v", err)
+
+		log.Info(
+			"Secret reference error",
+			"error",
+			err,
+		)
+		return r.requeueAfterFailure(ctx, req.NamespacedName, class), nil
+	}
+
+	if err := r.resolveSealedSecretValues(ctx, instance); err != nil {
+		class := classifyFailure(err)
+		instanceEncrypted.Status.Message = "Sealed secret value error"
+		setHealth(instanceEncrypted, class, "SealedValueFailed", err.Error())
 		r.Status().Update(context.Background(), instanceEncrypted)
+		r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeWarning, "SealedValueFailed", "Failed to unseal sealed secret value: %v", err)
+		return r.requeueAfterFailure(ctx, req.NamespacedName, class), nil
+	}
+
+	if err := ResolveSecretNames(instance, r.SecretNamePrefix); err != nil {
+		instanceEncrypted.Status.Message = "Secret name error"
+		setHealth(instanceEncrypted, permanentFailure, "InvalidSecretName", err.Error())
+		r.Status().Update(context.Background(), instanceEncrypted)
+		r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeWarning, "InvalidSecretName", "Failed to resolve secret names: %v", err)
+		return r.requeueAfterFailure(ctx, req.NamespacedName, permanentFailure), nil
+	}
 
-		// Failed to decrypt, re-schedule reconciliation in 5 minutes
-		return reconcile.Result{Requeue: true, RequeueAfter: time.Duration(r.RequeueAfter) * time.Minute}, nil
+	if dryRun(instanceEncrypted) {
+		return r.reconcileDryRun(ctx, instanceEncrypted, instance, decryptor)
+	}
+	instanceEncrypted.Status.DryRunChanges = nil
+	meta.RemoveStatusCondition(&instanceEncrypted.Status.Conditions, isindirv1alpha2.DryRunCondition)
+
+	// limits are checked again for every template
+	meta.RemoveStatusCondition(&instanceEncrypted.Status.Conditions, isindirv1alpha2.LimitExceededCondition)
+	if err := r.limits().checkSopsSecret(instance); err != nil {
+		instanceEncrypted.Status.Message = "Limit exceeded"
+		setLimitExceeded(instanceEncrypted, asLimitError(err))
+		setHealth(instanceEncrypted, permanentFailure, "LimitExceeded", err.Error())
+		r.Status().Update(context.Background(), instanceEncrypted)
+		r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeWarning, "LimitExceeded", "SopsSecret is rejected: %v", err)
+		return r.requeueAfterFailure(ctx, req.NamespacedName, permanentFailure), nil
 	}
 
 	// iterating over secret templates
-	r.Log.Info("Entering template data loop", "sopssecret", req.NamespacedName)
-	for _, secretTemplateValue := range instance.Spec.SecretsTemplate {
-		// Define a new secret object
-		newSecret, err := newSecretForCR(instance, &secretTemplateValue, r.Log)
-		if err != nil {
-			instanceEncrypted.Status.Message = "New child secret creation error"
+	log.Info("Entering template data loop")
+	declaredSecrets := make(map[string]bool)
+	declaredReplicas := make(map[string]bool)
+	declaredRemote := make(map[string]map[string]bool)
+	previousClusters := resetClusterStatus(&instanceEncrypted.Status)
+	var failedTemplates []string
+	var failedClass failureClass
+	// conflicts and expiring certificates are detected again for every template
+	meta.RemoveStatusCondition(&instanceEncrypted.Status.Conditions, isindirv1alpha2.ConflictCondition)
+	meta.RemoveStatusCondition(&instanceEncrypted.Status.Conditions, isindirv1alpha2.CertificateExpiringCondition)
+	for i := range instance.Spec.SecretsTemplate {
+		secretTemplate := &instance.Spec.SecretsTemplate[i]
+
+		var message string
+		var class failureClass
+		var err error
+		if secretTemplate.Cluster != "" {
+			message, class, err = r.pushSecret(ctx, instanceEncrypted, instance, secretTemplate, decryptor, declaredRemote)
+		} else {
+			declaredSecrets[secretTemplate.Name] = true
+			message, class, err = r.reconcileSecret(ctx, instanceEncrypted, instance, secretTemplate, decryptor)
+			if err == nil && replicated(secretTemplate) {
+				message, class, err = r.replicateSecret(ctx, instanceEncrypted, instance, secretTemplate, decryptor, declaredReplicas)
+			}
+		}
+		if err == nil {
+			continue
+		}
+		if limitErr := asLimitError(err); limitErr != nil {
+			setLimitExceeded(instanceEncrypted, limitErr)
+		}
+		if instanceEncrypted.Spec.OnTemplateError != isindirv1alpha2.ApplyValidOnTemplateError {
+			instanceEncrypted.Status.Message = message
+			setHealth(instanceEncrypted, class, "TemplateFailed", fmt.Sprintf("%s: %s: %v", secretTemplate.Name, message, err))
+			r.recordTiming(ctx, instanceEncrypted)
 			r.Status().Update(context.Background(), instanceEncrypted)
+			return r.requeueAfterFailure(ctx, req.NamespacedName, class), nil
+		}
 
-			r.Log.Info(
-				"New child secret creation error",
-				"sopssecret",
-				req.NamespacedName,
+		// apply remaining templates, transient failures are retried sooner
+		failedTemplates = append(failedTemplates, fmt.Sprintf("%s: %s: %v", secretTemplate.Name, message, err))
+		failedClass = soonerRetried(failedClass, class)
+	}
+
+	if r.CertificateExpiryWarning > 0 {
+		expiring := 0.0
+		if meta.IsStatusConditionTrue(instanceEncrypted.Status.Conditions, isindirv1alpha2.CertificateExpiringCondition) {
+			expiring = 1
+		}
+		certificateExpiring.WithLabelValues(instanceEncrypted.Namespace, instanceEncrypted.Name).Set(expiring)
+	}
+
+	pruneManagedSecrets(&instanceEncrypted.Status, declaredSecrets)
+	if err := r.pruneOrphanedSecrets(ctx, instance, declaredSecrets); err != nil {
+		instanceEncrypted.Status.Message = "Orphaned child secret deletion error"
+		setHealth(instanceEncrypted, transientFailure, "SecretDeleteFailed", err.Error())
+		r.Status().Update(context.Background(), instanceEncrypted)
+		r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeWarning, "SecretDeleteFailed", "Failed to delete orphaned secret: %v", err)
+
+		log.Info(
+			"Orphaned child secret deletion error",
+			"error",
+			err,
+		)
+		return r.requeueAfterFailure(ctx, req.NamespacedName, transientFailure), nil
+	}
+
+	// replicas of failed templates are not known, so they are pruned only
+	// when all templates were applied
+	if len(failedTemplates) == 0 {
+		if err := r.pruneRemoteSecrets(ctx, instanceEncrypted, previousClusters, declaredRemote); err != nil {
+			instanceEncrypted.Status.Message = "Pushed secret deletion error"
+			setHealth(instanceEncrypted, transientFailure, "SecretDeleteFailed", err.Error())
+			r.Status().Update(context.Background(), instanceEncrypted)
+			r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeWarning, "SecretDeleteFailed", "Failed to delete pushed secret: %v", err)
+
+			log.Info(
+				"Pushed secret deletion error",
 				"error",
 				err,
 			)
-			return reconcile.Result{Requeue: true, RequeueAfter: time.Duration(r.RequeueAfter) * time.Minute}, nil
+			return r.requeueAfterFailure(ctx, req.NamespacedName, transientFailure), nil
 		}
-
-		// Set SopsSecret instance as the owner and controller
-		if err := controllerutil.SetControllerReference(
-			instance,
-			newSecret,
-			r.Scheme,
-		); err != nil {
-			instanceEncrypted.Status.Message = "Setting controller ownership of the child secret error"
+		if err := r.pruneReplicas(ctx, instance, declaredReplicas); err != nil {
+			instanceEncrypted.Status.Message = "Replicated secret deletion error"
+			setHealth(instanceEncrypted, transientFailure, "SecretDeleteFailed", err.Error())
 			r.Status().Update(context.Background(), instanceEncrypted)
+			r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeWarning, "SecretDeleteFailed", "Failed to delete replicated secret: %v", err)
 
-			r.Log.Info(
-				"Setting controller ownership of the child secret error",
-				"sopssecret",
-				req.NamespacedName,
+			log.Info(
+				"Replicated secret deletion error",
 				"error",
 				err,
 			)
-			return reconcile.Result{Requeue: true, RequeueAfter: time.Duration(r.RequeueAfter) * time.Minute}, nil
+			return r.requeueAfterFailure(ctx, req.NamespacedName, transientFailure), nil
 		}
+	}
 
-		// Check if this Secret already exists
-		foundSecret := &corev1.Secret{}
-		err = r.Get(
-			context.TODO(),
-			types.NamespacedName{
-				Name:      newSecret.Name,
-				Namespace: newSecret.Namespace,
-			},
-			foundSecret,
+	if len(failedTemplates) > 0 {
+		instanceEncrypted.Status.Message = "Secret templates error"
+		meta.SetStatusCondition(&instanceEncrypted.Status.Conditions, metav1.Condition{
+			Type:               isindirv1alpha2.TemplateErrorCondition,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: instanceEncrypted.Generation,
+			Reason:             "TemplatesFailed",
+			Message:            strings.Join(failedTemplates, "; "),
+		})
+		setHealth(instanceEncrypted, failedClass, "TemplatesFailed", strings.Join(failedTemplates, "; "))
+		r.recordTiming(ctx, instanceEncrypted)
+		r.Status().Update(context.Background(), instanceEncrypted)
+
+		log.Info(
+			"Some secret templates failed, valid templates were applied",
+			"failed",
+			len(failedTemplates),
 		)
-		if errors.IsNotFound(err) {
-			r.Log.Info(
-				"Creating a new Secret",
-				"sopssecret",
-				req.NamespacedName,
-				"message",
-				err,
-			)
-			err = r.Create(context.TODO(), newSecret)
-			foundSecret = newSecret.DeepCopy()
+		return r.requeueAfterFailure(ctx, req.NamespacedName, failedClass), nil
+	}
+	meta.RemoveStatusCondition(&instanceEncrypted.Status.Conditions, isindirv1alpha2.TemplateErrorCondition)
+
+	if err := r.restartTargets(ctx, instanceEncrypted); err != nil {
+		instanceEncrypted.Status.Message = "Restart targets error"
+		setHealth(instanceEncrypted, classifyFailure(err), "RestartFailed", err.Error())
+		r.Status().Update(context.Background(), instanceEncrypted)
+
+		log.Info(
+			"Restart targets error",
+			"error",
+			err,
+		)
+		return r.requeueAfterFailure(ctx, req.NamespacedName, classifyFailure(err)), nil
+	}
+
+	instanceEncrypted.Status.Message = "Healthy"
+	instanceEncrypted.Status.SpecHash = instance.Status.SpecHash
+	if forceSync {
+		instanceEncrypted.Status.LastForceSync = instanceEncrypted.Annotations[ForceSyncAnnotation]
+		r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeNormal, "ForceSynced", "Secrets are re-synced for %s %s", ForceSyncAnnotation, instanceEncrypted.Status.LastForceSync)
+	}
+	setHealth(instanceEncrypted, "", ReconciledReason, "All secret templates are applied")
+	r.recordTiming(ctx, instanceEncrypted)
+	r.Status().Update(context.Background(), instanceEncrypted)
+
+	log.Info(
+		"SopsSecret is Healthy",
+	)
+	r.syncs.synced(req.NamespacedName, instanceEncrypted.Generation)
+	r.failures.reset(req.NamespacedName)
+	return r.requeueAfterSuccess(instanceEncrypted), nil
+}
+
+// reconcileDryRun records changes of generated secrets in SopsSecret status
+// without writing them
+func (r *SopsSecretReconciler) reconcileDryRun(
+	ctx context.Context,
+	instanceEncrypted *isindirv1alpha2.SopsSecret,
+	instance *isindirv1alpha2.SopsSecret,
+	decryptor Decryptor,
+) (ctrl.Result, error) {
+	log := r.logger(ctx)
+	name := types.NamespacedName{Namespace: instance.Namespace, Name: instance.Name}
+
+	changes, err := r.dryRunChanges(ctx, instance, decryptor)
+	if err != nil {
+		instanceEncrypted.Status.Message = "Dry run error"
+		setHealth(instanceEncrypted, classifyFailure(err), "DryRunFailed", err.Error())
+		r.Status().Update(context.Background(), instanceEncrypted)
+		r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeWarning, "DryRunFailed", "Failed to compute dry run changes: %v", err)
+
+		log.Info(
+			"Dry run error",
+			"error",
+			err,
+		)
+		return r.requeueAfterFailure(ctx, name, classifyFailure(err)), nil
+	}
+
+	pending := 0
+	for _, change := range changes {
+		if change.Action != "None" {
+			pending++
 		}
-		if err != nil {
-			instanceEncrypted.Status.Message = "Unknown Error"
-			r.Status().Update(context.Background(), instanceEncrypted)
+	}
+	instanceEncrypted.Status.DryRunChanges = changes
+	instanceEncrypted.Status.Message = "Dry run"
+	meta.SetStatusCondition(&instanceEncrypted.Status.Conditions, metav1.Condition{
+		Type:               isindirv1alpha2.DryRunCondition,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: instanceEncrypted.Generation,
+		Reason:             "DryRun",
+		Message:            fmt.Sprintf("%d of %d secrets would change", pending, len(changes)),
+	})
+	setHealth(instanceEncrypted, "", "DryRun", fmt.Sprintf("%d of %d secrets would change", pending, len(changes)))
+	r.Status().Update(context.Background(), instanceEncrypted)
+
+	log.Info(
+		"Dry run completed",
+		"changes",
+		pending,
+	)
+	r.failures.reset(name)
+	return ctrl.Result{}, nil
+}
+
+// reconcileSecret creates or refreshes the secret defined by secret template,
+// on failure it returns status message, failure class and error
+func (r *SopsSecretReconciler) reconcileSecret(
+	ctx context.Context,
+	instanceEncrypted *isindirv1alpha2.SopsSecret,
+	instance *isindirv1alpha2.SopsSecret,
+	secretTemplate *isindirv1alpha2.SopsSecretTemplate,
+	decryptor Decryptor,
+) (string, failureClass, error) {
+	log := r.logger(ctx)
+
+	// Define a new secret object
+	_, renderSpan := startSpan(ctx, "RenderTemplate", attribute.String("template", secretTemplate.Name))
+	renderStart := time.Now()
+	newSecret, err := r.newSecret(ctx, instance, secretTemplate, decryptor)
+	timingFrom(ctx).observe(renderPhase, renderStart)
+	endSpan(renderSpan, err)
+	if err != nil {
+		r.Recorder.Eventf(
+			instanceEncrypted,
+			corev1.EventTypeWarning,
+			"TemplateRenderError",
+			"Failed to render secret template %s: %v",
+			secretTemplate.Name,
+			err,
+		)
+
+		log.Info(
+			"New child secret creation error",
+			"error",
+			err,
+		)
+		return "New child secret creation error", permanentFailure, err
+	}
+	r.checkCertificateExpiry(instanceEncrypted, newSecret)
+
+	// Set SopsSecret instance as the owner and controller
+	if err := controllerutil.SetControllerReference(
+		instance,
+		newSecret,
+		r.Scheme,
+	); err != nil {
+		r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeWarning, "SecretOwnershipFailed", "Failed to set ownership of secret %s: %v", newSecret.Name, err)
+
+		log.Info(
+			"Setting controller ownership of the child secret error",
+			"error",
+			err,
+		)
+		return "Setting controller ownership of the child secret error", transientFailure, err
+	}
+	if protected(instanceEncrypted) {
+		controllerutil.AddFinalizer(newSecret, ProtectionFinalizer)
+	}
+
+	writer, err := r.secretWriter(instance)
+	if err != nil {
+		r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeWarning, "InvalidServiceAccount", "Failed to impersonate service account: %v", err)
+
+		log.Info(
+			"Service account impersonation error",
+			"error",
+			err,
+		)
+		return "Service account impersonation error", classifyFailure(err), err
+	}
+
+	ctx, applySpan := startSpan(ctx, "ApplySecret", attribute.String("secret", newSecret.Name))
+	defer applySpan.End()
+	defer timingFrom(ctx).observe(applyPhase, time.Now())
+
+	// Check if this Secret already exists
+	foundSecret := &corev1.Secret{}
+	err = r.Get(
+		ctx,
+		types.NamespacedName{
+			Name:      newSecret.Name,
+			Namespace: newSecret.Namespace,
+		},
+		foundSecret,
+	)
+	if err != nil && !errors.IsNotFound(err) {
+		r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeWarning, "SecretCreateFailed", "Failed to get or create secret %s: %v", newSecret.Name, err)
+
+		log.Info(
+			"Unknown Error",
+			"error",
+			err,
+		)
+		return "Unknown Error", transientFailure, err
+	}
+	exists := err == nil
+	var staleKeys []string
+	if exists {
+		preserveHtpasswd(secretTemplate, newSecret, foundSecret)
+		if metav1.IsControlledBy(foundSecret, instance) && !secretTemplate.Immutable {
+			staleKeys = mergeKeys(secretTemplate, newSecret, foundSecret)
+		}
+	}
+	setManagedKeys(newSecret)
+
+	adopt := false
+	if exists && !metav1.IsControlledBy(foundSecret, instance) {
+		// only secrets without controller can be adopted, secrets managed
+		// by another SopsSecret or controller are never taken over
+		if !secretTemplate.TakeOwnership || metav1.GetControllerOf(foundSecret) != nil {
+			r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeWarning, "SecretConflict", "Secret %s exists and is not owned by this SopsSecret", foundSecret.Name)
+			meta.SetStatusCondition(&instanceEncrypted.Status.Conditions, metav1.Condition{
+				Type:               isindirv1alpha2.ConflictCondition,
+				Status:             metav1.ConditionTrue,
+				ObservedGeneration: instanceEncrypted.Generation,
+				Reason:             "SecretNotOwned",
+				Message:            fmt.Sprintf("Secret %s exists and is not owned by this SopsSecret", foundSecret.Name),
+			})
 
-			r.Log.Info(
-				"Unknown Error",
-				"sopssecret",
-				req.NamespacedName,
+			err := fmt.Errorf("sopssecret has a conflict with existing kubernetes secret resource, potential reasons: target secret already pre-existed or is managed by multiple sops secrets")
+			log.Info(
+				"Child secret is not owned by controller or sopssecret Error",
 				"error",
 				err,
 			)
-			return reconcile.Result{Requeue: true, RequeueAfter: time.Duration(r.RequeueAfter) * time.Minute}, nil
+			return "Child secret is not owned by controller error", permanentFailure, err
 		}
+		adopt = true
+		log.Info(
+			"Taking ownership of existing secret",
+			"secret",
+			foundSecret.Name,
+			"namespace",
+			foundSecret.Namespace,
+		)
+	}
 
-		if !metav1.IsControlledBy(foundSecret, instance) {
-			instanceEncrypted.Status.Message = "Child secret is not owned by controller error"
-			r.Status().Update(context.Background(), instanceEncrypted)
+	if hash, ok := foundSecret.Annotations[ContentHashAnnotation]; ok && hash != appliedContentHash(foundSecret, newSecret) {
+		log.Info(
+			"Secret was changed outside of the operator, restoring",
+			"secret",
+			foundSecret.Name,
+			"namespace",
+			foundSecret.Namespace,
+		)
+	}
 
-			r.Log.Info(
-				"Child secret is not owned by controller or sopssecret Error",
-				"sopssecret",
-				req.NamespacedName,
+	// server-side apply only touches fields set by the operator, data keys
+	// and metadata added to the secret by other actors are preserved.
+	// Unchanged secrets are not applied at all to avoid resourceVersion churn
+	applied := newSecret.DeepCopy()
+	recreate := exists && secretTemplate.UpdateStrategy == isindirv1alpha2.RecreateUpdateStrategy
+	current := exists && !adopt && !forceSyncRequested(instanceEncrypted) && upToDate(foundSecret, newSecret)
+	if current && recreate {
+		// recreated secret holds exactly the rendered data keys
+		current = len(foundSecret.Data) == len(newSecret.Data)
+	}
+	if current {
+		log.V(1).Info("Secret is up to date", "secret", foundSecret.Name, "namespace", foundSecret.Namespace)
+		applied = foundSecret
+		if err := r.refreshLastSync(ctx, writer, instance, foundSecret); err != nil {
+			log.Info("Failed to refresh last sync time of secret", "secret", foundSecret.Name, "error", err)
+		}
+	} else if err = r.updateSecret(ctx, writer, foundSecret, applied, recreate, staleKeys); err != nil {
+		r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeWarning, "SecretApplyFailed", "Failed to apply secret %s: %v", newSecret.Name, err)
+
+		log.Info(
+			"Child secret apply error",
+			"error",
+			err,
+		)
+		if errors.IsForbidden(err) && instance.Spec.ServiceAccountName != "" {
+			// permissions of impersonated service account must be granted
+			return "Child secret apply error", credentialFailure, err
+		}
+		return "Child secret apply error", transientFailure, err
+	}
+	synced := !exists || applied.ResourceVersion != foundSecret.ResourceVersion
+	switch {
+	case !exists:
+		r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeNormal, "SecretCreated", "Secret %s created", applied.Name)
+		r.secretChanged(ctx, instance, SecretCreatedAction, applied)
+		log.Info(
+			"Secret created",
+			"secret",
+			applied.Name,
+			"namespace",
+			applied.Namespace,
+		)
+	case synced:
+		if adopt {
+			r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeNormal, "SecretAdopted", "Secret %s adopted", applied.Name)
+		}
+		r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeNormal, "SecretUpdated", "Secret %s updated", applied.Name)
+		r.secretChanged(ctx, instance, SecretUpdatedAction, applied)
+		log.Info(
+			"Secret successfully refreshed",
+			"secret",
+			applied.Name,
+			"namespace",
+			applied.Namespace,
+		)
+	}
+	foundSecret = applied
+	setManagedSecret(&instanceEncrypted.Status, foundSecret, synced)
+
+	if secretTemplate.Immutable {
+		if err := r.pruneSecretGenerations(ctx, instance, secretTemplate, foundSecret.Name); err != nil {
+			r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeWarning, "SecretDeleteFailed", "Failed to delete previous immutable secret of %s: %v", secretTemplate.Name, err)
+
+			log.Info(
+				"Previous immutable secret deletion error",
 				"error",
-				fmt.Errorf("sopssecret has a conflict with existing kubernetes secret resource, potential reasons: target secret already pre-existed or is managed by multiple sops secrets"),
+				err,
 			)
-			return reconcile.Result{Requeue: true, RequeueAfter: time.Duration(r.RequeueAfter) * time.Minute}, nil
+			return "Previous immutable secret deletion error", transientFailure, err
 		}
+	}
+	return "", "", nil
+}
 
-		origSecret := foundSecret
-		foundSecret = foundSecret.DeepCopy()
+// setManagedSecret records secret in SopsSecret status, sync time is updated
+// only when the secret was written by the operator. Immutable secret replaces
+// previous secret of the same template
+func setManagedSecret(status *isindirv1alpha2.SopsSecretStatus, secret *corev1.Secret, synced bool) {
+	managed := isindirv1alpha2.ManagedSecret{
+		Name:              secret.Name,
+		Template:          secret.Annotations[TemplateAnnotation],
+		KeysCount:         len(secret.Data),
+		LastSyncedHash:    secret.Annotations[ContentHashAnnotation],
+		SSHKeyFingerprint: sshKeyFingerprint(secret),
+	}
+	templateName := secretTemplateName(secret)
 
-		foundSecret.Data = newSecret.Data
-		foundSecret.Type = newSecret.Type
-		foundSecret.ObjectMeta.Annotations = newSecret.ObjectMeta.Annotations
-		foundSecret.ObjectMeta.Labels = newSecret.ObjectMeta.Labels
+	found := false
+	for i := range status.ManagedSecrets {
+		previous := &status.ManagedSecrets[i]
+		if previous.Name != secret.Name && previous.Template != templateName &&
+			(previous.Template != "" || previous.Name != templateName) {
+			continue
+		}
+		managed.LastSyncTime = previous.LastSyncTime
+		if synced || managed.LastSyncTime == nil || previous.Name != secret.Name {
+			now := metav1.Now()
+			managed.LastSyncTime = &now
+		}
+		status.ManagedSecrets[i] = managed
+		found = true
+		break
+	}
 
-		if !apiequality.Semantic.DeepEqual(origSecret, foundSecret) {
-			r.Log.Info(
-				"Secret already exists and needs to be refreshed",
-				"secret",
-				foundSecret.Name,
-				"namespace",
-				foundSecret.Namespace,
-			)
-			if err = r.Update(context.TODO(), foundSecret); err != nil {
-				instanceEncrypted.Status.Message = "Child secret update error"
-				r.Status().Update(context.Background(), instanceEncrypted)
-
-				r.Log.Info(
-					"Child secret update error",
-					"sopssecret",
-					req.NamespacedName,
-					"error",
-					err,
-				)
-				return reconcile.Result{Requeue: true, RequeueAfter: time.Duration(r.RequeueAfter) * time.Minute}, nil
-			}
-			r.Log.Info(
-				"Secret successfully refreshed",
-				"secret",
-				foundSecret.Name,
-				"namespace",
-				foundSecret.Namespace,
-			)
+	if !found {
+		now := metav1.Now()
+		managed.LastSyncTime = &now
+		status.ManagedSecrets = append(status.ManagedSecrets, managed)
+	}
+	sort.Slice(status.ManagedSecrets, func(i, j int) bool {
+		return status.ManagedSecrets[i].Name < status.ManagedSecrets[j].Name
+	})
+}
+
+// pruneManagedSecrets removes secrets no longer declared in secret templates from SopsSecret status
+func pruneManagedSecrets(status *isindirv1alpha2.SopsSecretStatus, declaredSecrets map[string]bool) {
+	managedSecrets := status.ManagedSecrets[:0]
+	for _, managed := range status.ManagedSecrets {
+		if declaredSecrets[managed.Name] || declaredSecrets[managed.Template] {
+			managedSecrets = append(managedSecrets, managed)
 		}
 	}
+	status.ManagedSecrets = managedSecrets
+}
 
-	instanceEncrypted.Status.Message = "Healthy"
-	r.Status().Update(context.Background(), instanceEncrypted)
+// requeueAfterFailure returns result which requeues SopsSecret using backoff
+// policy of the failure class. Permanent failures are not requeued, as the
+// same spec fails again, SopsSecret watch triggers reconciliation once it
+// changes
+func (r *SopsSecretReconciler) requeueAfterFailure(ctx context.Context, name types.NamespacedName, class failureClass) reconcile.Result {
+	if class == permanentFailure {
+		r.logger(ctx).Info("Waiting for SopsSecret change after permanent failure")
+		return reconcile.Result{}
+	}
+	policy := r.TransientBackoff
+	if class == credentialFailure {
+		policy = r.CredentialBackoff
+	}
+	delay := policy.Delay(r.failures.inc(name, class))
+	reconcileRetries.WithLabelValues(name.Namespace).Inc()
+	queuedRequests.add(name, time.Now().Add(delay))
 
-	r.Log.Info(
-		"SopsSecret is Healthy",
-		"sopssecret",
-		req.NamespacedName,
+	r.logger(ctx).Info(
+		"Requeueing failed reconciliation",
+		"failure",
+		string(class),
+		"after",
+		delay.String(),
 	)
-	return ctrl.Result{}, nil
+	return reconcile.Result{Requeue: true, RequeueAfter: delay}
+}
+
+// requeueAfterSuccess returns result which reconciles healthy SopsSecret
+// again after its refresh interval, so drift of generated secrets is repaired
+// even when no watch event is received
+func (r *SopsSecretReconciler) requeueAfterSuccess(instance *isindirv1alpha2.SopsSecret) reconcile.Result {
+	interval := r.refreshInterval(instance)
+	if interval <= 0 {
+		return reconcile.Result{}
+	}
+
+	// jitter spreads periodic reconciliations of SopsSecrets created or
+	// resynced at the same time
+	policy := BackoffPolicy{Initial: interval, Max: interval, Jitter: 0.1}
+	delay := policy.Delay(1)
+	queuedRequests.add(types.NamespacedName{Namespace: instance.Namespace, Name: instance.Name}, time.Now().Add(delay))
+	return reconcile.Result{RequeueAfter: delay}
+}
+
+// refreshInterval returns how often SopsSecret is reconciled after success,
+// 0 when it is not reconciled periodically
+func (r *SopsSecretReconciler) refreshInterval(instance *isindirv1alpha2.SopsSecret) time.Duration {
+	if instance.Spec.RefreshInterval != nil {
+		return instance.Spec.RefreshInterval.Duration
+	}
+	return r.RequeueSuccessAfter
 }
 
 // SetupWithManager sets up the controller with the Manager.
@@ -241,18 +1102,478 @@ func (r *SopsSecretReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		sopslogging.Loggers[k].Out = ioutil.Discard
 	}
 
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&isindirv1alpha2.SopsSecret{}).
-		Owns(&corev1.Secret{}).
+	// Index secrets by controlling SopsSecret, so orphaned secrets can be
+	// found. The index would start informer caching all secrets
+	if !r.DisableSecretCache {
+		if err := mgr.GetFieldIndexer().IndexField(
+			context.Background(),
+			&corev1.Secret{},
+			secretOwnerKey,
+			func(rawObj client.Object) []string {
+				owner := metav1.GetControllerOf(rawObj)
+				if owner == nil {
+					return nil
+				}
+				if owner.APIVersion != isindirv1alpha2.GroupVersion.String() || owner.Kind != "SopsSecret" {
+					return nil
+				}
+				return []string{owner.Name}
+			},
+		); err != nil {
+			return err
+		}
+	}
+
+	if r.DecryptionCacheSize > 0 {
+		cache, err := newDecryptionCache(r.DecryptionCacheSize, r.DecryptionCacheTTL)
+		if err != nil {
+			return err
+		}
+		r.decryptions = cache
+	}
+
+	if r.InitialSyncTimeout > 0 {
+		r.initialSync = &initialSync{
+			reconciler: r,
+			cache:      mgr.GetCache(),
+			timeout:    r.InitialSyncTimeout,
+			started:    time.Now(),
+			pending:    make(map[types.NamespacedName]bool),
+			reconciled: make(map[types.NamespacedName]bool),
+		}
+		if err := mgr.Add(r.initialSync); err != nil {
+			return err
+		}
+	}
+
+	if r.StuckThreshold > 0 {
+		interval := r.StuckScanInterval
+		if interval <= 0 {
+			interval = 10 * time.Minute
+		}
+		if err := mgr.Add(&stuckScanner{reconciler: r, threshold: r.StuckThreshold, interval: interval}); err != nil {
+			return err
+		}
+	}
+
+	r.dependencies = newSopsSecretDependencies()
+	r.secretDependencies = newSopsSecretDependencies()
+	r.breakers = newBackendBreakers(r.CircuitBreaker, r.resyncSopsSecrets)
+	if r.CircuitBreaker.Threshold > 0 {
+		if err := mgr.Add(r.breakers); err != nil {
+			return err
+		}
+	}
+
+	rateLimiter := workqueue.DefaultControllerRateLimiter()
+	if r.NamespaceRateLimit > 0 {
+		rateLimiter = workqueue.NewMaxOfRateLimiter(
+			rateLimiter,
+			newNamespaceRateLimiter(r.NamespaceRateLimit, r.NamespaceRateBurst),
+		)
+	}
+
+	// SopsSecret events are admitted to work queue by priority queue, For
+	// only sets the reconciled type
+	ignore := predicate.NewPredicateFuncs(func(client.Object) bool { return false })
+	admission := newPriorityQueue(r.MaxConcurrentReconciles)
+	r.resync = make(chan event.GenericEvent)
+	admitted := []predicate.Predicate{predicate.NewPredicateFuncs(r.watched)}
+	if r.EnableTimingStatus {
+		admitted = append(admitted, timingUpdatePredicate)
+	}
+	bldr := ctrl.NewControllerManagedBy(mgr).
+		For(&isindirv1alpha2.SopsSecret{}, builder.WithPredicates(ignore)).
+		Watches(
+			&source.Kind{Type: &isindirv1alpha2.SopsSecret{}},
+			admission,
+			builder.WithPredicates(admitted...),
+		).
+		Watches(&source.Channel{Source: r.resync}, admission).
+		Watches(
+			&source.Kind{Type: &isindirv1alpha2.SopsSecret{}},
+			trackPending(handler.EnqueueRequestsFromMapFunc(r.referencingSopsSecrets)),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{}),
+		)
+	if !r.DisableSecretCache {
+		// secret watches need informer caching all secrets, without them
+		// changed and deleted secrets are repaired by periodic reconciliation
+		bldr = bldr.
+			Watches(
+				&source.Kind{Type: &corev1.Secret{}},
+				trackPending(&handler.EnqueueRequestForOwner{OwnerType: &isindirv1alpha2.SopsSecret{}, IsController: true}),
+			).
+			Watches(
+				&source.Kind{Type: &corev1.Secret{}},
+				trackPending(handler.EnqueueRequestsFromMapFunc(replicaSopsSecret)),
+			).
+			Watches(
+				&source.Kind{Type: &corev1.Secret{}},
+				trackPending(handler.EnqueueRequestsFromMapFunc(r.pgpKeysSopsSecrets)),
+			).
+			Watches(
+				&source.Kind{Type: &corev1.Secret{}},
+				trackPending(handler.EnqueueRequestsFromMapFunc(r.secretReferencingSopsSecrets)),
+			)
+	}
+	return bldr.
+		Watches(
+			&source.Kind{Type: &corev1.Namespace{}},
+			trackPending(handler.EnqueueRequestsFromMapFunc(r.namespaceSelectingSopsSecrets)),
+		).
+		Watches(
+			&source.Kind{Type: &corev1.Namespace{}},
+			trackPending(handler.EnqueueRequestsFromMapFunc(r.namespaceVaultProfileSopsSecrets)),
+			builder.WithPredicates(vaultProfileAnnotationChanged),
+		).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: r.MaxConcurrentReconciles,
+			RateLimiter:             rateLimiter,
+			CacheSyncTimeout:        r.CacheSyncTimeout,
+		}).
 		Complete(r)
 }
 
+// watched reports whether SopsSecret matches watch label selector and
+// belongs to the shard of this replica
+func (r *SopsSecretReconciler) watched(obj client.Object) bool {
+	if r.WatchLabelSelector != nil && !r.WatchLabelSelector.Matches(labels.Set(obj.GetLabels())) {
+		return false
+	}
+	return r.ShardTotal <= 1 || shardOf(obj.GetNamespace(), obj.GetName(), r.ShardTotal) == r.ShardIndex
+}
+
+// shardOf returns shard of SopsSecret, shard depends only on namespace and
+// name, so it is the same in every replica and does not change over time
+func shardOf(namespace, name string, total int) int {
+	h := fnv.New32a()
+	h.Write([]byte(namespace + "/" + name))
+	return int(h.Sum32() % uint32(total))
+}
+
+// pruneOrphanedSecrets deletes secrets controlled by the SopsSecret which are
+// no longer declared in its secret templates
+func (r *SopsSecretReconciler) pruneOrphanedSecrets(
+	ctx context.Context,
+	instance *isindirv1alpha2.SopsSecret,
+	declaredSecrets map[string]bool,
+) error {
+	log := r.logger(ctx)
+	ownedSecrets := &corev1.SecretList{}
+	if err := r.listOwnedSecrets(ctx, instance, ownedSecrets); err != nil {
+		return err
+	}
+	writer, err := r.secretWriter(instance)
+	if err != nil {
+		return err
+	}
+
+	// previous immutable secrets are pruned by revision history limit
+	immutable := immutableTemplates(instance)
+	for i := range ownedSecrets.Items {
+		secret := &ownedSecrets.Items[i]
+		if declaredSecrets[secret.Name] ||
+			immutable[secret.Annotations[TemplateAnnotation]] ||
+			!metav1.IsControlledBy(secret, instance) {
+			continue
+		}
+		if protected(instance) {
+			log.Info(
+				"Keeping protected orphaned Secret",
+				"secret",
+				secret.Name,
+			)
+			continue
+		}
+		if err := r.unprotectSecret(ctx, writer, secret); err != nil {
+			return err
+		}
+
+		log.Info(
+			"Deleting orphaned Secret",
+			"secret",
+			secret.Name,
+			"namespace",
+			secret.Namespace,
+		)
+		if err := writer.Delete(ctx, secret); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		r.Recorder.Eventf(instance, corev1.EventTypeNormal, "SecretDeleted", "Orphaned secret %s deleted", secret.Name)
+		r.secretChanged(ctx, instance, SecretDeletedAction, secret)
+	}
+	return nil
+}
+
+// ensureFinalizer adds finalizer to SopsSecret when generated secrets must
+// outlive it, are replicated to other namespaces or pushed to remote
+// clusters, and removes finalizer when they are garbage collected. Protection
+// finalizer is kept while SopsSecret has protect annotation
+func (r *SopsSecretReconciler) ensureFinalizer(ctx context.Context, instance *isindirv1alpha2.SopsSecret) error {
+	policy := instance.Spec.DeletionPolicy
+	finalizers := map[string]bool{
+		SecretsFinalizer: policy == isindirv1alpha2.OrphanDeletionPolicy ||
+			policy == isindirv1alpha2.RetainDeletionPolicy ||
+			hasReplicatedTemplates(instance) ||
+			hasRemoteTemplates(instance),
+		ProtectionFinalizer: protected(instance),
+	}
+	changed := false
+	for finalizer, needed := range finalizers {
+		if needed == controllerutil.ContainsFinalizer(instance, finalizer) {
+			continue
+		}
+		if needed {
+			controllerutil.AddFinalizer(instance, finalizer)
+		} else {
+			controllerutil.RemoveFinalizer(instance, finalizer)
+		}
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return r.Update(ctx, instance)
+}
+
+// finalizeSopsSecret releases generated secrets of deleted SopsSecret and
+// removes the finalizer
+func (r *SopsSecretReconciler) finalizeSopsSecret(ctx context.Context, instance *isindirv1alpha2.SopsSecret) (reconcile.Result, error) {
+	log := r.logger(ctx)
+	name := types.NamespacedName{Namespace: instance.Namespace, Name: instance.Name}
+	if protected(instance) {
+		// reconciled again when the annotation is removed
+		instance.Status.Message = "Deletion blocked by protect annotation"
+		r.Status().Update(context.Background(), instance)
+		r.Recorder.Eventf(instance, corev1.EventTypeWarning, "DeletionProtected", "Deletion is blocked until %s annotation is removed", ProtectAnnotation)
+		return reconcile.Result{}, nil
+	}
+	if controllerutil.ContainsFinalizer(instance, ProtectionFinalizer) {
+		if err := r.unprotectSecrets(ctx, instance); err != nil {
+			log.Info(
+				"Removing secret protection error",
+				"error",
+				err,
+			)
+			return reconcile.Result{}, err
+		}
+		controllerutil.RemoveFinalizer(instance, ProtectionFinalizer)
+		if err := r.Update(ctx, instance); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+	if !controllerutil.ContainsFinalizer(instance, SecretsFinalizer) {
+		return reconcile.Result{}, nil
+	}
+
+	if instance.Spec.DeletionPolicy != isindirv1alpha2.DeleteDeletionPolicy && instance.Spec.DeletionPolicy != "" {
+		if err := r.releaseSecrets(ctx, instance); err != nil {
+			log.Info(
+				"Releasing child secrets error",
+				"error",
+				err,
+			)
+			return reconcile.Result{}, err
+		}
+	} else if err := r.pruneReplicas(ctx, instance, nil); err != nil {
+		// replicas in other namespaces are not garbage collected
+		log.Info(
+			"Replicated secret deletion error",
+			"error",
+			err,
+		)
+		return reconcile.Result{}, err
+	} else if err := r.pruneRemoteSecrets(ctx, instance, resetClusterStatus(&instance.Status), nil); err != nil {
+		// neither are secrets pushed to remote clusters
+		log.Info(
+			"Pushed secret deletion error",
+			"error",
+			err,
+		)
+		return reconcile.Result{}, err
+	}
+
+	controllerutil.RemoveFinalizer(instance, SecretsFinalizer)
+	if err := r.Update(ctx, instance); err != nil {
+		return reconcile.Result{}, err
+	}
+	r.failures.reset(name)
+	return reconcile.Result{}, nil
+}
+
+// releaseSecrets removes SopsSecret owner reference from generated secrets, so
+// they are not garbage collected, with Retain policy annotations and replica
+// labels set by the operator are removed as well
+func (r *SopsSecretReconciler) releaseSecrets(ctx context.Context, instance *isindirv1alpha2.SopsSecret) error {
+	log := r.logger(ctx)
+	ownedSecrets := &corev1.SecretList{}
+	if err := r.listOwnedSecrets(ctx, instance, ownedSecrets); err != nil {
+		return err
+	}
+	replicas, err := r.replicas(ctx, instance)
+	if err != nil {
+		return err
+	}
+	writer, err := r.secretWriter(instance)
+	if err != nil {
+		return err
+	}
+
+	for i := range ownedSecrets.Items {
+		secret := &ownedSecrets.Items[i]
+		if !metav1.IsControlledBy(secret, instance) {
+			continue
+		}
+		replicas = append(replicas, *secret)
+	}
+
+	for i := range replicas {
+		secret := &replicas[i]
+
+		patch := client.MergeFrom(secret.DeepCopy())
+		ownerReferences := secret.OwnerReferences[:0]
+		for _, ref := range secret.OwnerReferences {
+			if ref.UID != instance.UID {
+				ownerReferences = append(ownerReferences, ref)
+			}
+		}
+		secret.OwnerReferences = ownerReferences
+		if instance.Spec.DeletionPolicy == isindirv1alpha2.RetainDeletionPolicy {
+			for key := range secret.Annotations {
+				if strings.HasPrefix(key, operatorAnnotationPrefix) {
+					delete(secret.Annotations, key)
+				}
+			}
+			delete(secret.Labels, ReplicaOfUIDLabel)
+		}
+
+		log.Info(
+			"Releasing Secret",
+			"secret",
+			secret.Name,
+			"namespace",
+			secret.Namespace,
+			"policy",
+			instance.Spec.DeletionPolicy,
+		)
+		if err := writer.Patch(ctx, secret, patch); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		r.Recorder.Eventf(instance, corev1.EventTypeNormal, "SecretReleased", "Secret %s released by %s deletion policy", secret.Name, instance.Spec.DeletionPolicy)
+	}
+	return nil
+}
+
+// keyServices returns sops key services to use for data key decryption,
+// local key service with SopsSecret credentials is followed by remote key
+// services. SopsSecrets with key profile use profile credentials only
+func (r *SopsSecretReconciler) keyServices(ctx context.Context, instance *isindirv1alpha2.SopsSecret) []keyservice.KeyServiceClient {
+	if instance.Spec.KeyProfile != "" {
+		profile, _ := r.keyProfile(instance)
+		services := r.keyProfileKeyServices(ctx, instance.Spec.KeyProfile, profile)
+		for i := range services {
+			services[i] = r.breakers.guard(services[i])
+		}
+		return services
+	}
+	if r.DisableLocalKeyService {
+		return r.RemoteKeyServices
+	}
+
+	var svc keyservice.KeyServiceClient = keyservice.NewLocalClient()
+	vaultProfile, err := r.vaultProfile(ctx, instance)
+	switch {
+	case err != nil:
+		svc = &vaultProfileKeyService{err: err, next: svc}
+	case vaultProfile != nil:
+		svc = newVaultKeyService(vaultProfile.VaultAuth, instance.Spec.VaultNamespace)
+	case r.VaultAuth != nil:
+		svc = newVaultKeyService(r.VaultAuth, instance.Spec.VaultNamespace)
+	}
+	if usesPgp(instance) {
+		if keys := r.pgpKeys(ctx, instance.Namespace); len(keys) > 0 {
+			svc = newPgpKeyService(keys, svc)
+		}
+	}
+	if instance.Spec.AwsRoleARN != "" {
+		svc = newAwsRoleKeyService(instance.Spec.AwsRoleARN, svc)
+	}
+	if instance.Spec.GcpServiceAccount != "" {
+		svc = newGcpImpersonationKeyService(&r.gcpTokens, instance.Spec.GcpServiceAccount, svc)
+	}
+	azureIdentity := r.AzureIdentity
+	if instance.Spec.AzureIdentity != "" {
+		azureIdentity = instance.Spec.AzureIdentity
+	}
+	if azureIdentity != "" {
+		svc = newAzureIdentityKeyService(&r.azureAuthorizers, azureIdentity, svc)
+	}
+	return append([]keyservice.KeyServiceClient{r.breakers.guard(svc)}, r.RemoteKeyServices...)
+}
+
+// newSecret returns secret for secret template with operator default labels
+// and annotations added
+func (r *SopsSecretReconciler) newSecret(
+	ctx context.Context,
+	cr *isindirv1alpha2.SopsSecret,
+	secretTpl *isindirv1alpha2.SopsSecretTemplate,
+	decryptor Decryptor,
+) (*corev1.Secret, error) {
+	secret, err := newSecretForCR(cr, secretTpl, decryptor, r.logger(ctx))
+	if err != nil {
+		return nil, err
+	}
+	managedLabels, managedAnnotations := r.managedSecretMetadata()
+	SetManagedMetadata(secret, managedLabels, managedAnnotations)
+	if err := r.limits().checkSecret(secret); err != nil {
+		return nil, err
+	}
+	if cr.Status.SpecHash != "" {
+		secret.Annotations[SourceGenerationAnnotation] = strconv.FormatInt(cr.Generation, 10)
+		secret.Annotations[SpecHashAnnotation] = cr.Status.SpecHash
+	}
+	return secret, nil
+}
+
+// SetManagedMetadata adds default labels and annotations to generated
+// secret, labels and annotations of its secret template take precedence
+func SetManagedMetadata(secret *corev1.Secret, labels map[string]string, annotations map[string]string) {
+	if secret.Labels == nil && len(labels) > 0 {
+		secret.Labels = make(map[string]string, len(labels))
+	}
+	for key, value := range labels {
+		if _, ok := secret.Labels[key]; !ok {
+			secret.Labels[key] = value
+		}
+	}
+	if secret.Annotations == nil && len(annotations) > 0 {
+		secret.Annotations = make(map[string]string, len(annotations))
+	}
+	for key, value := range annotations {
+		if _, ok := secret.Annotations[key]; !ok {
+			secret.Annotations[key] = value
+		}
+	}
+}
+
 // newSecretForCR returns a secret with the same namespace as the cr
 func newSecretForCR(
 	cr *isindirv1alpha2.SopsSecret,
 	secretTpl *isindirv1alpha2.SopsSecretTemplate,
+	decryptor Decryptor,
 	reqLogger logr.Logger,
 ) (*corev1.Secret, error) {
+	switch secretTpl.UpdateStrategy {
+	case "", isindirv1alpha2.PatchUpdateStrategy, isindirv1alpha2.RecreateUpdateStrategy:
+	default:
+		return nil, fmt.Errorf("newSecretForCR(): updateStrategy %q is not one of Patch, Recreate", secretTpl.UpdateStrategy)
+	}
+	switch secretTpl.MergePolicy {
+	case "", isindirv1alpha2.ReplaceMergePolicy, isindirv1alpha2.MergeMergePolicy:
+	default:
+		return nil, fmt.Errorf("newSecretForCR(): mergePolicy %q is not one of Replace, Merge", secretTpl.MergePolicy)
+	}
+
 	labels := make(map[string]string)
 	for key, value := range secretTpl.Labels {
 		labels[key] = value
@@ -273,9 +1594,68 @@ func newSecretForCR(
 		}
 		data[key] = decoded
 	}
-	for key, value := range secretTpl.Data {
+	values := secretTpl.Data
+	if secretTpl.Expand || secretTpl.Format != "" {
+		values = make(map[string]string)
+		for key, value := range secretTpl.Data {
+			expanded, err := expandDocument(value, secretTpl.Format, decryptor)
+			if err != nil {
+				return nil, fmt.Errorf("newSecretForCR(): data[%v]: %v", key, err)
+			}
+			for expandedKey, expandedValue := range expanded {
+				if _, ok := values[expandedKey]; ok {
+					return nil, fmt.Errorf("newSecretForCR(): data[%v]: key %v is defined in more than one document", key, expandedKey)
+				}
+				values[expandedKey] = expandedValue
+			}
+		}
+	}
+	tplContext := &templateContext{
+		Data:           values,
+		SopsSecretName: cr.Name,
+		Namespace:      cr.Namespace,
+	}
+	for key, value := range values {
+		if secretTpl.Templated {
+			rendered, err := renderTemplate(key, value, tplContext)
+			if err != nil {
+				// the same spec would fail again, template must be fixed
+				return nil, &permanentError{fmt.Errorf("newSecretForCR(): data[%v]: %v", key, err)}
+			}
+			value = rendered
+		}
 		data[key] = []byte(value)
 	}
+	for _, file := range secretTpl.Files {
+		content, ok := cr.Spec.Files[file.Path]
+		if !ok {
+			return nil, fmt.Errorf("newSecretForCR(): files[%v]: file %v is not defined in spec.files", file.Name, file.Path)
+		}
+		if _, ok := data[file.Name]; ok {
+			return nil, fmt.Errorf("newSecretForCR(): files[%v]: key is already defined in data", file.Name)
+		}
+		data[file.Name] = []byte(content)
+	}
+	if secretTpl.DockerConfig != nil {
+		if _, ok := data[corev1.DockerConfigJsonKey]; ok {
+			return nil, fmt.Errorf("newSecretForCR(): dockerConfig: key %v is already defined in data", corev1.DockerConfigJsonKey)
+		}
+		dockerConfig, err := dockerConfigJSON(secretTpl.DockerConfig)
+		if err != nil {
+			return nil, fmt.Errorf("newSecretForCR(): %v", err)
+		}
+		data[corev1.DockerConfigJsonKey] = dockerConfig
+	}
+	if secretTpl.HtpasswdKey != "" {
+		entry, err := htpasswdEntry(secretTpl, data)
+		if err != nil {
+			return nil, fmt.Errorf("newSecretForCR(): %v", err)
+		}
+		data[secretTpl.HtpasswdKey] = entry
+	}
+	if err := setEncryptedAnnotations(secretTpl, data, annotations); err != nil {
+		return nil, fmt.Errorf("newSecretForCR(): %v", err)
+	}
 
 	if secretTpl.Name == "" {
 		return nil, fmt.Errorf("newSecretForCR(): secret template name must be specified and not empty string")
@@ -296,6 +1676,12 @@ func newSecretForCR(
 	)
 
 	kubeSecretType := getSecretType(secretTpl.Type)
+	if secretTpl.DockerConfig != nil {
+		var err error
+		if kubeSecretType, err = dockerConfigSecretType(secretTpl); err != nil {
+			return nil, fmt.Errorf("newSecretForCR(): %v", err)
+		}
+	}
 
 	// return resulting secret
 	secret := &corev1.Secret{
@@ -308,9 +1694,66 @@ func newSecretForCR(
 		Type: kubeSecretType,
 		Data: data,
 	}
+	if _, err := tlsCertificate(secret); err != nil {
+		return nil, fmt.Errorf("newSecretForCR(): %v", err)
+	}
+	if err := normalizeSSHAuthSecret(secret); err != nil {
+		return nil, fmt.Errorf("newSecretForCR(): %v", err)
+	}
+	contentHash := secretContentHash(secret)
+	if secretTpl.Immutable {
+		immutable := true
+		secret.Immutable = &immutable
+		secret.Name = immutableSecretName(secretTpl.Name, contentHash)
+		secret.Annotations[TemplateAnnotation] = secretTpl.Name
+	}
+	setContentHash(secret, contentHash)
 	return secret, nil
 }
 
+// specHash returns sha256 hash of encrypted spec and sops metadata of
+// SopsSecret, which identifies committed content without decrypting it
+func specHash(instanceEncrypted *isindirv1alpha2.SopsSecret) string {
+	content, err := json.Marshal(struct {
+		Spec isindirv1alpha2.SopsSecretSpec `json:"spec"`
+		Sops isindirv1alpha2.SopsMetadata   `json:"sops"`
+	}{instanceEncrypted.Spec, instanceEncrypted.Sops})
+	if err != nil {
+		return ""
+	}
+	hash := sha256.Sum256(content)
+	return hex.EncodeToString(hash[:])
+}
+
+// shortContentHashLength is the number of content hash characters in
+// ShortContentHashAnnotation
+const shortContentHashLength = 12
+
+// setContentHash annotates secret with content hash and its short form
+func setContentHash(secret *corev1.Secret, contentHash string) {
+	secret.Annotations[ContentHashAnnotation] = contentHash
+	secret.Annotations[ShortContentHashAnnotation] = contentHash[:shortContentHashLength]
+}
+
+// secretContentHash returns sha256 hash of the secret type and data
+func secretContentHash(secret *corev1.Secret) string {
+	keys := make([]string, 0, len(secret.Data))
+	for key := range secret.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	hash := sha256.New()
+	hash.Write([]byte(secret.Type))
+	for _, key := range keys {
+		hash.Write([]byte{0})
+		hash.Write([]byte(key))
+		hash.Write([]byte{0})
+		hash.Write(secret.Data[key])
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
 func getSecretType(paramType string) corev1.SecretType {
 	// by default secret type is Opaque
 	kubeSecretType := corev1.SecretTypeOpaque
@@ -341,27 +1784,24 @@ func getSecretType(paramType string) corev1.SecretType {
 // decryptSopsSecretInstance decrypts spec.secretTemplates
 func decryptSopsSecretInstance(
 	instanceEncrypted *isindirv1alpha2.SopsSecret,
+	keyServices []keyservice.KeyServiceClient,
 	reqLogger logr.Logger,
 ) (*isindirv1alpha2.SopsSecret, error) {
 	instance := &isindirv1alpha2.SopsSecret{}
-	reqBodyBytes, err := json.Marshal(instanceEncrypted)
+	reqBodyBytes, err := encryptedLayouts(instanceEncrypted)
 	if err != nil {
 		reqLogger.Info(
 			"Failed to convert encrypted sops secret to bytes[]",
-			"sopssecret",
-			fmt.Sprintf("%s/%s", instanceEncrypted.Namespace, instanceEncrypted.Name),
 			"error",
 			err,
 		)
 		return nil, err
 	}
 
-	decryptedInstanceBytes, err := customDecryptData(reqBodyBytes, "json")
+	decryptedInstanceBytes, layout, err := customDecryptAlternatives(reqBodyBytes, "json", keyServices)
 	if err != nil {
 		reqLogger.Info(
 			"Failed to Decrypt encrypted sops secret instance",
-			"sopssecret",
-			fmt.Sprintf("%s/%s", instanceEncrypted.Namespace, instanceEncrypted.Name),
 			"error",
 			err,
 		)
@@ -369,28 +1809,175 @@ func decryptSopsSecretInstance(
 	}
 
 	// Decrypted instance is empty structure here
-	err = json.Unmarshal(decryptedInstanceBytes, &instance)
+	if layout == 0 {
+		err = json.Unmarshal(decryptedInstanceBytes, &instance)
+	} else {
+		instanceV1alpha3 := &isindirv1alpha3.SopsSecret{}
+		err = json.Unmarshal(decryptedInstanceBytes, instanceV1alpha3)
+		if err == nil {
+			err = instanceV1alpha3.ConvertTo(instance)
+		}
+	}
 	if err != nil {
 		reqLogger.Info(
 			"Failed to Unmarshal decrypted sops secret instance",
-			"sopssecret",
-			fmt.Sprintf("%s/%s", instanceEncrypted.Namespace, instanceEncrypted.Name),
 			"error",
 			err,
 		)
-		return nil, err
+		return nil, &permanentError{err}
 	}
 
 	return instance, nil
 }
 
+// expandDocument decrypts SOPS encrypted document of given format (yaml by
+// default, json, dotenv or ini) and returns its top level keys, non string
+// values are serialized as JSON. Keys of ini sections other than DEFAULT are
+// prefixed with section name and a dot
+func expandDocument(
+	document string,
+	format string,
+	decryptor Decryptor,
+) (map[string]string, error) {
+	if format == "" {
+		format = "yaml"
+	}
+	cleartext, err := decryptor.DecryptDocument([]byte(document), format)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case "dotenv":
+		branches, err := (&sopsdotenv.Store{}).LoadPlainFile(cleartext)
+		if err != nil {
+			return nil, &permanentError{fmt.Errorf("document is not a dotenv file: %v", err)}
+		}
+		return treeValues(branches, "")
+	case "ini":
+		branches, err := (&sopsini.Store{}).LoadPlainFile(cleartext)
+		if err != nil {
+			return nil, &permanentError{fmt.Errorf("document is not an ini file: %v", err)}
+		}
+		return treeValues(branches, "")
+	}
+
+	var tree map[string]interface{}
+	if err := yaml.Unmarshal(cleartext, &tree); err != nil {
+		return nil, &permanentError{fmt.Errorf("document is not a %s map: %v", strings.ToUpper(format), err)}
+	}
+
+	values := make(map[string]string, len(tree))
+	for key, value := range tree {
+		if str, ok := value.(string); ok {
+			values[key] = str
+			continue
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, &permanentError{err}
+		}
+		values[key] = string(encoded)
+	}
+	return values, nil
+}
+
+// treeValues returns values of dotenv or ini sops tree, nested ini section
+// keys are prefixed with section name, comments are skipped
+func treeValues(branches sops.TreeBranches, prefix string) (map[string]string, error) {
+	values := make(map[string]string)
+	for _, branch := range branches {
+		for _, item := range branch {
+			key, ok := item.Key.(string)
+			if !ok {
+				continue
+			}
+			switch value := item.Value.(type) {
+			case sops.TreeBranch:
+				sectionPrefix := prefix + key + "."
+				if key == "DEFAULT" {
+					sectionPrefix = prefix
+				}
+				section, err := treeValues(sops.TreeBranches{value}, sectionPrefix)
+				if err != nil {
+					return nil, err
+				}
+				for sectionKey, sectionValue := range section {
+					if _, ok := values[sectionKey]; ok {
+						return nil, &permanentError{fmt.Errorf("key %v is defined more than once", sectionKey)}
+					}
+					values[sectionKey] = sectionValue
+				}
+			default:
+				if _, ok := values[prefix+key]; ok {
+					return nil, &permanentError{fmt.Errorf("key %v is defined more than once", prefix+key)}
+				}
+				values[prefix+key] = fmt.Sprint(value)
+			}
+		}
+	}
+	return values, nil
+}
+
+// encryptedLayouts returns JSON of encrypted SopsSecret in v1alpha2 layout
+// and, when secret templates have data, in v1alpha3 layout. sops binds
+// encrypted values to their path, so SopsSecret written as v1alpha3 and
+// stored as v1alpha2 decrypts only in v1alpha3 layout
+func encryptedLayouts(instanceEncrypted *isindirv1alpha2.SopsSecret) ([][]byte, error) {
+	layout, err := json.Marshal(instanceEncrypted)
+	if err != nil {
+		return nil, err
+	}
+	layouts := [][]byte{layout}
+
+	hasData := false
+	for i := range instanceEncrypted.Spec.SecretsTemplate {
+		secretTemplate := &instanceEncrypted.Spec.SecretsTemplate[i]
+		if len(secretTemplate.Data) > 0 || len(secretTemplate.BinaryData) > 0 {
+			hasData = true
+			break
+		}
+	}
+	if !hasData {
+		return layouts, nil
+	}
+
+	instanceV1alpha3 := &isindirv1alpha3.SopsSecret{}
+	if err := instanceV1alpha3.ConvertFrom(instanceEncrypted.DeepCopy()); err != nil {
+		return nil, err
+	}
+	layout, err = json.Marshal(instanceV1alpha3)
+	if err != nil {
+		return nil, err
+	}
+	return append(layouts, layout), nil
+}
+
 // Data is a helper that takes encrypted data and a format string,
 // decrypts the data and returns its cleartext in an []byte.
-// The format string can be `json`, `yaml`, `dotenv` or `binary`.
+// The format string can be `json`, `yaml`, `dotenv`, `ini` or `binary`.
 // If the format string is empty, binary format is assumed.
 // NOTE: this function is taken from sops code and adjusted
 //       to ignore mac, as CR will always be mutated in k8s
-func customDecryptData(data []byte, format string) (cleartext []byte, err error) {
+func customDecryptData(
+	data []byte,
+	format string,
+	keyServices []keyservice.KeyServiceClient,
+) (cleartext []byte, err error) {
+	cleartext, _, err = customDecryptAlternatives([][]byte{data}, format, keyServices)
+	return cleartext, err
+}
+
+// customDecryptAlternatives decrypts the first of alternative layouts of the
+// same sops document which decrypts cleanly and returns its index, data key
+// is decrypted only once. Values not selected by encrypted suffix or regex are
+// passed through as they are, layout which leaves encrypted values behind is
+// skipped and the last one fails with their paths
+func customDecryptAlternatives(
+	data [][]byte,
+	format string,
+	keyServices []keyservice.KeyServiceClient,
+) (cleartext []byte, index int, err error) {
 	// Initialize a Sops JSON store
 	var store sops.Store
 	switch format {
@@ -400,28 +1987,81 @@ func customDecryptData(data []byte, format string) (cleartext []byte, err error)
 		store = &sopsyaml.Store{}
 	case "dotenv":
 		store = &sopsdotenv.Store{}
+	case "ini":
+		store = &sopsini.Store{}
 	default:
 		store = &sopsjson.BinaryStore{}
 	}
 	// Load SOPS file and access the data key
-	tree, err := store.LoadEncryptedFile(data)
+	tree, err := store.LoadEncryptedFile(data[0])
 	if err != nil {
-		return nil, err
+		return nil, 0, &permanentError{err}
+	}
+	key, err := tree.Metadata.GetDataKeyWithKeyServices(keyServices)
+	if err != nil {
+		if groupsErr := checkKeyGroups(tree.Metadata, keyServices); groupsErr != nil {
+			return nil, 0, groupsErr
+		}
 	}
-	key, err := tree.Metadata.GetDataKey()
 	if userErr, ok := err.(sops.UserError); ok {
 		err = fmt.Errorf(userErr.UserError())
 	}
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
-	// Decrypt the tree
+	// Decrypt the tree, data key is valid here, so failure means corrupted payload
 	cipher := sopsaes.NewCipher()
-	_, err = tree.Decrypt(key, cipher)
-	if err != nil {
-		return nil, err
+	for index = range data {
+		if index > 0 {
+			tree, err = store.LoadEncryptedFile(data[index])
+			if err != nil {
+				return nil, index, &permanentError{err}
+			}
+		}
+		if _, err = tree.Decrypt(key, cipher); err != nil {
+			err = &permanentError{&corruptedPayloadError{err}}
+			continue
+		}
+		if paths := encryptedValuePaths(tree.Branches); len(paths) > 0 {
+			err = &permanentError{fmt.Errorf(
+				"sops document contains values which are not decrypted, as they do not match its encrypted or unencrypted suffix or regex: %s",
+				strings.Join(paths, ", "),
+			)}
+			continue
+		}
+		cleartext, err = store.EmitPlainFile(tree.Branches)
+		return cleartext, index, err
 	}
+	return nil, index, err
+}
 
-	return store.EmitPlainFile(tree.Branches)
+// encryptedValuePaths returns paths of values of decrypted sops tree which
+// are still encrypted
+func encryptedValuePaths(branches sops.TreeBranches) []string {
+	var paths []string
+	var walk func(value interface{}, path string)
+	walk = func(value interface{}, path string) {
+		switch value := value.(type) {
+		case sops.TreeBranch:
+			for _, item := range value {
+				if _, ok := item.Key.(sops.Comment); ok {
+					continue
+				}
+				walk(item.Value, fmt.Sprintf("%s.%v", path, item.Key))
+			}
+		case []interface{}:
+			for i, item := range value {
+				walk(item, fmt.Sprintf("%s[%d]", path, i))
+			}
+		case string:
+			if strings.HasPrefix(value, "ENC[AES256_GCM,") {
+				paths = append(paths, strings.TrimPrefix(path, "."))
+			}
+		}
+	}
+	for _, branch := range branches {
+		walk(branch, "")
+	}
+	return paths
 }
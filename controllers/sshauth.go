@@ -0,0 +1,62 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// normalizeSSHAuthSecret normalizes line endings of private key of
+// kubernetes.io/ssh-auth secret to LF with trailing newline, which OpenSSH
+// requires, and validates the key format
+func normalizeSSHAuthSecret(secret *corev1.Secret) error {
+	if secret.Type != corev1.SecretTypeSSHAuth {
+		return nil
+	}
+	key := secret.Data[corev1.SSHAuthPrivateKey]
+	if len(key) == 0 {
+		return fmt.Errorf("secret %s of type %s has no %s key", secret.Name, secret.Type, corev1.SSHAuthPrivateKey)
+	}
+	key = bytes.ReplaceAll(key, []byte("\r\n"), []byte("\n"))
+	key = bytes.ReplaceAll(key, []byte("\r"), []byte("\n"))
+	key = append(bytes.TrimSpace(key), '\n')
+	if _, err := sshPublicKey(key); err != nil {
+		return fmt.Errorf("secret %s has invalid SSH private key: %v", secret.Name, err)
+	}
+	secret.Data[corev1.SSHAuthPrivateKey] = key
+	return nil
+}
+
+// sshPublicKey returns public key of SSH private key, passphrase protected
+// keys are valid, but their public key is known only for OpenSSH format
+func sshPublicKey(key []byte) (ssh.PublicKey, error) {
+	signer, err := ssh.ParsePrivateKey(key)
+	if err == nil {
+		return signer.PublicKey(), nil
+	}
+	var passphraseErr *ssh.PassphraseMissingError
+	if errors.As(err, &passphraseErr) {
+		return passphraseErr.PublicKey, nil
+	}
+	return nil, err
+}
+
+// sshKeyFingerprint returns SHA256 fingerprint of public key of
+// kubernetes.io/ssh-auth secret, or empty string when it is not known
+func sshKeyFingerprint(secret *corev1.Secret) string {
+	if secret.Type != corev1.SecretTypeSSHAuth {
+		return ""
+	}
+	publicKey, err := sshPublicKey(secret.Data[corev1.SSHAuthPrivateKey])
+	if err != nil || publicKey == nil {
+		return ""
+	}
+	return ssh.FingerprintSHA256(publicKey)
+}
@@ -0,0 +1,149 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// Reasons of decryption failures
+const (
+	// KeyBackendUnavailableReason is reason of failure to reach KMS, Vault or
+	// key service, which is expected to resolve by itself
+	KeyBackendUnavailableReason = "KeyBackendUnavailable"
+	// KeyRejectedReason is reason of failure of key backend or local keys to
+	// decrypt the data key, which requires credentials or SopsSecret change
+	KeyRejectedReason = "KeyRejected"
+	// CorruptedPayloadReason is reason of encrypted values or SOPS MAC
+	// failing authentication with the data key
+	CorruptedPayloadReason = "CorruptedPayload"
+	// DecryptionFailedReason is reason of other decryption failures, such as
+	// malformed sops metadata
+	DecryptionFailedReason = "DecryptionFailed"
+)
+
+// unavailableMessages are fragments of errors returned by sops key sources
+// when key backend can not be reached or is overloaded. sops reports data key
+// failures of all master keys as text, so original errors are lost
+var unavailableMessages = []string{
+	"connection refused",
+	"connection reset",
+	"no such host",
+	"i/o timeout",
+	"TLS handshake timeout",
+	"context deadline exceeded",
+	"send request failed",
+	"ServiceUnavailable",
+	"code = Unavailable",
+	"ThrottlingException",
+	"RequestLimitExceeded",
+	"TooManyRequests",
+	"status code: 500",
+	"status code: 502",
+	"status code: 503",
+	"status code: 504",
+	"Code: 500",
+	"Code: 502",
+	"Code: 503",
+	"Code: 504",
+}
+
+// rejectedMessages are fragments of errors returned by sops key sources when
+// data key can not be decrypted with available credentials
+var rejectedMessages = []string{
+	"AccessDenied",
+	"PermissionDenied",
+	"permission denied",
+	"Unauthorized",
+	"Forbidden",
+	"Code: 403",
+	"status code: 403",
+	"InvalidCiphertext",
+	"IncorrectKey",
+	"no identity matched",
+	"could not decrypt data key with PGP key",
+	"openpgp: incorrect key",
+	"has no credentials for",
+}
+
+// classifyDecryptionFailure returns reason and failure class of SopsSecret
+// decryption error. Unreachable key backend and rejected data key are
+// credential failures, retried until the key backend or credentials are
+// fixed, even when other master keys reject the data key, as the unreachable
+// one may decrypt it later. Corrupted payload and invalid sops metadata are
+// permanent failures
+func classifyDecryptionFailure(err error) (string, failureClass) {
+	if isCorruptedPayload(err) {
+		return CorruptedPayloadReason, permanentFailure
+	}
+	if class := classifyFailure(err); class == permanentFailure {
+		return DecryptionFailedReason, class
+	}
+	if isBackendUnavailable(err) {
+		return KeyBackendUnavailableReason, credentialFailure
+	}
+	message := err.Error()
+	for _, fragment := range rejectedMessages {
+		if strings.Contains(message, fragment) {
+			return KeyRejectedReason, credentialFailure
+		}
+	}
+	return DecryptionFailedReason, credentialFailure
+}
+
+// isBackendUnavailable reports whether the error is caused by unreachable or
+// overloaded key backend, or by its open circuit breaker
+func isBackendUnavailable(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	message := err.Error()
+	if strings.Contains(message, circuitOpenMessage) {
+		return true
+	}
+	for _, fragment := range unavailableMessages {
+		if strings.Contains(message, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// setStale records unavailable key backend of SopsSecret which was
+// reconciled before, generated secrets are left intact, so Ready condition of
+// the last reconciliation is kept and SopsSecret is progressing as the
+// failure is retried. It reports whether SopsSecret has generated secrets
+// which became stale
+func setStale(instance *isindirv1alpha2.SopsSecret, message string) bool {
+	status := &instance.Status
+	if len(status.ManagedSecrets) == 0 {
+		return false
+	}
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               isindirv1alpha2.StaleCondition,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: instance.Generation,
+		Reason:             KeyBackendUnavailableReason,
+		Message:            message,
+	})
+	meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+		Type:               isindirv1alpha2.ProgressingCondition,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: instance.Generation,
+		Reason:             KeyBackendUnavailableReason,
+		Message:            message,
+	})
+	meta.RemoveStatusCondition(&status.Conditions, isindirv1alpha2.StalledCondition)
+	return true
+}
@@ -0,0 +1,100 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// StuckReason is reason of Warning events of SopsSecrets which are not
+// ready for longer than stuck threshold
+const StuckReason = "Stuck"
+
+// stuckScanner periodically reports SopsSecrets whose Ready condition is
+// False for longer than threshold, so failures which are not retried, or
+// retried without success, are noticed
+type stuckScanner struct {
+	reconciler *SopsSecretReconciler
+	threshold  time.Duration
+	interval   time.Duration
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, only the
+// leader reports stuck SopsSecrets, so events are not duplicated
+func (s *stuckScanner) NeedLeaderElection() bool {
+	return true
+}
+
+// Start implements manager.Runnable, it scans SopsSecrets every interval
+// until the context is done
+func (s *stuckScanner) Start(ctx context.Context) error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			s.scan(ctx)
+		}
+	}
+}
+
+// scan emits Warning event for every stuck SopsSecret and exports number of
+// stuck SopsSecrets per namespace
+func (s *stuckScanner) scan(ctx context.Context) {
+	r := s.reconciler
+	sopsSecrets := &isindirv1alpha2.SopsSecretList{}
+	if err := r.List(ctx, sopsSecrets); err != nil {
+		r.Log.Info("Listing SopsSecrets for stuck detection error", "error", err)
+		return
+	}
+
+	stuck := make(map[string]int)
+	for i := range sopsSecrets.Items {
+		sopsSecret := &sopsSecrets.Items[i]
+		if !r.watched(sopsSecret) || sopsSecret.Spec.Suspend {
+			continue
+		}
+		since, ok := stuckSince(sopsSecret, s.threshold)
+		if !ok {
+			continue
+		}
+		stuck[sopsSecret.Namespace]++
+		ready := meta.FindStatusCondition(sopsSecret.Status.Conditions, isindirv1alpha2.ReadyCondition)
+		r.Recorder.Eventf(
+			sopsSecret,
+			corev1.EventTypeWarning,
+			StuckReason,
+			"SopsSecret is not ready for %s: %s: %s",
+			time.Since(since).Round(time.Minute),
+			ready.Reason,
+			ready.Message,
+		)
+	}
+
+	stuckSopsSecrets.Reset()
+	for namespace, count := range stuck {
+		stuckSopsSecrets.WithLabelValues(namespace).Set(float64(count))
+	}
+}
+
+// stuckSince returns the time SopsSecret became not ready, if its Ready
+// condition is False for longer than threshold
+func stuckSince(sopsSecret *isindirv1alpha2.SopsSecret, threshold time.Duration) (time.Time, bool) {
+	ready := meta.FindStatusCondition(sopsSecret.Status.Conditions, isindirv1alpha2.ReadyCondition)
+	if ready == nil || ready.Status != metav1.ConditionFalse {
+		return time.Time{}, false
+	}
+	since := ready.LastTransitionTime.Time
+	return since, time.Since(since) > threshold
+}
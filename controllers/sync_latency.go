@@ -0,0 +1,77 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// syncTracker measures propagation latency of SopsSecret generations, from
+// the time generation is first seen until its secrets are synced
+type syncTracker struct {
+	lock    sync.Mutex
+	pending map[types.NamespacedName]pendingSync
+}
+
+// pendingSync is SopsSecret generation which is not synced yet
+type pendingSync struct {
+	generation int64
+	since      time.Time
+}
+
+// start records the time generation of SopsSecret is first seen, unless it is
+// already synced. Generation of new SopsSecret is seen at its creation
+func (t *syncTracker) start(name types.NamespacedName, instance *isindirv1alpha2.SopsSecret) {
+	ready := meta.FindStatusCondition(instance.Status.Conditions, isindirv1alpha2.ReadyCondition)
+	if ready != nil && ready.Status == metav1.ConditionTrue && ready.ObservedGeneration == instance.Generation {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.pending == nil {
+		t.pending = make(map[types.NamespacedName]pendingSync)
+	}
+	if pending, ok := t.pending[name]; ok && pending.generation == instance.Generation {
+		return
+	}
+	since := time.Now()
+	if instance.Generation == 1 && instance.Status.ObservedGeneration == 0 {
+		since = instance.CreationTimestamp.Time
+	}
+	t.pending[name] = pendingSync{generation: instance.Generation, since: since}
+}
+
+// synced records successful sync of SopsSecret generation
+func (t *syncTracker) synced(name types.NamespacedName, generation int64) {
+	lastSyncTimestamp.WithLabelValues(name.Namespace, name.Name).SetToCurrentTime()
+
+	t.lock.Lock()
+	pending, ok := t.pending[name]
+	delete(t.pending, name)
+	t.lock.Unlock()
+	if !ok || pending.generation != generation {
+		return
+	}
+	latency := time.Since(pending.since).Seconds()
+	propagationLatency.Observe(latency)
+	lastPropagationLatency.WithLabelValues(name.Namespace, name.Name).Set(latency)
+}
+
+// forget drops pending sync and metrics of deleted SopsSecret
+func (t *syncTracker) forget(name types.NamespacedName) {
+	t.lock.Lock()
+	delete(t.pending, name)
+	t.lock.Unlock()
+	lastSyncTimestamp.DeleteLabelValues(name.Namespace, name.Name)
+	lastPropagationLatency.DeleteLabelValues(name.Namespace, name.Name)
+}
@@ -0,0 +1,61 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// templateContext is data available to templated secret values
+type templateContext struct {
+	// Data holds decrypted values of all data keys of the secret template
+	Data map[string]string
+	// SopsSecretName is the name of SopsSecret
+	SopsSecretName string
+	// Namespace is the namespace of SopsSecret
+	Namespace string
+}
+
+// templateFuncs are functions available to templated secret values
+var templateFuncs = template.FuncMap{
+	"b64enc": func(value string) string {
+		return base64.StdEncoding.EncodeToString([]byte(value))
+	},
+	"b64dec": func(value string) (string, error) {
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		return string(decoded), err
+	},
+	"indent": func(spaces int, value string) string {
+		pad := strings.Repeat(" ", spaces)
+		return pad + strings.Replace(value, "\n", "\n"+pad, -1)
+	},
+	"nindent": func(spaces int, value string) string {
+		pad := strings.Repeat(" ", spaces)
+		return "\n" + pad + strings.Replace(value, "\n", "\n"+pad, -1)
+	},
+	"quote": strconv.Quote,
+	"trim":  strings.TrimSpace,
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// renderTemplate renders value as go template
+func renderTemplate(name string, value string, ctx *templateContext) (string, error) {
+	tpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(value)
+	if err != nil {
+		return "", fmt.Errorf("template %s parse error: %v", name, err)
+	}
+
+	var out bytes.Buffer
+	if err := tpl.Execute(&out, ctx); err != nil {
+		return "", fmt.Errorf("template %s render error: %v", name, err)
+	}
+	return out.String(), nil
+}
@@ -0,0 +1,127 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// timingStatusSamples is the number of reconciliations timings are kept for
+// in SopsSecret status
+const timingStatusSamples = 5
+
+// reconcileTiming accumulates durations of reconciliation phases, secret
+// templates add their render and apply durations
+type reconcileTiming struct {
+	lock       sync.Mutex
+	decryption time.Duration
+	render     time.Duration
+	apply      time.Duration
+}
+
+type reconcileTimingKey struct{}
+
+// withReconcileTiming returns context carrying timing of reconciliation
+func withReconcileTiming(ctx context.Context, timing *reconcileTiming) context.Context {
+	return context.WithValue(ctx, reconcileTimingKey{}, timing)
+}
+
+// timingFrom returns timing of reconciliation carried by the context, nil
+// timing ignores observations
+func timingFrom(ctx context.Context) *reconcileTiming {
+	timing, _ := ctx.Value(reconcileTimingKey{}).(*reconcileTiming)
+	return timing
+}
+
+// Reconciliation phases of timing status
+const (
+	decryptionPhase = "decryption"
+	renderPhase     = "render"
+	applyPhase      = "apply"
+)
+
+// observe adds time elapsed since start to the phase duration
+func (t *reconcileTiming) observe(phase string, start time.Time) {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	elapsed := time.Since(start)
+	switch phase {
+	case decryptionPhase:
+		t.decryption += elapsed
+	case renderPhase:
+		t.render += elapsed
+	case applyPhase:
+		t.apply += elapsed
+	}
+}
+
+// recordTiming prepends timing of the reconciliation to SopsSecret status,
+// keeping the last timingStatusSamples ones, timings are removed when timing
+// status is disabled
+func (r *SopsSecretReconciler) recordTiming(ctx context.Context, instance *isindirv1alpha2.SopsSecret) {
+	timing := timingFrom(ctx)
+	if !r.EnableTimingStatus || timing == nil {
+		instance.Status.Timings = nil
+		return
+	}
+	timing.lock.Lock()
+	sample := isindirv1alpha2.ReconcileTiming{
+		Time:       metav1.Now(),
+		Decryption: metav1.Duration{Duration: timing.decryption.Round(time.Millisecond)},
+		Render:     metav1.Duration{Duration: timing.render.Round(time.Millisecond)},
+		Apply:      metav1.Duration{Duration: timing.apply.Round(time.Millisecond)},
+	}
+	timing.lock.Unlock()
+
+	timings := append([]isindirv1alpha2.ReconcileTiming{sample}, instance.Status.Timings...)
+	if len(timings) > timingStatusSamples {
+		timings = timings[:timingStatusSamples]
+	}
+	instance.Status.Timings = timings
+}
+
+// timingUpdatePredicate drops SopsSecret updates which change only timing
+// status, SopsSecret status updates are watched and recorded timings would
+// otherwise trigger reconciliation again
+var timingUpdatePredicate = predicate.Funcs{
+	UpdateFunc: func(evt event.UpdateEvent) bool {
+		oldObj, ok := evt.ObjectOld.(*isindirv1alpha2.SopsSecret)
+		if !ok {
+			return true
+		}
+		newObj, ok := evt.ObjectNew.(*isindirv1alpha2.SopsSecret)
+		if !ok {
+			return true
+		}
+		return !timingOnlyChange(oldObj, newObj)
+	},
+}
+
+// timingOnlyChange reports whether SopsSecrets differ only in timing status
+func timingOnlyChange(oldObj *isindirv1alpha2.SopsSecret, newObj *isindirv1alpha2.SopsSecret) bool {
+	if apiequality.Semantic.DeepEqual(oldObj.Status.Timings, newObj.Status.Timings) {
+		return false
+	}
+	oldCopy, newCopy := oldObj.DeepCopy(), newObj.DeepCopy()
+	for _, obj := range []*isindirv1alpha2.SopsSecret{oldCopy, newCopy} {
+		obj.ResourceVersion = ""
+		obj.ObjectMeta.ManagedFields = nil
+		obj.Status.Timings = nil
+	}
+	return apiequality.Semantic.DeepEqual(oldCopy, newCopy)
+}
@@ -0,0 +1,69 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// tlsCertificate returns leaf certificate of kubernetes.io/tls secret, the
+// certificate chain and the private key must be PEM encoded and the key must
+// match the certificate. Secrets of other types have no certificate
+func tlsCertificate(secret *corev1.Secret) (*x509.Certificate, error) {
+	if secret.Type != corev1.SecretTypeTLS {
+		return nil, nil
+	}
+	for _, key := range []string{corev1.TLSCertKey, corev1.TLSPrivateKeyKey} {
+		if len(secret.Data[key]) == 0 {
+			return nil, fmt.Errorf("secret %s of type %s has no %s key", secret.Name, secret.Type, key)
+		}
+	}
+	pair, err := tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return nil, fmt.Errorf("secret %s has invalid TLS key pair: %v", secret.Name, err)
+	}
+	certificate, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("secret %s has invalid TLS certificate: %v", secret.Name, err)
+	}
+	return certificate, nil
+}
+
+// checkCertificateExpiry records certificate of TLS secret expiring within
+// CertificateExpiryWarning in CertificateExpiring condition
+func (r *SopsSecretReconciler) checkCertificateExpiry(instance *isindirv1alpha2.SopsSecret, secret *corev1.Secret) {
+	if r.CertificateExpiryWarning <= 0 {
+		return
+	}
+	certificate, err := tlsCertificate(secret)
+	if err != nil || certificate == nil {
+		return
+	}
+	if time.Until(certificate.NotAfter) > r.CertificateExpiryWarning {
+		return
+	}
+
+	message := fmt.Sprintf("certificate of secret %s expires at %s", secret.Name, certificate.NotAfter.UTC().Format(time.RFC3339))
+	// condition collects certificates of all templates
+	if condition := meta.FindStatusCondition(instance.Status.Conditions, isindirv1alpha2.CertificateExpiringCondition); condition != nil {
+		message = condition.Message + ", " + message
+	}
+	meta.SetStatusCondition(&instance.Status.Conditions, metav1.Condition{
+		Type:               isindirv1alpha2.CertificateExpiringCondition,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: instance.Generation,
+		Reason:             "CertificateExpiring",
+		Message:            message,
+	})
+}
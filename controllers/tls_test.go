@@ -0,0 +1,114 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// selfSignedKeyPair returns PEM certificate and private key of a new
+// self-signed certificate
+func selfSignedKeyPair(t *testing.T, commonName string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}))
+}
+
+func TestNewSecretTLS(t *testing.T) {
+	cert, key := selfSignedKeyPair(t, "app.example.com")
+	_, otherKey := selfSignedKeyPair(t, "other.example.com")
+
+	tests := []struct {
+		name string
+		data map[string]string
+		err  string
+	}{
+		{
+			name: "valid key pair",
+			data: map[string]string{"tls.crt": cert, "tls.key": key},
+		},
+		{
+			name: "key does not match certificate",
+			data: map[string]string{"tls.crt": cert, "tls.key": otherKey},
+			err:  "secret app-tls has invalid TLS key pair",
+		},
+		{
+			name: "certificate is not PEM",
+			data: map[string]string{"tls.crt": "not a certificate", "tls.key": key},
+			err:  "secret app-tls has invalid TLS key pair",
+		},
+		{
+			name: "missing key",
+			data: map[string]string{"tls.crt": cert},
+			err:  "secret app-tls of type kubernetes.io/tls has no tls.key key",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := &isindirv1alpha2.SopsSecret{}
+			instance.Name = "app"
+			instance.Namespace = "default"
+			secretTpl := &isindirv1alpha2.SopsSecretTemplate{Name: "app-tls", Type: "kubernetes.io/tls", Data: tt.data}
+
+			secret, err := newSecretForCR(instance, secretTpl, nil, logr.Discard())
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if secret.Type != corev1.SecretTypeTLS {
+				t.Errorf("secret type = %s, want %s", secret.Type, corev1.SecretTypeTLS)
+			}
+			if string(secret.Data[corev1.TLSCertKey]) != cert {
+				t.Errorf("%s does not hold the certificate", corev1.TLSCertKey)
+			}
+			if string(secret.Data[corev1.TLSPrivateKeyKey]) != key {
+				t.Errorf("%s does not hold the private key", corev1.TLSPrivateKeyKey)
+			}
+			certificate, err := tlsCertificate(secret)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if certificate.Subject.CommonName != "app.example.com" {
+				t.Errorf("leaf certificate common name = %s, want app.example.com", certificate.Subject.CommonName)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"context"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpgrpc"
+	"go.opentelemetry.io/otel/propagation"
+	sdkresource "go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// tracer is obtained from global tracer provider, spans are not recorded
+// unless SetupTracing configures exporter
+var tracer = otel.Tracer("github.com/isindir/sops-secrets-operator/controllers")
+
+// SetupTracing exports spans with OTLP over gRPC when
+// OTEL_EXPORTER_OTLP_ENDPOINT or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set,
+// exporter is configured by standard OTEL_EXPORTER_OTLP_* environment
+// variables. Returned function flushes and stops the exporter
+func SetupTracing(ctx context.Context) (func(context.Context) error, error) {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlp.NewExporter(ctx, otlpgrpc.NewDriver())
+	if err != nil {
+		return nil, err
+	}
+	resource, err := sdkresource.New(ctx, sdkresource.WithAttributes(semconv.ServiceNameKey.String("sops-secrets-operator")))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// startSpan starts span of reconcile phase
+func startSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// endSpan records error of reconcile phase and ends its span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// keyBackends returns sops key backends SopsSecret data key is encrypted with
+func keyBackends(instance *isindirv1alpha2.SopsSecret) []string {
+	var kms, gcpKms, azureKv, hcVault, pgp, age bool
+	for _, group := range sopsKeyGroups(&instance.Sops) {
+		kms = kms || len(group.AwsKms) > 0
+		gcpKms = gcpKms || len(group.GcpKms) > 0
+		azureKv = azureKv || len(group.AzureKms) > 0
+		hcVault = hcVault || len(group.HcVault) > 0
+		pgp = pgp || len(group.Pgp) > 0
+		age = age || len(group.Age) > 0
+	}
+
+	var backends []string
+	if kms {
+		backends = append(backends, "kms")
+	}
+	if gcpKms {
+		backends = append(backends, "gcp_kms")
+	}
+	if azureKv {
+		backends = append(backends, "azure_kv")
+	}
+	if hcVault {
+		backends = append(backends, "hc_vault")
+	}
+	if pgp {
+		backends = append(backends, "pgp")
+	}
+	if age {
+		backends = append(backends, "age")
+	}
+	return backends
+}
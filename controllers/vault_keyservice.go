@@ -0,0 +1,97 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"path"
+
+	"go.mozilla.org/sops/v3/keyservice"
+	"google.golang.org/grpc"
+)
+
+// vaultKeyService is a sops key service client which decrypts Hashicorp Vault
+// transit keys in-process using the token maintained by VaultAuth, all other
+// key types are delegated to the local sops key service
+type vaultKeyService struct {
+	auth      *VaultAuth
+	namespace string
+	local     keyservice.KeyServiceClient
+}
+
+// newVaultKeyService returns Vault key service, non-empty namespace overrides
+// Vault Enterprise namespace of the authenticator
+func newVaultKeyService(auth *VaultAuth, namespace string) keyservice.KeyServiceClient {
+	return &vaultKeyService{
+		auth:      auth,
+		namespace: namespace,
+		local:     keyservice.NewLocalClient(),
+	}
+}
+
+// Encrypt is not used by the operator and is always delegated to the local key service
+func (ks *vaultKeyService) Encrypt(
+	ctx context.Context,
+	req *keyservice.EncryptRequest,
+	opts ...grpc.CallOption,
+) (*keyservice.EncryptResponse, error) {
+	return ks.local.Encrypt(ctx, req, opts...)
+}
+
+// Decrypt decrypts data key using Vault transit engine if key is a Vault key
+func (ks *vaultKeyService) Decrypt(
+	ctx context.Context,
+	req *keyservice.DecryptRequest,
+	opts ...grpc.CallOption,
+) (*keyservice.DecryptResponse, error) {
+	vaultKey := req.Key.GetVaultKey()
+	if vaultKey == nil {
+		return ks.local.Decrypt(ctx, req, opts...)
+	}
+	token := ks.auth.Token()
+	if token == "" {
+		return nil, fmt.Errorf("vault token is not obtained yet")
+	}
+
+	client, err := ks.auth.client.Clone()
+	if err != nil {
+		return nil, err
+	}
+	if vaultKey.VaultAddress != "" {
+		if err := client.SetAddress(vaultKey.VaultAddress); err != nil {
+			return nil, err
+		}
+	}
+	client.SetToken(token)
+	namespace := ks.namespace
+	if namespace == "" {
+		namespace = ks.auth.namespace
+	}
+	if namespace != "" {
+		client.SetNamespace(namespace)
+	}
+
+	secret, err := client.Logical().Write(
+		path.Join(vaultKey.EnginePath, "decrypt", vaultKey.KeyName),
+		map[string]interface{}{"ciphertext": string(req.Ciphertext)},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, fmt.Errorf("vault transit returned empty response for key %s", vaultKey.KeyName)
+	}
+	encoded, ok := secret.Data["plaintext"].(string)
+	if !ok {
+		return nil, fmt.Errorf("vault transit response for key %s has no plaintext", vaultKey.KeyName)
+	}
+	plaintext, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	return &keyservice.DecryptResponse{Plaintext: plaintext}, nil
+}
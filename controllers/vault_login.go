@@ -0,0 +1,289 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	kubernetesauth "github.com/hashicorp/vault/api/auth/kubernetes"
+	userpassauth "github.com/hashicorp/vault/api/auth/userpass"
+)
+
+// VaultLoginMethod obtains Vault token, it is used with client.Auth().Login
+type VaultLoginMethod = api.AuthMethod
+
+// VaultLoginConfig holds settings of Vault login methods, each method uses
+// only the settings it needs
+type VaultLoginConfig struct {
+	// Path is login path under auth/, for example kubernetes/login, methods
+	// of Vault auth packages require it to end with /login
+	Path string
+	// Role is Vault role to log in with
+	Role string
+	// TokenPath is file with JWT used by kubernetes and jwt methods
+	TokenPath string
+	// TokenAudience is audience JWT must be issued for, it requires bound
+	// projected service account token with expiry
+	TokenAudience string
+	// Username is user name used by userpass method
+	Username string
+	// PasswordPath is file with password used by userpass method
+	PasswordPath string
+}
+
+// VaultLoginMethodFactory creates login method from configuration
+type VaultLoginMethodFactory func(config VaultLoginConfig) (VaultLoginMethod, error)
+
+var (
+	vaultLoginMethodsLock sync.RWMutex
+	vaultLoginMethods     = map[string]VaultLoginMethodFactory{
+		"kubernetes": newKubernetesLogin,
+		"jwt":        newJWTLogin,
+		"cert":       newCertLogin,
+		"userpass":   newUserpassLogin,
+	}
+)
+
+// RegisterVaultLoginMethod registers additional Vault login method
+func RegisterVaultLoginMethod(name string, factory VaultLoginMethodFactory) {
+	vaultLoginMethodsLock.Lock()
+	defer vaultLoginMethodsLock.Unlock()
+	vaultLoginMethods[name] = factory
+}
+
+// NewVaultLoginMethod creates registered Vault login method
+func NewVaultLoginMethod(name string, config VaultLoginConfig) (VaultLoginMethod, error) {
+	vaultLoginMethodsLock.RLock()
+	factory, ok := vaultLoginMethods[name]
+	if !ok {
+		names := make([]string, 0, len(vaultLoginMethods))
+		for n := range vaultLoginMethods {
+			names = append(names, n)
+		}
+		vaultLoginMethodsLock.RUnlock()
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown vault login method %q, supported: %s", name, strings.Join(names, ", "))
+	}
+	vaultLoginMethodsLock.RUnlock()
+	if config.Path == "" {
+		return nil, fmt.Errorf("vault login method %q requires login path", name)
+	}
+	return factory(config)
+}
+
+// vaultMountPath returns mount path of auth method from login path, for
+// methods of Vault auth packages which append /login to mount path
+func vaultMountPath(method string, loginPath string) (string, error) {
+	mountPath := strings.TrimSuffix(strings.Trim(loginPath, "/"), "/login")
+	if mountPath == strings.Trim(loginPath, "/") || mountPath == "" {
+		return "", fmt.Errorf("vault %s login path %q must be <mount path>/login", method, loginPath)
+	}
+	return mountPath, nil
+}
+
+// jwtLogin logs in with jwt/oidc auth method, JWT is read on every login as
+// projected service account tokens are rotated
+type jwtLogin struct {
+	path      string
+	role      string
+	tokenPath string
+	audience  string
+}
+
+func newJWTLogin(config VaultLoginConfig) (VaultLoginMethod, error) {
+	return newJWTFileLogin(config)
+}
+
+func newJWTFileLogin(config VaultLoginConfig) (*jwtLogin, error) {
+	if config.Role == "" || config.TokenPath == "" {
+		return nil, fmt.Errorf("vault jwt login requires role and token path")
+	}
+	l := &jwtLogin{path: config.Path, role: config.Role, tokenPath: config.TokenPath, audience: config.TokenAudience}
+
+	// legacy token never becomes valid, so fail at startup instead of retrying
+	if l.audience != "" {
+		if _, err := l.readToken(); err != nil && err != errJWTExpired && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func (l *jwtLogin) Login(ctx context.Context, client *api.Client) (*api.Secret, error) {
+	jwt, err := l.readToken()
+	if err != nil {
+		return nil, err
+	}
+	return vaultLogin(client, l.path, map[string]interface{}{
+		"jwt":  jwt,
+		"role": l.role,
+	})
+}
+
+// kubernetesLogin logs in with Vault kubernetes auth package, service
+// account token is read and checked on every login the same way as by
+// jwtLogin
+type kubernetesLogin struct {
+	token     *jwtLogin
+	mountPath string
+}
+
+func newKubernetesLogin(config VaultLoginConfig) (VaultLoginMethod, error) {
+	mountPath, err := vaultMountPath("kubernetes", config.Path)
+	if err != nil {
+		return nil, err
+	}
+	token, err := newJWTFileLogin(config)
+	if err != nil {
+		return nil, err
+	}
+	return &kubernetesLogin{token: token, mountPath: mountPath}, nil
+}
+
+func (l *kubernetesLogin) Login(ctx context.Context, client *api.Client) (*api.Secret, error) {
+	jwt, err := l.token.readToken()
+	if err != nil {
+		return nil, err
+	}
+	method, err := kubernetesauth.NewKubernetesAuth(
+		l.token.role,
+		kubernetesauth.WithServiceAccountToken(jwt),
+		kubernetesauth.WithMountPath(l.mountPath),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return method.Login(ctx, client)
+}
+
+var errJWTExpired = fmt.Errorf("service account token is expired")
+
+// readToken reads JWT and, when audience is configured, checks that it is
+// an unexpired bound token issued for the audience
+func (l *jwtLogin) readToken() (string, error) {
+	data, err := ioutil.ReadFile(l.tokenPath)
+	if err != nil {
+		return "", err
+	}
+	jwt := strings.TrimSpace(string(data))
+	if l.audience == "" {
+		return jwt, nil
+	}
+
+	claims, err := parseJWTClaims(jwt)
+	if err != nil {
+		return "", fmt.Errorf("service account token %s: %v", l.tokenPath, err)
+	}
+	if claims.Expiry == 0 {
+		return "", fmt.Errorf(
+			"service account token %s is a legacy token without expiry, mount projected service account token with audience %s",
+			l.tokenPath,
+			l.audience,
+		)
+	}
+	if !claims.hasAudience(l.audience) {
+		return "", fmt.Errorf("service account token %s is not issued for audience %s", l.tokenPath, l.audience)
+	}
+	if time.Now().After(time.Unix(claims.Expiry, 0)) {
+		return "", errJWTExpired
+	}
+	return jwt, nil
+}
+
+// jwtClaims are JWT claims checked before login
+type jwtClaims struct {
+	Expiry   int64           `json:"exp"`
+	Audience json.RawMessage `json:"aud"`
+}
+
+// hasAudience returns true if aud claim, a string or list of strings,
+// contains the audience
+func (c *jwtClaims) hasAudience(audience string) bool {
+	var audiences []string
+	if err := json.Unmarshal(c.Audience, &audiences); err != nil {
+		var single string
+		if err := json.Unmarshal(c.Audience, &single); err != nil {
+			return false
+		}
+		audiences = []string{single}
+	}
+	for _, aud := range audiences {
+		if aud == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// parseJWTClaims decodes JWT payload without verifying the signature, Vault
+// verifies the token
+func parseJWTClaims(jwt string) (*jwtClaims, error) {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, fmt.Errorf("malformed JWT payload: %v", err)
+	}
+	claims := &jwtClaims{}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, fmt.Errorf("malformed JWT claims: %v", err)
+	}
+	return claims, nil
+}
+
+// certLogin logs in with TLS certificate auth method, client certificate is
+// configured by VaultTLSConfig or VAULT_CLIENT_CERT and VAULT_CLIENT_KEY
+// environment variables
+type certLogin struct {
+	path string
+	role string
+}
+
+func newCertLogin(config VaultLoginConfig) (VaultLoginMethod, error) {
+	return &certLogin{path: config.Path, role: config.Role}, nil
+}
+
+func (l *certLogin) Login(ctx context.Context, client *api.Client) (*api.Secret, error) {
+	data := map[string]interface{}{}
+	if l.role != "" {
+		data["name"] = l.role
+	}
+	return vaultLogin(client, l.path, data)
+}
+
+// newUserpassLogin returns login method of Vault userpass auth package,
+// password is read from the file on every login
+func newUserpassLogin(config VaultLoginConfig) (VaultLoginMethod, error) {
+	if config.Username == "" || config.PasswordPath == "" {
+		return nil, fmt.Errorf("vault userpass login requires username and password path")
+	}
+	mountPath, err := vaultMountPath("userpass", config.Path)
+	if err != nil {
+		return nil, err
+	}
+	return userpassauth.NewUserpassAuth(
+		config.Username,
+		&userpassauth.Password{FromFile: config.PasswordPath},
+		userpassauth.WithMountPath(mountPath),
+	)
+}
+
+// vaultLogin writes login request to auth/<loginPath>, for methods without
+// Vault auth package, client.Auth().Login checks that token was issued
+func vaultLogin(client *api.Client, loginPath string, data map[string]interface{}) (*api.Secret, error) {
+	return client.Logical().Write("auth/"+strings.Trim(loginPath, "/"), data)
+}
@@ -0,0 +1,232 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// mockVault records login requests and responds with a token or an error
+type mockVault struct {
+	path      string
+	namespace string
+	body      map[string]interface{}
+	status    int
+}
+
+func (m *mockVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.path = r.URL.Path
+	m.namespace = r.Header.Get("X-Vault-Namespace")
+	m.body = map[string]interface{}{}
+	json.NewDecoder(r.Body).Decode(&m.body)
+
+	w.Header().Set("Content-Type", "application/json")
+	if m.status != 0 {
+		w.WriteHeader(m.status)
+		w.Write([]byte(`{"errors":["permission denied"]}`))
+		return
+	}
+	w.Write([]byte(`{"auth":{"client_token":"s.mock","lease_duration":3600,"renewable":true}}`))
+}
+
+func writeTestFile(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newMockVaultAuth(t *testing.T, mock *mockVault, namespace string, method VaultLoginMethod) *VaultAuth {
+	server := httptest.NewServer(mock)
+	t.Cleanup(server.Close)
+
+	auth, err := CreateVaultAuth(server.URL, namespace, method)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return auth
+}
+
+func TestKubernetesLogin(t *testing.T) {
+	method, err := NewVaultLoginMethod("kubernetes", VaultLoginConfig{
+		Path:      "kubernetes/login",
+		Role:      "operator",
+		TokenPath: writeTestFile(t, "token", "jwt-token\n"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock := &mockVault{}
+	auth := newMockVaultAuth(t, mock, "", method)
+
+	secret, err := auth.authenticate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Auth.ClientToken != "s.mock" || secret.Auth.LeaseDuration != 3600 {
+		t.Errorf("unexpected auth %+v", secret.Auth)
+	}
+	if mock.path != "/v1/auth/kubernetes/login" {
+		t.Errorf("unexpected login path %s", mock.path)
+	}
+	if mock.body["jwt"] != "jwt-token" || mock.body["role"] != "operator" {
+		t.Errorf("unexpected login body %v", mock.body)
+	}
+}
+
+func TestUserpassLogin(t *testing.T) {
+	method, err := NewVaultLoginMethod("userpass", VaultLoginConfig{
+		Path:         "userpass/login",
+		Username:     "operator",
+		PasswordPath: writeTestFile(t, "password", "secret"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock := &mockVault{}
+	auth := newMockVaultAuth(t, mock, "team-a", method)
+
+	if _, err := auth.authenticate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if mock.path != "/v1/auth/userpass/login/operator" {
+		t.Errorf("unexpected login path %s", mock.path)
+	}
+	if mock.body["password"] != "secret" {
+		t.Errorf("unexpected login body %v", mock.body)
+	}
+	if mock.namespace != "team-a" {
+		t.Errorf("expected namespace header team-a, got %q", mock.namespace)
+	}
+}
+
+func TestCertLogin(t *testing.T) {
+	method, err := NewVaultLoginMethod("cert", VaultLoginConfig{Path: "cert/login", Role: "operator"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock := &mockVault{}
+	auth := newMockVaultAuth(t, mock, "", method)
+
+	if _, err := auth.authenticate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if mock.path != "/v1/auth/cert/login" || mock.body["name"] != "operator" {
+		t.Errorf("unexpected login request %s %v", mock.path, mock.body)
+	}
+}
+
+func TestLoginDenied(t *testing.T) {
+	method, err := NewVaultLoginMethod("jwt", VaultLoginConfig{
+		Path:      "jwt/login",
+		Role:      "operator",
+		TokenPath: writeTestFile(t, "token", "jwt-token"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := newMockVaultAuth(t, &mockVault{status: http.StatusForbidden}, "", method)
+
+	if _, err := auth.authenticate(context.Background()); err == nil {
+		t.Error("expected login error")
+	}
+}
+
+func TestNewVaultLoginMethodValidation(t *testing.T) {
+	if _, err := NewVaultLoginMethod("ldap", VaultLoginConfig{Path: "ldap/login"}); err == nil {
+		t.Error("expected unknown method error")
+	}
+	if _, err := NewVaultLoginMethod("kubernetes", VaultLoginConfig{Path: "kubernetes/login"}); err == nil {
+		t.Error("expected missing role error")
+	}
+	if _, err := NewVaultLoginMethod("userpass", VaultLoginConfig{}); err == nil {
+		t.Error("expected missing path error")
+	}
+	if _, err := NewVaultLoginMethod("userpass", VaultLoginConfig{
+		Path:         "userpass",
+		Username:     "operator",
+		PasswordPath: "password",
+	}); err == nil {
+		t.Error("expected login path without /login error")
+	}
+}
+
+// testJWT returns unsigned JWT with given claims
+func testJWT(t *testing.T, claims map[string]interface{}) string {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString(payload) + ".c2lnbmF0dXJl"
+}
+
+func TestTokenAudience(t *testing.T) {
+	config := VaultLoginConfig{Path: "kubernetes/login", Role: "operator", TokenAudience: "vault"}
+
+	config.TokenPath = writeTestFile(t, "legacy", testJWT(t, map[string]interface{}{"iss": "kubernetes/serviceaccount"}))
+	if _, err := NewVaultLoginMethod("kubernetes", config); err == nil {
+		t.Error("expected legacy token error")
+	}
+
+	config.TokenPath = writeTestFile(t, "other", testJWT(t, map[string]interface{}{
+		"aud": []string{"https://kubernetes.default.svc"},
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}))
+	if _, err := NewVaultLoginMethod("kubernetes", config); err == nil {
+		t.Error("expected audience error")
+	}
+
+	token := testJWT(t, map[string]interface{}{"aud": "vault", "exp": time.Now().Add(time.Hour).Unix()})
+	config.TokenPath = writeTestFile(t, "token", token)
+	method, err := NewVaultLoginMethod("kubernetes", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock := &mockVault{}
+	auth := newMockVaultAuth(t, mock, "", method)
+	if _, err := auth.authenticate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if mock.body["jwt"] != token {
+		t.Errorf("unexpected login body %v", mock.body)
+	}
+}
+
+func TestExpiredToken(t *testing.T) {
+	method, err := NewVaultLoginMethod("jwt", VaultLoginConfig{
+		Path:          "jwt/login",
+		Role:          "operator",
+		TokenAudience: "vault",
+		TokenPath: writeTestFile(t, "token", testJWT(t, map[string]interface{}{
+			"aud": []string{"vault"},
+			"exp": time.Now().Add(-time.Minute).Unix(),
+		})),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock := &mockVault{}
+	auth := newMockVaultAuth(t, mock, "", method)
+
+	_, err = auth.authenticate(context.Background())
+	if err == nil {
+		t.Fatal("expected expired token error")
+	}
+	if reason := vaultLoginFailureReason(err); reason != vaultLoginExpiredJWT {
+		t.Errorf("expected %s failure reason, got %s", vaultLoginExpiredJWT, reason)
+	}
+	if mock.path != "" {
+		t.Errorf("expired token sent to vault")
+	}
+}
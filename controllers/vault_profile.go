@@ -0,0 +1,210 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+
+	"go.mozilla.org/sops/v3/keyservice"
+	"google.golang.org/grpc"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/yaml"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// VaultProfileAnnotation on a namespace selects Vault profile of SopsSecrets
+// in the namespace which do not set spec.vaultProfile
+const VaultProfileAnnotation = "sops-secrets-operator/vault-profile"
+
+// VaultProfiles is the operator Vault profiles configuration file
+type VaultProfiles struct {
+	Profiles map[string]*VaultProfile `json:"profiles"`
+}
+
+// VaultProfile is a named Vault connection used instead of the operator one
+// to decrypt Vault transit data keys of SopsSecrets which select it
+type VaultProfile struct {
+	KeyProfileVault `json:",inline"`
+
+	// Namespaces lists namespaces SopsSecrets of which may use the profile,
+	// "*" allows all namespaces
+	Namespaces []string `json:"namespaces"`
+	// CACertFile is file with PEM CA bundle trusted to verify Vault server
+	// certificate
+	CACertFile string `json:"caCertFile,omitempty"`
+	// ClientCert and ClientKey are files with PEM client certificate and key
+	ClientCert string `json:"clientCert,omitempty"`
+	ClientKey  string `json:"clientKey,omitempty"`
+	// TLSSkipVerify disables verification of Vault server certificate
+	TLSSkipVerify bool `json:"tlsSkipVerify,omitempty"`
+
+	// VaultAuth is authenticator of the profile
+	VaultAuth *VaultAuth `json:"-"`
+}
+
+// LoadVaultProfiles reads Vault profiles configuration file and creates
+// authenticators of profiles, which have to be started by the caller
+func LoadVaultProfiles(path string) (map[string]*VaultProfile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &VaultProfiles{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for name, profile := range config.Profiles {
+		if profile == nil || len(profile.Namespaces) == 0 {
+			return nil, fmt.Errorf("vault profile %s: namespaces must not be empty", name)
+		}
+		if profile.Server == "" || profile.Path == "" {
+			return nil, fmt.Errorf("vault profile %s: server and path must not be empty", name)
+		}
+
+		method := profile.Method
+		if method == "" {
+			method = "kubernetes"
+		}
+		login, err := NewVaultLoginMethod(method, VaultLoginConfig{
+			Path:          profile.Path,
+			Role:          profile.Role,
+			TokenPath:     profile.TokenPath,
+			TokenAudience: profile.TokenAudience,
+			Username:      profile.Username,
+			PasswordPath:  profile.PasswordPath,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("vault profile %s: %v", name, err)
+		}
+		tlsConfig := VaultTLSConfig{
+			ClientCert: profile.ClientCert,
+			ClientKey:  profile.ClientKey,
+			Insecure:   profile.TLSSkipVerify,
+		}
+		if profile.CACertFile != "" {
+			if tlsConfig.CACert, err = ioutil.ReadFile(profile.CACertFile); err != nil {
+				return nil, fmt.Errorf("vault profile %s: %v", name, err)
+			}
+		}
+		profile.VaultAuth, err = CreateVaultAuthWithTLS(profile.Server, profile.Namespace, login, tlsConfig)
+		if err != nil {
+			return nil, fmt.Errorf("vault profile %s: %v", name, err)
+		}
+	}
+	return config.Profiles, nil
+}
+
+// allowed reports whether SopsSecrets in the namespace may use the profile
+func (p *VaultProfile) allowed(namespace string) bool {
+	for _, allowed := range p.Namespaces {
+		if allowed == "*" || allowed == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// vaultProfileName returns name of Vault profile selected by SopsSecret
+// spec.vaultProfile or annotation of its namespace, empty when operator Vault
+// connection is used
+func (r *SopsSecretReconciler) vaultProfileName(ctx context.Context, instance *isindirv1alpha2.SopsSecret) (string, error) {
+	if instance.Spec.VaultProfile != "" || len(r.VaultProfiles) == 0 {
+		return instance.Spec.VaultProfile, nil
+	}
+	namespace := &corev1.Namespace{}
+	if err := r.Get(ctx, types.NamespacedName{Name: instance.Namespace}, namespace); err != nil {
+		return "", err
+	}
+	return namespace.Annotations[VaultProfileAnnotation], nil
+}
+
+// vaultProfile returns Vault profile selected by SopsSecret, nil is returned
+// when SopsSecret uses operator Vault connection or Vault of its key profile
+func (r *SopsSecretReconciler) vaultProfile(ctx context.Context, instance *isindirv1alpha2.SopsSecret) (*VaultProfile, error) {
+	if instance.Spec.KeyProfile != "" {
+		return nil, nil
+	}
+	name, err := r.vaultProfileName(ctx, instance)
+	if err != nil || name == "" {
+		return nil, err
+	}
+	profile, ok := r.VaultProfiles[name]
+	if !ok {
+		return nil, &permanentError{fmt.Errorf("vault profile %s does not exist", name)}
+	}
+	if !profile.allowed(instance.Namespace) {
+		return nil, &permanentError{fmt.Errorf("vault profile %s may not be used in namespace %s", name, instance.Namespace)}
+	}
+	return profile, nil
+}
+
+// vaultProfileKeyService fails decryption of Vault data keys of SopsSecret
+// which selects invalid Vault profile, so operator Vault connection is never
+// used for them. Other data keys are delegated
+type vaultProfileKeyService struct {
+	err  error
+	next keyservice.KeyServiceClient
+}
+
+// Encrypt is not used by the operator and is always delegated
+func (ks *vaultProfileKeyService) Encrypt(
+	ctx context.Context,
+	req *keyservice.EncryptRequest,
+	opts ...grpc.CallOption,
+) (*keyservice.EncryptResponse, error) {
+	return ks.next.Encrypt(ctx, req, opts...)
+}
+
+// Decrypt returns the profile error for Vault data keys
+func (ks *vaultProfileKeyService) Decrypt(
+	ctx context.Context,
+	req *keyservice.DecryptRequest,
+	opts ...grpc.CallOption,
+) (*keyservice.DecryptResponse, error) {
+	if req.Key.GetVaultKey() != nil {
+		return nil, ks.err
+	}
+	return ks.next.Decrypt(ctx, req, opts...)
+}
+
+// vaultProfileAnnotationChanged passes namespace updates which change Vault
+// profile annotation
+var vaultProfileAnnotationChanged = predicate.Funcs{
+	CreateFunc:  func(event.CreateEvent) bool { return false },
+	DeleteFunc:  func(event.DeleteEvent) bool { return false },
+	GenericFunc: func(event.GenericEvent) bool { return false },
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		return e.ObjectOld.GetAnnotations()[VaultProfileAnnotation] != e.ObjectNew.GetAnnotations()[VaultProfileAnnotation]
+	},
+}
+
+// namespaceVaultProfileSopsSecrets maps namespace to its SopsSecrets which
+// select Vault profile by namespace annotation
+func (r *SopsSecretReconciler) namespaceVaultProfileSopsSecrets(obj client.Object) []reconcile.Request {
+	sopsSecrets := &isindirv1alpha2.SopsSecretList{}
+	if err := r.List(context.Background(), sopsSecrets, client.InNamespace(obj.GetName())); err != nil {
+		r.Log.Info("Listing SopsSecrets error", "namespace", obj.GetName(), "error", err)
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for i := range sopsSecrets.Items {
+		sopsSecret := &sopsSecrets.Items[i]
+		if sopsSecret.Spec.VaultProfile == "" && sopsSecret.Spec.KeyProfile == "" {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: sopsSecret.Namespace, Name: sopsSecret.Name},
+			})
+		}
+	}
+	return requests
+}
@@ -0,0 +1,101 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+// VaultTokenSink receives Vault token whenever it is obtained by login.
+// Decryption always uses the token VaultAuth keeps in memory, sinks only
+// expose the token to other consumers
+type VaultTokenSink interface {
+	// Write stores the token, empty token clears it
+	Write(token string) error
+}
+
+// Vault token sink names accepted by NewVaultTokenSink
+const (
+	// MemoryVaultTokenSink keeps token only in operator memory
+	MemoryVaultTokenSink = "memory"
+	// EnvVaultTokenSink sets VAULT_TOKEN environment variable of the operator
+	// process, which is read by Vault clients of the in-process sops library
+	EnvVaultTokenSink = "env"
+	// FileVaultTokenSink writes token to a file, ~/.vault-token by default
+	FileVaultTokenSink = "file"
+)
+
+// NewVaultTokenSink returns token sink of given name, path is used by file
+// sink only
+func NewVaultTokenSink(name string, path string) (VaultTokenSink, error) {
+	switch name {
+	case MemoryVaultTokenSink, "":
+		return memoryTokenSink{}, nil
+	case EnvVaultTokenSink:
+		return envTokenSink{}, nil
+	case FileVaultTokenSink:
+		return &fileTokenSink{path: path}, nil
+	}
+	return nil, fmt.Errorf("unknown vault token sink %q, supported sinks are %s, %s and %s", name, MemoryVaultTokenSink, EnvVaultTokenSink, FileVaultTokenSink)
+}
+
+// memoryTokenSink discards the token, it is available from VaultAuth only
+type memoryTokenSink struct{}
+
+func (memoryTokenSink) Write(string) error {
+	return nil
+}
+
+// envTokenSink exposes the token as VAULT_TOKEN environment variable
+type envTokenSink struct{}
+
+func (envTokenSink) Write(token string) error {
+	if token == "" {
+		return os.Unsetenv("VAULT_TOKEN")
+	}
+	return os.Setenv("VAULT_TOKEN", token)
+}
+
+// fileTokenSink writes the token to a file, replacing it atomically so
+// readers never see partially written token
+type fileTokenSink struct {
+	path string
+}
+
+func (s *fileTokenSink) Write(token string) error {
+	path := s.path
+	if path == "" {
+		home, err := homedir.Dir()
+		if err != nil {
+			return err
+		}
+		path = filepath.Join(home, ".vault-token")
+	}
+	if token == "" {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".vault-token-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(token); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -1,135 +1,504 @@
 package controllers
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"github.com/hashicorp/vault/api"
-	"github.com/mitchellh/go-homedir"
-	"io/ioutil"
-	"path/filepath"
+	"net"
+	"net/http"
+	"net/url"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type VaultAuth struct {
-	client  *api.Client
-	path    string
-	role    string
-	jwtPath string
-}
+	client *api.Client
+	method VaultLoginMethod
+	// namespace is Vault Enterprise namespace, empty for root namespace
+	namespace string
+	// LoginBackoff is the delay between failed login attempts
+	LoginBackoff BackoffPolicy
+	// Sink receives every obtained token, token is kept only in memory when
+	// nil
+	Sink VaultTokenSink
+	// RevokeOnShutdown revokes the token and clears the sink when manager
+	// stops or leadership is lost
+	RevokeOnShutdown bool
+
+	tokenLock   sync.RWMutex
+	token       string
+	tokenExpiry time.Time
+	// renewing is set while auto-renewal runs, standby replicas do not log in
+	renewing bool
 
-type kubernetesAuth struct {
-	JWT  string `json:"jwt"`
-	Role string `json:"role"`
+	// caLoader reads trusted CAs again, nil when CAs are fixed at startup
+	caLoader func(ctx context.Context) ([]byte, error)
+	// caPool holds *x509.CertPool last read by caLoader
+	caPool   atomic.Value
+	caLock   sync.Mutex
+	caBundle []byte
+	// httpClient is shared by clones of client, its connections verified
+	// with replaced CAs are closed
+	httpClient *http.Client
 }
 
 var (
 	vaultLog = ctrl.Log.WithName("vault")
 )
 
-func CreateVaultAuth(server string, path string, role string, jwtPath string) (*VaultAuth, error) {
+// Vault login failure reasons, used in logs and metrics
+const (
+	vaultLoginNetworkError     = "network"
+	vaultLoginPermissionDenied = "permission_denied"
+	vaultLoginExpiredJWT       = "expired_jwt"
+	vaultLoginOtherError       = "error"
+	vaultLoginSuccess          = "success"
+)
+
+var vaultLoginFailureMessages = map[string]string{
+	vaultLoginNetworkError:     "could not reach vault",
+	vaultLoginPermissionDenied: "vault denied login",
+	vaultLoginExpiredJWT:       "vault rejected expired login JWT",
+	vaultLoginOtherError:       "could not authenticate with vault",
+}
+
+// VaultTLSConfig configures TLS of Vault client, zero values keep settings of
+// VAULT_CACERT, VAULT_CLIENT_CERT, VAULT_CLIENT_KEY and VAULT_SKIP_VERIFY
+// environment variables
+type VaultTLSConfig struct {
+	// CACert is PEM bundle of CAs trusted to verify Vault server certificate
+	CACert []byte
+	// CACertLoader reads PEM bundle of trusted CAs instead of CACert, it is
+	// read again before every login and every vaultCACertRefreshInterval, so
+	// rotated or revoked CAs are applied without restart
+	CACertLoader func(ctx context.Context) ([]byte, error)
+	// ClientCert is file with PEM client certificate, read on every TLS
+	// handshake so renewed certificates are used without restart
+	ClientCert string
+	// ClientKey is file with PEM private key of client certificate
+	ClientKey string
+	// Insecure disables verification of Vault server certificate
+	Insecure bool
+}
+
+func CreateVaultAuth(server string, namespace string, method VaultLoginMethod) (*VaultAuth, error) {
+	return CreateVaultAuthWithTLS(server, namespace, method, VaultTLSConfig{})
+}
+
+// CreateVaultAuthWithTLS creates Vault authenticator with client configured by
+// TLS config, its transit decryptions use the same TLS settings
+func CreateVaultAuthWithTLS(server string, namespace string, method VaultLoginMethod, tlsConfig VaultTLSConfig) (*VaultAuth, error) {
+	auth := &VaultAuth{
+		method:    method,
+		namespace: namespace,
+		caLoader:  tlsConfig.CACertLoader,
+		LoginBackoff: BackoffPolicy{
+			Initial:    time.Second,
+			Max:        5 * time.Minute,
+			Multiplier: 2,
+			Jitter:     0.2,
+		},
+	}
+
 	cfg := api.DefaultConfig()
 	cfg.Address = server
+	var verify func(tls.ConnectionState) error
+	if auth.caLoader != nil {
+		if err := auth.reloadCACert(context.Background()); err != nil {
+			return nil, err
+		}
+		serverURL, err := url.Parse(server)
+		if err != nil {
+			return nil, err
+		}
+		verify = auth.verifyServerCertificate(serverURL.Hostname())
+	}
+	if err := configureVaultTLS(cfg, tlsConfig, verify); err != nil {
+		return nil, err
+	}
+	auth.httpClient = cfg.HttpClient
 
 	client, err := api.NewClient(cfg)
 	if err != nil {
 		return nil, err
 	}
-
-	return &VaultAuth{
-		client:  client,
-		path:    path,
-		role:    role,
-		jwtPath: jwtPath,
-	}, nil
+	if namespace != "" {
+		client.SetNamespace(namespace)
+	}
+	auth.client = client
+	return auth, nil
 }
 
-func (auth *VaultAuth) authenticate() (*api.Secret, error) {
-	jwt, err := ioutil.ReadFile(auth.jwtPath)
+// vaultCACertRefreshInterval is the interval trusted CAs of CACertLoader are
+// read again in, in addition to every login
+const vaultCACertRefreshInterval = time.Minute
+
+// reloadCACert reads trusted CAs with caLoader, previous CAs are kept when
+// the bundle can not be read. Idle connections are closed when CAs change,
+// so connections verified with removed CAs are not reused
+func (auth *VaultAuth) reloadCACert(ctx context.Context) error {
+	bundle, err := auth.caLoader(ctx)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("vault CA bundle: %v", err)
 	}
 
-	request := auth.client.NewRequest("POST", fmt.Sprintf("/v1/auth/%s", auth.path))
-	err = request.SetJSONBody(&kubernetesAuth{
-		JWT:  string(jwt),
-		Role: auth.role,
-	})
-	if err != nil {
-		return nil, err
+	auth.caLock.Lock()
+	defer auth.caLock.Unlock()
+	if auth.caBundle != nil && bytes.Equal(bundle, auth.caBundle) {
+		return nil
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bundle) {
+		return fmt.Errorf("vault CA bundle contains no PEM certificates")
+	}
+	auth.caPool.Store(pool)
+	auth.caBundle = bundle
+	if auth.httpClient != nil {
+		auth.httpClient.CloseIdleConnections()
+		vaultLog.Info("vault CA bundle reloaded")
 	}
+	return nil
+}
 
-	response, err := auth.client.RawRequest(request)
-	if err != nil {
-		return nil, err
+// refreshCACert reads trusted CAs every vaultCACertRefreshInterval until
+// context is cancelled
+func (auth *VaultAuth) refreshCACert(ctx context.Context) {
+	ticker := time.NewTicker(vaultCACertRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := auth.reloadCACert(ctx); err != nil {
+				vaultLog.Error(err, "could not reload vault CA bundle")
+			}
+		}
+	}
+}
+
+// verifyServerCertificate returns verification of Vault server certificate
+// against CAs last read by caLoader, it replaces verification of the TLS
+// client whose trusted CAs are fixed
+func (auth *VaultAuth) verifyServerCertificate(serverName string) func(tls.ConnectionState) error {
+	return func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return fmt.Errorf("vault server sent no certificate")
+		}
+		opts := x509.VerifyOptions{
+			DNSName:       serverName,
+			Roots:         auth.caPool.Load().(*x509.CertPool),
+			Intermediates: x509.NewCertPool(),
+		}
+		for _, cert := range state.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		_, err := state.PeerCertificates[0].Verify(opts)
+		return err
 	}
-	defer response.Body.Close()
+}
+
+// configureVaultTLS applies TLS config to transport of Vault client config,
+// verify replaces verification of server certificate when not nil
+func configureVaultTLS(cfg *api.Config, config VaultTLSConfig, verify func(tls.ConnectionState) error) error {
+	if len(config.CACert) == 0 && verify == nil && config.ClientCert == "" && config.ClientKey == "" && !config.Insecure {
+		return nil
+	}
+	transport, ok := cfg.HttpClient.Transport.(*http.Transport)
+	if !ok {
+		return fmt.Errorf("vault client transport can not be configured for TLS")
+	}
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	clientTLS := transport.TLSClientConfig
 
-	if response.Error() != nil {
-		return nil, response.Error()
+	if len(config.CACert) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(config.CACert) {
+			return fmt.Errorf("vault CA bundle contains no PEM certificates")
+		}
+		clientTLS.RootCAs = pool
+	}
+	if config.ClientCert != "" || config.ClientKey != "" {
+		if config.ClientCert == "" || config.ClientKey == "" {
+			return fmt.Errorf("vault client certificate and key must be set together")
+		}
+		if _, err := tls.LoadX509KeyPair(config.ClientCert, config.ClientKey); err != nil {
+			return fmt.Errorf("vault client certificate: %v", err)
+		}
+		clientTLS.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(config.ClientCert, config.ClientKey)
+			if err != nil {
+				return nil, err
+			}
+			return &cert, nil
+		}
 	}
+	if config.Insecure {
+		clientTLS.InsecureSkipVerify = true
+	} else if verify != nil {
+		// built-in verification is skipped, verify checks the chain and
+		// server name against reloaded CAs
+		clientTLS.InsecureSkipVerify = true
+		clientTLS.VerifyConnection = verify
+	}
+	return nil
+}
 
-	secret, err := api.ParseSecret(response.Body)
+// authenticate logs in with login method, login uses clone of the client, so
+// expired token is not sent with login request, clone does not copy headers
+// so namespace is set again
+func (auth *VaultAuth) authenticate(ctx context.Context) (*api.Secret, error) {
+	client, err := auth.client.Clone()
 	if err != nil {
 		return nil, err
 	}
+	client.ClearToken()
+	if auth.namespace != "" {
+		client.SetNamespace(auth.namespace)
+	}
+	return client.Auth().Login(ctx, auth.method)
+}
 
-	return secret, nil
+// Token returns current vault token, empty string if not authenticated yet
+func (auth *VaultAuth) Token() string {
+	auth.tokenLock.RLock()
+	defer auth.tokenLock.RUnlock()
+	return auth.token
 }
 
-func (auth *VaultAuth) writeToken(secret *api.Secret) error {
-	homePath, err := homedir.Dir()
-	if err != nil {
-		return err
+func (auth *VaultAuth) setToken(token string, leaseDuration int) {
+	auth.tokenLock.Lock()
+	defer auth.tokenLock.Unlock()
+	auth.token = token
+	auth.setTokenExpiry(leaseDuration)
+}
+
+func (auth *VaultAuth) renewToken(leaseDuration int) {
+	auth.tokenLock.Lock()
+	defer auth.tokenLock.Unlock()
+	auth.setTokenExpiry(leaseDuration)
+	vaultTokenRenewals.Inc()
+	vaultTokenLastRenewal.SetToCurrentTime()
+}
+
+// setRenewing records whether auto-renewal runs, token of stopped renewal
+// is forgotten as it is no longer renewed, and revoked when RevokeOnShutdown
+// is set
+func (auth *VaultAuth) setRenewing(renewing bool) {
+	auth.tokenLock.Lock()
+	token := auth.token
+	auth.renewing = renewing
+	if !renewing {
+		auth.token = ""
+		auth.tokenExpiry = time.Time{}
 	}
-	tokenPath := filepath.Join(homePath, ".vault-token")
-	if err = ioutil.WriteFile(tokenPath, []byte(secret.Auth.ClientToken), 0600); err != nil {
-		return err
+	auth.tokenLock.Unlock()
+
+	if !renewing && auth.RevokeOnShutdown {
+		auth.revoke(token)
+	}
+}
+
+// setTokenExpiry must be called with tokenLock held, zero lease duration means token does not expire
+func (auth *VaultAuth) setTokenExpiry(leaseDuration int) {
+	if leaseDuration > 0 {
+		auth.tokenExpiry = time.Now().Add(time.Duration(leaseDuration) * time.Second)
+	} else {
+		auth.tokenExpiry = time.Time{}
+	}
+	vaultTokenExpiry.Store(auth.tokenExpiry)
+}
+
+// ReadyChecker returns readiness check failing when vault token is absent or expires within minTTL
+func (auth *VaultAuth) ReadyChecker(minTTL time.Duration) healthz.Checker {
+	return func(_ *http.Request) error {
+		auth.tokenLock.RLock()
+		defer auth.tokenLock.RUnlock()
+
+		if !auth.renewing {
+			// standby replica logs in once it is elected leader
+			return nil
+		}
+		if auth.token == "" {
+			return fmt.Errorf("vault token is not obtained yet")
+		}
+		if !auth.tokenExpiry.IsZero() && time.Until(auth.tokenExpiry) < minTTL {
+			return fmt.Errorf("vault token expires at %s", auth.tokenExpiry.Format(time.RFC3339))
+		}
+		return nil
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, only the
+// leader logs in to Vault, so standby replicas hold no tokens and don't
+// overwrite token sink of the leader
+func (auth *VaultAuth) NeedLeaderElection() bool {
+	return true
+}
+
+// Start implements manager.Runnable, it logs in and renews the token until
+// manager stops or leadership is lost
+func (auth *VaultAuth) Start(ctx context.Context) error {
+	if auth.caLoader != nil {
+		go auth.refreshCACert(ctx)
 	}
+	auth.StartAutoRenew(ctx)
 	return nil
 }
 
+// vaultRevokeTimeout limits token revocation during shutdown
+const vaultRevokeTimeout = 5 * time.Second
+
+// revoke revokes the token with auth/token/revoke-self and clears the sink,
+// so token leaked from the sink is not valid after operator stops. Failures
+// are only logged, the token still expires with its lease
+func (auth *VaultAuth) revoke(token string) {
+	if auth.Sink != nil {
+		if err := auth.Sink.Write(""); err != nil {
+			vaultLog.Error(err, "could not clear auth token")
+		}
+	}
+	if token == "" {
+		return
+	}
+
+	client, err := auth.client.Clone()
+	if err != nil {
+		vaultLog.Error(err, "could not revoke vault token")
+		return
+	}
+	client.SetToken(token)
+	client.SetClientTimeout(vaultRevokeTimeout)
+	if err := client.Auth().Token().RevokeSelf(""); err != nil {
+		vaultLog.Error(err, "could not revoke vault token")
+		return
+	}
+	vaultLog.Info("vault token revoked")
+}
+
+// vaultRenewalRetryDelay is the minimum delay before logging in again after
+// renewal of the token failed, the token may still be valid and Vault which
+// denies renewal would deny repeated logins too
+const vaultRenewalRetryDelay = 30 * time.Second
+
+// StartAutoRenew logs in and renews the token until context is cancelled,
+// token is forgotten afterwards
 func (auth *VaultAuth) StartAutoRenew(ctx context.Context) {
+	auth.setRenewing(true)
+	defer auth.setRenewing(false)
+
+	// failures counts consecutive failed login sessions for backoff, it is
+	// not reset by login alone, so session which logs in but fails to renew
+	// every time backs off as well
+	failures := 0
+	loginFailures := 0
 	for {
-		err := auth.autoRenewal(ctx)
+		loggedIn, renewed, err := auth.autoRenewal(ctx)
+		if loggedIn {
+			loginFailures = 0
+		} else if err != nil {
+			loginFailures++
+		}
+		vaultLoginFailures.Set(float64(loginFailures))
 
-		// if any error happened, wait for 30s before next attempt
 		if err == nil {
+			failures = 0
 			select {
 			case <-ctx.Done():
 				return
 			default:
 				continue
 			}
-		} else {
-			select {
-			case <-ctx.Done():
-				return
-			case <-time.After(30 * time.Second):
-				continue
-			}
+		}
+
+		if renewed {
+			failures = 0
+		}
+		failures++
+		// back off exponentially while sessions fail, renewal failure waits
+		// at least vaultRenewalRetryDelay
+		delay := auth.LoginBackoff.Delay(failures)
+		if loggedIn && delay < vaultRenewalRetryDelay {
+			delay = vaultRenewalRetryDelay
+		}
+		vaultLog.V(1).Info("retrying vault login", "failures", failures, "delay", delay.String())
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
 		}
 	}
 }
 
-func (auth *VaultAuth) autoRenewal(ctx context.Context) error {
-	initial, err := auth.authenticate()
-	if err != nil {
-		vaultLog.Error(err, "could not authenticate with vault")
-		return err
+// vaultLoginFailureReason classifies login error as network failure,
+// permission denial, expired JWT or other error
+func vaultLoginFailureReason(err error) string {
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return vaultLoginNetworkError
+	}
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "expired"):
+		return vaultLoginExpiredJWT
+	case strings.Contains(msg, "code: 403"), strings.Contains(msg, "permission denied"):
+		return vaultLoginPermissionDenied
+	case strings.Contains(msg, "connection refused"), strings.Contains(msg, "no such host"):
+		return vaultLoginNetworkError
+	}
+	return vaultLoginOtherError
+}
+
+// autoRenewal logs in and renews the token until renewal fails, loggedIn
+// reports whether login succeeded and renewed whether the token was renewed
+func (auth *VaultAuth) autoRenewal(ctx context.Context) (loggedIn bool, renewed bool, err error) {
+	// all log lines of one login session share correlation ID
+	log := vaultLog.WithValues(correlationIDKey, newCorrelationID())
+
+	if auth.caLoader != nil {
+		if err := auth.reloadCACert(ctx); err != nil {
+			log.Error(err, "could not reload vault CA bundle")
+		}
 	}
 
-	err = auth.writeToken(initial)
+	initial, err := auth.authenticate(ctx)
 	if err != nil {
-		vaultLog.Error(err, "could not write auth token")
-		return err
+		reason := vaultLoginFailureReason(err)
+		vaultLoginAttempts.WithLabelValues(reason).Inc()
+		log.Error(err, vaultLoginFailureMessages[reason], "reason", reason)
+		return false, false, err
+	}
+	vaultLoginAttempts.WithLabelValues(vaultLoginSuccess).Inc()
+
+	auth.setToken(initial.Auth.ClientToken, initial.Auth.LeaseDuration)
+
+	if auth.Sink != nil {
+		err = auth.Sink.Write(initial.Auth.ClientToken)
+		if err != nil {
+			log.Error(err, "could not write auth token")
+			return true, false, err
+		}
 	}
 
-	vaultLog.Info("vault token updated")
+	log.Info("vault token updated")
+
+	if !initial.Auth.Renewable {
+		return true, false, waitForTokenReplacement(ctx, initial.Auth.LeaseDuration)
+	}
 
 	watcher, err := auth.client.NewLifetimeWatcher(&api.LifetimeWatcherInput{Secret: initial})
 	if err != nil {
-		return err
+		return true, false, err
 	}
 
 	go watcher.Start()
@@ -138,14 +507,34 @@ func (auth *VaultAuth) autoRenewal(ctx context.Context) error {
 	for {
 		select {
 		case <-ctx.Done():
-			return nil
+			return true, renewed, nil
 		case err = <-watcher.DoneCh():
 			if err != nil {
-				vaultLog.Error(err, "could not renew vault token")
+				log.Error(err, "could not renew vault token")
 			}
-			return err
-		case <-watcher.RenewCh():
-			vaultLog.Info("vault token renewed")
+			return true, renewed, err
+		case renewal := <-watcher.RenewCh():
+			if renewal.Secret != nil && renewal.Secret.Auth != nil {
+				auth.renewToken(renewal.Secret.Auth.LeaseDuration)
+			}
+			renewed = true
+			log.Info("vault token renewed")
 		}
 	}
 }
+
+// waitForTokenReplacement waits until non-renewable token, such as batch
+// token, is replaced by new login after two thirds of its lease, the same
+// share of the lease lifetime watcher renews after. Token without lease is
+// used until context is cancelled
+func waitForTokenReplacement(ctx context.Context, leaseDuration int) error {
+	if leaseDuration <= 0 {
+		<-ctx.Done()
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Duration(leaseDuration) * time.Second * 2 / 3):
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingVault issues tokens and counts logins, renewal is denied
+type countingVault struct {
+	renewable bool
+	logins    int32
+}
+
+func (m *countingVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Path == "/v1/auth/token/renew-self" {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"errors":["permission denied"]}`))
+		return
+	}
+	atomic.AddInt32(&m.logins, 1)
+	fmt.Fprintf(w, `{"auth":{"client_token":"s.mock","lease_duration":3600,"renewable":%t}}`, m.renewable)
+}
+
+// runAutoRenew runs auto-renewal against the mock for the duration and
+// returns the number of logins
+func runAutoRenew(t *testing.T, mock *countingVault, duration time.Duration) int32 {
+	method, err := NewVaultLoginMethod("cert", VaultLoginConfig{Path: "cert/login"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(mock)
+	defer server.Close()
+	auth, err := CreateVaultAuth(server.URL, "", method)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), duration)
+	defer cancel()
+	auth.StartAutoRenew(ctx)
+	return atomic.LoadInt32(&mock.logins)
+}
+
+func TestAutoRenewNonRenewableToken(t *testing.T) {
+	// login backoff starts at 1s, repeated logins would show within 3s
+	if logins := runAutoRenew(t, &countingVault{renewable: false}, 3*time.Second); logins != 1 {
+		t.Errorf("non-renewable token should be used until it nearly expires, got %d logins", logins)
+	}
+}
+
+func TestAutoRenewDeniedRenewal(t *testing.T) {
+	if logins := runAutoRenew(t, &countingVault{renewable: true}, 3*time.Second); logins != 1 {
+		t.Errorf("denied renewal should wait %s before login, got %d logins", vaultRenewalRetryDelay, logins)
+	}
+}
+
+func TestWaitForTokenReplacement(t *testing.T) {
+	start := time.Now()
+	if err := waitForTokenReplacement(context.Background(), 3); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 2*time.Second || elapsed > 3*time.Second {
+		t.Errorf("token should be replaced after two thirds of lease, waited %s", elapsed)
+	}
+}
+
+// selfSignedCA returns PEM certificate of a new self-signed CA
+func selfSignedCA(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "rotated CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestVaultCACertReload(t *testing.T) {
+	server := httptest.NewTLSServer(&countingVault{renewable: true})
+	defer server.Close()
+	method, err := NewVaultLoginMethod("cert", VaultLoginConfig{Path: "cert/login"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	auth, err := CreateVaultAuthWithTLS(server.URL, "", method, VaultTLSConfig{
+		CACertLoader: func(context.Context) ([]byte, error) { return bundle, nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.authenticate(context.Background()); err != nil {
+		t.Fatalf("login with trusted CA failed: %v", err)
+	}
+
+	// CA of the server is rotated out of the bundle
+	bundle = selfSignedCA(t)
+	if err := auth.reloadCACert(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.authenticate(context.Background()); err == nil {
+		t.Error("login should fail after CA of the server is removed from the bundle")
+	}
+
+	// bundle which can not be read keeps the previous CAs
+	bundle = []byte("not a certificate")
+	if err := auth.reloadCACert(context.Background()); err == nil {
+		t.Error("invalid bundle should be rejected")
+	}
+	if _, err := auth.authenticate(context.Background()); err == nil {
+		t.Error("rejected bundle should keep the previous CAs")
+	}
+}
@@ -0,0 +1,92 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// reconcileVerifyOnly checks SOPS MAC of SopsSecret and decrypts it, and
+// records the result in Verified condition without generating secrets.
+// Decryption cache is not used, so broken keys or policies are detected on
+// every reconciliation
+func (r *SopsSecretReconciler) reconcileVerifyOnly(
+	ctx context.Context,
+	instanceEncrypted *isindirv1alpha2.SopsSecret,
+	decryptor Decryptor,
+) (ctrl.Result, error) {
+	log := r.logger(ctx)
+	name := types.NamespacedName{Namespace: instanceEncrypted.Namespace, Name: instanceEncrypted.Name}
+
+	_, decryptSpan := startSpan(ctx, "Decrypt", attribute.Array("sops.key_backends", keyBackends(instanceEncrypted)))
+	var err error
+	if verifier, ok := decryptor.(interface {
+		verifyMac(*isindirv1alpha2.SopsSecret) error
+	}); ok {
+		err = verifier.verifyMac(instanceEncrypted)
+	}
+	if err == nil {
+		_, err = decryptor.Decrypt(instanceEncrypted, log)
+	}
+	endSpan(decryptSpan, err)
+
+	now := metav1.Now()
+	instanceEncrypted.Status.LastVerificationTime = &now
+	if err != nil {
+		reason := "VerificationFailed"
+		if groupsErr := asKeyGroupsError(err); groupsErr != nil {
+			reason = isindirv1alpha2.InsufficientKeyGroupsCondition
+			instanceEncrypted.Status.KeyGroups = groupsErr.groups
+		}
+		instanceEncrypted.Status.Message = "Verification failed"
+		meta.SetStatusCondition(&instanceEncrypted.Status.Conditions, metav1.Condition{
+			Type:               isindirv1alpha2.VerifiedCondition,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: instanceEncrypted.Generation,
+			Reason:             reason,
+			Message:            err.Error(),
+		})
+		_, class := classifyDecryptionFailure(err)
+		setHealth(instanceEncrypted, class, reason, err.Error())
+		r.Status().Update(context.Background(), instanceEncrypted)
+		r.Recorder.Eventf(instanceEncrypted, corev1.EventTypeWarning, reason, "Failed to verify: %v", err)
+		verificationFailed.WithLabelValues(name.Namespace, name.Name).Set(1)
+
+		log.Info(
+			"Verification failed",
+			"error",
+			err,
+		)
+		return r.requeueAfterFailure(ctx, name, class), nil
+	}
+
+	instanceEncrypted.Status.KeyGroups = nil
+	instanceEncrypted.Status.Message = "Verified"
+	meta.SetStatusCondition(&instanceEncrypted.Status.Conditions, metav1.Condition{
+		Type:               isindirv1alpha2.VerifiedCondition,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: instanceEncrypted.Generation,
+		Reason:             "Verified",
+		Message:            "SOPS MAC is valid and all values are decrypted",
+	})
+	setHealth(instanceEncrypted, "", "Verified", "SOPS MAC is valid and all values are decrypted")
+	r.Status().Update(context.Background(), instanceEncrypted)
+	verificationFailed.WithLabelValues(name.Namespace, name.Name).Set(0)
+
+	log.Info(
+		"Verified",
+	)
+	r.failures.reset(name)
+	return r.requeueAfterSuccess(instanceEncrypted), nil
+}
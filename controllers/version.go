@@ -0,0 +1,74 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+
+	"github.com/prometheus/client_golang/prometheus"
+	sopsversion "go.mozilla.org/sops/v3/version"
+)
+
+// Version and GitCommit are injected at build time with
+// -ldflags "-X github.com/isindir/sops-secrets-operator/controllers.Version=..."
+var (
+	// Version is the operator release version
+	Version = "dev"
+	// GitCommit is SHA of the commit operator is built from
+	GitCommit = "unknown"
+)
+
+// BuildInfo describes operator build
+type BuildInfo struct {
+	Version     string `json:"version"`
+	GitCommit   string `json:"gitCommit"`
+	SopsVersion string `json:"sopsVersion"`
+	GoVersion   string `json:"goVersion"`
+}
+
+// GetBuildInfo returns build info of the running operator
+func GetBuildInfo() BuildInfo {
+	return BuildInfo{
+		Version:     Version,
+		GitCommit:   GitCommit,
+		SopsVersion: sopsversion.Version,
+		GoVersion:   runtime.Version(),
+	}
+}
+
+// VersionHandler serves build info of the operator as JSON
+func VersionHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(GetBuildInfo())
+	})
+}
+
+// buildInfo is always 1, its labels describe operator build, so versions of
+// operators running across clusters can be audited
+var buildInfo = newBuildInfoMetric(GetBuildInfo())
+
+func newBuildInfoMetric(info BuildInfo) prometheus.GaugeFunc {
+	return prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "sops_secrets_operator_build_info",
+			Help: "Operator build information, always 1.",
+			ConstLabels: prometheus.Labels{
+				"version":      info.Version,
+				"git_commit":   info.GitCommit,
+				"sops_version": info.SopsVersion,
+				"go_version":   info.GoVersion,
+			},
+		},
+		func() float64 { return 1 },
+	)
+}
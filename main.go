@@ -5,24 +5,42 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	corev1 "k8s.io/api/core/v1"
+	k8slabels "k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/yaml"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/zapr"
+	"go.mozilla.org/sops/v3/keyservice"
+	uberzap "go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 
 	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+	isindirv1alpha3 "github.com/isindir/sops-secrets-operator/api/v1alpha3"
 	"github.com/isindir/sops-secrets-operator/controllers"
+	sopsrender "github.com/isindir/sops-secrets-operator/pkg/render"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -35,32 +53,227 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(isindirv1alpha2.AddToScheme(scheme))
+	utilruntime.Must(isindirv1alpha3.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "render" {
+		if err := render(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	var metricsAddr string
+	var metricsSecure bool
+	var metricsCertFile string
+	var metricsKeyFile string
+	var metricsClientCAFile string
+	var metricsTokenAuth bool
 	var enableLeaderElection bool
+	var leaderElectionNamespace string
+	var leaderElectionID string
+	var leaseDuration time.Duration
+	var renewDeadline time.Duration
+	var retryPeriod time.Duration
 	var probeAddr string
+	var pprofAddr string
+	var kubeAPIQPS float64
+	var kubeAPIBurst int
+	var cacheSyncTimeout time.Duration
+	var disableSecretCache bool
+	var initialSyncTimeout time.Duration
+	var limits controllers.SecretLimits
+	var certificateExpiryWarningDays int
+	var currentRecipients string
+	var secretNamePrefix string
+	var enableReencryption bool
 	var requeueAfter int64
+	var requeueSuccessAfter time.Duration
+	var transientBackoff controllers.BackoffPolicy
+	var credentialBackoff controllers.BackoffPolicy
+	var backoffMultiplier float64
+	var backoffJitter float64
+	var circuitBreaker controllers.CircuitBreakerPolicy
+	var enableTimingStatus bool
+	var stuckThreshold time.Duration
+	var stuckScanInterval time.Duration
+	var maxConcurrentReconciles int
+	var watchLabelSelector string
+	var shardIndex int
+	var shardTotal int
+	var namespaceRateLimit float64
+	var namespaceRateBurst int
+	var decryptionCacheSize int
+	var decryptionCacheTTL time.Duration
+	var replicationSourceNamespaces string
+	var enableConversionWebhook bool
+	var conversionWebhookService string
+	var conversionWebhookCAFile string
+	var managedSecretLabels string
+	var managedSecretAnnotations string
+	var fieldManager string
+	var managedSecretsConfigMap string
+	var notificationWebhookURLs string
+	var notificationSlackURLs string
+	var notificationSigningKeyFile string
+	var notificationRetries int
+	var auditLogStdout bool
+	var auditLogFile string
 
 	var vaultAuth string
+	var vaultAuthMethod string
+	var vaultUsername string
+	var vaultPasswordPath string
 	var vaultRole string
 	var vaultServer string
 	var vaultTokenPath string
+	var vaultTokenAudience string
+	var vaultNamespace string
+	var vaultRequired bool
+	var vaultTokenMinTTL time.Duration
+	var vaultTokenSink string
+	var vaultTokenFile string
+	var vaultRevokeOnShutdown bool
+	var vaultLoginBackoff controllers.BackoffPolicy
+	var vaultCACertSecret string
+	var vaultTLSSkipVerify bool
+	var vaultClientCert string
+	var vaultClientKey string
+
+	var azureIdentity string
+	var allowedAwsRoles string
+	var allowedGcpServiceAccounts string
+	var allowedAzureIdentities string
+	var keyProfilesFile string
+	var vaultProfilesFile string
+	var operatorConfig string
+
+	var logSampling bool
+
+	var keyServiceAddresses string
+	var enableLocalKeyService bool
+
+	var gpgKeysSecret string
+	var namespaceGpgKeysSecret string
+
+	var pkcs11Library string
+	var pkcs11Slot uint
+	var pkcs11Secret string
+	var pkcs11Sessions int
+
+	var sealedSecretsKeyNamespace string
+
+	var remoteClusters string
+	var remoteClusterSourceNamespaces string
 
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
+	flag.BoolVar(&metricsSecure, "metrics-secure", false, "Serve metrics over TLS, requires --metrics-tls-cert-file and --metrics-tls-key-file.")
+	flag.StringVar(&metricsCertFile, "metrics-tls-cert-file", "", "Metrics server TLS certificate, reloaded when changed.")
+	flag.StringVar(&metricsKeyFile, "metrics-tls-key-file", "", "Metrics server TLS private key.")
+	flag.StringVar(&metricsClientCAFile, "metrics-client-ca-file", "", "Require metrics client certificates signed by CA from this file.")
+	flag.BoolVar(&metricsTokenAuth, "metrics-token-auth", false, "Require metrics bearer tokens authorized with TokenReview and SubjectAccessReview.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
+	flag.StringVar(&pprofAddr, "pprof-bind-address", "", "The address pprof endpoint binds to, for example localhost:6060, disabled when empty.")
+	flag.Float64Var(&kubeAPIQPS, "kube-api-qps", 20, "Maximum sustained queries per second to Kubernetes API server.")
+	flag.IntVar(&kubeAPIBurst, "kube-api-burst", 30, "Maximum burst of queries to Kubernetes API server.")
+	flag.DurationVar(&cacheSyncTimeout, "cache-sync-timeout", 2*time.Minute, "Time to wait for informer caches to sync before the controller fails to start.")
+	flag.BoolVar(&disableSecretCache, "disable-secret-cache", false, "Do not cache and watch Secrets, read them from API server instead, for clusters with very many Secrets.")
+	flag.IntVar(&limits.MaxSecretSize, "max-secret-size", 0, "Maximum size of decrypted keys and values of a generated secret in bytes, unlimited when 0.")
+	flag.IntVar(&limits.MaxSecretKeys, "max-secret-keys", 0, "Maximum number of data keys of a generated secret, unlimited when 0.")
+	flag.IntVar(&limits.MaxSecrets, "max-secrets-per-sopssecret", 0, "Maximum number of secret templates of a SopsSecret, unlimited when 0.")
+	flag.IntVar(&certificateExpiryWarningDays, "certificate-expiry-warning-days", 0, "Report certificates of generated TLS secrets expiring within this number of days, disabled when 0.")
+	flag.StringVar(&currentRecipients, "current-recipients", "", "Comma separated KMS ARNs, PGP fingerprints, age recipients and other sops recipients SopsSecrets should be encrypted for, re-encryption is not reported when empty.")
+	flag.BoolVar(&enableReencryption, "enable-reencryption", false, "Re-encrypt data keys of SopsSecrets annotated with sops-secrets-operator/reencrypt=true for --current-recipients and update them.")
+	flag.DurationVar(&initialSyncTimeout, "initial-sync-timeout", 0, "Report ready only after all SopsSecrets were reconciled once since start or this timeout elapsed, disabled when 0.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
-	flag.Int64Var(&requeueAfter, "requeue-decrypt-after", 5, "Requeue failed reconciliation in minutes (min 1).")
+	flag.StringVar(&leaderElectionNamespace, "leader-election-namespace", "", "Namespace to create leader election lease in (default operator namespace).")
+	flag.StringVar(&leaderElectionID, "leader-election-id", "ca57d051.github.com", "Name of leader election lease, must differ between operator instances sharing a namespace.")
+	flag.DurationVar(&leaseDuration, "leader-elect-lease-duration", 15*time.Second, "Duration non-leader candidates wait before acquiring leadership.")
+	flag.DurationVar(&renewDeadline, "leader-elect-renew-deadline", 10*time.Second, "Duration the acting leader retries refreshing leadership before giving it up.")
+	flag.DurationVar(&retryPeriod, "leader-elect-retry-period", 2*time.Second, "Duration leader election clients wait between action attempts.")
+	flag.Int64Var(&requeueAfter, "requeue-decrypt-after", 5, "Requeue failed reconciliation in minutes (min 1). Deprecated: use --transient-backoff-max.")
+	flag.DurationVar(&requeueSuccessAfter, "requeue-success-after", 0, "Reconcile successfully reconciled SopsSecrets again after this interval to repair drift, 0 disables periodic reconciliation.")
+	flag.DurationVar(&transientBackoff.Initial, "transient-backoff-initial", 10*time.Second, "Initial requeue delay after transient failure (API server errors).")
+	flag.DurationVar(&transientBackoff.Max, "transient-backoff-max", 0, "Maximum requeue delay after transient failures (default --requeue-decrypt-after).")
+	flag.DurationVar(&credentialBackoff.Initial, "credential-backoff-initial", 5*time.Minute, "Initial requeue delay after decryption credential or key backend failure.")
+	flag.DurationVar(&credentialBackoff.Max, "credential-backoff-max", time.Hour, "Maximum requeue delay after decryption credential or key backend failures.")
+	flag.Float64Var(&backoffMultiplier, "backoff-multiplier", 2, "Requeue delay multiplier applied after each consecutive failure.")
+	flag.Float64Var(&backoffJitter, "backoff-jitter", 0.1, "Maximum fraction of requeue delay randomly added or subtracted.")
+	flag.IntVar(&circuitBreaker.Threshold, "circuit-breaker-threshold", 5, "Consecutive failures to reach KMS, Vault or other key backend which mark it unavailable for all SopsSecrets, 0 disables circuit breakers.")
+	flag.DurationVar(&circuitBreaker.ProbeInterval, "circuit-breaker-probe-interval", 30*time.Second, "Interval of probes checking recovery of unavailable key backend.")
+	flag.BoolVar(&enableTimingStatus, "enable-timing-status", false, "Record decryption, render and apply durations of the last reconciliations in SopsSecret status.")
+	flag.DurationVar(&stuckThreshold, "stuck-threshold", 0, "Report SopsSecrets not ready for longer than this duration with Warning event and sops_secret_stuck_total metric, 0 disables stuck detection.")
+	flag.DurationVar(&stuckScanInterval, "stuck-scan-interval", 10*time.Minute, "Interval of stuck SopsSecrets scans, Warning events are repeated with this interval.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 1, "Maximum number of SopsSecrets reconciled concurrently.")
+	flag.StringVar(&watchLabelSelector, "watch-label-selector", "", "Reconcile only SopsSecrets matching this label selector, for example owner=platform.")
+	flag.IntVar(&shardIndex, "shard-index", 0, "Shard of SopsSecrets reconciled by this replica, from 0 to --shard-total minus 1.")
+	flag.IntVar(&shardTotal, "shard-total", 1, "Number of replicas SopsSecrets are distributed to by hash of namespace and name.")
+	flag.Float64Var(&namespaceRateLimit, "namespace-rate-limit", 0, "Maximum reconciliations per second per namespace, 0 disables rate limiting.")
+	flag.IntVar(&namespaceRateBurst, "namespace-rate-burst", 10, "Maximum burst of reconciliations per namespace.")
+	flag.IntVar(&decryptionCacheSize, "decryption-cache-size", 0, "Maximum number of decrypted SopsSecrets cached in memory, 0 disables caching.")
+	flag.DurationVar(&decryptionCacheTTL, "decryption-cache-ttl", time.Hour, "Maximum age of cached decrypted SopsSecret.")
+	flag.StringVar(&managedSecretLabels, "managed-secret-labels", "", "Comma separated key=value labels added to every generated secret.")
+	flag.StringVar(&managedSecretAnnotations, "managed-secret-annotations", "", "Comma separated key=value annotations added to every generated secret.")
+	flag.StringVar(&secretNamePrefix, "secret-name-prefix", "", "Prefix added to generated secret names not starting with it, may use {{ .Namespace }} and {{ .SopsSecretName }}.")
+	flag.StringVar(&fieldManager, "field-manager", controllers.DefaultFieldManager, "Server-side apply field manager of generated secrets.")
+	flag.StringVar(&managedSecretsConfigMap, "managed-secrets-configmap", "", "Name of ConfigMap published to namespaces with SopsSecrets, listing generated secrets for admission policies and audits, empty disables it.")
+	flag.StringVar(&notificationWebhookURLs, "notification-webhook-urls", "", "Comma separated webhook URLs notified about generated secret changes.")
+	flag.StringVar(&notificationSlackURLs, "notification-slack-urls", "", "Comma separated Slack incoming webhook URLs notified about generated secret changes.")
+	flag.StringVar(&notificationSigningKeyFile, "notification-signing-key-file", "", "File with key used to sign webhook notifications with HMAC-SHA256.")
+	flag.IntVar(&notificationRetries, "notification-retries", 5, "Number of retries of failed notification delivery.")
+	flag.BoolVar(&auditLogStdout, "audit-log", false, "Write JSON audit records of generated secret mutations to standard output.")
+	flag.StringVar(&auditLogFile, "audit-log-file", "", "Append JSON audit records of generated secret mutations to this file.")
+	flag.BoolVar(&enableConversionWebhook, "enable-conversion-webhook", false, "Serve SopsSecret conversion webhook, requires webhook server certificate.")
+	flag.StringVar(&conversionWebhookService, "conversion-webhook-service", "", "Namespace/name of conversion webhook Service, when set SopsSecret CRD conversion is configured with it on startup.")
+	flag.StringVar(&conversionWebhookCAFile, "conversion-webhook-ca-file", "/tmp/k8s-webhook-server/serving-certs/ca.crt", "File with PEM CA bundle of conversion webhook serving certificate, set in SopsSecret CRD with --conversion-webhook-service.")
+	flag.StringVar(&replicationSourceNamespaces, "replication-source-namespaces", "", "Comma separated namespaces SopsSecrets of which may replicate secrets to other namespaces, * allows all.")
 
-	flag.StringVar(&vaultAuth, "vault-auth", "", "Vault Kubernetes authentication path.")
-	flag.StringVar(&vaultRole, "vault-role", "", "Vault Kubernetes authentication role.")
+	flag.StringVar(&vaultAuth, "vault-auth", "", "Vault authentication path, for example kubernetes/login.")
+	flag.StringVar(&vaultAuthMethod, "vault-auth-method", "kubernetes", "Vault authentication method: kubernetes, jwt, cert or userpass.")
+	flag.StringVar(&vaultRole, "vault-role", "", "Vault authentication role.")
+	flag.StringVar(&vaultUsername, "vault-username", "", "Vault userpass authentication user name.")
+	flag.StringVar(&vaultPasswordPath, "vault-password-path", "", "File with Vault userpass authentication password.")
 	flag.StringVar(&vaultServer, "vault-server", "", "Vault API URL.")
 	flag.StringVar(&vaultTokenPath, "vault-token-path", "/var/run/secrets/kubernetes.io/serviceaccount/token", "Service account token to use for Vault authentication.")
+	flag.StringVar(&vaultTokenAudience, "vault-token-audience", "", "Audience of projected service account token used for Vault authentication, legacy tokens are rejected when set.")
+	flag.StringVar(&vaultNamespace, "vault-namespace", "", "Vault Enterprise namespace used for authentication and transit decryption.")
+	flag.BoolVar(&vaultRequired, "vault-required", false, "Report not ready when Vault token is absent or about to expire.")
+	flag.StringVar(&vaultTokenSink, "vault-token-sink", controllers.MemoryVaultTokenSink, "Where Vault token is exposed besides operator memory: memory, env (VAULT_TOKEN variable) or file.")
+	flag.StringVar(&vaultTokenFile, "vault-token-file", "", "File Vault token is written to by file sink (default ~/.vault-token).")
+	flag.BoolVar(&vaultRevokeOnShutdown, "vault-revoke-on-shutdown", false, "Revoke Vault tokens and clear token sink when operator stops or loses leadership.")
+	flag.DurationVar(&vaultTokenMinTTL, "vault-token-min-ttl", 30*time.Second, "Minimum remaining Vault token TTL to report ready when --vault-required is set.")
+	flag.DurationVar(&vaultLoginBackoff.Initial, "vault-login-backoff-initial", time.Second, "Delay after the first failed Vault login.")
+	flag.DurationVar(&vaultLoginBackoff.Max, "vault-login-backoff-max", 5*time.Minute, "Maximum delay between failed Vault logins.")
+	flag.StringVar(&vaultCACertSecret, "vault-ca-cert-secret", "", "Secret with PEM CA bundle in ca.crt key trusted to verify Vault server certificate, in namespace/name format. The secret is read again before every Vault login and every minute, so rotated CA bundle is used without restart.")
+	flag.BoolVar(&vaultTLSSkipVerify, "vault-tls-skip-verify", false, "Do not verify Vault server certificate, insecure and meant for test environments only.")
+	flag.StringVar(&vaultClientCert, "vault-client-cert", "", "File with PEM client certificate presented to Vault.")
+	flag.StringVar(&vaultClientKey, "vault-client-key", "", "File with PEM private key of Vault client certificate.")
 
+	flag.StringVar(&keyServiceAddresses, "keyservice-address", "", "Comma separated addresses of external sops keyservices, tcp://host:port or unix:///path.")
+	flag.BoolVar(&enableLocalKeyService, "enable-local-keyservice", true, "Decrypt data keys in the operator process, disable to use external keyservices only.")
+	flag.StringVar(&gpgKeysSecret, "gpg-keys-secret", "", "Secret with PGP private keys used to decrypt all SopsSecrets, in namespace/name format.")
+	flag.StringVar(&namespaceGpgKeysSecret, "namespace-gpg-keys-secret", "", "Name of secret with PGP private keys used to decrypt SopsSecrets in the same namespace.")
+	flag.StringVar(&pkcs11Library, "pkcs11-library", "", "Path of PKCS#11 module of token holding PGP private keys, requires operator built with pkcs11 tag.")
+	flag.UintVar(&pkcs11Slot, "pkcs11-slot", 0, "PKCS#11 token slot ID.")
+	flag.StringVar(&pkcs11Secret, "pkcs11-secret", "", "Secret with PKCS#11 token PIN and PGP public keys of token private keys, in namespace/name format.")
+	flag.IntVar(&pkcs11Sessions, "pkcs11-sessions", 4, "Number of PKCS#11 token sessions used concurrently.")
+	flag.StringVar(&sealedSecretsKeyNamespace, "sealed-secrets-key-namespace", "", "Namespace of sealed-secrets controller sealing keys used to decrypt sealedSecret values of secret templates, usually kube-system.")
+	flag.StringVar(&remoteClusters, "remote-clusters", "", "Comma separated cluster=namespace/name pairs of secrets with kubeconfig of clusters secret templates push to.")
+	flag.StringVar(&remoteClusterSourceNamespaces, "remote-cluster-source-namespaces", "", "Comma separated cluster=namespace entries of namespaces SopsSecrets of which may push secrets to remote cluster, repeated for more namespaces, * allows all.")
+	flag.StringVar(&azureIdentity, "azure-identity", "", "Default client ID of Azure user-assigned managed identity to decrypt Key Vault keys.")
+	flag.StringVar(&allowedAwsRoles, "allowed-aws-roles", "", "Comma separated namespace=pattern entries of AWS role ARNs SopsSecrets of the namespace may assume with spec.awsRoleARN, \"*\" allows any namespace or role.")
+	flag.StringVar(&allowedGcpServiceAccounts, "allowed-gcp-service-accounts", "", "Comma separated namespace=pattern entries of GCP service accounts SopsSecrets of the namespace may impersonate with spec.gcpServiceAccount, \"*\" allows any namespace or service account.")
+	flag.StringVar(&allowedAzureIdentities, "allowed-azure-identities", "", "Comma separated namespace=pattern entries of Azure identity client IDs SopsSecrets of the namespace may use with spec.azureIdentity, \"*\" allows any namespace or identity.")
+	flag.StringVar(&keyProfilesFile, "key-profiles-file", "", "File with key profiles SopsSecrets select with spec.keyProfile.")
+	flag.StringVar(&vaultProfilesFile, "vault-profiles-file", "", "File with Vault profiles SopsSecrets select with spec.vaultProfile or namespace annotation.")
+	flag.StringVar(&operatorConfig, "operator-config", "", "ConfigMap with operator configuration applied without restart, in namespace/name format.")
+
+	flag.BoolVar(&logSampling, "log-sampling", true, "Sample repeated log entries in production logging mode.")
 	opts := zap.Options{
 		Development: false,
 	}
@@ -68,68 +281,640 @@ func main() {
 
 	flag.Parse()
 
-	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+	logLevel := configureLogging(&opts)
+	ctrl.SetLogger(newLogger(&opts, logSampling))
+
+	buildInfo := controllers.GetBuildInfo()
+	setupLog.Info(
+		"starting sops-secrets-operator",
+		"version",
+		buildInfo.Version,
+		"gitCommit",
+		buildInfo.GitCommit,
+		"sopsVersion",
+		buildInfo.SopsVersion,
+		"goVersion",
+		buildInfo.GoVersion,
+	)
+
+	managerMetricsAddr := metricsAddr
+	if metricsSecure {
+		if metricsCertFile == "" || metricsKeyFile == "" {
+			setupLog.Error(fmt.Errorf("--metrics-tls-cert-file and --metrics-tls-key-file are required"), "invalid secure metrics configuration")
+			os.Exit(1)
+		}
+		// metrics are served by secure metrics server instead
+		managerMetricsAddr = "0"
+	} else if metricsClientCAFile != "" || metricsTokenAuth {
+		setupLog.Error(fmt.Errorf("metrics authentication requires --metrics-secure"), "invalid secure metrics configuration")
+		os.Exit(1)
+	}
+
+	var watchSelector k8slabels.Selector
+	if watchLabelSelector != "" {
+		selector, err := k8slabels.Parse(watchLabelSelector)
+		if err != nil {
+			setupLog.Error(err, "invalid watch label selector")
+			os.Exit(1)
+		}
+		watchSelector = selector
+	}
+
+	if shardTotal < 1 || shardIndex < 0 || shardIndex >= shardTotal {
+		setupLog.Error(fmt.Errorf("expected 0 <= shard index < shard total, got %d and %d", shardIndex, shardTotal), "invalid sharding configuration")
+		os.Exit(1)
+	}
+	if shardTotal > 1 {
+		// every shard elects its own leader
+		leaderElectionID = fmt.Sprintf("shard-%d.%s", shardIndex, leaderElectionID)
+	}
+
+	restConfig := ctrl.GetConfigOrDie()
+	restConfig.QPS = float32(kubeAPIQPS)
+	restConfig.Burst = kubeAPIBurst
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Scheme:                 scheme,
-		MetricsBindAddress:     metricsAddr,
-		Port:                   9443,
-		HealthProbeBindAddress: probeAddr,
-		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       "ca57d051.github.com",
+	var uncachedObjects []client.Object
+	if disableSecretCache {
+		uncachedObjects = append(uncachedObjects, &corev1.Secret{})
+	}
+	mgr, err := ctrl.NewManager(restConfig, ctrl.Options{
+		Scheme:                  scheme,
+		MetricsBindAddress:      managerMetricsAddr,
+		Port:                    9443,
+		HealthProbeBindAddress:  probeAddr,
+		LeaderElection:          enableLeaderElection,
+		LeaderElectionID:        leaderElectionID,
+		LeaderElectionNamespace: leaderElectionNamespace,
+		LeaseDuration:           &leaseDuration,
+		RenewDeadline:           &renewDeadline,
+		RetryPeriod:             &retryPeriod,
+		ClientDisableCacheFor:   uncachedObjects,
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
+	if metricsSecure {
+		handlers := map[string]http.Handler{"/version": controllers.VersionHandler()}
+		// log level may only be changed by authenticated callers
+		if metricsClientCAFile != "" || metricsTokenAuth {
+			handlers["/debug/loglevel"] = logLevel
+		}
+		if err := mgr.Add(&secureMetricsServer{
+			Addr:         metricsAddr,
+			CertFile:     metricsCertFile,
+			KeyFile:      metricsKeyFile,
+			ClientCAFile: metricsClientCAFile,
+			TokenAuth:    metricsTokenAuth,
+			Client:       mgr.GetClient(),
+			Handlers:     handlers,
+			Log:          ctrl.Log.WithName("metrics"),
+		}); err != nil {
+			setupLog.Error(err, "unable to set up secure metrics server")
+			os.Exit(1)
+		}
+	} else {
+		if err := mgr.AddMetricsExtraHandler("/version", controllers.VersionHandler()); err != nil {
+			setupLog.Error(err, "unable to set up version handler")
+			os.Exit(1)
+		}
+	}
+
+	if pprofAddr != "" {
+		if err := mgr.Add(&pprofServer{Addr: pprofAddr, Log: ctrl.Log.WithName("pprof")}); err != nil {
+			setupLog.Error(err, "unable to set up pprof server")
+			os.Exit(1)
+		}
+	}
+
 	if requeueAfter < 1 {
 		requeueAfter = 1
 	}
+	if transientBackoff.Max <= 0 {
+		transientBackoff.Max = time.Duration(requeueAfter) * time.Minute
+	}
+	transientBackoff.Multiplier = backoffMultiplier
+	transientBackoff.Jitter = backoffJitter
+	credentialBackoff.Multiplier = backoffMultiplier
+	credentialBackoff.Jitter = backoffJitter
 	setupLog.Info(
 		fmt.Sprintf(
-			"SopsSecret reconciliation will be requeued after %d minutes after decryption failures",
-			requeueAfter,
+			"SopsSecret reconciliation will be requeued after failures with backoff from %s to %s (transient) and from %s to %s (credential), permanent failures wait for SopsSecret change",
+			transientBackoff.Initial,
+			transientBackoff.Max,
+			credentialBackoff.Initial,
+			credentialBackoff.Max,
 		),
 	)
 
-	if err = (&controllers.SopsSecretReconciler{
-		Client:       mgr.GetClient(),
-		Log:          ctrl.Log.WithName("controllers").WithName("SopsSecret"),
-		Scheme:       mgr.GetScheme(),
-		RequeueAfter: requeueAfter,
-	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "SopsSecret")
+	labels, err := splitMap(managedSecretLabels)
+	if err != nil {
+		setupLog.Error(err, "invalid managed secret labels")
+		os.Exit(1)
+	}
+	annotations, err := splitMap(managedSecretAnnotations)
+	if err != nil {
+		setupLog.Error(err, "invalid managed secret annotations")
+		os.Exit(1)
+	}
+
+	var gpgKeys types.NamespacedName
+	if gpgKeysSecret != "" {
+		parts := strings.SplitN(gpgKeysSecret, "/", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			setupLog.Error(fmt.Errorf("expected namespace/name, got %q", gpgKeysSecret), "invalid gpg keys secret")
+			os.Exit(1)
+		}
+		gpgKeys = types.NamespacedName{Namespace: parts[0], Name: parts[1]}
+	}
+
+	var operatorConfigMap types.NamespacedName
+	if operatorConfig != "" {
+		parts := strings.SplitN(operatorConfig, "/", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			setupLog.Error(fmt.Errorf("expected namespace/name, got %q", operatorConfig), "invalid operator config")
+			os.Exit(1)
+		}
+		operatorConfigMap = types.NamespacedName{Namespace: parts[0], Name: parts[1]}
+	}
+
+	var pkcs11 *controllers.Pkcs11Config
+	if pkcs11Library != "" {
+		parts := strings.SplitN(pkcs11Secret, "/", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			setupLog.Error(fmt.Errorf("expected namespace/name, got %q", pkcs11Secret), "invalid pkcs11 secret")
+			os.Exit(1)
+		}
+		pkcs11 = &controllers.Pkcs11Config{
+			Library:  pkcs11Library,
+			Slot:     pkcs11Slot,
+			Secret:   types.NamespacedName{Namespace: parts[0], Name: parts[1]},
+			Sessions: pkcs11Sessions,
+		}
+	}
+
+	clusters, err := splitMap(remoteClusters)
+	if err != nil {
+		setupLog.Error(err, "invalid remote clusters")
 		os.Exit(1)
 	}
+	remoteClusterSecrets := make(map[string]types.NamespacedName, len(clusters))
+	for cluster, secret := range clusters {
+		parts := strings.SplitN(secret, "/", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			setupLog.Error(fmt.Errorf("expected namespace/name, got %q", secret), "invalid remote cluster secret", "cluster", cluster)
+			os.Exit(1)
+		}
+		remoteClusterSecrets[cluster] = types.NamespacedName{Namespace: parts[0], Name: parts[1]}
+	}
+	remoteClusterNamespaces := make(map[string][]string)
+	for _, item := range splitList(remoteClusterSourceNamespaces) {
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			setupLog.Error(fmt.Errorf("expected cluster=namespace, got %q", item), "invalid remote cluster source namespaces")
+			os.Exit(1)
+		}
+		cluster := strings.TrimSpace(parts[0])
+		if _, ok := remoteClusterSecrets[cluster]; !ok {
+			setupLog.Error(fmt.Errorf("cluster %s is not in --remote-clusters", cluster), "invalid remote cluster source namespaces")
+			os.Exit(1)
+		}
+		remoteClusterNamespaces[cluster] = append(remoteClusterNamespaces[cluster], strings.TrimSpace(parts[1]))
+	}
+
+	var vault *controllers.VaultAuth
+	if len(vaultServer) > 0 && len(vaultAuth) > 0 {
+		method, err := controllers.NewVaultLoginMethod(vaultAuthMethod, controllers.VaultLoginConfig{
+			Path:          vaultAuth,
+			Role:          vaultRole,
+			TokenPath:     vaultTokenPath,
+			TokenAudience: vaultTokenAudience,
+			Username:      vaultUsername,
+			PasswordPath:  vaultPasswordPath,
+		})
+		if err != nil {
+			setupLog.Error(err, "unable to create vault login method")
+			os.Exit(1)
+		}
+		vaultTLS := controllers.VaultTLSConfig{
+			ClientCert: vaultClientCert,
+			ClientKey:  vaultClientKey,
+			Insecure:   vaultTLSSkipVerify,
+		}
+		if vaultCACertSecret != "" {
+			parts := strings.SplitN(vaultCACertSecret, "/", 2)
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				setupLog.Error(fmt.Errorf("expected namespace/name, got %q", vaultCACertSecret), "invalid vault CA certificate secret")
+				os.Exit(1)
+			}
+			// the secret is read directly, it is read again before every
+			// login and every minute so rotated CA is trusted without restart
+			caSecretName := types.NamespacedName{Namespace: parts[0], Name: parts[1]}
+			vaultTLS.CACertLoader = func(ctx context.Context) ([]byte, error) {
+				caSecret := &corev1.Secret{}
+				if err := mgr.GetAPIReader().Get(ctx, caSecretName, caSecret); err != nil {
+					return nil, err
+				}
+				if len(caSecret.Data["ca.crt"]) == 0 {
+					return nil, fmt.Errorf("secret %s has no ca.crt key", caSecretName)
+				}
+				return caSecret.Data["ca.crt"], nil
+			}
+		}
+		if vaultTLSSkipVerify {
+			setupLog.Info("Vault server certificate is not verified, do not use --vault-tls-skip-verify in production")
+		}
+		vault, err = controllers.CreateVaultAuthWithTLS(vaultServer, vaultNamespace, method, vaultTLS)
+		if err != nil {
+			setupLog.Error(err, "unable to create vault authenticator")
+			os.Exit(1)
+		}
+		vault.LoginBackoff.Initial = vaultLoginBackoff.Initial
+		vault.LoginBackoff.Max = vaultLoginBackoff.Max
+		vault.Sink, err = controllers.NewVaultTokenSink(vaultTokenSink, vaultTokenFile)
+		if err != nil {
+			setupLog.Error(err, "unable to create vault token sink")
+			os.Exit(1)
+		}
+	}
+
+	var keyProfiles map[string]*controllers.KeyProfile
+	if keyProfilesFile != "" {
+		keyProfiles, err = controllers.LoadKeyProfiles(keyProfilesFile)
+		if err != nil {
+			setupLog.Error(err, "unable to load key profiles")
+			os.Exit(1)
+		}
+		for _, profile := range keyProfiles {
+			if profile.VaultAuth != nil {
+				profile.VaultAuth.LoginBackoff.Initial = vaultLoginBackoff.Initial
+				profile.VaultAuth.LoginBackoff.Max = vaultLoginBackoff.Max
+			}
+		}
+	}
+
+	var vaultProfiles map[string]*controllers.VaultProfile
+	if vaultProfilesFile != "" {
+		vaultProfiles, err = controllers.LoadVaultProfiles(vaultProfilesFile)
+		if err != nil {
+			setupLog.Error(err, "unable to load vault profiles")
+			os.Exit(1)
+		}
+		for _, profile := range vaultProfiles {
+			profile.VaultAuth.LoginBackoff.Initial = vaultLoginBackoff.Initial
+			profile.VaultAuth.LoginBackoff.Max = vaultLoginBackoff.Max
+		}
+	}
+
+	var remoteKeyServices []keyservice.KeyServiceClient
+	for _, address := range splitList(keyServiceAddresses) {
+		svc, err := controllers.DialKeyService(context.Background(), address)
+		if err != nil {
+			setupLog.Error(err, "unable to connect to keyservice")
+			os.Exit(1)
+		}
+		remoteKeyServices = append(remoteKeyServices, svc)
+	}
+	if !enableLocalKeyService && len(remoteKeyServices) == 0 {
+		setupLog.Error(fmt.Errorf("--keyservice-address is required"), "local keyservice is disabled")
+		os.Exit(1)
+	}
+
+	exit := os.Exit
+	var auditLog *controllers.AuditLog
+	if auditLogStdout || auditLogFile != "" {
+		auditLog, err = controllers.NewAuditLog(auditLogStdout, auditLogFile)
+		if err != nil {
+			setupLog.Error(err, "unable to open audit log")
+			os.Exit(1)
+		}
+		defer auditLog.Close()
+		// os.Exit does not run deferred calls
+		exit = func(code int) {
+			auditLog.Close()
+			os.Exit(code)
+		}
+	}
+
+	var notifier *controllers.Notifier
+	if notificationWebhookURLs != "" || notificationSlackURLs != "" {
+		notifier = &controllers.Notifier{
+			Retries: notificationRetries,
+			Backoff: controllers.BackoffPolicy{Initial: time.Second, Max: time.Minute, Multiplier: 2, Jitter: 0.1},
+			Log:     ctrl.Log.WithName("notifications"),
+		}
+		for _, url := range splitList(notificationWebhookURLs) {
+			notifier.Webhooks = append(notifier.Webhooks, controllers.NotificationWebhook{URL: url})
+		}
+		for _, url := range splitList(notificationSlackURLs) {
+			notifier.Webhooks = append(notifier.Webhooks, controllers.NotificationWebhook{URL: url, Slack: true})
+		}
+		if notificationSigningKeyFile != "" {
+			key, err := ioutil.ReadFile(notificationSigningKeyFile)
+			if err != nil {
+				setupLog.Error(err, "unable to read notification signing key")
+				exit(1)
+			}
+			notifier.SigningKey = []byte(strings.TrimSpace(string(key)))
+		}
+		if err := mgr.Add(notifier); err != nil {
+			setupLog.Error(err, "unable to set up notifications")
+			exit(1)
+		}
+	}
+
+	awsRoles, err := controllers.ParseIdentityPatterns(allowedAwsRoles)
+	if err != nil {
+		setupLog.Error(err, "invalid allowed AWS roles")
+		exit(1)
+	}
+	gcpServiceAccounts, err := controllers.ParseIdentityPatterns(allowedGcpServiceAccounts)
+	if err != nil {
+		setupLog.Error(err, "invalid allowed GCP service accounts")
+		exit(1)
+	}
+	azureIdentities, err := controllers.ParseIdentityPatterns(allowedAzureIdentities)
+	if err != nil {
+		setupLog.Error(err, "invalid allowed Azure identities")
+		exit(1)
+	}
+	identityAllowlist := controllers.IdentityAllowlist{
+		AwsRoles:           awsRoles,
+		GcpServiceAccounts: gcpServiceAccounts,
+		AzureIdentities:    azureIdentities,
+	}
+
+	reconciler := &controllers.SopsSecretReconciler{
+		Client:                      mgr.GetClient(),
+		Log:                         ctrl.Log.WithName("controllers").WithName("SopsSecret"),
+		Scheme:                      mgr.GetScheme(),
+		Recorder:                    mgr.GetEventRecorderFor("sopssecret-controller"),
+		TransientBackoff:            transientBackoff,
+		CredentialBackoff:           credentialBackoff,
+		CircuitBreaker:              circuitBreaker,
+		EnableTimingStatus:          enableTimingStatus,
+		StuckThreshold:              stuckThreshold,
+		StuckScanInterval:           stuckScanInterval,
+		RequeueSuccessAfter:         requeueSuccessAfter,
+		VaultAuth:                   vault,
+		AzureIdentity:               azureIdentity,
+		IdentityAllowlist:           identityAllowlist,
+		KeyProfiles:                 keyProfiles,
+		VaultProfiles:               vaultProfiles,
+		MaxConcurrentReconciles:     maxConcurrentReconciles,
+		CacheSyncTimeout:            cacheSyncTimeout,
+		DisableSecretCache:          disableSecretCache,
+		RestConfig:                  restConfig,
+		WatchLabelSelector:          watchSelector,
+		ShardIndex:                  shardIndex,
+		ShardTotal:                  shardTotal,
+		NamespaceRateLimit:          namespaceRateLimit,
+		NamespaceRateBurst:          namespaceRateBurst,
+		Limits:                      limits,
+		CertificateExpiryWarning:    time.Duration(certificateExpiryWarningDays) * 24 * time.Hour,
+		CurrentRecipients:           splitList(currentRecipients),
+		SecretNamePrefix:            secretNamePrefix,
+		EnableReencryption:          enableReencryption,
+		DecryptionCacheSize:         decryptionCacheSize,
+		DecryptionCacheTTL:          decryptionCacheTTL,
+		ReplicationSourceNamespaces: splitList(replicationSourceNamespaces),
+		ManagedSecretLabels:         labels,
+		ManagedSecretAnnotations:    annotations,
+		GpgKeysSecret:               gpgKeys,
+		NamespaceGpgKeysSecret:      namespaceGpgKeysSecret,
+		Pkcs11:                      pkcs11,
+		SealedSecretsKeyNamespace:   sealedSecretsKeyNamespace,
+		RemoteClusters:              remoteClusterSecrets,
+		RemoteSourceNamespaces:      remoteClusterNamespaces,
+		FieldManager:                fieldManager,
+		ManagedSecretsConfigMap:     managedSecretsConfigMap,
+		AuditLog:                    auditLog,
+		Notifier:                    notifier,
+		RemoteKeyServices:           remoteKeyServices,
+		DisableLocalKeyService:      !enableLocalKeyService,
+		InitialSyncTimeout:          initialSyncTimeout,
+	}
+	if err = reconciler.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "SopsSecret")
+		exit(1)
+	}
+	if operatorConfig != "" {
+		if err = (&controllers.OperatorConfigReconciler{
+			Client:            mgr.GetClient(),
+			Log:               ctrl.Log.WithName("controllers").WithName("OperatorConfig"),
+			Recorder:          mgr.GetEventRecorderFor("operatorconfig-controller"),
+			ConfigMap:         operatorConfigMap,
+			SopsSecrets:       reconciler,
+			VaultLoginBackoff: vaultLoginBackoff,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "OperatorConfig")
+			exit(1)
+		}
+	}
+	if enableConversionWebhook {
+		if err = (&isindirv1alpha2.SopsSecret{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "SopsSecret")
+			exit(1)
+		}
+		if conversionWebhookService != "" {
+			parts := strings.SplitN(conversionWebhookService, "/", 2)
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				setupLog.Error(fmt.Errorf("expected namespace/name, got %q", conversionWebhookService), "invalid conversion webhook service")
+				exit(1)
+			}
+			caBundle, err := ioutil.ReadFile(conversionWebhookCAFile)
+			if err != nil {
+				setupLog.Error(err, "unable to read conversion webhook CA bundle")
+				exit(1)
+			}
+			if err := controllers.ConfigureConversionWebhook(context.Background(), mgr.GetClient(), controllers.ConversionWebhookConfig{
+				Service:  types.NamespacedName{Namespace: parts[0], Name: parts[1]},
+				CABundle: caBundle,
+			}); err != nil {
+				setupLog.Error(err, "unable to configure SopsSecret CRD conversion webhook")
+				exit(1)
+			}
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
-		os.Exit(1)
+		exit(1)
 	}
 	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up ready check")
-		os.Exit(1)
+		exit(1)
+	}
+	if err := mgr.AddReadyzCheck("initial-sync", reconciler.InitialSyncChecker()); err != nil {
+		setupLog.Error(err, "unable to set up initial sync ready check")
+		exit(1)
+	}
+	if vault != nil && vaultRequired {
+		if err := mgr.AddReadyzCheck("vault", vault.ReadyChecker(vaultTokenMinTTL)); err != nil {
+			setupLog.Error(err, "unable to set up vault ready check")
+			exit(1)
+		}
+	}
+
+	shutdownTracing, err := controllers.SetupTracing(context.Background())
+	if err != nil {
+		setupLog.Error(err, "unable to set up tracing")
+		exit(1)
+	}
+
+	// vault authenticators run only on the elected leader and stop when
+	// leadership is lost
+	if vault != nil {
+		vault.RevokeOnShutdown = vaultRevokeOnShutdown
+		if err := mgr.Add(vault); err != nil {
+			setupLog.Error(err, "unable to set up vault authenticator")
+			exit(1)
+		}
+	}
+	for name, profile := range keyProfiles {
+		if profile.VaultAuth != nil {
+			profile.VaultAuth.RevokeOnShutdown = vaultRevokeOnShutdown
+			if err := mgr.Add(profile.VaultAuth); err != nil {
+				setupLog.Error(err, "unable to set up vault authenticator", "keyProfile", name)
+				exit(1)
+			}
+		}
+	}
+	for name, profile := range vaultProfiles {
+		profile.VaultAuth.RevokeOnShutdown = vaultRevokeOnShutdown
+		if err := mgr.Add(profile.VaultAuth); err != nil {
+			setupLog.Error(err, "unable to set up vault authenticator", "vaultProfile", name)
+			exit(1)
+		}
 	}
 
 	stopCh := ctrl.SetupSignalHandler()
 
-	if len(vaultRole) > 0 && len(vaultServer) > 0 && len(vaultTokenPath) > 0 && len(vaultAuth) > 0 {
-		setupLog.Info("starting vault authenticator")
+	setupLog.Info("starting manager")
+	err = mgr.Start(stopCh)
+	if shutdownErr := shutdownTracing(context.Background()); shutdownErr != nil {
+		setupLog.Error(shutdownErr, "unable to flush traces")
+	}
+	if err != nil {
+		setupLog.Error(err, "problem running manager")
+		exit(1)
+	}
+}
+
+// render decrypts SopsSecret manifests outside of the cluster and prints
+// generated secrets, for CI validation and GitOps diffs before merge
+func render(args []string) error {
+	flags := flag.NewFlagSet("render", flag.ExitOnError)
+	file := flags.String("f", "-", "SopsSecret manifest file, - reads standard input.")
+	keyFiles := flags.String("keys", "", "Comma separated age identity and PGP private key files, other keys use local credentials.")
+	namespace := flags.String("n", "", "Namespace of generated secrets, overrides SopsSecret namespace.")
+	secretNamePrefix := flags.String("secret-name-prefix", "", "Prefix added to generated secret names not starting with it, as --secret-name-prefix of the operator.")
+	flags.Parse(args)
+
+	var data []byte
+	var err error
+	if *file == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(*file)
+	}
+	if err != nil {
+		return err
+	}
 
-		vault, err := controllers.CreateVaultAuth(vaultServer, vaultAuth, vaultRole, vaultTokenPath)
+	renderer, err := sopsrender.New(
+		context.Background(),
+		sopsrender.Options{Namespace: *namespace, SecretNamePrefix: *secretNamePrefix, Log: zap.New(zap.WriteTo(os.Stderr), zap.Level(zapcore.ErrorLevel))},
+		sopsrender.KeyFiles(splitList(*keyFiles)...),
+	)
+	if err != nil {
+		return err
+	}
+	secrets, err := renderer.RenderManifests(data)
+	if err != nil {
+		return err
+	}
+
+	for i, secret := range secrets {
+		out, err := yaml.Marshal(secret)
 		if err != nil {
-			setupLog.Error(err, "unable to start vault authenticator")
-			os.Exit(1)
+			return err
+		}
+		if i > 0 {
+			fmt.Println("---")
+		}
+		os.Stdout.Write(out)
+	}
+	return nil
+}
+
+// configureLogging makes log level changeable at runtime, returned level is
+// served at /debug/loglevel of authenticated secure metrics endpoint
+func configureLogging(opts *zap.Options) uberzap.AtomicLevel {
+	level, ok := opts.Level.(uberzap.AtomicLevel)
+	if !ok {
+		level = uberzap.NewAtomicLevelAt(zapcore.InfoLevel)
+		if opts.Development {
+			level = uberzap.NewAtomicLevelAt(zapcore.DebugLevel)
 		}
+		opts.Level = level
+	}
+	return level
+}
 
-		go vault.StartAutoRenew(stopCh)
+// newLogger creates logger of flag options. controller-runtime samples every
+// production logger, so without sampling the production logger is built the
+// same way minus the sampler. Development mode is not used for it, as it
+// panics on DPanic, which zapr logs for malformed key/value pairs
+func newLogger(opts *zap.Options, sampling bool) logr.Logger {
+	if sampling || opts.Development {
+		return zap.New(zap.UseFlagOptions(opts))
 	}
 
-	setupLog.Info("starting manager")
-	if err := mgr.Start(stopCh); err != nil {
-		setupLog.Error(err, "problem running manager")
-		os.Exit(1)
+	encoder := opts.Encoder
+	if encoder == nil {
+		if opts.NewEncoder != nil {
+			encoder = opts.NewEncoder(opts.EncoderConfigOptions...)
+		} else {
+			zap.JSONEncoder(opts.EncoderConfigOptions...)(opts)
+			encoder = opts.Encoder
+		}
+	}
+	stacktraceLevel := opts.StacktraceLevel
+	if stacktraceLevel == nil {
+		stacktraceLevel = uberzap.NewAtomicLevelAt(zapcore.ErrorLevel)
+	}
+
+	sink := zapcore.AddSync(os.Stderr)
+	zapOpts := append([]uberzap.Option{uberzap.AddStacktrace(stacktraceLevel)}, opts.ZapOpts...)
+	zapOpts = append(zapOpts, uberzap.AddCallerSkip(1), uberzap.ErrorOutput(sink))
+	core := zapcore.NewCore(&zap.KubeAwareEncoder{Encoder: encoder}, sink, opts.Level)
+	return zapr.NewLogger(uberzap.New(core, zapOpts...))
+}
+
+// splitList splits comma separated flag value, empty items are skipped
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// splitMap parses comma separated key=value pairs
+func splitMap(value string) (map[string]string, error) {
+	items := make(map[string]string)
+	for _, item := range splitList(value) {
+		parts := strings.SplitN(item, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || key == "" {
+			return nil, fmt.Errorf("invalid key=value pair %q", item)
+		}
+		items[key] = strings.TrimSpace(parts[1])
 	}
+	return items, nil
 }
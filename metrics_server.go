@@ -0,0 +1,233 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// tokenCacheTTL is how long authorized bearer tokens are remembered
+const tokenCacheTTL = time.Minute
+
+// secureMetricsServer serves metrics over TLS, optionally requiring client
+// certificates signed by a trusted CA or bearer tokens authorized to get
+// metrics path with TokenReview and SubjectAccessReview, so no
+// kube-rbac-proxy sidecar is needed
+type secureMetricsServer struct {
+	Addr         string
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+	TokenAuth    bool
+	Client       client.Client
+	Handlers     map[string]http.Handler
+	Log          logr.Logger
+
+	lock   sync.Mutex
+	tokens map[string]time.Time // hashes of authorized requests
+	cert   *tls.Certificate
+	certAt time.Time
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, metrics are
+// served by all replicas
+func (s *secureMetricsServer) NeedLeaderElection() bool {
+	return false
+}
+
+// Start implements manager.Runnable
+func (s *secureMetricsServer) Start(ctx context.Context) error {
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: s.certificate,
+	}
+	if s.ClientCAFile != "" {
+		ca, err := ioutil.ReadFile(s.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read metrics client CA: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return fmt.Errorf("no certificates found in metrics client CA %s", s.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if _, err := s.certificate(nil); err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{
+		ErrorHandling: promhttp.HTTPErrorOnError,
+	}))
+	for path, handler := range s.Handlers {
+		mux.Handle(path, handler)
+	}
+
+	listener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on metrics address %s: %v", s.Addr, err)
+	}
+	server := &http.Server{
+		Handler:   s.authenticate(mux),
+		TLSConfig: tlsConfig,
+	}
+
+	s.Log.Info("serving secure metrics", "address", listener.Addr().String(), "clientCert", s.ClientCAFile != "", "tokenAuth", s.TokenAuth)
+	errs := make(chan error, 1)
+	go func() {
+		if err := server.ServeTLS(listener, "", ""); err != nil && err != http.ErrServerClosed {
+			errs <- err
+		}
+		close(errs)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return server.Shutdown(shutdownCtx)
+	}
+}
+
+// certificate returns serving certificate, reloading it when certificate
+// file changes, so rotated certificates are picked up without restart
+func (s *secureMetricsServer) certificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	info, err := os.Stat(s.CertFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metrics certificate: %v", err)
+	}
+	if s.cert != nil && !info.ModTime().After(s.certAt) {
+		return s.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+	if err != nil {
+		if s.cert != nil {
+			// certificate and key may be written separately, keep serving
+			// the previous certificate until both are updated
+			return s.cert, nil
+		}
+		return nil, fmt.Errorf("failed to load metrics certificate: %v", err)
+	}
+	s.cert = &cert
+	s.certAt = info.ModTime()
+	return s.cert, nil
+}
+
+// authenticate requires bearer token allowed to access request path when
+// token authentication is enabled
+func (s *secureMetricsServer) authenticate(next http.Handler) http.Handler {
+	if !s.TokenAuth {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if token == "" || token == req.Header.Get("Authorization") {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		status, err := s.authorize(req.Context(), token, req.URL.Path, strings.ToLower(req.Method))
+		if err != nil {
+			s.Log.Error(err, "metrics request authorization failed")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if status != http.StatusOK {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+//+kubebuilder:rbac:groups=authentication.k8s.io,resources=tokenreviews,verbs=create
+//+kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+
+// authorize reviews token and checks whether its user may perform verb on
+// non-resource path, returns HTTP status of the result
+func (s *secureMetricsServer) authorize(ctx context.Context, token string, path string, verb string) (int, error) {
+	sum := sha256.Sum256([]byte(verb + " " + path + " " + token))
+	key := hex.EncodeToString(sum[:])
+	s.lock.Lock()
+	if s.tokens == nil {
+		s.tokens = make(map[string]time.Time)
+	}
+	now := time.Now()
+	for cached, expiry := range s.tokens {
+		if now.After(expiry) {
+			delete(s.tokens, cached)
+		}
+	}
+	_, cached := s.tokens[key]
+	s.lock.Unlock()
+	if cached {
+		return http.StatusOK, nil
+	}
+
+	review := &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}
+	if err := s.Client.Create(ctx, review); err != nil {
+		return 0, err
+	}
+	if !review.Status.Authenticated {
+		return http.StatusUnauthorized, nil
+	}
+
+	extra := make(map[string]authorizationv1.ExtraValue, len(review.Status.User.Extra))
+	for name, values := range review.Status.User.Extra {
+		extra[name] = authorizationv1.ExtraValue(values)
+	}
+	access := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   review.Status.User.Username,
+			UID:    review.Status.User.UID,
+			Groups: review.Status.User.Groups,
+			Extra:  extra,
+			NonResourceAttributes: &authorizationv1.NonResourceAttributes{
+				Path: path,
+				Verb: verb,
+			},
+		},
+	}
+	if err := s.Client.Create(ctx, access); err != nil {
+		return 0, err
+	}
+	if !access.Status.Allowed {
+		return http.StatusForbidden, nil
+	}
+
+	s.lock.Lock()
+	s.tokens[key] = now.Add(tokenCacheTTL)
+	s.lock.Unlock()
+	return http.StatusOK, nil
+}
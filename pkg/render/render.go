@@ -0,0 +1,175 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+// Package render decrypts SopsSecrets and renders Kubernetes secrets from
+// their secret templates with the same code the operator runs, so secrets
+// rendered in CI or by other controllers match secrets generated in cluster
+package render
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	"go.mozilla.org/sops/v3/keyservice"
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+	"github.com/isindir/sops-secrets-operator/controllers"
+)
+
+// KeyProvider provides sops key services decrypting data keys
+type KeyProvider interface {
+	KeyServices(ctx context.Context) ([]keyservice.KeyServiceClient, error)
+}
+
+// KeyProviderFunc is KeyProvider function
+type KeyProviderFunc func(ctx context.Context) ([]keyservice.KeyServiceClient, error)
+
+// KeyServices calls the function
+func (f KeyProviderFunc) KeyServices(ctx context.Context) ([]keyservice.KeyServiceClient, error) {
+	return f(ctx)
+}
+
+// KeyFiles returns provider decrypting data keys with age identities and PGP
+// private keys from files, other keys are decrypted with local credentials
+// as sops does
+func KeyFiles(files ...string) KeyProvider {
+	return KeyProviderFunc(func(ctx context.Context) ([]keyservice.KeyServiceClient, error) {
+		return controllers.OfflineKeyServices(files)
+	})
+}
+
+// KeyServiceAddresses returns provider of external sops keyservices,
+// tcp://host:port or unix:///path/to/socket
+func KeyServiceAddresses(addresses ...string) KeyProvider {
+	return KeyProviderFunc(func(ctx context.Context) ([]keyservice.KeyServiceClient, error) {
+		var keyServices []keyservice.KeyServiceClient
+		for _, address := range addresses {
+			svc, err := controllers.DialKeyService(ctx, address)
+			if err != nil {
+				return nil, err
+			}
+			keyServices = append(keyServices, svc)
+		}
+		return keyServices, nil
+	})
+}
+
+// KeyServices returns provider of given sops key services
+func KeyServices(keyServices ...keyservice.KeyServiceClient) KeyProvider {
+	return KeyProviderFunc(func(ctx context.Context) ([]keyservice.KeyServiceClient, error) {
+		return keyServices, nil
+	})
+}
+
+// Options of rendered secrets
+type Options struct {
+	// Namespace overrides namespace of SopsSecrets when not empty
+	Namespace string
+	// SecretNamePrefix is added to secret names, like --secret-name-prefix
+	// of the operator
+	SecretNamePrefix string
+	// Labels are added to every secret, like --managed-secret-labels of the
+	// operator
+	Labels map[string]string
+	// Annotations are added to every secret, like
+	// --managed-secret-annotations of the operator
+	Annotations map[string]string
+	// Log receives decryption logs, controller-runtime logger is used when nil
+	Log logr.Logger
+}
+
+// Renderer renders secrets of SopsSecrets
+type Renderer struct {
+	decryptor controllers.Decryptor
+	options   Options
+}
+
+// New returns renderer decrypting data keys with key services of providers,
+// tried in order. Local sops key service is used when there are no providers
+func New(ctx context.Context, options Options, providers ...KeyProvider) (*Renderer, error) {
+	var keyServices []keyservice.KeyServiceClient
+	for _, provider := range providers {
+		providerKeyServices, err := provider.KeyServices(ctx)
+		if err != nil {
+			return nil, err
+		}
+		keyServices = append(keyServices, providerKeyServices...)
+	}
+	if len(keyServices) == 0 {
+		keyServices = []keyservice.KeyServiceClient{keyservice.NewLocalClient()}
+	}
+	return NewWithDecryptor(controllers.NewSopsDecryptor(keyServices...), options), nil
+}
+
+// NewWithDecryptor returns renderer using given decryptor
+func NewWithDecryptor(decryptor controllers.Decryptor, options Options) *Renderer {
+	if options.Log == nil {
+		options.Log = ctrl.Log.WithName("render")
+	}
+	return &Renderer{decryptor: decryptor, options: options}
+}
+
+// Render decrypts SopsSecret unless it is not encrypted and returns secrets
+// generated from its secret templates. Secrets are not replicated and have
+// no owner reference
+func (r *Renderer) Render(instance *isindirv1alpha2.SopsSecret) ([]corev1.Secret, error) {
+	var err error
+	if instance.Sops.Mac != "" {
+		if instance, err = controllers.DecryptSopsSecret(instance, r.decryptor, r.options.Log); err != nil {
+			return nil, err
+		}
+	} else {
+		instance = instance.DeepCopy()
+	}
+	if r.options.Namespace != "" {
+		instance.Namespace = r.options.Namespace
+	}
+	if err := controllers.ExpandRawSecrets(instance, r.decryptor); err != nil {
+		return nil, err
+	}
+	if err := controllers.ResolveSecretNames(instance, r.options.SecretNamePrefix); err != nil {
+		return nil, err
+	}
+	secrets, err := controllers.RenderSecrets(instance, r.decryptor, r.options.Log)
+	if err != nil {
+		return nil, err
+	}
+	return r.secrets(secrets), nil
+}
+
+// RenderManifests returns secrets generated from all SopsSecret manifests
+// of multi-document YAML
+func (r *Renderer) RenderManifests(data []byte) ([]corev1.Secret, error) {
+	documents, err := controllers.SplitDocuments(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var secrets []corev1.Secret
+	for i, document := range documents {
+		instance, err := controllers.DecodeSopsSecret(document)
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %v", i+1, err)
+		}
+		documentSecrets, err := r.Render(instance)
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %v", i+1, err)
+		}
+		secrets = append(secrets, documentSecrets...)
+	}
+	return secrets, nil
+}
+
+// secrets adds default metadata to rendered secrets
+func (r *Renderer) secrets(rendered []*corev1.Secret) []corev1.Secret {
+	secrets := make([]corev1.Secret, 0, len(rendered))
+	for _, secret := range rendered {
+		controllers.SetManagedMetadata(secret, r.options.Labels, r.options.Annotations)
+		secrets = append(secrets, *secret)
+	}
+	return secrets
+}
@@ -0,0 +1,95 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package testing
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"filippo.io/age"
+	"go.mozilla.org/sops/v3"
+	sopsage "go.mozilla.org/sops/v3/age"
+	"go.mozilla.org/sops/v3/keyservice"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+	"github.com/isindir/sops-secrets-operator/controllers"
+)
+
+// AgeKeystore holds generated age identity, SopsSecrets are encrypted for
+// its recipient and decrypted by its key services
+type AgeKeystore struct {
+	identity *age.X25519Identity
+	dir      string
+}
+
+// NewAgeKeystore generates age identity and stores it in a temporary
+// directory, which is removed by Close
+func NewAgeKeystore() (*AgeKeystore, error) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		return nil, err
+	}
+	dir, err := ioutil.TempDir("", "sops-age-")
+	if err != nil {
+		return nil, err
+	}
+	keystore := &AgeKeystore{identity: identity, dir: dir}
+	if err := ioutil.WriteFile(keystore.KeyFile(), []byte(identity.String()+"\n"), 0600); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return keystore, nil
+}
+
+// Close removes the identity file
+func (k *AgeKeystore) Close() error {
+	return os.RemoveAll(k.dir)
+}
+
+// Recipient returns age recipient of the identity
+func (k *AgeKeystore) Recipient() string {
+	return k.identity.Recipient().String()
+}
+
+// Identity returns the identity, AGE-SECRET-KEY-1...
+func (k *AgeKeystore) Identity() string {
+	return k.identity.String()
+}
+
+// KeyFile returns path of file with the identity, in the format of sops
+// age key file and key profile age keys secret values
+func (k *AgeKeystore) KeyFile() string {
+	return filepath.Join(k.dir, "keys.txt")
+}
+
+// KeyServices returns sops key services decrypting data keys with the
+// identity, suitable for SopsSecretReconciler.RemoteKeyServices or
+// controllers.NewSopsDecryptor
+func (k *AgeKeystore) KeyServices() ([]keyservice.KeyServiceClient, error) {
+	return controllers.OfflineKeyServices([]string{k.KeyFile()})
+}
+
+// KeyGroup returns sops key group with the recipient
+func (k *AgeKeystore) KeyGroup() (sops.KeyGroup, error) {
+	keys, err := sopsage.MasterKeysFromRecipients(k.Recipient())
+	if err != nil {
+		return nil, err
+	}
+	var group sops.KeyGroup
+	for _, key := range keys {
+		group = append(group, key)
+	}
+	return group, nil
+}
+
+// Encrypt returns copy of SopsSecret encrypted for the recipient
+func (k *AgeKeystore) Encrypt(instance *isindirv1alpha2.SopsSecret) (*isindirv1alpha2.SopsSecret, error) {
+	group, err := k.KeyGroup()
+	if err != nil {
+		return nil, err
+	}
+	return EncryptSopsSecret(instance, group)
+}
@@ -0,0 +1,78 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package testing
+
+import (
+	"fmt"
+	"time"
+
+	"go.mozilla.org/sops/v3"
+	sopsaes "go.mozilla.org/sops/v3/aes"
+	"go.mozilla.org/sops/v3/keyservice"
+	sopsyaml "go.mozilla.org/sops/v3/stores/yaml"
+	"go.mozilla.org/sops/v3/version"
+	"sigs.k8s.io/yaml"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+)
+
+// EncryptedRegex selects values EncryptSopsSecret encrypts, data and
+// binaryData of secret templates and files
+const EncryptedRegex = "^(data|stringData|binaryData|files)$"
+
+// EncryptSopsSecret returns copy of SopsSecret encrypted with data key
+// encrypted for master keys of the key group by key services, the same way
+// as sops --encrypt --encrypted-regex EncryptedRegex
+func EncryptSopsSecret(
+	instance *isindirv1alpha2.SopsSecret,
+	group sops.KeyGroup,
+	keyServices ...keyservice.KeyServiceClient,
+) (*isindirv1alpha2.SopsSecret, error) {
+	if len(keyServices) == 0 {
+		keyServices = []keyservice.KeyServiceClient{keyservice.NewLocalClient()}
+	}
+	document, err := yaml.Marshal(instance)
+	if err != nil {
+		return nil, err
+	}
+	store := &sopsyaml.Store{}
+	branches, err := store.LoadPlainFile(document)
+	if err != nil {
+		return nil, err
+	}
+	tree := sops.Tree{
+		Branches: branches,
+		Metadata: sops.Metadata{
+			KeyGroups:      []sops.KeyGroup{group},
+			EncryptedRegex: EncryptedRegex,
+			Version:        version.Version,
+		},
+	}
+
+	dataKey, errs := tree.GenerateDataKeyWithKeyServices(keyServices)
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("could not encrypt data key: %v", errs)
+	}
+	cipher := sopsaes.NewCipher()
+	mac, err := tree.Encrypt(dataKey, cipher)
+	if err != nil {
+		return nil, err
+	}
+	tree.Metadata.LastModified = time.Now().UTC()
+	tree.Metadata.MessageAuthenticationCode, err = cipher.Encrypt(mac, dataKey, tree.Metadata.LastModified.Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := store.EmitEncryptedFile(tree)
+	if err != nil {
+		return nil, err
+	}
+
+	instanceEncrypted := &isindirv1alpha2.SopsSecret{}
+	if err := yaml.Unmarshal(encrypted, instanceEncrypted); err != nil {
+		return nil, err
+	}
+	return instanceEncrypted, nil
+}
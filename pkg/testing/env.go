@@ -0,0 +1,139 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+// Package testing provides integration test harness for code embedding
+// SopsSecret reconciler: envtest API server with SopsSecret CRDs, age
+// keystore and mock Vault server, which encrypt SopsSecrets the way sops
+// does, so tests exercise the same decryption flows as the operator
+package testing
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+	isindirv1alpha3 "github.com/isindir/sops-secrets-operator/api/v1alpha3"
+	"github.com/isindir/sops-secrets-operator/controllers"
+)
+
+// Environment is envtest API server with SopsSecret CRDs installed
+type Environment struct {
+	// Config connects to the API server
+	Config *rest.Config
+	// Client is uncached client of the API server
+	Client client.Client
+	// Scheme has Kubernetes and SopsSecret types registered
+	Scheme *runtime.Scheme
+
+	env *envtest.Environment
+}
+
+// StartEnvironment starts envtest API server and installs CRDs from given
+// directories, which must include SopsSecret CRD, config/crd/bases of the
+// operator repository. API server binaries are located by envtest, usually
+// with KUBEBUILDER_ASSETS environment variable
+func StartEnvironment(crdDirectoryPaths ...string) (*Environment, error) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	if err := isindirv1alpha2.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	if err := isindirv1alpha3.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+
+	env := &envtest.Environment{
+		CRDDirectoryPaths:     crdDirectoryPaths,
+		ErrorIfCRDPathMissing: true,
+	}
+	config, err := env.Start()
+	if err != nil {
+		return nil, err
+	}
+	c, err := client.New(config, client.Options{Scheme: scheme})
+	if err != nil {
+		env.Stop()
+		return nil, err
+	}
+	return &Environment{
+		Config: config,
+		Client: c,
+		Scheme: scheme,
+		env:    env,
+	}, nil
+}
+
+// Stop stops the API server
+func (e *Environment) Stop() error {
+	return e.env.Stop()
+}
+
+// StartReconciler runs reconciler in a new manager until context is
+// cancelled. Client, Scheme, Log and Recorder are set unless the reconciler
+// has them, other settings are used as they are. Metrics and health probe
+// servers are disabled
+func (e *Environment) StartReconciler(ctx context.Context, reconciler *controllers.SopsSecretReconciler) error {
+	mgr, err := ctrl.NewManager(e.Config, ctrl.Options{
+		Scheme:                 e.Scheme,
+		MetricsBindAddress:     "0",
+		HealthProbeBindAddress: "0",
+	})
+	if err != nil {
+		return err
+	}
+	if reconciler.Client == nil {
+		reconciler.Client = mgr.GetClient()
+	}
+	if reconciler.Scheme == nil {
+		reconciler.Scheme = mgr.GetScheme()
+	}
+	if reconciler.Log == nil {
+		reconciler.Log = ctrl.Log.WithName("controllers").WithName("SopsSecret")
+	}
+	if reconciler.Recorder == nil {
+		reconciler.Recorder = mgr.GetEventRecorderFor("sopssecret-controller")
+	}
+	if err := reconciler.SetupWithManager(mgr); err != nil {
+		return err
+	}
+
+	go func() {
+		if err := mgr.Start(ctx); err != nil {
+			ctrl.Log.WithName("testing").Error(err, "manager stopped")
+		}
+	}()
+	if !mgr.GetCache().WaitForCacheSync(ctx) {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// WaitFor polls condition every 100ms until it returns true or error, or
+// timeout elapses
+func WaitFor(ctx context.Context, timeout time.Duration, condition func(ctx context.Context) (bool, error)) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		done, err := condition(ctx)
+		if err != nil || done {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
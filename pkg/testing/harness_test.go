@@ -0,0 +1,341 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package testing
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	gotesting "testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+	isindirv1alpha3 "github.com/isindir/sops-secrets-operator/api/v1alpha3"
+	"github.com/isindir/sops-secrets-operator/controllers"
+)
+
+func newTestSopsSecret() *isindirv1alpha2.SopsSecret {
+	return &isindirv1alpha2.SopsSecret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: isindirv1alpha2.GroupVersion.String(),
+			Kind:       "SopsSecret",
+		},
+		ObjectMeta: metav1.ObjectMeta{Name: "harness", Namespace: "default"},
+		Spec: isindirv1alpha2.SopsSecretSpec{
+			SecretsTemplate: []isindirv1alpha2.SopsSecretTemplate{{
+				Name: "harness-secret",
+				Data: map[string]string{"password": "s3cr3t"},
+			}},
+		},
+	}
+}
+
+func TestAgeKeystoreEncryptDecrypt(t *gotesting.T) {
+	keystore, err := NewAgeKeystore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer keystore.Close()
+
+	encrypted, err := keystore.Encrypt(newTestSopsSecret())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := encrypted.Spec.SecretsTemplate[0].Data["password"]; !strings.HasPrefix(value, "ENC[AES256_GCM,") {
+		t.Fatalf("password is not encrypted: %q", value)
+	}
+	if encrypted.Spec.SecretsTemplate[0].Name != "harness-secret" {
+		t.Errorf("template name is encrypted: %q", encrypted.Spec.SecretsTemplate[0].Name)
+	}
+	if len(encrypted.Sops.Age) != 1 || encrypted.Sops.Age[0].Recipient != keystore.Recipient() {
+		t.Errorf("unexpected age recipients %+v", encrypted.Sops.Age)
+	}
+
+	keyServices, err := keystore.KeyServices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decryptor := controllers.NewSopsDecryptor(keyServices...)
+	decrypted, err := decryptor.Decrypt(encrypted, ctrl.Log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets, err := controllers.RenderSecrets(decrypted, decryptor, ctrl.Log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secrets) != 1 || string(secrets[0].Data["password"]) != "s3cr3t" {
+		t.Errorf("unexpected secrets %+v", secrets)
+	}
+
+	other, err := NewAgeKeystore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	otherKeyServices, err := other.KeyServices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := controllers.NewSopsDecryptor(otherKeyServices...).Decrypt(encrypted, ctrl.Log); err == nil {
+		t.Error("SopsSecret is decrypted with other identity")
+	}
+}
+
+func TestVaultServerTransit(t *gotesting.T) {
+	server := NewVaultServer()
+	defer server.Close()
+
+	encrypted, err := server.Encrypt(newTestSopsSecret(), "transit", "harness")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(encrypted.Sops.HcVault) != 1 || encrypted.Sops.HcVault[0].KeyName != "harness" {
+		t.Fatalf("unexpected vault keys %+v", encrypted.Sops.HcVault)
+	}
+	decrypted, err := controllers.NewSopsDecryptor(server.KeyService()).Decrypt(encrypted, ctrl.Log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted.Spec.SecretsTemplate[0].Data["password"] != "s3cr3t" {
+		t.Errorf("unexpected decrypted data %+v", decrypted.Spec.SecretsTemplate[0].Data)
+	}
+
+	if _, err := vaultDecrypt("other", encrypted.Sops.HcVault[0].EncryptedKey); err == nil {
+		t.Error("data key is decrypted with other transit key")
+	}
+}
+
+func TestReconcilerWithVault(t *gotesting.T) {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS is not set")
+	}
+	env, err := StartEnvironment(filepath.Join("..", "..", "config", "crd", "bases"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := NewVaultServer()
+	defer server.Close()
+	auth, err := server.VaultAuth()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go auth.StartAutoRenew(ctx)
+	if err := env.StartReconciler(ctx, &controllers.SopsSecretReconciler{VaultAuth: auth}); err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := server.Encrypt(newTestSopsSecret(), "transit", "harness")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := env.Client.Create(ctx, encrypted); err != nil {
+		t.Fatal(err)
+	}
+
+	secret := &corev1.Secret{}
+	err = WaitFor(ctx, 30*time.Second, func(ctx context.Context) (bool, error) {
+		err := env.Client.Get(ctx, types.NamespacedName{Namespace: "default", Name: "harness-secret"}, secret)
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return err == nil, err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(secret.Data["password"]) != "s3cr3t" {
+		t.Errorf("unexpected secret data %+v", secret.Data)
+	}
+	if server.Logins() == 0 || server.Decrypts() == 0 {
+		t.Errorf("vault was not used, logins %d, decrypts %d", server.Logins(), server.Decrypts())
+	}
+}
+
+func TestConversionWebhookRoundTrip(t *gotesting.T) {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS is not set")
+	}
+	env, err := StartEnvironment(filepath.Join("..", "..", "config", "crd", "bases"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := env.StartConversionWebhook(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	templates := []isindirv1alpha3.SopsSecretTemplate{{
+		Name:       "conversion-secret",
+		StringData: map[string]string{"username": "admin"},
+		Data:       map[string]string{"password": "czNjcjN0"},
+		BinaryData: map[string]string{"keystore.jks": "/u3+7QAAAAIAAAAA"},
+	}}
+	original := &isindirv1alpha3.SopsSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: "conversion", Namespace: "default"},
+		Spec:       isindirv1alpha3.SopsSecretSpec{SecretsTemplate: templates},
+	}
+	// API server picks up CRD conversion webhook asynchronously, SopsSecret
+	// created before is stored without conversion, so it is created again
+	name := types.NamespacedName{Namespace: "default", Name: "conversion"}
+	stored := &isindirv1alpha2.SopsSecret{}
+	err = WaitFor(ctx, 30*time.Second, func(ctx context.Context) (bool, error) {
+		if err := env.Client.Create(ctx, original.DeepCopy()); err != nil {
+			return false, nil
+		}
+		if err := env.Client.Get(ctx, name, stored); err != nil {
+			return false, err
+		}
+		if stored.Annotations[isindirv1alpha3.DataKeysAnnotation] != "" {
+			return true, nil
+		}
+		return false, env.Client.Delete(ctx, stored)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	storedTemplate := stored.Spec.SecretsTemplate[0]
+	if !reflect.DeepEqual(storedTemplate.Data, map[string]string{"username": "admin"}) {
+		t.Errorf("unexpected v1alpha2 data %+v", storedTemplate.Data)
+	}
+	if !reflect.DeepEqual(storedTemplate.BinaryData, map[string]string{"password": "czNjcjN0", "keystore.jks": "/u3+7QAAAAIAAAAA"}) {
+		t.Errorf("unexpected v1alpha2 binary data %+v", storedTemplate.BinaryData)
+	}
+
+	converted := &isindirv1alpha3.SopsSecret{}
+	if err := env.Client.Get(ctx, name, converted); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(converted.Spec.SecretsTemplate, templates) {
+		t.Errorf("v1alpha3 secret templates changed in round trip %+v", converted.Spec.SecretsTemplate)
+	}
+	if _, ok := converted.Annotations[isindirv1alpha3.DataKeysAnnotation]; ok {
+		t.Errorf("v1alpha3 data keys annotation is exposed %+v", converted.Annotations)
+	}
+}
+
+func TestRecreateWaitsForSecretFinalizers(t *gotesting.T) {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS is not set")
+	}
+	env, err := StartEnvironment(filepath.Join("..", "..", "config", "crd", "bases"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	keystore, err := NewAgeKeystore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer keystore.Close()
+	keyServices, err := keystore.KeyServices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reconciler := &controllers.SopsSecretReconciler{Decryptor: controllers.NewSopsDecryptor(keyServices...)}
+	if err := env.StartReconciler(ctx, reconciler); err != nil {
+		t.Fatal(err)
+	}
+
+	plain := newTestSopsSecret()
+	plain.Spec.SecretsTemplate[0].UpdateStrategy = isindirv1alpha2.RecreateUpdateStrategy
+	encrypted, err := keystore.Encrypt(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := env.Client.Create(ctx, encrypted); err != nil {
+		t.Fatal(err)
+	}
+
+	name := types.NamespacedName{Namespace: "default", Name: "harness-secret"}
+	secret := &corev1.Secret{}
+	err = WaitFor(ctx, 30*time.Second, func(ctx context.Context) (bool, error) {
+		err := env.Client.Get(ctx, name, secret)
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return err == nil, err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// finalizer of another controller keeps the secret terminating when
+	// the operator deletes it to recreate it
+	const finalizer = "example.com/hold"
+	secret.Finalizers = append(secret.Finalizers, finalizer)
+	if err := env.Client.Update(ctx, secret); err != nil {
+		t.Fatal(err)
+	}
+	uid := secret.UID
+
+	plain.Spec.SecretsTemplate[0].Data["password"] = "n3w"
+	updated, err := keystore.Encrypt(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := &isindirv1alpha2.SopsSecret{}
+	if err := env.Client.Get(ctx, types.NamespacedName{Namespace: "default", Name: "harness"}, current); err != nil {
+		t.Fatal(err)
+	}
+	updated.ResourceVersion = current.ResourceVersion
+	if err := env.Client.Update(ctx, updated); err != nil {
+		t.Fatal(err)
+	}
+
+	err = WaitFor(ctx, 30*time.Second, func(ctx context.Context) (bool, error) {
+		if err := env.Client.Get(ctx, name, secret); err != nil {
+			return false, err
+		}
+		return !secret.DeletionTimestamp.IsZero(), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// failed reconciliation is retried every second
+	time.Sleep(3 * time.Second)
+	if err := env.Client.Get(ctx, name, secret); err != nil {
+		t.Fatal(err)
+	}
+	if secret.UID != uid || string(secret.Data["password"]) != "s3cr3t" {
+		t.Fatalf("terminating secret was updated %+v", secret)
+	}
+
+	controllerutil.RemoveFinalizer(secret, finalizer)
+	if err := env.Client.Update(ctx, secret); err != nil {
+		t.Fatal(err)
+	}
+	err = WaitFor(ctx, 30*time.Second, func(ctx context.Context) (bool, error) {
+		err := env.Client.Get(ctx, name, secret)
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return err == nil && secret.UID != uid, err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(secret.Data["password"]) != "n3w" || !secret.DeletionTimestamp.IsZero() {
+		t.Errorf("secret was not recreated with new data %+v", secret)
+	}
+}
@@ -0,0 +1,244 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package testing
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/vault/api"
+	"go.mozilla.org/sops/v3"
+	"go.mozilla.org/sops/v3/hcvault"
+	"go.mozilla.org/sops/v3/keyservice"
+	"google.golang.org/grpc"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+	"github.com/isindir/sops-secrets-operator/controllers"
+)
+
+// VaultToken is token issued by VaultServer logins
+const VaultToken = "s.testing"
+
+// vaultCiphertextPrefix prefixes ciphertexts of mock transit engine
+const vaultCiphertextPrefix = "vault:v1:"
+
+// VaultServer is mock Vault server with transit engine. Every login under
+// auth/ issues VaultToken, transit decryption requires it. Ciphertexts are
+// not encrypted, they only encode key name and plaintext
+type VaultServer struct {
+	// URL is address of the server
+	URL string
+
+	server *httptest.Server
+	lock   sync.Mutex
+	// denyLogin rejects logins with permission denied
+	denyLogin bool
+	logins    int
+	decrypts  int
+}
+
+// NewVaultServer starts mock Vault server, it is stopped by Close
+func NewVaultServer() *VaultServer {
+	s := &VaultServer{}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.server.URL
+	return s
+}
+
+// Close stops the server
+func (s *VaultServer) Close() {
+	s.server.Close()
+}
+
+// DenyLogin makes logins fail with permission denied when deny is true
+func (s *VaultServer) DenyLogin(deny bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.denyLogin = deny
+}
+
+// Logins returns number of successful logins
+func (s *VaultServer) Logins() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.logins
+}
+
+// Decrypts returns number of successful transit decryptions
+func (s *VaultServer) Decrypts() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.decrypts
+}
+
+func (s *VaultServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body := map[string]interface{}{}
+	if r.Body != nil {
+		json.NewDecoder(r.Body).Decode(&body)
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	switch {
+	case path == "auth/token/renew-self":
+		if r.Header.Get("X-Vault-Token") != VaultToken {
+			vaultError(w, http.StatusForbidden, "permission denied")
+			return
+		}
+		vaultAuthResponse(w)
+	case strings.HasPrefix(path, "auth/"):
+		if s.denyLogin {
+			vaultError(w, http.StatusForbidden, "permission denied")
+			return
+		}
+		s.logins++
+		vaultAuthResponse(w)
+	case strings.Contains(path, "/decrypt/"):
+		if r.Header.Get("X-Vault-Token") != VaultToken {
+			vaultError(w, http.StatusForbidden, "permission denied")
+			return
+		}
+		ciphertext, _ := body["ciphertext"].(string)
+		plaintext, err := vaultDecrypt(path[strings.LastIndex(path, "/")+1:], ciphertext)
+		if err != nil {
+			vaultError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.decrypts++
+		vaultDataResponse(w, map[string]interface{}{"plaintext": base64.StdEncoding.EncodeToString(plaintext)})
+	case strings.Contains(path, "/encrypt/"):
+		if r.Header.Get("X-Vault-Token") != VaultToken {
+			vaultError(w, http.StatusForbidden, "permission denied")
+			return
+		}
+		encoded, _ := body["plaintext"].(string)
+		plaintext, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			vaultError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		vaultDataResponse(w, map[string]interface{}{"ciphertext": vaultEncrypt(path[strings.LastIndex(path, "/")+1:], plaintext)})
+	default:
+		vaultError(w, http.StatusNotFound, "unsupported path")
+	}
+}
+
+func vaultAuthResponse(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"auth": map[string]interface{}{
+			"client_token":   VaultToken,
+			"lease_duration": 3600,
+			"renewable":      true,
+		},
+	})
+}
+
+func vaultDataResponse(w http.ResponseWriter, data map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+func vaultError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{message}})
+}
+
+// vaultEncrypt encodes plaintext as ciphertext of transit key
+func vaultEncrypt(keyName string, plaintext []byte) string {
+	return vaultCiphertextPrefix + base64.StdEncoding.EncodeToString(append([]byte(keyName+"\x00"), plaintext...))
+}
+
+// vaultDecrypt decodes plaintext of ciphertext of transit key
+func vaultDecrypt(keyName string, ciphertext string) ([]byte, error) {
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(ciphertext, vaultCiphertextPrefix))
+	if err != nil || !strings.HasPrefix(ciphertext, vaultCiphertextPrefix) {
+		return nil, fmt.Errorf("invalid ciphertext")
+	}
+	parts := bytes.SplitN(decoded, []byte{0}, 2)
+	if len(parts) != 2 || string(parts[0]) != keyName {
+		return nil, fmt.Errorf("ciphertext is not encrypted with key %s", keyName)
+	}
+	return parts[1], nil
+}
+
+// VaultAuth returns authenticator logging in to the server, it obtains token
+// once started with StartAutoRenew
+func (s *VaultServer) VaultAuth() (*controllers.VaultAuth, error) {
+	return controllers.CreateVaultAuth(s.URL, "", vaultLogin{})
+}
+
+// vaultLogin logs in at auth/testing/login
+type vaultLogin struct{}
+
+func (vaultLogin) Login(ctx context.Context, client *api.Client) (*api.Secret, error) {
+	secret, err := client.Logical().Write("auth/testing/login", map[string]interface{}{"role": "testing"})
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Auth == nil {
+		return nil, fmt.Errorf("vault login returned no token")
+	}
+	return secret, nil
+}
+
+// KeyGroup returns sops key group with transit key of the server
+func (s *VaultServer) KeyGroup(enginePath string, keyName string) sops.KeyGroup {
+	return sops.KeyGroup{hcvault.NewMasterKey(s.URL, enginePath, keyName)}
+}
+
+// KeyService returns sops key service encrypting and decrypting data keys
+// of transit keys of the server in-process, other keys are handled by local
+// key service
+func (s *VaultServer) KeyService() keyservice.KeyServiceClient {
+	return &vaultKeyService{local: keyservice.NewLocalClient()}
+}
+
+// Encrypt returns copy of SopsSecret encrypted for transit key of the server
+func (s *VaultServer) Encrypt(instance *isindirv1alpha2.SopsSecret, enginePath string, keyName string) (*isindirv1alpha2.SopsSecret, error) {
+	return EncryptSopsSecret(instance, s.KeyGroup(enginePath, keyName), s.KeyService())
+}
+
+// vaultKeyService encodes data keys of transit keys like VaultServer
+type vaultKeyService struct {
+	local keyservice.KeyServiceClient
+}
+
+func (ks *vaultKeyService) Encrypt(
+	ctx context.Context,
+	req *keyservice.EncryptRequest,
+	opts ...grpc.CallOption,
+) (*keyservice.EncryptResponse, error) {
+	vaultKey := req.Key.GetVaultKey()
+	if vaultKey == nil {
+		return ks.local.Encrypt(ctx, req, opts...)
+	}
+	return &keyservice.EncryptResponse{Ciphertext: []byte(vaultEncrypt(vaultKey.KeyName, req.Plaintext))}, nil
+}
+
+func (ks *vaultKeyService) Decrypt(
+	ctx context.Context,
+	req *keyservice.DecryptRequest,
+	opts ...grpc.CallOption,
+) (*keyservice.DecryptResponse, error) {
+	vaultKey := req.Key.GetVaultKey()
+	if vaultKey == nil {
+		return ks.local.Decrypt(ctx, req, opts...)
+	}
+	plaintext, err := vaultDecrypt(vaultKey.KeyName, string(req.Ciphertext))
+	if err != nil {
+		return nil, err
+	}
+	return &keyservice.DecryptResponse{Plaintext: plaintext}, nil
+}
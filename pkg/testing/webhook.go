@@ -0,0 +1,123 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package testing
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	isindirv1alpha2 "github.com/isindir/sops-secrets-operator/api/v1alpha2"
+	"github.com/isindir/sops-secrets-operator/controllers"
+)
+
+// StartConversionWebhook serves SopsSecret conversion webhook the way the
+// operator does with --enable-conversion-webhook in a new manager until
+// context is cancelled, and configures SopsSecret CRD to use it, so v1alpha3
+// SopsSecrets are converted by the API server
+func (e *Environment) StartConversionWebhook(ctx context.Context) error {
+	certDir, err := ioutil.TempDir("", "sops-conversion-webhook")
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		os.RemoveAll(certDir)
+	}()
+	caBundle, err := writeServingCert(certDir)
+	if err != nil {
+		return err
+	}
+	port, err := freePort()
+	if err != nil {
+		return err
+	}
+
+	mgr, err := ctrl.NewManager(e.Config, ctrl.Options{
+		Scheme:                 e.Scheme,
+		MetricsBindAddress:     "0",
+		HealthProbeBindAddress: "0",
+		Host:                   "127.0.0.1",
+		Port:                   port,
+		CertDir:                certDir,
+	})
+	if err != nil {
+		return err
+	}
+	if err := (&isindirv1alpha2.SopsSecret{}).SetupWebhookWithManager(mgr); err != nil {
+		return err
+	}
+	go func() {
+		if err := mgr.Start(ctx); err != nil {
+			ctrl.Log.WithName("testing").Error(err, "webhook manager stopped")
+		}
+	}()
+
+	return controllers.ConfigureConversionWebhook(ctx, e.Client, controllers.ConversionWebhookConfig{
+		URL:      fmt.Sprintf("https://127.0.0.1:%d/convert", port),
+		CABundle: caBundle,
+	})
+}
+
+// writeServingCert writes self-signed certificate of 127.0.0.1 to tls.crt
+// and tls.key of the directory and returns the certificate PEM
+func writeServingCert(dir string) ([]byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := ioutil.WriteFile(filepath.Join(dir, "tls.crt"), certPEM, 0600); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "tls.key"), keyPEM, 0600); err != nil {
+		return nil, err
+	}
+	return certPEM, nil
+}
+
+// freePort returns local TCP port which is not in use
+func freePort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
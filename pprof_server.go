@@ -0,0 +1,64 @@
+/* This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/. */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// pprofServer serves net/http/pprof profiles for live profiling, profiles
+// expose process internals, so it is meant to be bound to localhost and
+// reached with kubectl port-forward
+type pprofServer struct {
+	Addr string
+	Log  logr.Logger
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, standby
+// replicas can be profiled too
+func (s *pprofServer) NeedLeaderElection() bool {
+	return false
+}
+
+// Start implements manager.Runnable
+func (s *pprofServer) Start(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	listener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on pprof address %s: %v", s.Addr, err)
+	}
+	server := &http.Server{Handler: mux}
+
+	s.Log.Info("serving pprof", "address", listener.Addr().String())
+	errs := make(chan error, 1)
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			errs <- err
+		}
+		close(errs)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return server.Shutdown(shutdownCtx)
+	}
+}